@@ -14,6 +14,7 @@ import (
 
 	"github.com/mkimuram/k8s-ext-connector/pkg/apis"
 	"github.com/mkimuram/k8s-ext-connector/pkg/controller"
+	"github.com/mkimuram/k8s-ext-connector/pkg/controller/externalservice"
 	"github.com/mkimuram/k8s-ext-connector/version"
 
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
@@ -55,6 +56,8 @@ func main() {
 	// controller-runtime)
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 
+	pflag.StringVar(&externalservice.ConnectorNamespace, "connector-namespace", externalservice.DefaultConnectorNamespace, "Namespace to deploy forwarders for external services.")
+
 	pflag.Parse()
 
 	// Use a zap logr.Logger implementation. If none of the zap
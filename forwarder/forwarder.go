@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"time"
 
@@ -11,14 +12,25 @@ import (
 	sbinformers "github.com/mkimuram/k8s-ext-connector/pkg/client/informers/externalversions"
 	"github.com/mkimuram/k8s-ext-connector/pkg/forwarder"
 	"github.com/mkimuram/k8s-ext-connector/pkg/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
 )
 
 var (
-	namespace string
-	name      string
-	fwd       *util.Controller
+	namespace         string
+	name              string
+	drainTimeout      = flag.Duration("drain-timeout", 0, "Grace period for connections on deleted tunnels to finish. Connections are closed immediately if 0.")
+	keepAliveInterval = flag.Duration("keepalive-interval", 15*time.Second, "Interval to send keepalive to gateway. It needs to be shorter than -idle-timeout of gateways, otherwise idle tunnels are closed by them. Keepalive is disabled if 0, which requires idle timeout of gateways to be disabled too.")
+	keepAliveCountMax = flag.Int("keepalive-count-max", 3, "Number of keepalive that gateway can miss before forwarder reconnects to it.")
+	metricsAddr       = flag.String("metrics-addr", fmt.Sprintf(":%d", util.MetricsPort), "Address to serve metrics on. Metrics are not served if empty.")
+	healthAddr        = flag.String("health-addr", fmt.Sprintf(":%d", util.HealthPort), "Address to serve /healthz and /readyz on. They are not served if empty.")
+	stuckTimeout      = flag.Duration("stuck-timeout", time.Minute, "Time that processing a forwarder can take, before /healthz reports that forwarder is stuck.")
+	driftInterval     = flag.Duration("drift-check-interval", 10*time.Second, "Interval to check that synced rules haven't drifted and repair them, independent of informer resync. Drift is checked only on events if 0.")
+	fwd               *util.Controller
+	reconciler        *forwarder.Reconciler
 )
 
 func init() {
@@ -31,6 +43,12 @@ func init() {
 	if namespace == "" || name == "" {
 		glog.Fatalf("FORWARDER_NAMESPACE and FORWARDER_NAME need to be defined as environment variables")
 	}
+	// Gateways can be configured with other idle timeouts, so this isn't fatal
+	if *keepAliveInterval <= 0 {
+		glog.Warningf("Keepalive is disabled, so idle tunnels are closed by gateways unless their -idle-timeout is disabled")
+	} else if *keepAliveInterval >= util.DefaultIdleTimeout {
+		glog.Warningf("-keepalive-interval %v isn't shorter than the default -idle-timeout %v of gateways, so idle tunnels are closed by gateways unless their -idle-timeout is longer", *keepAliveInterval, util.DefaultIdleTimeout)
+	}
 
 	// create in-cluster config
 	config, err := rest.InClusterConfig()
@@ -49,12 +67,45 @@ func init() {
 		glog.Fatalf("Failed to create versioned client: %v", err)
 	}
 
-	informerFactory := sbinformers.NewSharedInformerFactory(vcl, time.Second*30)
+	// create kubernetes clientset for events
+	kcl, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create kubernetes client: %v", err)
+	}
+
+	// Watch only the forwarder CR for this forwarder, which is the only one allowed to access
+	informerFactory := sbinformers.NewSharedInformerFactoryWithOptions(vcl, time.Second*30,
+		sbinformers.WithNamespace(namespace),
+		sbinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+		}))
 	informer := informerFactory.Submariner().V1alpha1().Forwarders().Informer()
-	reconciler := forwarder.NewReconciler(cl, namespace, name)
-	fwd = util.NewController(cl, informerFactory, informer, reconciler)
+	reconciler = forwarder.NewReconciler(cl, namespace, name, forwarder.TunnelOptions{
+		DrainTimeout:      *drainTimeout,
+		KeepAliveInterval: *keepAliveInterval,
+		KeepAliveCountMax: *keepAliveCountMax,
+	}, util.NewEventRecorder(kcl, "forwarder"))
+	reconciler.SetDriftCheckInterval(*driftInterval)
+	fwd = util.NewController("forwarder", cl, informerFactory, informer, reconciler)
 }
 
 func main() {
+	if *metricsAddr != "" {
+		go func() {
+			if err := util.ServeMetrics(*metricsAddr); err != nil {
+				glog.Errorf("Failed to serve metrics on %q: %v", *metricsAddr, err)
+			}
+		}()
+	}
+	if *healthAddr != "" {
+		go func() {
+			healthz := func() error {
+				return fwd.Healthz(*stuckTimeout)
+			}
+			if err := util.ServeHealth(*healthAddr, healthz, reconciler.Ready); err != nil {
+				glog.Errorf("Failed to serve health checks on %q: %v", *healthAddr, err)
+			}
+		}()
+	}
 	fwd.Run()
 }
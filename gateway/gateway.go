@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
 	"time"
@@ -13,15 +14,22 @@ import (
 	clversioned "github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned"
 	clv1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned/typed/submariner/v1alpha1"
 	sbinformers "github.com/mkimuram/k8s-ext-connector/pkg/client/informers/externalversions"
+	"k8s.io/apimachinery/pkg/util/wait"
+	kubeinformers "k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 )
 
 var (
-	kubeconfig *string
-	namespace  = flag.String("namespace", "external-services", "Kubernetes's namespace to watch for.")
-	g          *util.Controller
+	kubeconfig    *string
+	namespace     = flag.String("namespace", "external-services", "Kubernetes's namespace to watch for.")
+	idleTimeout   = flag.Duration("idle-timeout", util.DefaultIdleTimeout, "Timeout to close ssh connections without any activity, including keepalive from forwarders. It needs to be longer than -keepalive-interval of forwarders. Disabled if 0.")
+	metricsAddr   = flag.String("metrics-addr", fmt.Sprintf(":%d", util.MetricsPort), "Address to serve metrics on. Metrics are not served if empty.")
+	driftInterval = flag.Duration("drift-check-interval", 10*time.Second, "Interval to check that synced rules haven't drifted and repair them, independent of informer resync. Drift is checked only on events if 0.")
+	g             *util.Controller
 )
 
 func init() {
@@ -50,12 +58,35 @@ func init() {
 		glog.Fatalf("Failed to create versioned client from %q: %v", *kubeconfig, err)
 	}
 
+	// create kubernetes clientset for events and certificates
+	kcl, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		glog.Fatalf("Failed to create kubernetes client from %q: %v", *kubeconfig, err)
+	}
+
+	// secrets are watched only in the namespace, to read certificates for TLS transport from the cache
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kcl, time.Second*30, kubeinformers.WithNamespace(*namespace))
+	secretInformer := kubeInformerFactory.Core().V1().Secrets()
+	secretLister := secretInformer.Lister()
+	kubeInformerFactory.Start(wait.NeverStop)
+	if ok := cache.WaitForCacheSync(wait.NeverStop, secretInformer.Informer().HasSynced); !ok {
+		glog.Fatalf("time out while waiting secrets cache to be synced")
+	}
+
 	informerFactory := sbinformers.NewSharedInformerFactory(vcl, time.Second*30)
 	informer := informerFactory.Submariner().V1alpha1().Gateways().Informer()
-	reconciler := gateway.NewReconciler(cl, *namespace)
-	g = util.NewController(cl, informerFactory, informer, reconciler)
+	reconciler := gateway.NewReconciler(cl, secretLister, *namespace, *idleTimeout, util.NewEventRecorder(kcl, "gateway"))
+	reconciler.SetDriftCheckInterval(*driftInterval)
+	g = util.NewController("gateway", cl, informerFactory, informer, reconciler)
 }
 
 func main() {
+	if *metricsAddr != "" {
+		go func() {
+			if err := util.ServeMetrics(*metricsAddr); err != nil {
+				glog.Errorf("Failed to serve metrics on %q: %v", *metricsAddr, err)
+			}
+		}()
+	}
 	g.Run()
 }
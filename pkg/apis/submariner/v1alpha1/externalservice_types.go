@@ -10,14 +10,163 @@ type ExternalServiceSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
 	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
-	TargetIP string               `json:"targetIP"`
+	TargetIP string               `json:"targetIP,omitempty"`
 	Sources  []Source             `json:"sources"`
 	Ports    []corev1.ServicePort `json:"ports"`
+	// TargetHost is the DNS name of the external server, which is used instead of TargetIP.
+	// It is resolved periodically by honoring TTL, and the resolved addresses are reported in status.
+	TargetHost string `json:"targetHost,omitempty"`
+	// TargetIPs are IP addresses of multiple external servers, which are used instead of TargetIP.
+	// Addresses resolved from TargetHost are also used as multiple external servers.
+	TargetIPs []string `json:"targetIPs,omitempty"`
+	// TargetPolicy is the policy to select one of the external servers for each connection, which is
+	// RoundRobin or Failover. Failover selects the first healthy one in order. Defaults to RoundRobin.
+	TargetPolicy string `json:"targetPolicy,omitempty"`
+	// HealthCheck enables TCP health checks of the external servers in gateways
+	HealthCheck *TargetHealthCheck `json:"healthCheck,omitempty"`
+	// Replicas is the number of forwarder pods. Defaults to 1.
+	Replicas *int32 `json:"replicas,omitempty"`
+	// ForwarderTemplate customizes forwarder pods. Defaults are used for fields omitted.
+	ForwarderTemplate *ForwarderTemplate `json:"forwarderTemplate,omitempty"`
+	// ClientService is a service for clients in the namespace of the external service.
+	// It isn't created if omitted.
+	ClientService *ClientService `json:"clientService,omitempty"`
+	// PortRanges are ranges of consecutive ports forwarded in addition to Ports.
+	// Each of them can be forwarded to an external server other than the target.
+	PortRanges []PortRange `json:"portRanges,omitempty"`
+	// Transport is the transport between forwarders and gateways, which is SSH, WireGuard or TLS.
+	// WireGuard routes packets for egress through WireGuard tunnels in kernel instead of relaying connections
+	// through ssh tunnels in userspace, so limits and multiple targets are not applied.
+	// TLS relays connections for both egress and ingress over HTTP/2 on mutual TLS, with certificates
+	// issued by the operator, for environments that block ssh. Defaults to SSH.
+	Transport string `json:"transport,omitempty"`
+}
+
+const (
+	// TransportSSH forwards connections through ssh tunnels from forwarders to gateways
+	TransportSSH = "SSH"
+	// TransportWireGuard routes packets through WireGuard tunnels from forwarders to gateways
+	TransportWireGuard = "WireGuard"
+	// TransportTLS relays connections over HTTP/2 on mutual TLS from forwarders to gateways
+	TransportTLS = "TLS"
+)
+
+// ClientService defines a service in the namespace of the external service,
+// which forwards to forwarder pods
+type ClientService struct {
+	// Name is the name of the service. Defaults to the name of the external service.
+	Name string `json:"name,omitempty"`
+}
+
+// ForwarderTemplate defines customizations for forwarder pods.
+// Forwarder pods are rolled when it is changed.
+type ForwarderTemplate struct {
+	// Image is the container image of forwarder
+	Image string `json:"image,omitempty"`
+	// ImagePullPolicy is the pull policy of the image
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// ImagePullSecrets are secrets to pull the image
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// Resources are compute resources required by forwarder container
+	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// NodeSelector restricts nodes that forwarder pods are scheduled on
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations are tolerations of forwarder pods
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// PriorityClassName is the priority class of forwarder pods
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// Annotations are added to forwarder pods
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// ServiceAccountName is the service account of forwarder pods
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// SSHKeySecretName is the secret that contains ssh key to connect to gateways
+	SSHKeySecretName string `json:"sshKeySecretName,omitempty"`
+}
+
+// PortRange defines consecutive ports forwarded to the same ports of the external server.
+// Services have a port for each port in the range, but forwarders and gateways handle the range at once.
+type PortRange struct {
+	// Protocol is the protocol of the ports. Defaults to TCP.
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+	// Port is the first port of the range
+	Port int32 `json:"port"`
+	// EndPort is the last port of the range. Defaults to Port, which means a single port.
+	EndPort int32 `json:"endPort,omitempty"`
+	// TargetPort is the first port of the range that forwarder pods listen on. Defaults to Port.
+	TargetPort int32 `json:"targetPort,omitempty"`
+	// TargetHost is the IP address or the DNS name of the external server for the ports,
+	// which overrides TargetIP, TargetIPs and TargetHost of the external service
+	TargetHost string `json:"targetHost,omitempty"`
 }
 
 type Source struct {
-	Service  ServiceRef `json:"service"`
-	SourceIP string     `json:"sourceIP"`
+	Service  ServiceRef    `json:"service"`
+	SourceIP string        `json:"sourceIP"`
+	Limits   *SourceLimits `json:"limits,omitempty"`
+	// Direction is the direction of traffic forwarded for the source, which is Egress, Ingress or Both.
+	// Egress forwards access from pods of the service to the external servers, and Ingress forwards
+	// access from the external servers to the service. Defaults to Both.
+	Direction string `json:"direction,omitempty"`
+	// Egress defines how access from pods of the service is forwarded to the external servers
+	Egress *SourceEgress `json:"egress,omitempty"`
+	// Ingress selects ports of the service exposed for ingress and external clients allowed to access them.
+	// All the ports of the service are exposed only to the external servers if omitted.
+	Ingress *SourceIngress `json:"ingress,omitempty"`
+}
+
+// SourceEgress defines how access from pods of the service of a source is forwarded
+type SourceEgress struct {
+	// ProxyProtocol is the version of HAProxy PROXY protocol header, which is v1 or v2, sent to the external
+	// servers on each connection to tell the original pod, because they see only the source IP otherwise.
+	// v2 header also has a TLV of type 0xE0 with namespace/name of the pod. No header is sent if omitted.
+	ProxyProtocol string `json:"proxyProtocol,omitempty"`
+}
+
+// SourceIngress defines how the service of a source is exposed on the source IP
+type SourceIngress struct {
+	// Ports are ports of the service exposed. All the ports are exposed if omitted.
+	Ports []IngressPort `json:"ports,omitempty"`
+	// AllowedCIDRs are CIDRs of external clients allowed to access the ports, in addition to the external servers
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty"`
+}
+
+// IngressPort defines a port of the service exposed on the source IP
+type IngressPort struct {
+	// Port is the port of the service
+	Port int32 `json:"port"`
+	// ExternalPort is the port published on the source IP. Defaults to Port.
+	ExternalPort int32 `json:"externalPort,omitempty"`
+	// ProxyProtocol is the version of HAProxy PROXY protocol header, which is v1 or v2, sent to the service
+	// on each connection to tell the original external client. No header is sent if omitted.
+	// It is not supported by the gateway in Direct mode.
+	ProxyProtocol string `json:"proxyProtocol,omitempty"`
+}
+
+const (
+	// DirectionEgress forwards only access from pods of the source service to the external servers
+	DirectionEgress = "Egress"
+	// DirectionIngress forwards only access from the external servers to the source service
+	DirectionIngress = "Ingress"
+	// DirectionBoth forwards access in both directions
+	DirectionBoth = "Both"
+)
+
+// TargetHealthCheck defines TCP health checks of external servers, which connect to the ports
+// of external servers from source IPs
+type TargetHealthCheck struct {
+	// PeriodSeconds is the interval of health checks. Defaults to 10.
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+	// TimeoutSeconds is the timeout of each health check. Defaults to 1.
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+}
+
+// SourceLimits defines limits on connections forwarded for a source.
+// They are applied to all the connections for the source in total.
+type SourceLimits struct {
+	// MaxConnections is the maximum number of concurrent connections. Unlimited if 0.
+	MaxConnections int `json:"maxConnections,omitempty"`
+	// BytesPerSecond is the maximum bandwidth in bytes per second for both directions. Unlimited if 0.
+	BytesPerSecond int64 `json:"bytesPerSecond,omitempty"`
 }
 
 type ServiceRef struct {
@@ -30,6 +179,21 @@ type ExternalServiceStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
 	// Add custom validation using kubebuilder tags: https://book-v1.book.kubebuilder.io/beyond_basics/generating_crd.html
+
+	// ResolvedAddresses are IPv4 addresses resolved from TargetHost
+	ResolvedAddresses []string `json:"resolvedAddresses,omitempty"`
+	// TargetIP is the address in ResolvedAddresses used as the target
+	TargetIP string `json:"targetIP,omitempty"`
+	// PortTargets are addresses resolved from TargetHost of PortRanges that are DNS names
+	PortTargets []PortTargetStatus `json:"portTargets,omitempty"`
+}
+
+// PortTargetStatus defines the observed state of TargetHost of PortRanges
+type PortTargetStatus struct {
+	// TargetHost is the DNS name resolved
+	TargetHost string `json:"targetHost"`
+	// ResolvedAddresses are IPv4 addresses resolved from TargetHost
+	ResolvedAddresses []string `json:"resolvedAddresses,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
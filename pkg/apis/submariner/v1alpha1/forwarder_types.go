@@ -15,14 +15,37 @@ type ForwarderSpec struct {
 }
 
 type ForwarderRule struct {
-	Protocol        string     `json:"protocol,omitempty"`
-	SourceIP        string     `json:"sourceip,omitempty"`
-	TargetPort      string     `json:"targetport,omitempty"`
-	DestinationIP   string     `json:"destinationip,omitempty"`
-	DestinationPort string     `json:"destinationport,omitempty"`
-	Gateway         GatewayRef `json:"gateway"`
-	GatewayIP       string     `json:"gatewayip,omitempty"`
-	RelayPort       string     `json:"relayPort,omitempty"`
+	Protocol        string        `json:"protocol,omitempty"`
+	SourceIP        string        `json:"sourceip,omitempty"`
+	TargetPort      string        `json:"targetport,omitempty"`
+	DestinationIP   string        `json:"destinationip,omitempty"`
+	DestinationPort string        `json:"destinationport,omitempty"`
+	Gateway         GatewayRef    `json:"gateway"`
+	GatewayIP       string        `json:"gatewayip,omitempty"`
+	RelayPort       string        `json:"relayPort,omitempty"`
+	Limits          *SourceLimits `json:"limits,omitempty"`
+	// DestinationIPs are all the destinations including DestinationIP, one of which is selected
+	// by TargetPolicy for each connection. It is set only if there are multiple destinations.
+	DestinationIPs []string           `json:"destinationips,omitempty"`
+	TargetPolicy   string             `json:"targetpolicy,omitempty"`
+	HealthCheck    *TargetHealthCheck `json:"healthcheck,omitempty"`
+	// TargetEndPort is the last port of the range from TargetPort. It is set only for a port range,
+	// then each port in the range is forwarded to the port with the same offset from DestinationPort.
+	TargetEndPort string `json:"targetendport,omitempty"`
+	// Transport is the transport to the gateway, which is SSH, WireGuard or TLS. Defaults to SSH.
+	// Rules for WireGuard have no RelayPort, and are routed through the WireGuard tunnel to the gateway.
+	Transport string `json:"transport,omitempty"`
+	// WireGuardPublicKey and WireGuardPort are the public key and the port of the gateway for WireGuard
+	WireGuardPublicKey string `json:"wireguardpublickey,omitempty"`
+	WireGuardPort      string `json:"wireguardport,omitempty"`
+	// TLSRelayPort is the port of the mTLS relay server of the gateway for TLS transport
+	TLSRelayPort string `json:"tlsrelayport,omitempty"`
+	// ProxyProtocol is the version of PROXY protocol header sent to DestinationIP for ingress rules,
+	// which tells the original client of the connection accepted on the gateway. For egress rules,
+	// the gateway sends it to the destination to tell the pod with SourceIP. No header is sent if empty.
+	ProxyProtocol string `json:"proxyprotocol,omitempty"`
+	// SourcePod is namespace/name of the pod with SourceIP for egress rules that send PROXY protocol header
+	SourcePod string `json:"sourcepod,omitempty"`
 }
 
 type GatewayRef struct {
@@ -32,14 +55,49 @@ type GatewayRef struct {
 
 // ForwarderStatus defines the observed state of Forwarder
 type ForwarderStatus struct {
-	Conditions     status.Conditions `json:"conditions"`
-	RuleGeneration int               `json:"rulegeneration,omitempty"`
-	SyncGeneration int               `json:"syncgeneration,omitempty"`
+	Conditions status.Conditions `json:"conditions"`
+	// ObservedGeneration is metadata.generation of the forwarder whose rules are synced last
+	ObservedGeneration  int64                   `json:"observedGeneration,omitempty"`
+	EgressRuleStatuses  []ForwarderRuleStatus   `json:"egressrulestatuses,omitempty"`
+	IngressRuleStatuses []ForwarderRuleStatus   `json:"ingressrulestatuses,omitempty"`
+	SourceStatuses      []ForwarderSourceStatus `json:"sourcestatuses,omitempty"`
+	// WireGuardPublicKey is the public key of the forwarder for WireGuard
+	WireGuardPublicKey string `json:"wireguardpublickey,omitempty"`
+}
+
+// ForwarderRuleStatus defines the observed state of the tunnel for a ForwarderRule
+type ForwarderRuleStatus struct {
+	GatewayIP          string      `json:"gatewayip,omitempty"`
+	RelayPort          string      `json:"relayport,omitempty"`
+	DestinationIP      string      `json:"destinationip,omitempty"`
+	DestinationPort    string      `json:"destinationport,omitempty"`
+	State              string      `json:"state,omitempty"`
+	LastTransitionTime metav1.Time `json:"lasttransitiontime,omitempty"`
+	LastError          string      `json:"lasterror,omitempty"`
+	LastErrorTime      metav1.Time `json:"lasterrortime,omitempty"`
+}
+
+// ForwarderSourceStatus defines the observed state of the limits for a source, which is identified by GatewayIP
+type ForwarderSourceStatus struct {
+	GatewayIP   string `json:"gatewayip,omitempty"`
+	LimitStatus `json:",inline"`
+}
+
+// LimitStatus defines the observed state of the limits for a source
+type LimitStatus struct {
+	// ActiveConnections is the number of connections being forwarded
+	ActiveConnections int `json:"activeconnections,omitempty"`
+	// RejectedConnections is the number of connections rejected by MaxConnections
+	RejectedConnections int64 `json:"rejectedconnections,omitempty"`
+	// ThrottledTransfers is the number of transfers delayed by BytesPerSecond
+	ThrottledTransfers int64 `json:"throttledtransfers,omitempty"`
 }
 
 const (
-	ConditionRuleUpdating status.ConditionType = "RuleUpdating"
-	ConditionRuleSyncing  status.ConditionType = "RuleSyncing"
+	// ConditionSynced is True if the rules for ObservedGeneration are synced and haven't drifted since then
+	ConditionSynced status.ConditionType = "Synced"
+	// ConditionReady is True if the synced rules are ready to forward connections
+	ConditionReady status.ConditionType = "Ready"
 )
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -3,6 +3,7 @@ package v1alpha1
 import (
 	"github.com/operator-framework/operator-sdk/pkg/status"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // Important: Run "operator-sdk generate k8s" to regenerate code after modifying this file
@@ -12,17 +13,60 @@ type GatewaySpec struct {
 	EgressRules  []GatewayRule `json:"egressrules"`
 	IngressRules []GatewayRule `json:"ingressrules"`
 	GatewayIP    string        `json:"gatewayip,omitempty"`
+	// Mode is the data-plane mode for ingress, which is Tunnel, Direct or Listener. Tunnel forwards access
+	// through remote ssh tunnels from forwarders, and Direct DNATs access to the service in the cluster
+	// directly, which requires the gateway to be able to route to it. Listener accepts access on
+	// userspace listeners, and relays it through the sessions of forwarders without DNAT. Defaults to Tunnel.
+	Mode string `json:"mode,omitempty"`
+	// DirectTarget is the destination of DNAT in Direct mode, which is Pod or ClusterIP. Defaults to Pod.
+	DirectTarget string `json:"directtarget,omitempty"`
+	// TLSRelayPort is the port that the mTLS relay server listens on for TLS transport, which can't be
+	// used as external ports of ingress. Defaults to 443.
+	TLSRelayPort string `json:"tlsrelayport,omitempty"`
 }
 
+const (
+	// GatewayModeTunnel forwards ingress through remote ssh tunnels from forwarders
+	GatewayModeTunnel = "Tunnel"
+	// GatewayModeDirect DNATs ingress to the service in the cluster directly
+	GatewayModeDirect = "Direct"
+	// GatewayModeListener accepts ingress on userspace listeners and relays it through forwarder sessions
+	GatewayModeListener = "Listener"
+	// DirectTargetPod DNATs ingress to the addresses of pods in endpoints of the service
+	DirectTargetPod = "Pod"
+	// DirectTargetClusterIP DNATs ingress to the cluster IP of the service
+	DirectTargetClusterIP = "ClusterIP"
+)
+
 type GatewayRule struct {
-	Protocol        string       `json:"protocol,omitempty"`
-	SourceIP        string       `json:"sourceip,omitempty"`
-	TargetPort      string       `json:"targetport,omitempty"`
-	DestinationPort string       `json:"destinationport,omitempty"`
-	DestinationIP   string       `json:"destinationip,omitempty"`
-	Forwarder       ForwarderRef `json:"forwarder"`
-	ForwarderIP     string       `json:"forwarderip,omitempty"`
-	RelayPort       string       `json:"relayport,omitempty"`
+	Protocol        string        `json:"protocol,omitempty"`
+	SourceIP        string        `json:"sourceip,omitempty"`
+	TargetPort      string        `json:"targetport,omitempty"`
+	DestinationPort string        `json:"destinationport,omitempty"`
+	DestinationIP   string        `json:"destinationip,omitempty"`
+	Forwarder       ForwarderRef  `json:"forwarder"`
+	ForwarderIP     string        `json:"forwarderip,omitempty"`
+	RelayPort       string        `json:"relayport,omitempty"`
+	Limits          *SourceLimits `json:"limits,omitempty"`
+	// DestinationIPs are all the destinations including DestinationIP, one of which is selected
+	// by TargetPolicy for each connection. It is set only if there are multiple destinations.
+	DestinationIPs []string           `json:"destinationips,omitempty"`
+	TargetPolicy   string             `json:"targetpolicy,omitempty"`
+	HealthCheck    *TargetHealthCheck `json:"healthcheck,omitempty"`
+	// TargetEndPort is the last port of the range from TargetPort. It is set only for a port range,
+	// then each port in the range is forwarded to the port with the same offset from DestinationPort.
+	TargetEndPort string `json:"targetendport,omitempty"`
+	// Transport is the transport from the forwarder, which is SSH, WireGuard or TLS. Defaults to SSH.
+	Transport string `json:"transport,omitempty"`
+	// WireGuardPublicKey is the public key of the forwarder for WireGuard
+	WireGuardPublicKey string `json:"wireguardpublickey,omitempty"`
+	// ProxyProtocol is the version of PROXY protocol header sent to the destination for egress rules,
+	// which tells the pod with SourceIP and SourcePod, namespace/name of it. No header is sent if empty.
+	ProxyProtocol string `json:"proxyprotocol,omitempty"`
+	SourcePod     string `json:"sourcepod,omitempty"`
+	// ExternalService is the external service that the rule belongs to, which labels metrics
+	// and has events recorded on it
+	ExternalService ExternalServiceRef `json:"externalservice,omitempty"`
 }
 
 type ForwarderRef struct {
@@ -30,11 +74,38 @@ type ForwarderRef struct {
 	Name      string `json:"name,omitempty"`
 }
 
+type ExternalServiceRef struct {
+	Namespace string    `json:"namespace,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	UID       types.UID `json:"uid,omitempty"`
+}
+
 // GatewayStatus defines the observed state of Gateway
 type GatewayStatus struct {
-	Conditions     status.Conditions `json:"conditions"`
-	RuleGeneration int               `json:"rulegeneration,omitempty"`
-	SyncGeneration int               `json:"syncgeneration,omitempty"`
+	Conditions status.Conditions `json:"conditions"`
+	// ObservedGeneration is metadata.generation of the gateway whose rules are synced last
+	ObservedGeneration int64                    `json:"observedGeneration,omitempty"`
+	ForwarderStatuses  []GatewayForwarderStatus `json:"forwarderstatuses,omitempty"`
+	// WireGuardPublicKey and WireGuardPort are the public key and the port of the gateway for WireGuard
+	WireGuardPublicKey string `json:"wireguardpublickey,omitempty"`
+	WireGuardPort      string `json:"wireguardport,omitempty"`
+	// IngressListeners are the userspace listeners for ingress in Listener mode
+	IngressListeners []GatewayListenerStatus `json:"ingresslisteners,omitempty"`
+}
+
+// GatewayListenerStatus defines the observed state of a userspace listener for ingress on Port,
+// and the number of forwarder sessions that it relays to
+type GatewayListenerStatus struct {
+	Port       string `json:"port"`
+	Forwarders int    `json:"forwarders"`
+}
+
+// GatewayForwarderStatus defines the observed state of the limits for a forwarder, which is identified by Forwarder.
+// ForwarderIP is the IP of the forwarder pod, which can be different from the source address seen by the gateway.
+type GatewayForwarderStatus struct {
+	Forwarder   ForwarderRef `json:"forwarder,omitempty"`
+	ForwarderIP string       `json:"forwarderip,omitempty"`
+	LimitStatus `json:",inline"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
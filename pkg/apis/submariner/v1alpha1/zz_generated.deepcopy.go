@@ -10,13 +10,29 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClientService) DeepCopyInto(out *ClientService) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClientService.
+func (in *ClientService) DeepCopy() *ClientService {
+	if in == nil {
+		return nil
+	}
+	out := new(ClientService)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ExternalService) DeepCopyInto(out *ExternalService) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -71,19 +87,67 @@ func (in *ExternalServiceList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExternalServiceRef) DeepCopyInto(out *ExternalServiceRef) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExternalServiceRef.
+func (in *ExternalServiceRef) DeepCopy() *ExternalServiceRef {
+	if in == nil {
+		return nil
+	}
+	out := new(ExternalServiceRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ExternalServiceSpec) DeepCopyInto(out *ExternalServiceSpec) {
 	*out = *in
 	if in.Sources != nil {
 		in, out := &in.Sources, &out.Sources
 		*out = make([]Source, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	if in.Ports != nil {
 		in, out := &in.Ports, &out.Ports
 		*out = make([]v1.ServicePort, len(*in))
 		copy(*out, *in)
 	}
+	if in.Replicas != nil {
+		in, out := &in.Replicas, &out.Replicas
+		*out = new(int32)
+		**out = **in
+	}
+	if in.ForwarderTemplate != nil {
+		in, out := &in.ForwarderTemplate, &out.ForwarderTemplate
+		*out = new(ForwarderTemplate)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ClientService != nil {
+		in, out := &in.ClientService, &out.ClientService
+		*out = new(ClientService)
+		**out = **in
+	}
+	if in.TargetIPs != nil {
+		in, out := &in.TargetIPs, &out.TargetIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.HealthCheck != nil {
+		in, out := &in.HealthCheck, &out.HealthCheck
+		*out = new(TargetHealthCheck)
+		**out = **in
+	}
+	if in.PortRanges != nil {
+		in, out := &in.PortRanges, &out.PortRanges
+		*out = make([]PortRange, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -100,6 +164,18 @@ func (in *ExternalServiceSpec) DeepCopy() *ExternalServiceSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ExternalServiceStatus) DeepCopyInto(out *ExternalServiceStatus) {
 	*out = *in
+	if in.ResolvedAddresses != nil {
+		in, out := &in.ResolvedAddresses, &out.ResolvedAddresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.PortTargets != nil {
+		in, out := &in.PortTargets, &out.PortTargets
+		*out = make([]PortTargetStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	return
 }
 
@@ -194,6 +270,21 @@ func (in *ForwarderRef) DeepCopy() *ForwarderRef {
 func (in *ForwarderRule) DeepCopyInto(out *ForwarderRule) {
 	*out = *in
 	out.Gateway = in.Gateway
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = new(SourceLimits)
+		**out = **in
+	}
+	if in.DestinationIPs != nil {
+		in, out := &in.DestinationIPs, &out.DestinationIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.HealthCheck != nil {
+		in, out := &in.HealthCheck, &out.HealthCheck
+		*out = new(TargetHealthCheck)
+		**out = **in
+	}
 	return
 }
 
@@ -207,18 +298,57 @@ func (in *ForwarderRule) DeepCopy() *ForwarderRule {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ForwarderRuleStatus) DeepCopyInto(out *ForwarderRuleStatus) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+	in.LastErrorTime.DeepCopyInto(&out.LastErrorTime)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ForwarderRuleStatus.
+func (in *ForwarderRuleStatus) DeepCopy() *ForwarderRuleStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ForwarderRuleStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ForwarderSourceStatus) DeepCopyInto(out *ForwarderSourceStatus) {
+	*out = *in
+	out.LimitStatus = in.LimitStatus
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ForwarderSourceStatus.
+func (in *ForwarderSourceStatus) DeepCopy() *ForwarderSourceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ForwarderSourceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ForwarderSpec) DeepCopyInto(out *ForwarderSpec) {
 	*out = *in
 	if in.EgressRules != nil {
 		in, out := &in.EgressRules, &out.EgressRules
 		*out = make([]ForwarderRule, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	if in.IngressRules != nil {
 		in, out := &in.IngressRules, &out.IngressRules
 		*out = make([]ForwarderRule, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	return
 }
@@ -243,6 +373,25 @@ func (in *ForwarderStatus) DeepCopyInto(out *ForwarderStatus) {
 			(*out)[key] = *val.DeepCopy()
 		}
 	}
+	if in.EgressRuleStatuses != nil {
+		in, out := &in.EgressRuleStatuses, &out.EgressRuleStatuses
+		*out = make([]ForwarderRuleStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.IngressRuleStatuses != nil {
+		in, out := &in.IngressRuleStatuses, &out.IngressRuleStatuses
+		*out = make([]ForwarderRuleStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.SourceStatuses != nil {
+		in, out := &in.SourceStatuses, &out.SourceStatuses
+		*out = make([]ForwarderSourceStatus, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -256,6 +405,49 @@ func (in *ForwarderStatus) DeepCopy() *ForwarderStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ForwarderTemplate) DeepCopyInto(out *ForwarderTemplate) {
+	*out = *in
+	if in.ImagePullSecrets != nil {
+		in, out := &in.ImagePullSecrets, &out.ImagePullSecrets
+		*out = make([]v1.LocalObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	in.Resources.DeepCopyInto(&out.Resources)
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Tolerations != nil {
+		in, out := &in.Tolerations, &out.Tolerations
+		*out = make([]v1.Toleration, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ForwarderTemplate.
+func (in *ForwarderTemplate) DeepCopy() *ForwarderTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(ForwarderTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Gateway) DeepCopyInto(out *Gateway) {
 	*out = *in
@@ -284,6 +476,40 @@ func (in *Gateway) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayForwarderStatus) DeepCopyInto(out *GatewayForwarderStatus) {
+	*out = *in
+	out.Forwarder = in.Forwarder
+	out.LimitStatus = in.LimitStatus
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayForwarderStatus.
+func (in *GatewayForwarderStatus) DeepCopy() *GatewayForwarderStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayForwarderStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GatewayListenerStatus) DeepCopyInto(out *GatewayListenerStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GatewayListenerStatus.
+func (in *GatewayListenerStatus) DeepCopy() *GatewayListenerStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(GatewayListenerStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *GatewayList) DeepCopyInto(out *GatewayList) {
 	*out = *in
@@ -337,6 +563,22 @@ func (in *GatewayRef) DeepCopy() *GatewayRef {
 func (in *GatewayRule) DeepCopyInto(out *GatewayRule) {
 	*out = *in
 	out.Forwarder = in.Forwarder
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = new(SourceLimits)
+		**out = **in
+	}
+	if in.DestinationIPs != nil {
+		in, out := &in.DestinationIPs, &out.DestinationIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.HealthCheck != nil {
+		in, out := &in.HealthCheck, &out.HealthCheck
+		*out = new(TargetHealthCheck)
+		**out = **in
+	}
+	out.ExternalService = in.ExternalService
 	return
 }
 
@@ -356,12 +598,16 @@ func (in *GatewaySpec) DeepCopyInto(out *GatewaySpec) {
 	if in.EgressRules != nil {
 		in, out := &in.EgressRules, &out.EgressRules
 		*out = make([]GatewayRule, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	if in.IngressRules != nil {
 		in, out := &in.IngressRules, &out.IngressRules
 		*out = make([]GatewayRule, len(*in))
-		copy(*out, *in)
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
 	}
 	return
 }
@@ -386,6 +632,16 @@ func (in *GatewayStatus) DeepCopyInto(out *GatewayStatus) {
 			(*out)[key] = *val.DeepCopy()
 		}
 	}
+	if in.ForwarderStatuses != nil {
+		in, out := &in.ForwarderStatuses, &out.ForwarderStatuses
+		*out = make([]GatewayForwarderStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.IngressListeners != nil {
+		in, out := &in.IngressListeners, &out.IngressListeners
+		*out = make([]GatewayListenerStatus, len(*in))
+		copy(*out, *in)
+	}
 	return
 }
 
@@ -399,6 +655,75 @@ func (in *GatewayStatus) DeepCopy() *GatewayStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *IngressPort) DeepCopyInto(out *IngressPort) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new IngressPort.
+func (in *IngressPort) DeepCopy() *IngressPort {
+	if in == nil {
+		return nil
+	}
+	out := new(IngressPort)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LimitStatus) DeepCopyInto(out *LimitStatus) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LimitStatus.
+func (in *LimitStatus) DeepCopy() *LimitStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(LimitStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PortRange) DeepCopyInto(out *PortRange) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortRange.
+func (in *PortRange) DeepCopy() *PortRange {
+	if in == nil {
+		return nil
+	}
+	out := new(PortRange)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PortTargetStatus) DeepCopyInto(out *PortTargetStatus) {
+	*out = *in
+	if in.ResolvedAddresses != nil {
+		in, out := &in.ResolvedAddresses, &out.ResolvedAddresses
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortTargetStatus.
+func (in *PortTargetStatus) DeepCopy() *PortTargetStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PortTargetStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ServiceRef) DeepCopyInto(out *ServiceRef) {
 	*out = *in
@@ -419,6 +744,21 @@ func (in *ServiceRef) DeepCopy() *ServiceRef {
 func (in *Source) DeepCopyInto(out *Source) {
 	*out = *in
 	out.Service = in.Service
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = new(SourceLimits)
+		**out = **in
+	}
+	if in.Egress != nil {
+		in, out := &in.Egress, &out.Egress
+		*out = new(SourceEgress)
+		**out = **in
+	}
+	if in.Ingress != nil {
+		in, out := &in.Ingress, &out.Ingress
+		*out = new(SourceIngress)
+		(*in).DeepCopyInto(*out)
+	}
 	return
 }
 
@@ -431,3 +771,77 @@ func (in *Source) DeepCopy() *Source {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SourceEgress) DeepCopyInto(out *SourceEgress) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SourceEgress.
+func (in *SourceEgress) DeepCopy() *SourceEgress {
+	if in == nil {
+		return nil
+	}
+	out := new(SourceEgress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SourceIngress) DeepCopyInto(out *SourceIngress) {
+	*out = *in
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]IngressPort, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedCIDRs != nil {
+		in, out := &in.AllowedCIDRs, &out.AllowedCIDRs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SourceIngress.
+func (in *SourceIngress) DeepCopy() *SourceIngress {
+	if in == nil {
+		return nil
+	}
+	out := new(SourceIngress)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SourceLimits) DeepCopyInto(out *SourceLimits) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SourceLimits.
+func (in *SourceLimits) DeepCopy() *SourceLimits {
+	if in == nil {
+		return nil
+	}
+	out := new(SourceLimits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TargetHealthCheck) DeepCopyInto(out *TargetHealthCheck) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TargetHealthCheck.
+func (in *TargetHealthCheck) DeepCopy() *TargetHealthCheck {
+	if in == nil {
+		return nil
+	}
+	out := new(TargetHealthCheck)
+	in.DeepCopyInto(out)
+	return out
+}
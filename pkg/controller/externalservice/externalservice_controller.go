@@ -2,30 +2,55 @@ package externalservice
 
 import (
 	"context"
+	"time"
 
 	submarinerv1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
+	"github.com/mkimuram/k8s-ext-connector/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 var log = logf.Log.WithName("controller_externalservice")
 
+// ConnectorNamespace is the namespace to deploy forwarders for external services
+var ConnectorNamespace = DefaultConnectorNamespace
+
 const (
-	// ConnectorNamespace is the namespace to deploy external services
-	ConnectorNamespace = "external-services"
+	// DefaultConnectorNamespace is the default namespace to deploy forwarders for external services
+	DefaultConnectorNamespace = "external-services"
 	// ExternalServiceNamespaceLabel is the label for namespace of external service
-	ExternalServiceNamespaceLabel = "externalservice.submariner.io/namespace"
+	ExternalServiceNamespaceLabel = util.ExternalServiceNamespaceLabel
 	// ExternalServiceNameLabel is the label for name of external service
-	ExternalServiceNameLabel = "externalservice.submariner.io/name"
+	ExternalServiceNameLabel = util.ExternalServiceNameLabel
+	// ClientServiceLabel is the label for client service and its endpoints in the namespace of external service
+	ClientServiceLabel = "externalservice.submariner.io/client-service"
 	// ExternalServiceFinalizerName is the name of finalizer for external service
 	ExternalServiceFinalizerName = "finalizer.externalservice.submariner.io"
+	// ForwarderTemplateHashAnnotation is the annotation for hash of forwarder pod template in forwarder deployment
+	ForwarderTemplateHashAnnotation = "externalservice.submariner.io/template-hash"
+	// DefaultForwarderImage is the default container image of forwarder
+	DefaultForwarderImage = "docker.io/mkimuram/forwarder:v0.3.0"
+	// DefaultSSHKeySecretName is the default secret that contains ssh key for forwarder pods
+	DefaultSSHKeySecretName = "my-ssh-key"
+	// RelayCASecretName is the secret in ConnectorNamespace that contains the CA for TLS transport
+	RelayCASecretName = "relay-ca"
+	// RelayCAValidity is the validity of the CA for TLS transport
+	RelayCAValidity = 10 * 365 * 24 * time.Hour
+	// RelayCertValidity is the validity of certificates for TLS transport issued to forwarders and gateways.
+	// They are renewed when two thirds of it have passed.
+	RelayCertValidity = 90 * 24 * time.Hour
+	// MinResolveInterval is the minimum interval to resolve target host, which is used if TTL is shorter
+	MinResolveInterval = 5 * time.Second
 	// MinPort is the smallest port number that can be used by forwarder pod
 	MinPort = 2049
 	// MaxPort is the biggest port number that can be used by forwarder pod
@@ -35,12 +60,21 @@ const (
 // Add creates a new ExternalService Controller and adds it to the Manager. The Manager will set fields on the Controller
 // and Start it when the Manager is Started.
 func Add(mgr manager.Manager) error {
-	return add(mgr, newReconciler(mgr))
+	resolver, err := util.NewResolverFromResolvConf(util.DefaultResolvConf)
+	if err != nil {
+		return err
+	}
+	return add(mgr, newReconciler(mgr, resolver))
 }
 
 // newReconciler returns a new reconcile.Reconciler
-func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileExternalService{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+func newReconciler(mgr manager.Manager, resolver hostResolver) reconcile.Reconciler {
+	return &ReconcileExternalService{
+		client:   mgr.GetClient(),
+		scheme:   mgr.GetScheme(),
+		recorder: mgr.GetEventRecorderFor("externalservice-controller"),
+		resolver: resolver,
+	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -88,6 +122,37 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for forwarder deployment
+	// Cross-namespace owner references is not allowed, so using EnqueueRequestsFromMapFunc
+	err = c.Watch(&source.Kind{Type: &appsv1.Deployment{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			deploy := a.Object.(*appsv1.Deployment)
+			requests := []reconcile.Request{}
+
+			// Forwarder deployment exists only in ConnectorNamespace
+			if deploy.Namespace != ConnectorNamespace {
+				return requests
+			}
+
+			// Append external service to request only if the deployment has the labels
+			namespace, ok1 := deploy.Labels[ExternalServiceNamespaceLabel]
+			name, ok2 := deploy.Labels[ExternalServiceNameLabel]
+			if ok1 && ok2 {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{
+						Namespace: namespace,
+						Name:      name,
+					},
+				})
+			}
+
+			return requests
+		}),
+	})
+	if err != nil {
+		return err
+	}
+
 	// Watch for forwarder service
 	// Cross-namespace owner references is not allowed, so using EnqueueRequestsFromMapFunc
 	err = c.Watch(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestsFromMapFunc{
@@ -95,8 +160,8 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 			svc := a.Object.(*corev1.Service)
 			requests := []reconcile.Request{}
 
-			// Forwarder service exists only in ConnectorNamespace
-			if svc.Namespace != ConnectorNamespace {
+			// Forwarder service exists only in ConnectorNamespace, and client service has ClientServiceLabel
+			if _, ok := svc.Labels[ClientServiceLabel]; svc.Namespace != ConnectorNamespace && !ok {
 				return requests
 			}
 
@@ -125,6 +190,21 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 			ep := a.Object.(*corev1.Endpoints)
 			requests := []reconcile.Request{}
 
+			// Endpoints of client service is managed for the external service in the labels
+			if _, ok := ep.Labels[ClientServiceLabel]; ok {
+				namespace, ok1 := ep.Labels[ExternalServiceNamespaceLabel]
+				name, ok2 := ep.Labels[ExternalServiceNameLabel]
+				if ok1 && ok2 {
+					requests = append(requests, reconcile.Request{
+						NamespacedName: types.NamespacedName{
+							Namespace: namespace,
+							Name:      name,
+						},
+					})
+				}
+				return requests
+			}
+
 			// Get list of externalService
 			list := &submarinerv1alpha1.ExternalServiceList{}
 			opts := []client.ListOption{}
@@ -154,5 +234,101 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for gateway, only when it is created in Direct mode or with the port of mTLS relay server,
+	// its data-plane mode or the port of mTLS relay server is changed, or its public key or port for WireGuard is changed.
+	// Rules in gateways are updated by the operator itself, so other changes are ignored
+	err = c.Watch(&source.Kind{Type: &submarinerv1alpha1.Gateway{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			gw := a.Object.(*submarinerv1alpha1.Gateway)
+			requests := []reconcile.Request{}
+
+			// Get list of externalService
+			list := &submarinerv1alpha1.ExternalServiceList{}
+			opts := []client.ListOption{}
+			if err := mgr.GetClient().List(context.TODO(), list, opts...); err != nil {
+				return requests
+			}
+
+			// Append external services that have a source for the gateway
+			for _, es := range list.Items {
+				for _, source := range es.Spec.Sources {
+					if name, err := util.GetRuleName(source.SourceIP); err == nil && gw.Namespace == ConnectorNamespace && gw.Name == name {
+						requests = append(requests, reconcile.Request{
+							NamespacedName: types.NamespacedName{
+								Namespace: es.Namespace,
+								Name:      es.Name,
+							},
+						})
+						break
+					}
+				}
+			}
+
+			return requests
+		}),
+	}, predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			gw, ok := e.Object.(*submarinerv1alpha1.Gateway)
+			return ok && (gw.Spec.Mode == submarinerv1alpha1.GatewayModeDirect || gw.Spec.TLSRelayPort != "")
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldGw, ok1 := e.ObjectOld.(*submarinerv1alpha1.Gateway)
+			newGw, ok2 := e.ObjectNew.(*submarinerv1alpha1.Gateway)
+			return ok1 && ok2 && (oldGw.Spec.Mode != newGw.Spec.Mode || oldGw.Spec.DirectTarget != newGw.Spec.DirectTarget ||
+				oldGw.Spec.TLSRelayPort != newGw.Spec.TLSRelayPort ||
+				oldGw.Status.WireGuardPublicKey != newGw.Status.WireGuardPublicKey || oldGw.Status.WireGuardPort != newGw.Status.WireGuardPort)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Watch for forwarder, only when its public key for WireGuard is changed, which is passed to gateways.
+	// Rules in forwarders are updated by the operator itself, so other changes are ignored
+	err = c.Watch(&source.Kind{Type: &submarinerv1alpha1.Forwarder{}}, &handler.EnqueueRequestsFromMapFunc{
+		ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
+			fwd := a.Object.(*submarinerv1alpha1.Forwarder)
+			requests := []reconcile.Request{}
+
+			// Append external service to request only if the forwarder has the labels
+			namespace, ok1 := fwd.Labels[ExternalServiceNamespaceLabel]
+			name, ok2 := fwd.Labels[ExternalServiceNameLabel]
+			if ok1 && ok2 {
+				requests = append(requests, reconcile.Request{
+					NamespacedName: types.NamespacedName{
+						Namespace: namespace,
+						Name:      name,
+					},
+				})
+			}
+
+			return requests
+		}),
+	}, predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return false
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldFwd, ok1 := e.ObjectOld.(*submarinerv1alpha1.Forwarder)
+			newFwd, ok2 := e.ObjectNew.(*submarinerv1alpha1.Forwarder)
+			return ok1 && ok2 && oldFwd.Status.WireGuardPublicKey != newFwd.Status.WireGuardPublicKey
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	})
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -1,18 +1,29 @@
 package externalservice
 
 import (
+	"bytes"
 	"context"
+	"crypto/x509"
 	"fmt"
+	"net"
+	"reflect"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	submarinerv1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
 	"github.com/mkimuram/k8s-ext-connector/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
@@ -20,12 +31,31 @@ import (
 // blank assignment to verify that ReconcileExternalService implements reconcile.Reconciler
 var _ reconcile.Reconciler = &ReconcileExternalService{}
 
+var (
+	errForwarderNoIP      = fmt.Errorf("forwarder pod has no IP address assigned")
+	errRelayPortExhausted = fmt.Errorf("RelayPort exhausted")
+	errForwarderInCache   = fmt.Errorf("Deleted forwader CR still exists in cache")
+	errNoTarget           = fmt.Errorf("either targetIP, targetIPs or targetHost must be specified")
+)
+
+const (
+	// maxPort is the biggest port number of TCP and UDP
+	maxPort = 65535
+)
+
+// hostResolver resolves host names to addresses with the duration until they expire
+type hostResolver interface {
+	Resolve(host string) ([]string, time.Duration, error)
+}
+
 // ReconcileExternalService reconciles a ExternalService object
 type ReconcileExternalService struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
-	client client.Client
-	scheme *runtime.Scheme
+	client   client.Client
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+	resolver hostResolver
 }
 
 // Reconcile reads that state of the cluster for a ExternalService object and makes changes based on the state read
@@ -55,6 +85,7 @@ func (r *ReconcileExternalService) Reconcile(request reconcile.Request) (reconci
 	if instance.GetDeletionTimestamp() != nil {
 		// Clean up related resources
 		if err := r.deleteResourceForExternalService(instance); err != nil {
+			r.recordError(instance, util.ReasonFailedCleanup, err)
 			return reconcile.Result{}, err
 		}
 
@@ -72,20 +103,84 @@ func (r *ReconcileExternalService) Reconcile(request reconcile.Request) (reconci
 		return reconcile.Result{}, err
 	}
 
-	// Define a new forwarder Pod object
-	pod := genForwardPodSpec(instance)
+	// Resolve target host, which needs to be resolved again after resolveAfter
+	resolveAfter, err := r.resolveTargetHost(instance)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
 
-	// Check if this Pod already exists
-	foundPod := &corev1.Pod{}
-	err = r.client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, foundPod)
+	// Resolve target hosts of port ranges, which also need to be resolved again
+	if err := validatePortRanges(instance); err != nil {
+		r.recordError(instance, util.ReasonFailedUpdateRules, err)
+		return reconcile.Result{}, err
+	}
+	if err := validateSources(r.client, instance); err != nil {
+		r.recordError(instance, util.ReasonFailedUpdateRules, err)
+		return reconcile.Result{}, err
+	}
+	if err := validateTransport(instance); err != nil {
+		r.recordError(instance, util.ReasonFailedUpdateRules, err)
+		return reconcile.Result{}, err
+	}
+	portResolveAfter, err := r.resolvePortTargets(instance)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if portResolveAfter > 0 && (resolveAfter == 0 || portResolveAfter < resolveAfter) {
+		resolveAfter = portResolveAfter
+	}
+
+	// Ensure certificates for TLS transport, which need to be renewed after renewAfter
+	renewAfter, err := r.ensureRelayCertificates(instance)
+	if err != nil {
+		r.recordError(instance, util.ReasonFailedCreate, err)
+		return reconcile.Result{}, err
+	}
+	if renewAfter > 0 && (resolveAfter == 0 || renewAfter < resolveAfter) {
+		resolveAfter = renewAfter
+	}
+
+	// Ensure RBAC for forwarder pods
+	if err := r.ensureForwarderRBAC(instance); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// Define a new forwarder deployment object
+	deploy := genForwardDeploymentSpec(instance)
+
+	// Check if this deployment already exists
+	foundDeploy := &appsv1.Deployment{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: deploy.Name, Namespace: deploy.Namespace}, foundDeploy)
 	if err != nil && !errors.IsNotFound(err) {
 		return reconcile.Result{}, err
 	} else if err != nil {
-		reqLogger.Info("Creating a new Pod", "Pod.Namespace", pod.Namespace, "Pod.Name", pod.Name)
-		err = r.client.Create(context.TODO(), pod)
+		reqLogger.Info("Creating a new deployment", "Deployment.Namespace", deploy.Namespace, "Deployment.Name", deploy.Name)
+		err = r.client.Create(context.TODO(), deploy)
 		if err != nil {
+			r.recordError(instance, util.ReasonFailedCreate, err)
 			return reconcile.Result{}, err
 		}
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, util.ReasonCreated, "Created forwarder deployment %s/%s", deploy.Namespace, deploy.Name)
+	} else if err := r.updateDeployment(instance, foundDeploy, deploy); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// Define a new forwarder pod disruption budget object
+	pdb := genForwardPDBSpec(instance)
+
+	// Check if this pod disruption budget already exists
+	foundPDB := &policyv1beta1.PodDisruptionBudget{}
+	err = r.client.Get(context.TODO(), types.NamespacedName{Name: pdb.Name, Namespace: pdb.Namespace}, foundPDB)
+	if err != nil && !errors.IsNotFound(err) {
+		return reconcile.Result{}, err
+	} else if err != nil {
+		reqLogger.Info("Creating a new pod disruption budget", "PodDisruptionBudget.Namespace", pdb.Namespace, "PodDisruptionBudget.Name", pdb.Name)
+		err = r.client.Create(context.TODO(), pdb)
+		if err != nil {
+			r.recordError(instance, util.ReasonFailedCreate, err)
+			return reconcile.Result{}, err
+		}
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, util.ReasonCreated, "Created forwarder pod disruption budget %s/%s", pdb.Namespace, pdb.Name)
 	}
 
 	// Define a new forwarder service object
@@ -100,23 +195,675 @@ func (r *ReconcileExternalService) Reconcile(request reconcile.Request) (reconci
 		reqLogger.Info("Creating a new service", "Service.Namespace", service.Namespace, "Serivce.Name", service.Name)
 		err = r.client.Create(context.TODO(), service)
 		if err != nil {
+			r.recordError(instance, util.ReasonFailedCreate, err)
 			return reconcile.Result{}, err
 		}
+		r.recorder.Eventf(instance, corev1.EventTypeNormal, util.ReasonCreated, "Created forwarder service %s/%s", service.Namespace, service.Name)
+	} else if err := r.updateService(instance, foundSvc, service); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// Ensure client service in the namespace of external service
+	if err := r.ensureClientService(instance); err != nil {
+		return reconcile.Result{}, err
 	}
 
 	// Update forwarder CRD
-	err = updateForwarderRules(r.client, instance)
+	deleted, err := updateForwarderRules(r.client, instance)
 	if err != nil {
+		r.recordError(instance, rulesErrorReason(err), err)
 		return reconcile.Result{}, err
 	}
 
 	// Update Gateway CRD
-	err = updateGatewayRules(r.client, instance)
+	err = updateGatewayRules(r.client, instance, deleted)
 	if err != nil {
+		r.recordError(instance, rulesErrorReason(err), err)
 		return reconcile.Result{}, err
 	}
 
-	return reconcile.Result{}, nil
+	return reconcile.Result{RequeueAfter: resolveAfter}, nil
+}
+
+// resolveTargetHost resolves cr.Spec.TargetHost, and updates the resolved addresses and the target IP in status of {cr}.
+// The duration until the resolved addresses expire is returned, or zero if TargetHost isn't specified.
+func (r *ReconcileExternalService) resolveTargetHost(cr *submarinerv1alpha1.ExternalService) (time.Duration, error) {
+	if cr.Spec.TargetHost == "" {
+		if cr.Spec.TargetIP == "" && len(cr.Spec.TargetIPs) == 0 {
+			r.recordError(cr, util.ReasonFailedUpdateRules, errNoTarget)
+			return 0, errNoTarget
+		}
+		if cr.Status.TargetIP == "" && cr.Status.ResolvedAddresses == nil {
+			return 0, nil
+		}
+		// TargetHost is removed, so clear the status for it
+		cr.Status.ResolvedAddresses = nil
+		cr.Status.TargetIP = ""
+		if err := r.client.Status().Update(context.TODO(), cr); err != nil {
+			r.recordError(cr, util.ReasonFailedUpdate, err)
+			return 0, err
+		}
+		return 0, nil
+	}
+
+	addrs, ttl, err := r.resolver.Resolve(cr.Spec.TargetHost)
+	if err != nil {
+		r.recordError(cr, util.ReasonFailedResolve, err)
+		// Keep the last resolved target, not to break rules by a transient failure, and retry later
+		if cr.Status.TargetIP != "" {
+			return MinResolveInterval, nil
+		}
+		return 0, err
+	}
+	if ttl < MinResolveInterval {
+		ttl = MinResolveInterval
+	}
+
+	// Keep the current target while it is resolved, not to switch targets by round robin DNS
+	targetIP := addrs[0]
+	for _, addr := range addrs {
+		if addr == cr.Status.TargetIP {
+			targetIP = addr
+		}
+	}
+	if targetIP == cr.Status.TargetIP && reflect.DeepEqual(addrs, cr.Status.ResolvedAddresses) {
+		return ttl, nil
+	}
+
+	log.Info("Updating resolved addresses", "TargetHost", cr.Spec.TargetHost, "ResolvedAddresses", addrs, "TargetIP", targetIP)
+	oldTargetIP := cr.Status.TargetIP
+	cr.Status.ResolvedAddresses = addrs
+	cr.Status.TargetIP = targetIP
+	if err := r.client.Status().Update(context.TODO(), cr); err != nil {
+		r.recordError(cr, util.ReasonFailedUpdate, err)
+		return 0, err
+	}
+	if targetIP != oldTargetIP {
+		r.recorder.Eventf(cr, corev1.EventTypeNormal, util.ReasonResolved, "Resolved target host %s to %s", cr.Spec.TargetHost, targetIP)
+	}
+
+	return ttl, nil
+}
+
+// validatePortRanges checks that all the ports in port ranges of {cr} are valid
+func validatePortRanges(cr *submarinerv1alpha1.ExternalService) error {
+	for _, pr := range cr.Spec.PortRanges {
+		start, end, tStart, tEnd := portRangeBounds(pr)
+		if start < 1 || end > maxPort || start > end || tStart < 1 || tEnd > maxPort {
+			return fmt.Errorf("invalid port range %d-%d with target port %d", pr.Port, pr.EndPort, pr.TargetPort)
+		}
+	}
+
+	return nil
+}
+
+// validateSources checks that directions and ingress of all the sources of {cr} are valid. External ports
+// of ingress can't be the port of mTLS relay server of the gateway, if the gateway runs it for TLS transport.
+// PROXY protocol can't be used for ingress through the gateway in Direct mode, because it doesn't go through
+// forwarders that send the header, and the client is hidden by SNAT to the gateway IP.
+func validateSources(cl client.Client, cr *submarinerv1alpha1.ExternalService) error {
+	for _, src := range cr.Spec.Sources {
+		switch src.Direction {
+		case "", submarinerv1alpha1.DirectionEgress, submarinerv1alpha1.DirectionIngress, submarinerv1alpha1.DirectionBoth:
+		default:
+			return fmt.Errorf("invalid direction %q of source %s/%s", src.Direction, src.Service.Namespace, src.Service.Name)
+		}
+		if version := egressProxyProtocol(src); version != "" {
+			if !util.IsProxyProtocolVersion(version) {
+				return fmt.Errorf("invalid proxy protocol %q for egress of source %s/%s", version, src.Service.Namespace, src.Service.Name)
+			}
+			// Packets routed through WireGuard are never relayed by gateways, so they can't add header
+			if isWireGuard(cr) {
+				return fmt.Errorf("proxy protocol for egress of source %s/%s is not supported by %s transport", src.Service.Namespace, src.Service.Name, cr.Spec.Transport)
+			}
+		}
+		if src.Ingress == nil {
+			continue
+		}
+		gwName, err := util.GetRuleName(src.SourceIP)
+		if err != nil {
+			return err
+		}
+		gw := submarinerv1alpha1.GatewayRef{Namespace: ConnectorNamespace, Name: gwName}
+		tlsPort, tlsRules, err := getGatewayTLSRelay(cl, gw)
+		if err != nil {
+			return err
+		}
+		direct, err := isDirectGateway(cl, gw)
+		if err != nil {
+			return err
+		}
+		externalPorts := map[int32]bool{}
+		for _, port := range src.Ingress.Ports {
+			externalPort := port.ExternalPort
+			if externalPort == 0 {
+				externalPort = port.Port
+			}
+			if port.Port < 1 || port.Port > maxPort || externalPort < 1 || externalPort > maxPort {
+				return fmt.Errorf("invalid ingress port %d with external port %d of source %s/%s", port.Port, port.ExternalPort, src.Service.Namespace, src.Service.Name)
+			}
+			if externalPorts[externalPort] {
+				return fmt.Errorf("duplicate external port %d of source %s/%s", externalPort, src.Service.Namespace, src.Service.Name)
+			}
+			if (isTLS(cr) || tlsRules) && strconv.Itoa(int(externalPort)) == tlsPort {
+				return fmt.Errorf("external port %d of source %s/%s conflicts with mTLS relay server of gateway %s", externalPort, src.Service.Namespace, src.Service.Name, gwName)
+			}
+			if port.ProxyProtocol != "" && !util.IsProxyProtocolVersion(port.ProxyProtocol) {
+				return fmt.Errorf("invalid proxy protocol %q for ingress port %d of source %s/%s", port.ProxyProtocol, port.Port, src.Service.Namespace, src.Service.Name)
+			}
+			if port.ProxyProtocol != "" && direct {
+				return fmt.Errorf("proxy protocol for ingress port %d of source %s/%s is not supported by gateway %s in %s mode", port.Port, src.Service.Namespace, src.Service.Name, gwName, submarinerv1alpha1.GatewayModeDirect)
+			}
+			externalPorts[externalPort] = true
+		}
+		for _, cidr := range src.Ingress.AllowedCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("invalid allowed CIDR %q of source %s/%s", cidr, src.Service.Namespace, src.Service.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateTransport checks that the transport of {cr} is valid, and that it can forward all the port ranges.
+// WireGuard forwards packets of a port range by DNAT without relaying, so they can't be forwarded to other ports.
+func validateTransport(cr *submarinerv1alpha1.ExternalService) error {
+	switch cr.Spec.Transport {
+	case "", submarinerv1alpha1.TransportSSH:
+		return nil
+	case submarinerv1alpha1.TransportTLS:
+		// TLS relays connections in the same way as SSH
+		return nil
+	case submarinerv1alpha1.TransportWireGuard:
+	default:
+		return fmt.Errorf("invalid transport %q", cr.Spec.Transport)
+	}
+
+	for _, pr := range cr.Spec.PortRanges {
+		start, end, tStart, _ := portRangeBounds(pr)
+		if start != end && start != tStart {
+			return fmt.Errorf("port range %d-%d with target port %d is not supported by %s transport", pr.Port, pr.EndPort, pr.TargetPort, cr.Spec.Transport)
+		}
+	}
+
+	return nil
+}
+
+// isWireGuard returns true if egress of {cr} is routed through WireGuard
+func isWireGuard(cr *submarinerv1alpha1.ExternalService) bool {
+	return cr.Spec.Transport == submarinerv1alpha1.TransportWireGuard
+}
+
+// isTLS returns true if connections of {cr} are relayed over mutual TLS
+func isTLS(cr *submarinerv1alpha1.ExternalService) bool {
+	return cr.Spec.Transport == submarinerv1alpha1.TransportTLS
+}
+
+// hasEgress returns true if access from pods of the service of {src} is forwarded
+func hasEgress(src submarinerv1alpha1.Source) bool {
+	return src.Direction != submarinerv1alpha1.DirectionIngress
+}
+
+// egressProxyProtocol returns the version of PROXY protocol header sent to the external servers for {src}
+func egressProxyProtocol(src submarinerv1alpha1.Source) string {
+	if src.Egress == nil {
+		return ""
+	}
+
+	return src.Egress.ProxyProtocol
+}
+
+// hasIngress returns true if access to the service of {src} is forwarded
+func hasIngress(src submarinerv1alpha1.Source) bool {
+	return src.Direction != submarinerv1alpha1.DirectionEgress
+}
+
+// portRangeBounds returns the first and the last ports of {pr}, and those of its target ports
+func portRangeBounds(pr submarinerv1alpha1.PortRange) (int32, int32, int32, int32) {
+	end := pr.EndPort
+	if end == 0 {
+		end = pr.Port
+	}
+	tStart := pr.TargetPort
+	if tStart == 0 {
+		tStart = pr.Port
+	}
+
+	return pr.Port, end, tStart, tStart + end - pr.Port
+}
+
+// portRangeProtocol returns the protocol of {pr}, which defaults to TCP
+func portRangeProtocol(pr submarinerv1alpha1.PortRange) corev1.Protocol {
+	if pr.Protocol == "" {
+		return corev1.ProtocolTCP
+	}
+
+	return pr.Protocol
+}
+
+// resolvePortTargets resolves target hosts of port ranges in {cr} that are DNS names, and updates
+// the resolved addresses in status of {cr}. The minimum duration until the resolved addresses expire
+// is returned, or zero if there are no such target hosts.
+func (r *ReconcileExternalService) resolvePortTargets(cr *submarinerv1alpha1.ExternalService) (time.Duration, error) {
+	var resolveAfter time.Duration
+	targets := []submarinerv1alpha1.PortTargetStatus{}
+	seen := map[string]bool{}
+	for _, pr := range cr.Spec.PortRanges {
+		if pr.TargetHost == "" || net.ParseIP(pr.TargetHost) != nil || seen[pr.TargetHost] {
+			continue
+		}
+		seen[pr.TargetHost] = true
+
+		addrs, ttl, err := r.resolver.Resolve(pr.TargetHost)
+		if err != nil {
+			r.recordError(cr, util.ReasonFailedResolve, err)
+			// Keep the last resolved addresses, not to break rules by a transient failure, and retry later
+			last := lastPortTarget(cr, pr.TargetHost)
+			if last == nil {
+				return 0, err
+			}
+			addrs, ttl = last.ResolvedAddresses, MinResolveInterval
+		}
+		if ttl < MinResolveInterval {
+			ttl = MinResolveInterval
+		}
+		if resolveAfter == 0 || ttl < resolveAfter {
+			resolveAfter = ttl
+		}
+		targets = append(targets, submarinerv1alpha1.PortTargetStatus{TargetHost: pr.TargetHost, ResolvedAddresses: addrs})
+	}
+	if len(targets) == 0 {
+		targets = nil
+	}
+	if reflect.DeepEqual(targets, cr.Status.PortTargets) {
+		return resolveAfter, nil
+	}
+
+	log.Info("Updating resolved addresses of port targets", "PortTargets", targets)
+	old := map[string][]string{}
+	for _, target := range cr.Status.PortTargets {
+		old[target.TargetHost] = target.ResolvedAddresses
+	}
+	cr.Status.PortTargets = targets
+	if err := r.client.Status().Update(context.TODO(), cr); err != nil {
+		r.recordError(cr, util.ReasonFailedUpdate, err)
+		return 0, err
+	}
+	for _, target := range targets {
+		if reflect.DeepEqual(old[target.TargetHost], target.ResolvedAddresses) {
+			continue
+		}
+		r.recorder.Eventf(cr, corev1.EventTypeNormal, util.ReasonResolved, "Resolved target host %s to %s", target.TargetHost, strings.Join(target.ResolvedAddresses, ","))
+	}
+
+	return resolveAfter, nil
+}
+
+// lastPortTarget returns the status of {host} resolved last time for port ranges of {cr}, or nil if it isn't resolved yet
+func lastPortTarget(cr *submarinerv1alpha1.ExternalService, host string) *submarinerv1alpha1.PortTargetStatus {
+	for i := range cr.Status.PortTargets {
+		if cr.Status.PortTargets[i].TargetHost == host {
+			return &cr.Status.PortTargets[i]
+		}
+	}
+
+	return nil
+}
+
+// getPortRangeTargetIPs returns IP addresses of the external servers for {pr} in {cr}.
+// The first one is the primary, which is the destination of the rules.
+func getPortRangeTargetIPs(cr *submarinerv1alpha1.ExternalService, pr submarinerv1alpha1.PortRange) []string {
+	if pr.TargetHost == "" {
+		return getTargetIPs(cr)
+	}
+	if net.ParseIP(pr.TargetHost) != nil {
+		return []string{pr.TargetHost}
+	}
+	for _, target := range cr.Status.PortTargets {
+		if target.TargetHost == pr.TargetHost {
+			return target.ResolvedAddresses
+		}
+	}
+
+	return []string{}
+}
+
+// getTargetIPs returns IP addresses of the external servers for {cr}.
+// The first one is the primary, which is the destination of the rules.
+func getTargetIPs(cr *submarinerv1alpha1.ExternalService) []string {
+	switch {
+	case cr.Spec.TargetHost != "":
+		if cr.Status.TargetIP == "" {
+			return []string{}
+		}
+		ips := []string{cr.Status.TargetIP}
+		for _, addr := range cr.Status.ResolvedAddresses {
+			if addr != cr.Status.TargetIP {
+				ips = append(ips, addr)
+			}
+		}
+		return ips
+	case len(cr.Spec.TargetIPs) > 0:
+		return cr.Spec.TargetIPs
+	case cr.Spec.TargetIP != "":
+		return []string{cr.Spec.TargetIP}
+	}
+
+	return []string{}
+}
+
+// getTargetIP returns the IP address of the primary external server for {cr}
+func getTargetIP(cr *submarinerv1alpha1.ExternalService) string {
+	if ips := getTargetIPs(cr); len(ips) > 0 {
+		return ips[0]
+	}
+
+	return ""
+}
+
+// setTargets sets the external servers {ips} for {cr} to {rule}, if there are multiple ones.
+// Gateways select one of them for each connection to DestinationIP.
+func setTargets(rule *submarinerv1alpha1.ForwarderRule, cr *submarinerv1alpha1.ExternalService, ips []string) {
+	if len(ips) < 2 {
+		return
+	}
+	rule.DestinationIPs = append([]string{}, ips...)
+	rule.TargetPolicy = cr.Spec.TargetPolicy
+	if rule.TargetPolicy == "" {
+		rule.TargetPolicy = util.TargetPolicyRoundRobin
+	}
+	rule.HealthCheck = cr.Spec.HealthCheck.DeepCopy()
+}
+
+// object is an object in k8s API
+type object interface {
+	runtime.Object
+	metav1.Object
+}
+
+// createIfNotExist creates {obj} if it doesn't exist, otherwise the existing object is read into {found}.
+// True is returned if {obj} is created.
+func (r *ReconcileExternalService) createIfNotExist(cr *submarinerv1alpha1.ExternalService, obj, found object, kind string) (bool, error) {
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}, found)
+	if err == nil {
+		return false, nil
+	} else if !errors.IsNotFound(err) {
+		return false, err
+	}
+
+	log.Info("Creating a new "+kind, "Namespace", obj.GetNamespace(), "Name", obj.GetName())
+	if err := r.client.Create(context.TODO(), obj); err != nil {
+		r.recordError(cr, util.ReasonFailedCreate, err)
+		return false, err
+	}
+	r.recorder.Eventf(cr, corev1.EventTypeNormal, util.ReasonCreated, "Created forwarder %s %s/%s", kind, obj.GetNamespace(), obj.GetName())
+
+	return true, nil
+}
+
+// ensureForwarderRBAC ensures that forwarder pods for {cr} have a service account and roles
+// to access only their own forwarder CRs and to record events on {cr}
+func (r *ReconcileExternalService) ensureForwarderRBAC(cr *submarinerv1alpha1.ExternalService) error {
+	// Service account is created only if it isn't specified in forwarder template
+	if getForwarderTemplate(cr).ServiceAccountName == ForwarderName(cr) {
+		if _, err := r.createIfNotExist(cr, genForwardServiceAccountSpec(cr), &corev1.ServiceAccount{}, "service account"); err != nil {
+			return err
+		}
+	}
+
+	// Forwarder pods access forwarder CRs with the same names as them
+	pods, err := getForwarderPods(r.client, cr)
+	if err != nil {
+		return err
+	}
+	fwdNames := []string{}
+	for _, pod := range pods {
+		fwdNames = append(fwdNames, pod.Name)
+	}
+	role := genForwardRoleSpec(cr, fwdNames)
+	if err := r.ensureRole(cr, role); err != nil {
+		return err
+	}
+	if err := r.ensureRoleBinding(cr, genForwardRoleBindingSpec(cr, role)); err != nil {
+		return err
+	}
+
+	eventsRole := genForwardEventsRoleSpec(cr)
+	if err := r.ensureRole(cr, eventsRole); err != nil {
+		return err
+	}
+	if err := r.ensureRoleBinding(cr, genForwardRoleBindingSpec(cr, eventsRole)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ensureRole creates {role}, or updates rules of the existing one to those of {role}
+func (r *ReconcileExternalService) ensureRole(cr *submarinerv1alpha1.ExternalService, role *rbacv1.Role) error {
+	found := &rbacv1.Role{}
+	created, err := r.createIfNotExist(cr, role, found, "role")
+	if err != nil || created {
+		return err
+	}
+	if reflect.DeepEqual(found.Rules, role.Rules) {
+		return nil
+	}
+
+	log.Info("Updating role", "Namespace", role.Namespace, "Name", role.Name, "Rules", role.Rules)
+	found.Rules = role.Rules
+	if err := r.client.Update(context.TODO(), found); err != nil {
+		r.recordError(cr, util.ReasonFailedUpdate, err)
+		return err
+	}
+
+	return nil
+}
+
+// ensureRoleBinding creates {rb}, or updates subjects of the existing one to those of {rb}
+func (r *ReconcileExternalService) ensureRoleBinding(cr *submarinerv1alpha1.ExternalService, rb *rbacv1.RoleBinding) error {
+	found := &rbacv1.RoleBinding{}
+	created, err := r.createIfNotExist(cr, rb, found, "role binding")
+	if err != nil || created {
+		return err
+	}
+	if reflect.DeepEqual(found.Subjects, rb.Subjects) {
+		return nil
+	}
+
+	log.Info("Updating role binding", "Namespace", rb.Namespace, "Name", rb.Name, "Subjects", rb.Subjects)
+	found.Subjects = rb.Subjects
+	if err := r.client.Update(context.TODO(), found); err != nil {
+		r.recordError(cr, util.ReasonFailedUpdate, err)
+		return err
+	}
+
+	return nil
+}
+
+// updateDeployment updates {found} forwarder deployment to {expected}, if replicas or pod template are changed.
+// Changes in pod template are detected by ForwarderTemplateHashAnnotation, or by drift from {expected}
+// made by others. The update rolls forwarder pods.
+func (r *ReconcileExternalService) updateDeployment(cr *submarinerv1alpha1.ExternalService, found, expected *appsv1.Deployment) error {
+	reqLogger := log.WithValues("Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+
+	scaled := found.Spec.Replicas == nil || *found.Spec.Replicas != *expected.Spec.Replicas
+	templateChanged := found.Annotations[ForwarderTemplateHashAnnotation] != expected.Annotations[ForwarderTemplateHashAnnotation] ||
+		podTemplateDrifted(&found.Spec.Template, &expected.Spec.Template)
+	if !scaled && !templateChanged {
+		return nil
+	}
+
+	found.Spec.Replicas = expected.Spec.Replicas
+	if templateChanged {
+		if found.Annotations == nil {
+			found.Annotations = map[string]string{}
+		}
+		found.Annotations[ForwarderTemplateHashAnnotation] = expected.Annotations[ForwarderTemplateHashAnnotation]
+		found.Spec.Template = expected.Spec.Template
+	}
+	reqLogger.Info("Updating deployment", "Replicas", *expected.Spec.Replicas, "TemplateChanged", templateChanged)
+	if err := r.client.Update(context.TODO(), found); err != nil {
+		r.recordError(cr, util.ReasonFailedUpdate, err)
+		return err
+	}
+
+	if scaled {
+		r.recorder.Eventf(cr, corev1.EventTypeNormal, util.ReasonScaled, "Scaled forwarder deployment %s/%s to %d", found.Namespace, found.Name, *expected.Spec.Replicas)
+	}
+	if templateChanged {
+		r.recorder.Eventf(cr, corev1.EventTypeNormal, util.ReasonUpdated, "Updated pod template of forwarder deployment %s/%s", found.Namespace, found.Name)
+	}
+
+	return nil
+}
+
+// updateService updates ports, selector and labels of {found} forwarder service to those of {expected}, if they differ
+func (r *ReconcileExternalService) updateService(cr *submarinerv1alpha1.ExternalService, found, expected *corev1.Service) error {
+	if !serviceDrifted(found, expected) {
+		return nil
+	}
+
+	log.Info("Updating service", "Service.Namespace", found.Namespace, "Service.Name", found.Name, "Ports", expected.Spec.Ports)
+	if found.Labels == nil {
+		found.Labels = map[string]string{}
+	}
+	for k, v := range expected.Labels {
+		found.Labels[k] = v
+	}
+	found.Spec.Selector = expected.Spec.Selector
+	found.Spec.Ports = expected.Spec.Ports
+	if err := r.client.Update(context.TODO(), found); err != nil {
+		r.recordError(cr, util.ReasonFailedUpdate, err)
+		return err
+	}
+	r.recorder.Eventf(cr, corev1.EventTypeNormal, util.ReasonUpdated, "Updated forwarder service %s/%s", found.Namespace, found.Name)
+
+	return nil
+}
+
+// ensureClientService ensures that the client service and its endpoints for {cr} exist in the namespace of {cr},
+// if cr.Spec.ClientService is specified. Those with other names, which are left by name changes, are deleted.
+// Existing service or endpoints that aren't created for {cr} are never overwritten.
+func (r *ReconcileExternalService) ensureClientService(cr *submarinerv1alpha1.ExternalService) error {
+	keep := ""
+	if cr.Spec.ClientService != nil {
+		keep = clientServiceName(cr)
+	}
+	if err := r.deleteClientServices(cr, keep); err != nil {
+		return err
+	}
+	if cr.Spec.ClientService == nil {
+		return nil
+	}
+
+	service := genClientServiceSpec(cr)
+	foundSvc := &corev1.Service{}
+	created, err := r.createIfNotExist(cr, service, foundSvc, "client service")
+	if err != nil {
+		return err
+	}
+	if !created {
+		if !isManagedBy(foundSvc, cr) {
+			err := fmt.Errorf("service %s/%s already exists and isn't managed by the external service", foundSvc.Namespace, foundSvc.Name)
+			r.recordError(cr, util.ReasonFailedCreate, err)
+			return err
+		}
+		if err := r.updateService(cr, foundSvc, service); err != nil {
+			return err
+		}
+	}
+
+	pods, err := getForwarderPods(r.client, cr)
+	if err != nil {
+		return err
+	}
+	ep := genClientEndpointsSpec(cr, pods)
+	foundEp := &corev1.Endpoints{}
+	created, err = r.createIfNotExist(cr, ep, foundEp, "client endpoints")
+	if err != nil || created {
+		return err
+	}
+	if !isManagedBy(foundEp, cr) {
+		err := fmt.Errorf("endpoints %s/%s already exists and isn't managed by the external service", foundEp.Namespace, foundEp.Name)
+		r.recordError(cr, util.ReasonFailedCreate, err)
+		return err
+	}
+	if !endpointsDrifted(foundEp, ep) {
+		return nil
+	}
+
+	// Endpoints change whenever forwarder pods change, so no event is recorded
+	log.Info("Updating endpoints", "Endpoints.Namespace", foundEp.Namespace, "Endpoints.Name", foundEp.Name)
+	for k, v := range ep.Labels {
+		foundEp.Labels[k] = v
+	}
+	foundEp.Subsets = ep.Subsets
+	if err := r.client.Update(context.TODO(), foundEp); err != nil {
+		r.recordError(cr, util.ReasonFailedUpdate, err)
+		return err
+	}
+
+	return nil
+}
+
+// deleteClientServices deletes client services and their endpoints for {cr} except for those named {keep}
+func (r *ReconcileExternalService) deleteClientServices(cr *submarinerv1alpha1.ExternalService, keep string) error {
+	opts := []client.ListOption{
+		client.InNamespace(cr.Namespace),
+		client.MatchingLabels(genClientServiceLabels(cr)),
+	}
+
+	svcs := &corev1.ServiceList{}
+	if err := r.client.List(context.TODO(), svcs, opts...); err != nil {
+		return err
+	}
+	for i := range svcs.Items {
+		if svcs.Items[i].Name == keep {
+			continue
+		}
+		log.Info("Deleting client service", "Service.Namespace", svcs.Items[i].Namespace, "Service.Name", svcs.Items[i].Name)
+		if err := r.client.Delete(context.TODO(), &svcs.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	eps := &corev1.EndpointsList{}
+	if err := r.client.List(context.TODO(), eps, opts...); err != nil {
+		return err
+	}
+	for i := range eps.Items {
+		if eps.Items[i].Name == keep {
+			continue
+		}
+		if err := r.client.Delete(context.TODO(), &eps.Items[i]); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordError records a warning event for {err} on {cr} with {reason}.
+// Conflicts aren't recorded, because they are resolved soon by requeue.
+func (r *ReconcileExternalService) recordError(cr *submarinerv1alpha1.ExternalService, reason string, err error) {
+	if errors.IsConflict(err) {
+		return
+	}
+	r.recorder.Event(cr, corev1.EventTypeWarning, reason, err.Error())
+}
+
+// rulesErrorReason returns the reason of event for {err} returned on updating rules
+func rulesErrorReason(err error) string {
+	switch err {
+	case errForwarderNoIP:
+		return util.ReasonForwarderNoIP
+	case errRelayPortExhausted:
+		return util.ReasonRelayPortExhausted
+	}
+
+	return util.ReasonFailedUpdateRules
 }
 
 func genUsedPortsForEgress(fwd *submarinerv1alpha1.Forwarder) map[string]string {
@@ -144,7 +891,7 @@ func genRelayPortForEgress(srcIP string, tPort string, ePorts map[string]string)
 		}
 	}
 
-	return "", fmt.Errorf("RelayPort exhausted")
+	return "", errRelayPortExhausted
 }
 
 func genUsedPortsForIngress(gws *submarinerv1alpha1.GatewayList) map[string]map[string]string {
@@ -154,19 +901,26 @@ func genUsedPortsForIngress(gws *submarinerv1alpha1.GatewayList) map[string]map[
 			usedPorts[gw.Name] = map[string]string{}
 		}
 		for _, irule := range gw.Spec.IngressRules {
-			usedPorts[gw.Name][irule.RelayPort] = irule.SourceIP + ":" + irule.TargetPort
+			usedPorts[gw.Name][irule.RelayPort] = ingressPortKey(irule.Forwarder.Name, irule.SourceIP, irule.TargetPort)
 		}
 	}
 	return usedPorts
 }
 
-func genRelayPortForIngress(srcIP string, tPort string, gwName string, iPorts map[string]map[string]string) (string, error) {
+// ingressPortKey returns a key to identify a relay port for ingress in a gateway.
+// Each forwarder pod listens on its own relay port in the gateway, so it includes {fwdName}.
+func ingressPortKey(fwdName, srcIP, tPort string) string {
+	return fwdName + "/" + srcIP + ":" + tPort
+}
+
+func genRelayPortForIngress(fwdName string, srcIP string, tPort string, gwName string, iPorts map[string]map[string]string) (string, error) {
 	if _, ok := iPorts[gwName]; !ok {
 		iPorts[gwName] = map[string]string{}
 	}
+	key := ingressPortKey(fwdName, srcIP, tPort)
 	// Find relayPort from ePorts and return the value if already exists
 	for k, v := range iPorts[gwName] {
-		if v == srcIP+":"+tPort {
+		if v == key {
 			return k, nil
 		}
 	}
@@ -175,12 +929,12 @@ func genRelayPortForIngress(srcIP string, tPort string, gwName string, iPorts ma
 	for port := util.MinPort; port < util.MaxPort+1; port++ {
 		strPort := strconv.Itoa(port)
 		if _, ok := iPorts[gwName][strPort]; !ok {
-			iPorts[gwName][strPort] = srcIP + ":" + tPort
+			iPorts[gwName][strPort] = key
 			return strPort, nil
 		}
 	}
 
-	return "", fmt.Errorf("RelayPort exhausted")
+	return "", errRelayPortExhausted
 }
 
 func getEndpointAddrs(cl client.Client, ns string, name string) ([]string, error) {
@@ -197,115 +951,453 @@ func getEndpointAddrs(cl client.Client, ns string, name string) ([]string, error
 		}
 	}
 
-	return addrs, nil
+	return addrs, nil
+}
+
+// getEndpointPods returns namespace/name of pods in endpoints of the service keyed by their IPs
+func getEndpointPods(cl client.Client, ns string, name string) (map[string]string, error) {
+	pods := map[string]string{}
+
+	ep := &corev1.Endpoints{}
+	err := cl.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ns}, ep)
+	if err != nil && !errors.IsNotFound(err) {
+		return pods, err
+	}
+	for _, subset := range ep.Subsets {
+		for _, addr := range subset.Addresses {
+			if addr.TargetRef != nil && addr.TargetRef.Kind == "Pod" {
+				pods[addr.IP] = addr.TargetRef.Namespace + "/" + addr.TargetRef.Name
+			}
+		}
+	}
+
+	return pods, nil
+}
+
+func genForwarderEgressRules(cl client.Client, cr *submarinerv1alpha1.ExternalService, ePorts map[string]string) ([]submarinerv1alpha1.ForwarderRule, error) {
+	eRules := []submarinerv1alpha1.ForwarderRule{}
+
+	for _, src := range cr.Spec.Sources {
+		if !hasEgress(src) {
+			continue
+		}
+
+		// Create gateway ref from SourceIP
+		gwName, err := util.GetRuleName(src.SourceIP)
+		if err != nil {
+			return eRules, err
+		}
+		gw := submarinerv1alpha1.GatewayRef{
+			Namespace: ConnectorNamespace,
+			Name:      gwName,
+		}
+
+		// Get endpoint addresses for src
+		addrs, err := getEndpointAddrs(cl, src.Service.Namespace, src.Service.Name)
+		if err != nil {
+			return eRules, err
+		}
+
+		// Gateways tell pods to the external servers by PROXY protocol header, with their names if known
+		proxyProtocol := egressProxyProtocol(src)
+		pods := map[string]string{}
+		if proxyProtocol != "" {
+			pods, err = getEndpointPods(cl, src.Service.Namespace, src.Service.Name)
+			if err != nil {
+				return eRules, err
+			}
+		}
+
+		// Rules for WireGuard need the key and the port of the gateway instead of relay ports,
+		// and rules for TLS need the port of mTLS relay server of the gateway
+		wgKey, wgPort, tlsPort := "", "", ""
+		if isWireGuard(cr) {
+			wgKey, wgPort, err = getGatewayWireGuard(cl, gw)
+			if err != nil {
+				return eRules, err
+			}
+		}
+		if isTLS(cr) {
+			tlsPort, _, err = getGatewayTLSRelay(cl, gw)
+			if err != nil {
+				return eRules, err
+			}
+		}
+
+		for _, port := range cr.Spec.Ports {
+			for _, srcIP := range addrs {
+				rPort := ""
+				if !isWireGuard(cr) {
+					rPort, err = genRelayPortForEgress(srcIP, port.TargetPort.String(), ePorts)
+					if err != nil {
+						return eRules, err
+					}
+				}
+				er := submarinerv1alpha1.ForwarderRule{
+					Protocol:        string(port.Protocol),
+					SourceIP:        srcIP,
+					TargetPort:      port.TargetPort.String(),
+					DestinationPort: strconv.Itoa(int(port.Port)),
+					DestinationIP:   getTargetIP(cr),
+					Gateway:         gw,
+					GatewayIP:       src.SourceIP,
+					RelayPort:       rPort,
+					Limits:          src.Limits.DeepCopy(),
+					ProxyProtocol:   proxyProtocol,
+					SourcePod:       pods[srcIP],
+				}
+				setTargets(&er, cr, getTargetIPs(cr))
+				setTransport(&er, cr, wgKey, wgPort, tlsPort)
+				eRules = append(eRules, er)
+			}
+		}
+
+		// Each port range is forwarded by a rule with a relay port
+		for _, pr := range cr.Spec.PortRanges {
+			ips := getPortRangeTargetIPs(cr, pr)
+			if len(ips) == 0 {
+				continue
+			}
+			_, end, tStart, tEnd := portRangeBounds(pr)
+			tPort, tEndPort := strconv.Itoa(int(tStart)), ""
+			if end != pr.Port {
+				tEndPort = strconv.Itoa(int(tEnd))
+			}
+			for _, srcIP := range addrs {
+				rPort := ""
+				if !isWireGuard(cr) {
+					rPort, err = genRelayPortForEgress(srcIP, util.FormatPortRange(tPort, tEndPort), ePorts)
+					if err != nil {
+						return eRules, err
+					}
+				}
+				er := submarinerv1alpha1.ForwarderRule{
+					Protocol:        string(portRangeProtocol(pr)),
+					SourceIP:        srcIP,
+					TargetPort:      tPort,
+					TargetEndPort:   tEndPort,
+					DestinationPort: strconv.Itoa(int(pr.Port)),
+					DestinationIP:   ips[0],
+					Gateway:         gw,
+					GatewayIP:       src.SourceIP,
+					RelayPort:       rPort,
+					Limits:          src.Limits.DeepCopy(),
+					ProxyProtocol:   proxyProtocol,
+					SourcePod:       pods[srcIP],
+				}
+				setTargets(&er, cr, ips)
+				setTransport(&er, cr, wgKey, wgPort, tlsPort)
+				eRules = append(eRules, er)
+			}
+		}
+	}
+
+	return eRules, nil
+}
+
+func genForwarderIngressRules(cl client.Client, cr *submarinerv1alpha1.ExternalService, fwdName string, iPorts map[string]map[string]string) ([]submarinerv1alpha1.ForwarderRule, error) {
+	reqLogger := log.WithValues("Request.Namespace", cr.Namespace, "Request.Name", cr.Name)
+	reqLogger.Info("genForwarderIngressRules")
+	iRules := []submarinerv1alpha1.ForwarderRule{}
+
+	for _, src := range cr.Spec.Sources {
+		if !hasIngress(src) {
+			continue
+		}
+
+		// Create gateway ref from SourceIP
+		gwName, err := util.GetRuleName(src.SourceIP)
+		if err != nil {
+			return iRules, err
+		}
+		gw := submarinerv1alpha1.GatewayRef{
+			Namespace: ConnectorNamespace,
+			Name:      gwName,
+		}
+
+		// Gateway in Direct mode DNATs to the service by itself, so no remote ssh tunnels are needed
+		direct, err := isDirectGateway(cl, gw)
+		if err != nil {
+			return iRules, err
+		}
+		if direct {
+			continue
+		}
+		tlsPort := ""
+		if isTLS(cr) {
+			tlsPort, _, err = getGatewayTLSRelay(cl, gw)
+			if err != nil {
+				return iRules, err
+			}
+		}
+
+		svc := &corev1.Service{}
+		err = cl.Get(context.TODO(), types.NamespacedName{Name: src.Service.Name, Namespace: src.Service.Namespace}, svc)
+		if err != nil && !errors.IsNotFound(err) {
+			return iRules, err
+		}
+
+		// Access from any of the external servers and the allowed clients is forwarded
+		for _, clientIP := range getIngressClients(cr, src) {
+			for _, port := range getExposedPorts(src, svc) {
+				rPort, err := genRelayPortForIngress(fwdName, clientIP, strconv.Itoa(int(port.externalPort)), gwName, iPorts)
+				reqLogger.Info("genRelayPortForIngress", "clientIP", clientIP, "port", strconv.Itoa(int(port.externalPort)), "gwName", gwName, "rPort", rPort, "iPorts", iPorts)
+				if err != nil {
+					return iRules, err
+				}
+
+				ir := submarinerv1alpha1.ForwarderRule{
+					Protocol:        string(port.protocol),
+					SourceIP:        clientIP,
+					TargetPort:      strconv.Itoa(int(port.externalPort)),
+					DestinationPort: strconv.Itoa(int(port.port)),
+					DestinationIP:   svc.Spec.ClusterIP,
+					Gateway:         gw,
+					GatewayIP:       src.SourceIP,
+					RelayPort:       rPort,
+					Limits:          src.Limits.DeepCopy(),
+					ProxyProtocol:   port.proxyProtocol,
+				}
+				// WireGuard is only for egress, so ingress is relayed over TLS or SSH
+				if isTLS(cr) {
+					ir.Transport = submarinerv1alpha1.TransportTLS
+					ir.TLSRelayPort = tlsPort
+				}
+				iRules = append(iRules, ir)
+			}
+		}
+	}
+
+	return iRules, nil
+}
+
+// exposedPort is a port of the service of a source exposed on the source IP
+type exposedPort struct {
+	name          string
+	protocol      corev1.Protocol
+	port          int32
+	externalPort  int32
+	proxyProtocol string
+}
+
+// getExposedPorts returns ports of {svc} exposed for {src}, which are all the ports of {svc}
+// if they aren't selected by the ingress of {src}
+func getExposedPorts(src submarinerv1alpha1.Source, svc *corev1.Service) []exposedPort {
+	ports := []exposedPort{}
+	for _, svcPort := range svc.Spec.Ports {
+		if src.Ingress == nil || len(src.Ingress.Ports) == 0 {
+			ports = append(ports, exposedPort{name: svcPort.Name, protocol: svcPort.Protocol, port: svcPort.Port, externalPort: svcPort.Port})
+			continue
+		}
+		for _, ip := range src.Ingress.Ports {
+			if ip.Port != svcPort.Port {
+				continue
+			}
+			externalPort := ip.ExternalPort
+			if externalPort == 0 {
+				externalPort = ip.Port
+			}
+			ports = append(ports, exposedPort{name: svcPort.Name, protocol: svcPort.Protocol, port: svcPort.Port, externalPort: externalPort, proxyProtocol: ip.ProxyProtocol})
+		}
+	}
+
+	return ports
+}
+
+// getIngressClients returns addresses of clients allowed to access the service of {src},
+// which are the external servers and CIDRs allowed by the ingress of {src}
+func getIngressClients(cr *submarinerv1alpha1.ExternalService, src submarinerv1alpha1.Source) []string {
+	clients := getTargetIPs(cr)
+	if src.Ingress != nil {
+		clients = append(clients, src.Ingress.AllowedCIDRs...)
+	}
+
+	return clients
+}
+
+// getGatewayWireGuard returns the public key and the port for WireGuard published by the gateway referred by {ref}.
+// They are empty if the gateway doesn't exist or hasn't published them yet.
+func getGatewayWireGuard(cl client.Client, ref submarinerv1alpha1.GatewayRef) (string, string, error) {
+	gw := &submarinerv1alpha1.Gateway{}
+	if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, gw); err != nil {
+		if errors.IsNotFound(err) {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+
+	return gw.Status.WireGuardPublicKey, gw.Status.WireGuardPort, nil
+}
+
+// getGatewayTLSRelay returns the port of mTLS relay server of the gateway referred by {ref}, and whether
+// the gateway has any rules for TLS transport. The port is the default one if the gateway doesn't exist.
+func getGatewayTLSRelay(cl client.Client, ref submarinerv1alpha1.GatewayRef) (string, bool, error) {
+	gw := &submarinerv1alpha1.Gateway{}
+	if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, gw); err != nil {
+		if errors.IsNotFound(err) {
+			return util.TLSRelayPort, false, nil
+		}
+		return "", false, err
+	}
+
+	for _, rule := range append(append([]submarinerv1alpha1.GatewayRule{}, gw.Spec.EgressRules...), gw.Spec.IngressRules...) {
+		if rule.Transport == submarinerv1alpha1.TransportTLS {
+			return util.GetTLSRelayPort(gw), true, nil
+		}
+	}
+
+	return util.GetTLSRelayPort(gw), false, nil
+}
+
+// setTransport sets the transport of {cr} to {rule}, with the public key {wgKey} and the port {wgPort}
+// of the gateway for WireGuard, or the port {tlsPort} of mTLS relay server of the gateway for TLS
+func setTransport(rule *submarinerv1alpha1.ForwarderRule, cr *submarinerv1alpha1.ExternalService, wgKey, wgPort, tlsPort string) {
+	switch cr.Spec.Transport {
+	case submarinerv1alpha1.TransportTLS:
+		rule.Transport = submarinerv1alpha1.TransportTLS
+		rule.TLSRelayPort = tlsPort
+	case submarinerv1alpha1.TransportWireGuard:
+		rule.Transport = submarinerv1alpha1.TransportWireGuard
+		rule.WireGuardPublicKey = wgKey
+		rule.WireGuardPort = wgPort
+	}
+}
+
+// isDirectGateway returns true if the gateway referred by {ref} exists and is in Direct mode
+func isDirectGateway(cl client.Client, ref submarinerv1alpha1.GatewayRef) (bool, error) {
+	gw := &submarinerv1alpha1.Gateway{}
+	if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, gw); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return gw.Spec.Mode == submarinerv1alpha1.GatewayModeDirect, nil
 }
 
-func genForwarderEgressRules(cl client.Client, cr *submarinerv1alpha1.ExternalService, ePorts map[string]string) ([]submarinerv1alpha1.ForwarderRule, error) {
-	eRules := []submarinerv1alpha1.ForwarderRule{}
+// genLabels returns labels to identify resources for {cr}
+func genLabels(cr *submarinerv1alpha1.ExternalService) map[string]string {
+	return map[string]string{
+		ExternalServiceNamespaceLabel: cr.Namespace,
+		ExternalServiceNameLabel:      cr.Name,
+	}
+}
 
-	for _, src := range cr.Spec.Sources {
-		// Create gateway ref from SourceIP
-		gwName, err := util.GetRuleName(src.SourceIP)
-		if err != nil {
-			return eRules, err
-		}
-		gw := submarinerv1alpha1.GatewayRef{
-			Namespace: ConnectorNamespace,
-			Name:      gwName,
-		}
+// getForwarderPods returns forwarder pods for {cr} that aren't being deleted, sorted by name
+func getForwarderPods(cl client.Client, cr *submarinerv1alpha1.ExternalService) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	opts := []client.ListOption{
+		client.InNamespace(ConnectorNamespace),
+		client.MatchingLabels(genLabels(cr)),
+	}
+	if err := cl.List(context.TODO(), pods, opts...); err != nil {
+		return nil, err
+	}
 
-		// Get endpoint addresses for src
-		addrs, err := getEndpointAddrs(cl, src.Service.Namespace, src.Service.Name)
-		if err != nil {
-			return eRules, err
+	activePods := []corev1.Pod{}
+	for _, pod := range pods.Items {
+		if pod.GetDeletionTimestamp() != nil {
+			continue
 		}
+		activePods = append(activePods, pod)
+	}
+	sort.Slice(activePods, func(i, j int) bool { return activePods[i].Name < activePods[j].Name })
 
-		for _, port := range cr.Spec.Ports {
-			for _, srcIP := range addrs {
-				rPort, err := genRelayPortForEgress(srcIP, port.TargetPort.String(), ePorts)
-				if err != nil {
-					return eRules, err
-				}
-				er := submarinerv1alpha1.ForwarderRule{
-					Protocol:        string(port.Protocol),
-					SourceIP:        srcIP,
-					TargetPort:      port.TargetPort.String(),
-					DestinationPort: strconv.Itoa(int(port.Port)),
-					DestinationIP:   cr.Spec.TargetIP,
-					Gateway:         gw,
-					GatewayIP:       src.SourceIP,
-					RelayPort:       rPort,
-				}
-				eRules = append(eRules, er)
-			}
-		}
+	return activePods, nil
+}
+
+// getForwarders returns forwarder CRs for {cr}
+func getForwarders(cl client.Client, cr *submarinerv1alpha1.ExternalService) (*submarinerv1alpha1.ForwarderList, error) {
+	fwds := &submarinerv1alpha1.ForwarderList{}
+	opts := []client.ListOption{
+		client.InNamespace(ConnectorNamespace),
+		client.MatchingLabels(genLabels(cr)),
+	}
+	if err := cl.List(context.TODO(), fwds, opts...); err != nil {
+		return nil, err
 	}
 
-	return eRules, nil
+	return fwds, nil
 }
 
-func genForwarderIngressRules(cl client.Client, cr *submarinerv1alpha1.ExternalService, iPorts map[string]map[string]string) ([]submarinerv1alpha1.ForwarderRule, error) {
+// updateForwarderRules updates rules of forwarder CRs for {cr}.
+// Each forwarder pod has its own forwarder CR with the same name, and forwarder CRs
+// whose pods no longer exist are deleted. Names of deleted forwarder CRs are returned.
+func updateForwarderRules(cl client.Client, cr *submarinerv1alpha1.ExternalService) ([]string, error) {
 	reqLogger := log.WithValues("Request.Namespace", cr.Namespace, "Request.Name", cr.Name)
-	reqLogger.Info("genForwarderIngressRules")
-	iRules := []submarinerv1alpha1.ForwarderRule{}
+	reqLogger.Info("updateForwarderRules")
 
-	for _, src := range cr.Spec.Sources {
-		// Create gateway ref from SourceIP
-		gwName, err := util.GetRuleName(src.SourceIP)
-		if err != nil {
-			return iRules, err
-		}
-		gw := submarinerv1alpha1.GatewayRef{
-			Namespace: ConnectorNamespace,
-			Name:      gwName,
-		}
+	pods, err := getForwarderPods(cl, cr)
+	if err != nil {
+		return nil, err
+	}
+	podIPs := map[string]string{}
+	for _, pod := range pods {
+		podIPs[pod.Name] = pod.Status.PodIP
+	}
 
-		svc := &corev1.Service{}
-		err = cl.Get(context.TODO(), types.NamespacedName{Name: src.Service.Name, Namespace: src.Service.Namespace}, svc)
-		if err != nil && !errors.IsNotFound(err) {
-			return iRules, err
+	// Delete forwarder CRs for pods that no longer exist
+	fwds, err := getForwarders(cl, cr)
+	if err != nil {
+		return nil, err
+	}
+	deleted := []string{}
+	for i := range fwds.Items {
+		fwd := &fwds.Items[i]
+		if _, ok := podIPs[fwd.Name]; ok {
+			continue
 		}
+		if err := cl.Delete(context.TODO(), fwd); err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		reqLogger.Info("Delete forwarder for deleted pod", "forwarder", fwd.Name)
+		deleted = append(deleted, fwd.Name)
+	}
 
-		for _, svcPort := range svc.Spec.Ports {
-			rPort, err := genRelayPortForIngress(cr.Spec.TargetIP, strconv.Itoa(int(svcPort.Port)), gwName, iPorts)
-			reqLogger.Info("genRelayPortForIngress", "targetIP", cr.Spec.TargetIP, "port", strconv.Itoa(int(svcPort.Port)), "gwName", gwName, "rPort", rPort, "iPorts", iPorts)
-			if err != nil {
-				return iRules, err
-			}
+	// Get list of all gateways
+	gws := &submarinerv1alpha1.GatewayList{}
+	opts := []client.ListOption{}
+	if err := cl.List(context.TODO(), gws, opts...); err != nil {
+		return deleted, err
+	}
+	iPorts := genUsedPortsForIngress(gws)
 
-			ir := submarinerv1alpha1.ForwarderRule{
-				Protocol:        string(svcPort.Protocol),
-				SourceIP:        cr.Spec.TargetIP,
-				TargetPort:      strconv.Itoa(int(svcPort.Port)),
-				DestinationPort: strconv.Itoa(int(svcPort.Port)),
-				DestinationIP:   svc.Spec.ClusterIP,
-				Gateway:         gw,
-				GatewayIP:       src.SourceIP,
-				RelayPort:       rPort,
-			}
-			iRules = append(iRules, ir)
+	updated := 0
+	for _, pod := range pods {
+		if pod.Status.PodIP == "" {
+			// Rules for this pod will be updated once IP address is assigned
+			continue
+		}
+		if err := updateRulesForOneForwarder(cl, cr, pod.Name, pod.Status.PodIP, iPorts); err != nil {
+			return deleted, err
 		}
+		updated++
+	}
+	if updated == 0 {
+		return deleted, errForwarderNoIP
 	}
 
-	return iRules, nil
+	return deleted, nil
 }
 
-func updateForwarderRules(cl client.Client, cr *submarinerv1alpha1.ExternalService) error {
+// updateRulesForOneForwarder updates rules of forwarder CR named {name} for {cr},
+// which is handled by the forwarder pod with {fwdIP}
+func updateRulesForOneForwarder(cl client.Client, cr *submarinerv1alpha1.ExternalService, name string, fwdIP string, iPorts map[string]map[string]string) error {
 	reqLogger := log.WithValues("Request.Namespace", cr.Namespace, "Request.Name", cr.Name)
-	reqLogger.Info("updateForwarderRules")
 
 	fwd := &submarinerv1alpha1.Forwarder{}
-	// TODO: specify the right name
-	err := cl.Get(context.TODO(), types.NamespacedName{Name: cr.Name, Namespace: ConnectorNamespace}, fwd)
+	err := cl.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: ConnectorNamespace}, fwd)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Create a new empty forwarder CRD
 			fwd = &submarinerv1alpha1.Forwarder{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      cr.Name,
+					Name:      name,
 					Namespace: ConnectorNamespace,
 				},
 			}
+			setExternalServiceMeta(fwd, cr)
 			if err := cl.Create(context.TODO(), fwd); err != nil {
 				return err
 			}
@@ -313,65 +1405,53 @@ func updateForwarderRules(cl client.Client, cr *submarinerv1alpha1.ExternalServi
 			return err
 		}
 	}
-	// Update RuleUpdatingCondition to true
-	if fwd.Status.Conditions.SetCondition(util.RuleUpdatingCondition(corev1.ConditionTrue)) {
-		if err := cl.Status().Update(context.TODO(), fwd); err != nil {
-			return err
-		}
-		reqLogger.Info("Update RuleUpdatingCondition to true", "forwarder", fwd.Name)
-	}
-
-	// Get forwarderPod's IP
-	fwdPod := &corev1.Pod{}
-	err = cl.Get(context.TODO(), types.NamespacedName{Name: cr.Name, Namespace: ConnectorNamespace}, fwdPod)
-	if err != nil {
-		return err
-	}
-	if fwdPod.Status.PodIP == "" {
-		return fmt.Errorf("forwarder pod has no IP address assigned")
-	}
-
 	// Generate new rules
 	ePorts := genUsedPortsForEgress(fwd)
 	eRules, err := genForwarderEgressRules(cl, cr, ePorts)
 	if err != nil {
 		return err
 	}
-
-	// Get list of all gateways
-	gws := &submarinerv1alpha1.GatewayList{}
-	opts := []client.ListOption{}
-	if err := cl.List(context.TODO(), gws, opts...); err != nil {
-		return err
-	}
-	iPorts := genUsedPortsForIngress(gws)
-	iRules, err := genForwarderIngressRules(cl, cr, iPorts)
+	iRules, err := genForwarderIngressRules(cl, cr, fwd.Name, iPorts)
 	if err != nil {
 		return err
 	}
 
-	// Update with new rule
+	// Update with new rule, which bumps metadata.generation to make the forwarder sync it
+	setExternalServiceMeta(fwd, cr)
 	fwd.Spec.EgressRules = eRules
 	fwd.Spec.IngressRules = iRules
-	fwd.Spec.ForwarderIP = fwdPod.Status.PodIP
+	fwd.Spec.ForwarderIP = fwdIP
 	// TODO: skip updating if there are no changes
 	if err := cl.Update(context.TODO(), fwd); err != nil {
 		return err
 	}
 	reqLogger.Info("Update to new rule", "forwarder", fwd.Name, "egressRules", eRules, "ingressRules", iRules)
 
-	// Update RuleUpdatingCondition to false
-	updateChanged := fwd.Status.Conditions.SetCondition(util.RuleUpdatingCondition(corev1.ConditionFalse))
-	syncChanged := fwd.Status.Conditions.SetCondition(util.RuleSyncingCondition(corev1.ConditionUnknown))
-	if updateChanged || syncChanged {
-		fwd.Status.RuleGeneration++
-		if err := cl.Status().Update(context.TODO(), fwd); err != nil {
-			return err
-		}
-		reqLogger.Info("Update RuleUpdatingCondition to false and SyncingCondition to unknown", "forwarder", fwd.Name)
+	return nil
+}
+
+// setExternalServiceMeta sets labels and annotations for {cr} to {fwd},
+// so that forwarder and gateway can record events on {cr}
+func setExternalServiceMeta(fwd *submarinerv1alpha1.Forwarder, cr *submarinerv1alpha1.ExternalService) {
+	if fwd.Labels == nil {
+		fwd.Labels = map[string]string{}
+	}
+	fwd.Labels[util.ExternalServiceNamespaceLabel] = cr.Namespace
+	fwd.Labels[util.ExternalServiceNameLabel] = cr.Name
+	if fwd.Annotations == nil {
+		fwd.Annotations = map[string]string{}
 	}
+	fwd.Annotations[util.ExternalServiceUIDAnnotation] = string(cr.UID)
+}
 
-	return nil
+// externalServiceOf returns a reference to the external service that {fwd} belongs to,
+// which is found from the labels and the annotation of {fwd}
+func externalServiceOf(fwd *submarinerv1alpha1.Forwarder) submarinerv1alpha1.ExternalServiceRef {
+	return submarinerv1alpha1.ExternalServiceRef{
+		Namespace: fwd.Labels[util.ExternalServiceNamespaceLabel],
+		Name:      fwd.Labels[util.ExternalServiceNameLabel],
+		UID:       types.UID(fwd.Annotations[util.ExternalServiceUIDAnnotation]),
+	}
 }
 
 func genGatewayEgressRules(cl client.Client, fwds *submarinerv1alpha1.ForwarderList, gw *submarinerv1alpha1.Gateway) []submarinerv1alpha1.GatewayRule {
@@ -397,8 +1477,21 @@ func genGatewayEgressRules(cl client.Client, fwds *submarinerv1alpha1.ForwarderL
 					Namespace: fwd.Namespace,
 					Name:      fwd.Name,
 				},
-				ForwarderIP: fwd.Spec.ForwarderIP,
-				RelayPort:   rule.RelayPort,
+				ForwarderIP:     fwd.Spec.ForwarderIP,
+				RelayPort:       rule.RelayPort,
+				Limits:          rule.Limits.DeepCopy(),
+				DestinationIPs:  append([]string(nil), rule.DestinationIPs...),
+				TargetPolicy:    rule.TargetPolicy,
+				HealthCheck:     rule.HealthCheck.DeepCopy(),
+				TargetEndPort:   rule.TargetEndPort,
+				Transport:       rule.Transport,
+				ProxyProtocol:   rule.ProxyProtocol,
+				SourcePod:       rule.SourcePod,
+				ExternalService: externalServiceOf(&fwd),
+			}
+			// Gateway accepts packets for WireGuard from the forwarder by its public key
+			if rule.Transport == submarinerv1alpha1.TransportWireGuard {
+				eRule.WireGuardPublicKey = fwd.Status.WireGuardPublicKey
 			}
 			egressRules = append(egressRules, eRule)
 		}
@@ -426,8 +1519,11 @@ func genGatewayIngressRules(cl client.Client, fwds *submarinerv1alpha1.Forwarder
 					Namespace: fwd.Namespace,
 					Name:      fwd.Name,
 				},
-				ForwarderIP: fwd.Spec.ForwarderIP,
-				RelayPort:   rule.RelayPort,
+				ForwarderIP:     fwd.Spec.ForwarderIP,
+				RelayPort:       rule.RelayPort,
+				Limits:          rule.Limits.DeepCopy(),
+				Transport:       rule.Transport,
+				ExternalService: externalServiceOf(&fwd),
 			}
 			ingressRules = append(ingressRules, iRule)
 		}
@@ -436,45 +1532,130 @@ func genGatewayIngressRules(cl client.Client, fwds *submarinerv1alpha1.Forwarder
 	return ingressRules
 }
 
+// genGatewayDirectIngressRules returns ingress rules of {gw} in Direct mode for {gwIP}, which DNAT to
+// the services of sources for all the external services directly without forwarders
+func genGatewayDirectIngressRules(cl client.Client, gw *submarinerv1alpha1.Gateway, gwIP string) ([]submarinerv1alpha1.GatewayRule, error) {
+	ingressRules := []submarinerv1alpha1.GatewayRule{}
+
+	list := &submarinerv1alpha1.ExternalServiceList{}
+	if err := cl.List(context.TODO(), list); err != nil {
+		return ingressRules, err
+	}
+	// Sort external services, so that the order of rules in gateways is stable
+	sort.Slice(list.Items, func(i, j int) bool {
+		if list.Items[i].Namespace != list.Items[j].Namespace {
+			return list.Items[i].Namespace < list.Items[j].Namespace
+		}
+		return list.Items[i].Name < list.Items[j].Name
+	})
+
+	for i := range list.Items {
+		es := &list.Items[i]
+		if es.GetDeletionTimestamp() != nil {
+			continue
+		}
+		for _, src := range es.Spec.Sources {
+			if src.SourceIP != gwIP || !hasIngress(src) {
+				continue
+			}
+
+			svc := &corev1.Service{}
+			if err := cl.Get(context.TODO(), types.NamespacedName{Name: src.Service.Name, Namespace: src.Service.Namespace}, svc); err != nil {
+				if errors.IsNotFound(err) {
+					continue
+				}
+				return ingressRules, err
+			}
+
+			for _, clientIP := range getIngressClients(es, src) {
+				for _, port := range getExposedPorts(src, svc) {
+					dests, err := getDirectDestinations(cl, gw, svc, port)
+					if err != nil {
+						return ingressRules, err
+					}
+					for _, dest := range dests {
+						ingressRules = append(ingressRules, submarinerv1alpha1.GatewayRule{
+							Protocol:        string(port.protocol),
+							SourceIP:        clientIP,
+							TargetPort:      strconv.Itoa(int(port.externalPort)),
+							DestinationIP:   dest.ip,
+							DestinationPort: strconv.Itoa(int(dest.port)),
+							ExternalService: submarinerv1alpha1.ExternalServiceRef{Namespace: es.Namespace, Name: es.Name, UID: es.UID},
+						})
+					}
+				}
+			}
+		}
+	}
+
+	return ingressRules, nil
+}
+
+// directDestination is an address that ingress is DNATed to by a gateway in Direct mode
+type directDestination struct {
+	ip   string
+	port int32
+}
+
+// getDirectDestinations returns addresses that {port} of {svc} is DNATed to by {gw} in Direct mode,
+// which are the addresses of pods in the endpoints or the cluster IP by DirectTarget of {gw}
+func getDirectDestinations(cl client.Client, gw *submarinerv1alpha1.Gateway, svc *corev1.Service, port exposedPort) ([]directDestination, error) {
+	dests := []directDestination{}
+	if gw.Spec.DirectTarget == submarinerv1alpha1.DirectTargetClusterIP {
+		if svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != corev1.ClusterIPNone {
+			dests = append(dests, directDestination{ip: svc.Spec.ClusterIP, port: port.port})
+		}
+		return dests, nil
+	}
+
+	ep := &corev1.Endpoints{}
+	if err := cl.Get(context.TODO(), types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}, ep); err != nil {
+		if errors.IsNotFound(err) {
+			return dests, nil
+		}
+		return dests, err
+	}
+	// Ports of endpoints have the same names as the ports of the service
+	for _, subset := range ep.Subsets {
+		for _, epPort := range subset.Ports {
+			if epPort.Name != port.name || epPort.Protocol != port.protocol {
+				continue
+			}
+			for _, addr := range subset.Addresses {
+				dests = append(dests, directDestination{ip: addr.IP, port: epPort.Port})
+			}
+		}
+	}
+
+	return dests, nil
+}
+
 func updateRulesForOneGateway(cl client.Client, fwds *submarinerv1alpha1.ForwarderList, gw *submarinerv1alpha1.Gateway, gwIP string) error {
 	reqLogger := log.WithValues("Gateway.Namespace", gw.Namespace, "Gateway.Name", gw.Name)
 	reqLogger.Info("updateRulesForOneGateway")
-	// Update RuleUpdatingCondition to true
-	if gw.Status.Conditions.SetCondition(util.RuleUpdatingCondition(corev1.ConditionTrue)) {
-		if err := cl.Status().Update(context.TODO(), gw); err != nil {
+	// Generate new rules
+	gw.Spec.EgressRules = genGatewayEgressRules(cl, fwds, gw)
+	if gw.Spec.Mode == submarinerv1alpha1.GatewayModeDirect {
+		ingressRules, err := genGatewayDirectIngressRules(cl, gw, gwIP)
+		if err != nil {
 			return err
 		}
-		reqLogger.Info("Update RuleUpdatingCondition to true", "gateway", gw.Name)
+		gw.Spec.IngressRules = ingressRules
+	} else {
+		gw.Spec.IngressRules = genGatewayIngressRules(cl, fwds, gw)
 	}
-
-	// Generate new rules
-	gw.Spec.EgressRules = genGatewayEgressRules(cl, fwds, gw)
-	gw.Spec.IngressRules = genGatewayIngressRules(cl, fwds, gw)
 	gw.Spec.GatewayIP = gwIP
-	// Update with new rule
+	// Update with new rule, which bumps metadata.generation to make the gateway sync it
 	// TODO: skip updating if there are no changes
 	if err := cl.Update(context.TODO(), gw); err != nil {
 		return err
 	}
 	reqLogger.Info("Update to new rule", "gateway", gw.Name, "egressRules", gw.Spec.EgressRules, "ingressRules", gw.Spec.IngressRules)
 
-	// Update UpdatingCondition to false and SyncingCondition to unknown
-	updateChanged := gw.Status.Conditions.SetCondition(util.RuleUpdatingCondition(corev1.ConditionFalse))
-	syncChanged := gw.Status.Conditions.SetCondition(util.RuleSyncingCondition(corev1.ConditionUnknown))
-	if updateChanged || syncChanged {
-		gw.Status.RuleGeneration++
-		if err := cl.Status().Update(context.TODO(), gw); err != nil {
-			return err
-		}
-		reqLogger.Info("Update RuleUpdatingCondition to false and RuleSyncingCondition to unknown", "gateway", gw.Name)
-	}
-
 	return nil
 }
 
-func getUniqueGatwey(rules []submarinerv1alpha1.ForwarderRule) map[string]types.NamespacedName {
-	nMap := map[string]types.NamespacedName{}
-
+func getUniqueGatwey(rules []submarinerv1alpha1.ForwarderRule, nMap map[string]types.NamespacedName) map[string]types.NamespacedName {
 	// Make a map to remove duplicated
 	for _, rule := range rules {
 		val := rule.GatewayIP
@@ -491,27 +1672,59 @@ func getUniqueGatwey(rules []submarinerv1alpha1.ForwarderRule) map[string]types.
 	return nMap
 }
 
-func updateGatewayRules(cl client.Client, cr *submarinerv1alpha1.ExternalService) error {
+// updateGatewayRules updates rules of gateway CRs used by forwarder CRs for {cr}.
+// Forwarder CRs in {deleted} are ignored, even if they still remain in cache.
+func updateGatewayRules(cl client.Client, cr *submarinerv1alpha1.ExternalService, deleted []string) error {
 	reqLogger := log.WithValues("Request.Namespace", cr.Namespace, "Request.Name", cr.Name)
 	reqLogger.Info("updateGatewayRules")
 
-	// Get target forwarder to handle
-	fwd := &submarinerv1alpha1.Forwarder{}
-	// TODO: specify the right name
-	err := cl.Get(context.TODO(), types.NamespacedName{Name: cr.Name, Namespace: ConnectorNamespace}, fwd)
-	if err != nil {
-		// Return error even for not found case and wait for forwarder to be created
+	// Get list of all forwarders
+	allFwds := &submarinerv1alpha1.ForwarderList{}
+	opts := []client.ListOption{}
+	if err := cl.List(context.TODO(), allFwds, opts...); err != nil {
 		return err
 	}
 
-	// Get list of all forwarders
+	deletedMap := map[string]bool{}
+	for _, name := range deleted {
+		deletedMap[name] = true
+	}
 	fwds := &submarinerv1alpha1.ForwarderList{}
-	opts := []client.ListOption{}
-	if err := cl.List(context.TODO(), fwds, opts...); err != nil {
-		return err
+	gwMap := map[string]types.NamespacedName{}
+	for _, fwd := range allFwds.Items {
+		if fwd.Namespace == ConnectorNamespace && deletedMap[fwd.Name] {
+			continue
+		}
+		fwds.Items = append(fwds.Items, fwd)
+
+		// Get target gateways to handle from forwarders for cr
+		if fwd.Labels[ExternalServiceNamespaceLabel] == cr.Namespace && fwd.Labels[ExternalServiceNameLabel] == cr.Name {
+			getUniqueGatwey(fwd.Spec.EgressRules, gwMap)
+			getUniqueGatwey(fwd.Spec.IngressRules, gwMap)
+		}
+	}
+	// Gateways in Direct mode for ingress aren't referred by forwarders, so they are also handled
+	for _, src := range cr.Spec.Sources {
+		if _, ok := gwMap[src.SourceIP]; ok || !hasIngress(src) {
+			continue
+		}
+		gwName, err := util.GetRuleName(src.SourceIP)
+		if err != nil {
+			return err
+		}
+		ref := submarinerv1alpha1.GatewayRef{Namespace: ConnectorNamespace, Name: gwName}
+		direct, err := isDirectGateway(cl, ref)
+		if err != nil {
+			return err
+		}
+		if direct {
+			gwMap[src.SourceIP] = types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}
+		}
 	}
+	// Sort forwarders, so that the order of rules in gateways is stable
+	sort.Slice(fwds.Items, func(i, j int) bool { return fwds.Items[i].Name < fwds.Items[j].Name })
 
-	for gwIP, n := range getUniqueGatwey(fwd.Spec.IngressRules) {
+	for gwIP, n := range gwMap {
 		gw := &submarinerv1alpha1.Gateway{}
 		err := cl.Get(context.TODO(), n, gw)
 		if err != nil {
@@ -556,31 +1769,71 @@ func (r *ReconcileExternalService) addFinalizer(reqLogger logr.Logger, cr *subma
 
 // deleteResourceForExternalService deletes all related resource for the external service
 func (r *ReconcileExternalService) deleteResourceForExternalService(cr *submarinerv1alpha1.ExternalService) error {
-	// Delete pod
-	pod := &corev1.Pod{}
-	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: cr.Name, Namespace: ConnectorNamespace}, pod); err != nil && !errors.IsNotFound(err) {
+	// Delete deployment
+	deploy := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: ForwarderName(cr), Namespace: ConnectorNamespace}, deploy); err != nil && !errors.IsNotFound(err) {
+		return err
+	} else if err == nil {
+		// Deployment exists, so delete it with its pods
+		_ = r.client.Delete(context.Background(), deploy, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	}
+
+	// Delete pod disruption budget
+	pdb := &policyv1beta1.PodDisruptionBudget{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: ForwarderName(cr), Namespace: ConnectorNamespace}, pdb); err != nil && !errors.IsNotFound(err) {
 		return err
 	} else if err == nil {
-		// Pod exists, so delete it
-		_ = r.client.Delete(context.Background(), pod)
+		// Pod disruption budget exists, so delete it
+		_ = r.client.Delete(context.Background(), pdb)
+	}
+
+	// Delete RBAC for forwarder pods
+	for _, obj := range []object{
+		&corev1.ServiceAccount{},
+		&rbacv1.Role{},
+		&rbacv1.RoleBinding{},
+	} {
+		if err := r.deleteIfExist(types.NamespacedName{Name: ForwarderName(cr), Namespace: ConnectorNamespace}, obj); err != nil {
+			return err
+		}
+	}
+	for _, obj := range []object{
+		&rbacv1.Role{},
+		&rbacv1.RoleBinding{},
+	} {
+		if err := r.deleteIfExist(types.NamespacedName{Name: forwarderEventsRoleName(cr), Namespace: cr.Namespace}, obj); err != nil {
+			return err
+		}
+	}
+
+	// Delete certificate of forwarder pods for TLS transport.
+	// Certificates of gateways are kept, because they can be shared with other external services.
+	if err := r.deleteIfExist(types.NamespacedName{Name: util.TLSSecretName(ForwarderName(cr)), Namespace: ConnectorNamespace}, &corev1.Secret{}); err != nil {
+		return err
 	}
 
 	// Delete service
 	svc := &corev1.Service{}
-	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: cr.Name, Namespace: ConnectorNamespace}, svc); err != nil && !errors.IsNotFound(err) {
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: ForwarderName(cr), Namespace: ConnectorNamespace}, svc); err != nil && !errors.IsNotFound(err) {
 		return err
 	} else if err == nil {
 		// Service exists, so delete it
 		_ = r.client.Delete(context.Background(), svc)
 	}
 
-	// Delete forwarder CR
-	fwd := &submarinerv1alpha1.Forwarder{}
-	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: cr.Name, Namespace: ConnectorNamespace}, fwd); err != nil && !errors.IsNotFound(err) {
+	// Delete client service and its endpoints
+	if err := r.deleteClientServices(cr, ""); err != nil {
 		return err
-	} else if err == nil {
+	}
+
+	// Delete forwarder CRs
+	crFwds, err := getForwarders(r.client, cr)
+	if err != nil {
+		return err
+	}
+	for i := range crFwds.Items {
 		// Forwarder exists, so delete it
-		_ = r.client.Delete(context.Background(), fwd)
+		_ = r.client.Delete(context.Background(), &crFwds.Items[i])
 	}
 
 	// Update gateway CRs
@@ -591,10 +1844,10 @@ func (r *ReconcileExternalService) deleteResourceForExternalService(cr *submarin
 	if err := r.client.List(context.TODO(), fwds, opts...); err != nil {
 		return err
 	}
-	// Ensure that the deleted forwarder no longer exists, before updating gateway CRs
+	// Ensure that the deleted forwarders no longer exist, before updating gateway CRs
 	for _, fwd := range fwds.Items {
-		if fwd.Namespace == ConnectorNamespace && fwd.Name == cr.Name {
-			return fmt.Errorf("Deleted forwader CR still exists in cache")
+		if fwd.Namespace == ConnectorNamespace && fwd.Labels[ExternalServiceNamespaceLabel] == cr.Namespace && fwd.Labels[ExternalServiceNameLabel] == cr.Name {
+			return errForwarderInCache
 		}
 	}
 
@@ -611,3 +1864,116 @@ func (r *ReconcileExternalService) deleteResourceForExternalService(cr *submarin
 
 	return nil
 }
+
+// ensureRelayCertificates ensures certificates for TLS transport of {cr}, which are issued by the CA of
+// the operator to the forwarder as a client and to the gateways of the sources as servers.
+// It returns the duration after which any of them needs to be renewed, or 0 if TLS transport isn't used.
+func (r *ReconcileExternalService) ensureRelayCertificates(cr *submarinerv1alpha1.ExternalService) (time.Duration, error) {
+	if !isTLS(cr) {
+		return 0, nil
+	}
+
+	caCert, caKey, err := r.ensureRelayCA()
+	if err != nil {
+		return 0, err
+	}
+
+	renewAfter, err := r.ensureRelayCertificate(cr, ForwarderName(cr), nil /* ips */, x509.ExtKeyUsageClientAuth, caCert, caKey)
+	if err != nil {
+		return 0, err
+	}
+	for _, src := range cr.Spec.Sources {
+		gwName, err := util.GetRuleName(src.SourceIP)
+		if err != nil {
+			return 0, err
+		}
+		// Forwarders verify the gateway by its IP
+		after, err := r.ensureRelayCertificate(cr, gwName, []string{src.SourceIP}, x509.ExtKeyUsageServerAuth, caCert, caKey)
+		if err != nil {
+			return 0, err
+		}
+		if after < renewAfter {
+			renewAfter = after
+		}
+	}
+
+	return renewAfter, nil
+}
+
+// ensureRelayCA returns the certificate and the private key of the CA for TLS transport,
+// which is generated on the first call and kept in RelayCASecretName
+func (r *ReconcileExternalService) ensureRelayCA() ([]byte, []byte, error) {
+	secret := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: RelayCASecretName, Namespace: ConnectorNamespace}, secret)
+	if err == nil {
+		return secret.Data[util.TLSCAKey], secret.Data[util.TLSCAPrivateKeyKey], nil
+	} else if !errors.IsNotFound(err) {
+		return nil, nil, err
+	}
+
+	caCert, caKey, err := util.GenerateCA(RelayCASecretName, RelayCAValidity)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := r.client.Create(context.TODO(), genRelayCASecretSpec(caCert, caKey)); err != nil {
+		return nil, nil, err
+	}
+	log.Info("Created CA for TLS transport", "Secret.Namespace", ConnectorNamespace, "Secret.Name", RelayCASecretName)
+
+	return caCert, caKey, nil
+}
+
+// ensureRelayCertificate ensures the certificate for TLS transport of the forwarder or the gateway named {name},
+// which is issued for {usage} and {ips} by the CA of {caCert} and {caKey}. It is issued again if it is
+// issued by another CA or needs to be renewed. It returns the duration after which it needs to be renewed.
+func (r *ReconcileExternalService) ensureRelayCertificate(cr *submarinerv1alpha1.ExternalService, name string, ips []string, usage x509.ExtKeyUsage, caCert, caKey []byte) (time.Duration, error) {
+	found := &corev1.Secret{}
+	err := r.client.Get(context.TODO(), types.NamespacedName{Name: util.TLSSecretName(name), Namespace: ConnectorNamespace}, found)
+	if err != nil && !errors.IsNotFound(err) {
+		return 0, err
+	}
+	exists := err == nil
+	if exists && bytes.Equal(found.Data[util.TLSCAKey], caCert) {
+		if renewAt, err := util.CertificateRenewTime(found.Data[util.TLSCertKey]); err == nil && time.Now().Before(renewAt) {
+			return time.Until(renewAt), nil
+		}
+	}
+
+	certPEM, keyPEM, err := util.IssueCertificate(caCert, caKey, name, ips, usage, RelayCertValidity)
+	if err != nil {
+		return 0, err
+	}
+	secret := genRelayCertSecretSpec(name, certPEM, keyPEM, caCert)
+	if exists {
+		found.Type = secret.Type
+		found.Data = secret.Data
+		if err := r.client.Update(context.TODO(), found); err != nil {
+			return 0, err
+		}
+		r.recorder.Eventf(cr, corev1.EventTypeNormal, util.ReasonUpdated, "Renewed certificate %s/%s for TLS transport", found.Namespace, found.Name)
+	} else {
+		if err := r.client.Create(context.TODO(), secret); err != nil {
+			return 0, err
+		}
+		r.recorder.Eventf(cr, corev1.EventTypeNormal, util.ReasonCreated, "Created certificate %s/%s for TLS transport", secret.Namespace, secret.Name)
+	}
+
+	renewAt, err := util.CertificateRenewTime(certPEM)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Until(renewAt), nil
+}
+
+// deleteIfExist deletes the object named {key} with the type of {obj}, if it exists
+func (r *ReconcileExternalService) deleteIfExist(key types.NamespacedName, obj object) error {
+	if err := r.client.Get(context.TODO(), key, obj); err != nil && !errors.IsNotFound(err) {
+		return err
+	} else if err == nil {
+		// Object exists, so delete it
+		_ = r.client.Delete(context.Background(), obj)
+	}
+
+	return nil
+}
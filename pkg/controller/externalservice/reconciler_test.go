@@ -2,17 +2,26 @@ package externalservice
 
 import (
 	"context"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
 	submarinerv1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
+	"github.com/mkimuram/k8s-ext-connector/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
@@ -59,12 +68,199 @@ var (
 			PodIP: "10.0.0.3",
 		},
 	}
-	fwdSvcWithIP = &corev1.Service{
+	fwdPod2WithIP = &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "es1-2",
+			Namespace: "external-services",
+			Labels: map[string]string{
+				ExternalServiceNamespaceLabel: "ns1",
+				ExternalServiceNameLabel:      "es1",
+			},
+		},
+		Status: corev1.PodStatus{
+			PodIP: "10.0.0.5",
+		},
+	}
+	esUpdated = func() *v1alpha1.ExternalService {
+		es := es.DeepCopy()
+		replicas := int32(2)
+		es.Spec.Replicas = &replicas
+		es.Spec.ForwarderTemplate = &v1alpha1.ForwarderTemplate{
+			Image: "registry.example.com/forwarder:v0.4.0",
+		}
+		return es
+	}()
+	esWithClientSvc = func() *v1alpha1.ExternalService {
+		es := es.DeepCopy()
+		es.Spec.ClientService = &v1alpha1.ClientService{Name: "es1-client"}
+		return es
+	}()
+	staleClientSvc = &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "es1",
+			Namespace: "ns1",
+			Labels:    genClientServiceLabels(es),
+		},
+	}
+	unmanagedClientSvc = &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "es1-client",
+			Namespace: "ns1",
+		},
+	}
+	esWithHost = func() *v1alpha1.ExternalService {
+		es := es.DeepCopy()
+		es.Spec.TargetIP = ""
+		es.Spec.TargetHost = "db.example.com"
+		return es
+	}()
+	esWithHostResolved = func() *v1alpha1.ExternalService {
+		es := esWithHost.DeepCopy()
+		es.Status.ResolvedAddresses = []string{"192.168.122.140"}
+		es.Status.TargetIP = "192.168.122.140"
+		return es
+	}()
+	esWithUnknownHost = func() *v1alpha1.ExternalService {
+		es := esWithHost.DeepCopy()
+		es.Spec.TargetHost = "unknown.example.com"
+		return es
+	}()
+	esWithUnknownHostResolved = func() *v1alpha1.ExternalService {
+		es := esWithHostResolved.DeepCopy()
+		es.Spec.TargetHost = "unknown.example.com"
+		return es
+	}()
+	esWithTargetIPs = func() *v1alpha1.ExternalService {
+		es := es.DeepCopy()
+		es.Spec.TargetIP = ""
+		es.Spec.TargetIPs = []string{"192.168.122.139", "192.168.122.140"}
+		es.Spec.TargetPolicy = "Failover"
+		es.Spec.HealthCheck = &v1alpha1.TargetHealthCheck{PeriodSeconds: 5, TimeoutSeconds: 2}
+		return es
+	}()
+	esWithPortRanges = func() *v1alpha1.ExternalService {
+		es := es.DeepCopy()
+		es.Spec.PortRanges = []v1alpha1.PortRange{
+			{Port: 30000, EndPort: 30002},
+			{Port: 5432, TargetHost: "db.example.com"},
+		}
+		return es
+	}()
+	esWithUnknownPortTargetResolved = func() *v1alpha1.ExternalService {
+		es := esWithPortRanges.DeepCopy()
+		es.Spec.PortRanges[1].TargetHost = "unknown.example.com"
+		es.Status.PortTargets = []v1alpha1.PortTargetStatus{
+			{TargetHost: "unknown.example.com", ResolvedAddresses: []string{"192.168.122.140"}},
+		}
+		return es
+	}()
+	esEgressOnly = func() *v1alpha1.ExternalService {
+		es := es.DeepCopy()
+		es.Spec.Sources[0].Direction = v1alpha1.DirectionEgress
+		return es
+	}()
+	esIngressOnly = func() *v1alpha1.ExternalService {
+		es := es.DeepCopy()
+		es.Spec.Sources[0].Direction = v1alpha1.DirectionIngress
+		return es
+	}()
+	esWithInvalidDirection = func() *v1alpha1.ExternalService {
+		es := es.DeepCopy()
+		es.Spec.Sources[0].Direction = "Outbound"
+		return es
+	}()
+	esWithIngress = func() *v1alpha1.ExternalService {
+		es := es.DeepCopy()
+		es.Spec.Sources[0].Ingress = &v1alpha1.SourceIngress{
+			Ports:        []v1alpha1.IngressPort{{Port: 8443, ExternalPort: 443, ProxyProtocol: util.ProxyProtocolV2}},
+			AllowedCIDRs: []string{"203.0.113.0/24"},
+		}
+		return es
+	}()
+	esWithTLSIngress = func() *v1alpha1.ExternalService {
+		es := esWithIngress.DeepCopy()
+		es.Spec.Transport = v1alpha1.TransportTLS
+		return es
+	}()
+	esWithInvalidCIDR = func() *v1alpha1.ExternalService {
+		es := esWithIngress.DeepCopy()
+		es.Spec.Sources[0].Ingress.AllowedCIDRs = []string{"203.0.113.0"}
+		return es
+	}()
+	esWithInvalidProxyProtocol = func() *v1alpha1.ExternalService {
+		es := esWithIngress.DeepCopy()
+		es.Spec.Sources[0].Ingress.Ports[0].ProxyProtocol = "v3"
+		return es
+	}()
+	esWithEgressProxyProtocol = func() *v1alpha1.ExternalService {
+		es := es.DeepCopy()
+		es.Spec.Sources[0].Egress = &v1alpha1.SourceEgress{ProxyProtocol: util.ProxyProtocolV2}
+		return es
+	}()
+	esWithWireGuard = func() *v1alpha1.ExternalService {
+		es := esEgressOnly.DeepCopy()
+		es.Spec.Transport = v1alpha1.TransportWireGuard
+		es.Spec.PortRanges = []v1alpha1.PortRange{
+			{Port: 30000, EndPort: 30002},
+		}
+		return es
+	}()
+	esWithWireGuardProxyProtocol = func() *v1alpha1.ExternalService {
+		es := esWithWireGuard.DeepCopy()
+		es.Spec.Sources[0].Egress = &v1alpha1.SourceEgress{ProxyProtocol: util.ProxyProtocolV2}
+		return es
+	}()
+	esWithInvalidTransport = func() *v1alpha1.ExternalService {
+		es := es.DeepCopy()
+		es.Spec.Transport = "IPsec"
+		return es
+	}()
+	esWithShiftedPortRange = func() *v1alpha1.ExternalService {
+		es := esWithWireGuard.DeepCopy()
+		es.Spec.PortRanges[0].TargetPort = 31000
+		return es
+	}()
+	esWithoutTarget = func() *v1alpha1.ExternalService {
+		es := es.DeepCopy()
+		es.Spec.TargetIP = ""
+		return es
+	}()
+	fwdRBAC = []runtime.Object{
+		genForwardServiceAccountSpec(es),
+		genForwardRoleSpec(es, []string{"es1"}),
+		genForwardRoleBindingSpec(es, genForwardRoleSpec(es, []string{"es1"})),
+		genForwardEventsRoleSpec(es),
+		genForwardRoleBindingSpec(es, genForwardEventsRoleSpec(es)),
+	}
+	fwdDeploy = genForwardDeploymentSpec(es)
+	fwdPDB    = genForwardPDBSpec(es)
+	staleFwd  = &v1alpha1.Forwarder{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "es1-stale",
+			Namespace: "external-services",
+			Labels: map[string]string{
+				ExternalServiceNamespaceLabel: "ns1",
+				ExternalServiceNameLabel:      "es1",
+			},
+		},
+		Spec: v1alpha1.ForwarderSpec{
+			ForwarderIP: "10.0.0.9",
+		},
+	}
+	fwdSvcWithIP = &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ns1-es1-76a7fed5",
 			Namespace: "external-services",
+			Labels: map[string]string{
+				ExternalServiceNamespaceLabel: "ns1",
+				ExternalServiceNameLabel:      "es1",
+			},
 		},
 		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{
+				ExternalServiceNamespaceLabel: "ns1",
+				ExternalServiceNameLabel:      "es1",
+			},
 			Ports: []corev1.ServicePort{
 				{
 					Protocol: corev1.ProtocolTCP,
@@ -78,6 +274,25 @@ var (
 			ClusterIP: "10.10.0.5",
 		},
 	}
+	fwdSvcDrifted = &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ns1-es1-76a7fed5",
+			Namespace: "external-services",
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Protocol: corev1.ProtocolTCP,
+					Port:     8080,
+					TargetPort: intstr.IntOrString{
+						Type:   intstr.Int,
+						IntVal: 8080,
+					},
+				},
+			},
+			ClusterIP: "10.10.0.5",
+		},
+	}
 	svc = &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "svc1",
@@ -93,6 +308,62 @@ var (
 			ClusterIP: "10.20.0.8",
 		},
 	}
+	epWithPorts = func() *corev1.Endpoints {
+		ep := ep.DeepCopy()
+		ep.Subsets[0].Ports = []corev1.EndpointPort{{Protocol: corev1.ProtocolTCP, Port: 8443}}
+		return ep
+	}()
+	gwDirect = &v1alpha1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "gwrulec0a87ac8",
+			Namespace: "external-services",
+		},
+		Spec: v1alpha1.GatewaySpec{
+			Mode: v1alpha1.GatewayModeDirect,
+		},
+	}
+	gwDirectClusterIP = func() *v1alpha1.Gateway {
+		gw := gwDirect.DeepCopy()
+		gw.Spec.DirectTarget = v1alpha1.DirectTargetClusterIP
+		return gw
+	}()
+	gwWithTLSRelayPort = &v1alpha1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "gwrulec0a87ac8",
+			Namespace: "external-services",
+		},
+		Spec: v1alpha1.GatewaySpec{
+			TLSRelayPort: "8443",
+		},
+	}
+	gwWithWireGuardKey = &v1alpha1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "gwrulec0a87ac8",
+			Namespace: "external-services",
+		},
+		Status: v1alpha1.GatewayStatus{
+			WireGuardPublicKey: "gwkey",
+			WireGuardPort:      "51821",
+		},
+	}
+	fwdWithWireGuardKey = &v1alpha1.Forwarder{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "es1",
+			Namespace: "external-services",
+			Labels: map[string]string{
+				ExternalServiceNamespaceLabel: "ns1",
+				ExternalServiceNameLabel:      "es1",
+			},
+		},
+		Status: v1alpha1.ForwarderStatus{
+			WireGuardPublicKey: "fwdkey",
+		},
+	}
+	svcWithMetricsPort = func() *corev1.Service {
+		svc := svc.DeepCopy()
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{Protocol: corev1.ProtocolTCP, Port: 9090})
+		return svc
+	}()
 	ep = &corev1.Endpoints{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "svc1",
@@ -106,6 +377,11 @@ var (
 			},
 		},
 	}
+	epWithPod = func() *corev1.Endpoints {
+		ep := ep.DeepCopy()
+		ep.Subsets[0].Addresses[0].TargetRef = &corev1.ObjectReference{Kind: "Pod", Namespace: "ns1", Name: "pod1"}
+		return ep
+	}()
 	emptyRuleFwd = &v1alpha1.Forwarder{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      "es1",
@@ -173,8 +449,69 @@ var (
 						Namespace: "external-services",
 						Name:      "es1",
 					},
-					ForwarderIP: "10.0.0.3",
-					RelayPort:   "2049",
+					ForwarderIP:     "10.0.0.3",
+					RelayPort:       "2049",
+					ExternalService: v1alpha1.ExternalServiceRef{Namespace: "ns1", Name: "es1"},
+				},
+			},
+			IngressRules: []v1alpha1.GatewayRule{
+				{
+					Protocol:        "TCP",
+					SourceIP:        "192.168.122.139",
+					TargetPort:      "8443",
+					DestinationPort: "8443",
+					DestinationIP:   "10.20.0.8",
+					Forwarder: v1alpha1.ForwarderRef{
+						Namespace: "external-services",
+						Name:      "es1",
+					},
+					ForwarderIP:     "10.0.0.3",
+					RelayPort:       "2049",
+					ExternalService: v1alpha1.ExternalServiceRef{Namespace: "ns1", Name: "es1"},
+				},
+			},
+			GatewayIP: "192.168.122.200",
+		},
+	}
+	gwWithTLSRules = func() *v1alpha1.Gateway {
+		g := gw.DeepCopy()
+		g.Spec.EgressRules[0].Transport = v1alpha1.TransportTLS
+		return g
+	}()
+	gwForPods = &v1alpha1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "gwrulec0a87ac8",
+			Namespace: "external-services",
+		},
+		Spec: v1alpha1.GatewaySpec{
+			EgressRules: []v1alpha1.GatewayRule{
+				{
+					Protocol:        "TCP",
+					SourceIP:        "10.0.0.4",
+					TargetPort:      "8080",
+					DestinationPort: "80",
+					DestinationIP:   "192.168.122.139",
+					Forwarder: v1alpha1.ForwarderRef{
+						Namespace: "external-services",
+						Name:      "es1",
+					},
+					ForwarderIP:     "10.0.0.3",
+					RelayPort:       "2049",
+					ExternalService: v1alpha1.ExternalServiceRef{Namespace: "ns1", Name: "es1"},
+				},
+				{
+					Protocol:        "TCP",
+					SourceIP:        "10.0.0.4",
+					TargetPort:      "8080",
+					DestinationPort: "80",
+					DestinationIP:   "192.168.122.139",
+					Forwarder: v1alpha1.ForwarderRef{
+						Namespace: "external-services",
+						Name:      "es1-2",
+					},
+					ForwarderIP:     "10.0.0.5",
+					RelayPort:       "2049",
+					ExternalService: v1alpha1.ExternalServiceRef{Namespace: "ns1", Name: "es1"},
 				},
 			},
 			IngressRules: []v1alpha1.GatewayRule{
@@ -188,8 +525,23 @@ var (
 						Namespace: "external-services",
 						Name:      "es1",
 					},
-					ForwarderIP: "10.0.0.3",
-					RelayPort:   "2049",
+					ForwarderIP:     "10.0.0.3",
+					RelayPort:       "2049",
+					ExternalService: v1alpha1.ExternalServiceRef{Namespace: "ns1", Name: "es1"},
+				},
+				{
+					Protocol:        "TCP",
+					SourceIP:        "192.168.122.139",
+					TargetPort:      "8443",
+					DestinationPort: "8443",
+					DestinationIP:   "10.20.0.8",
+					Forwarder: v1alpha1.ForwarderRef{
+						Namespace: "external-services",
+						Name:      "es1-2",
+					},
+					ForwarderIP:     "10.0.0.5",
+					RelayPort:       "2050",
+					ExternalService: v1alpha1.ExternalServiceRef{Namespace: "ns1", Name: "es1"},
 				},
 			},
 			GatewayIP: "192.168.122.200",
@@ -197,6 +549,155 @@ var (
 	}
 )
 
+// fwdForTargets returns the expected forwarder for multiple targets with {policy} and {healthCheck}
+func fwdForTargets(policy string, healthCheck *v1alpha1.TargetHealthCheck) *v1alpha1.Forwarder {
+	f := fwd.DeepCopy()
+	f.Spec.EgressRules[0].DestinationIPs = []string{"192.168.122.139", "192.168.122.140"}
+	f.Spec.EgressRules[0].TargetPolicy = policy
+	f.Spec.EgressRules[0].HealthCheck = healthCheck
+	ir := f.Spec.IngressRules[0]
+	ir.SourceIP = "192.168.122.140"
+	ir.RelayPort = "2050"
+	f.Spec.IngressRules = append(f.Spec.IngressRules, ir)
+	return f
+}
+
+// fwdForPortRanges returns the expected forwarder for esWithPortRanges
+func fwdForPortRanges() *v1alpha1.Forwarder {
+	f := fwd.DeepCopy()
+	er := f.Spec.EgressRules[0]
+	er.TargetPort = "30000"
+	er.TargetEndPort = "30002"
+	er.DestinationPort = "30000"
+	er.RelayPort = "2050"
+	f.Spec.EgressRules = append(f.Spec.EgressRules, er)
+	er = f.Spec.EgressRules[0]
+	er.TargetPort = "5432"
+	er.DestinationPort = "5432"
+	er.DestinationIPs = []string{"192.168.122.139", "192.168.122.140"}
+	er.TargetPolicy = "RoundRobin"
+	er.RelayPort = "2051"
+	f.Spec.EgressRules = append(f.Spec.EgressRules, er)
+	return f
+}
+
+// fwdForDirection returns the expected forwarder only with rules for {direction}
+func fwdForDirection(direction string) *v1alpha1.Forwarder {
+	f := fwd.DeepCopy()
+	if direction == v1alpha1.DirectionIngress {
+		f.Spec.EgressRules = []v1alpha1.ForwarderRule{}
+	} else {
+		f.Spec.IngressRules = []v1alpha1.ForwarderRule{}
+	}
+	return f
+}
+
+// fwdForIngress returns the expected forwarder for esWithIngress
+func fwdForIngress() *v1alpha1.Forwarder {
+	f := fwd.DeepCopy()
+	f.Spec.IngressRules[0].TargetPort = "443"
+	f.Spec.IngressRules[0].ProxyProtocol = util.ProxyProtocolV2
+	ir := f.Spec.IngressRules[0]
+	ir.SourceIP = "203.0.113.0/24"
+	ir.RelayPort = "2050"
+	f.Spec.IngressRules = append(f.Spec.IngressRules, ir)
+	return f
+}
+
+// fwdForEgressProxyProtocol returns the expected forwarder for esWithEgressProxyProtocol
+func fwdForEgressProxyProtocol() *v1alpha1.Forwarder {
+	f := fwd.DeepCopy()
+	f.Spec.EgressRules[0].ProxyProtocol = util.ProxyProtocolV2
+	f.Spec.EgressRules[0].SourcePod = "ns1/pod1"
+	return f
+}
+
+// gwFor returns the expected gateway for the expected forwarder {f}, whose rules are derived from the rules of {f}
+// in the same way as the operator does, so that the expected forwarders and gateways don't drift apart.
+// Forwarders publish "fwdkey" as their public key for WireGuard.
+func gwFor(f *v1alpha1.Forwarder) *v1alpha1.Gateway {
+	g := &v1alpha1.Gateway{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "gwrulec0a87ac8",
+			Namespace: "external-services",
+		},
+		Spec: v1alpha1.GatewaySpec{
+			EgressRules:  []v1alpha1.GatewayRule{},
+			IngressRules: []v1alpha1.GatewayRule{},
+			GatewayIP:    "192.168.122.200",
+		},
+	}
+	toGatewayRule := func(rule v1alpha1.ForwarderRule) v1alpha1.GatewayRule {
+		return v1alpha1.GatewayRule{
+			Protocol:        rule.Protocol,
+			SourceIP:        rule.SourceIP,
+			TargetPort:      rule.TargetPort,
+			DestinationPort: rule.DestinationPort,
+			DestinationIP:   rule.DestinationIP,
+			Forwarder: v1alpha1.ForwarderRef{
+				Namespace: f.Namespace,
+				Name:      f.Name,
+			},
+			ForwarderIP:     f.Spec.ForwarderIP,
+			RelayPort:       rule.RelayPort,
+			Limits:          rule.Limits.DeepCopy(),
+			Transport:       rule.Transport,
+			ExternalService: v1alpha1.ExternalServiceRef{Namespace: "ns1", Name: "es1"},
+		}
+	}
+	for _, rule := range f.Spec.EgressRules {
+		er := toGatewayRule(rule)
+		er.DestinationIPs = rule.DestinationIPs
+		er.TargetPolicy = rule.TargetPolicy
+		er.HealthCheck = rule.HealthCheck
+		er.TargetEndPort = rule.TargetEndPort
+		er.ProxyProtocol = rule.ProxyProtocol
+		er.SourcePod = rule.SourcePod
+		if rule.Transport == v1alpha1.TransportWireGuard {
+			er.WireGuardPublicKey = "fwdkey"
+		}
+		g.Spec.EgressRules = append(g.Spec.EgressRules, er)
+	}
+	// Ingress rules only have the relay to the forwarder, and the PROXY protocol header is sent by the forwarder
+	for _, rule := range f.Spec.IngressRules {
+		g.Spec.IngressRules = append(g.Spec.IngressRules, toGatewayRule(rule))
+	}
+	return g
+}
+
+// gwForDirect returns the expected gateway in Direct mode, whose ingress rule DNATs to {destIP}
+func gwForDirect(destIP string) *v1alpha1.Gateway {
+	g := gw.DeepCopy()
+	g.Spec.IngressRules = []v1alpha1.GatewayRule{
+		{
+			Protocol:        "TCP",
+			SourceIP:        "192.168.122.139",
+			TargetPort:      "8443",
+			DestinationPort: "8443",
+			DestinationIP:   destIP,
+			ExternalService: v1alpha1.ExternalServiceRef{Namespace: "ns1", Name: "es1"},
+		},
+	}
+	return g
+}
+
+// fwdForWireGuard returns the expected forwarder for esWithWireGuard
+func fwdForWireGuard() *v1alpha1.Forwarder {
+	f := fwdForDirection(v1alpha1.DirectionEgress)
+	er := f.Spec.EgressRules[0]
+	er.TargetPort = "30000"
+	er.TargetEndPort = "30002"
+	er.DestinationPort = "30000"
+	f.Spec.EgressRules = append(f.Spec.EgressRules, er)
+	for i := range f.Spec.EgressRules {
+		f.Spec.EgressRules[i].RelayPort = ""
+		f.Spec.EgressRules[i].Transport = v1alpha1.TransportWireGuard
+		f.Spec.EgressRules[i].WireGuardPublicKey = "gwkey"
+		f.Spec.EgressRules[i].WireGuardPort = "51821"
+	}
+	return f
+}
+
 func compareForwarder(a, b *v1alpha1.Forwarder) error {
 	if a.ObjectMeta.Namespace != b.ObjectMeta.Namespace || a.ObjectMeta.Name != b.ObjectMeta.Name {
 		return fmt.Errorf("Metadata are different between %#v and %#v", a.ObjectMeta, b.ObjectMeta)
@@ -223,15 +724,38 @@ func compareGateway(a, b *v1alpha1.Gateway) error {
 	return nil
 }
 
+// fakeResolver resolves host names in it with TTL of 30 seconds
+type fakeResolver map[string][]string
+
+func (f fakeResolver) Resolve(host string) ([]string, time.Duration, error) {
+	addrs, ok := f[host]
+	if !ok {
+		return nil, 0, fmt.Errorf("no such host %q", host)
+	}
+	return addrs, 30 * time.Second, nil
+}
+
 func TestReconcile(t *testing.T) {
 	testCases := []struct {
-		name        string
-		req         reconcile.Request
-		objs        []runtime.Object
-		expected    reconcile.Result
-		expectedErr error
-		expectedFwd *v1alpha1.Forwarder
-		expectedGw  *v1alpha1.Gateway
+		name           string
+		req            reconcile.Request
+		objs           []runtime.Object
+		expected       reconcile.Result
+		expectedErr    error
+		expectedFwd    *v1alpha1.Forwarder
+		expectedGw     *v1alpha1.Gateway
+		expectedDeploy *appsv1.Deployment
+		expectedSvc    *corev1.Service
+		expectedEp     *corev1.Endpoints
+		expectedStatus *v1alpha1.ExternalServiceStatus
+		// expectedRoleNames are resource names in the role for forwarder pods
+		expectedRoleNames []string
+		// expectedDeletedFwds are names of forwarders that should be deleted
+		expectedDeletedFwds []string
+		// expectedDeletedSvcs are names of services in ns1 that should be deleted
+		expectedDeletedSvcs []string
+		// expectedEvents are events recorded on the external service in order
+		expectedEvents []string
 	}{
 		{
 			name: "Error case (Fails but not requeued, due to lack of external service)",
@@ -241,11 +765,12 @@ func TestReconcile(t *testing.T) {
 					Name:      "es1",
 				},
 			},
-			objs:        []runtime.Object{},
-			expected:    reconcile.Result{},
-			expectedErr: nil,
-			expectedFwd: nil,
-			expectedGw:  nil,
+			objs:           []runtime.Object{},
+			expected:       reconcile.Result{},
+			expectedErr:    nil,
+			expectedFwd:    nil,
+			expectedGw:     nil,
+			expectedEvents: []string{},
 		},
 		{
 			name: "Error case (Fails and requeued, due to no IP assigned to forwarder pod)",
@@ -261,6 +786,17 @@ func TestReconcile(t *testing.T) {
 			expectedErr: fmt.Errorf("forwarder pod has no IP address assigned"),
 			expectedFwd: nil,
 			expectedGw:  nil,
+			expectedEvents: []string{
+				"Normal Created Created forwarder service account external-services/ns1-es1-76a7fed5",
+				"Normal Created Created forwarder role external-services/ns1-es1-76a7fed5",
+				"Normal Created Created forwarder role binding external-services/ns1-es1-76a7fed5",
+				"Normal Created Created forwarder role ns1/externalservice-forwarder-es1",
+				"Normal Created Created forwarder role binding ns1/externalservice-forwarder-es1",
+				"Normal Created Created forwarder deployment external-services/ns1-es1-76a7fed5",
+				"Normal Created Created forwarder pod disruption budget external-services/ns1-es1-76a7fed5",
+				"Normal Created Created forwarder service external-services/ns1-es1-76a7fed5",
+				"Warning ForwarderNoIP forwarder pod has no IP address assigned",
+			},
 		},
 		{
 			name: "Normal case (no service exists)",
@@ -275,6 +811,16 @@ func TestReconcile(t *testing.T) {
 			expectedErr: nil,
 			expectedFwd: emptyRuleFwd,
 			expectedGw:  nil,
+			expectedEvents: []string{
+				"Normal Created Created forwarder service account external-services/ns1-es1-76a7fed5",
+				"Normal Created Created forwarder role external-services/ns1-es1-76a7fed5",
+				"Normal Created Created forwarder role binding external-services/ns1-es1-76a7fed5",
+				"Normal Created Created forwarder role ns1/externalservice-forwarder-es1",
+				"Normal Created Created forwarder role binding ns1/externalservice-forwarder-es1",
+				"Normal Created Created forwarder deployment external-services/ns1-es1-76a7fed5",
+				"Normal Created Created forwarder pod disruption budget external-services/ns1-es1-76a7fed5",
+				"Normal Created Created forwarder service external-services/ns1-es1-76a7fed5",
+			},
 		},
 		{
 			name: "Normal case (service exists)",
@@ -284,53 +830,847 @@ func TestReconcile(t *testing.T) {
 					Name:      "es1",
 				},
 			},
-			objs:        []runtime.Object{es, fwdPodWithIP, fwdSvcWithIP, svc, ep},
+			objs:           append([]runtime.Object{es, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:       reconcile.Result{},
+			expectedErr:    nil,
+			expectedFwd:    fwd,
+			expectedGw:     gw,
+			expectedEvents: []string{},
+		},
+		{
+			name: "Normal case (replicas and forwarder template changed)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:           append([]runtime.Object{esUpdated, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:       reconcile.Result{},
+			expectedErr:    nil,
+			expectedFwd:    fwd,
+			expectedGw:     gw,
+			expectedDeploy: genForwardDeploymentSpec(esUpdated),
+			expectedEvents: []string{
+				"Normal Scaled Scaled forwarder deployment external-services/ns1-es1-76a7fed5 to 2",
+				"Normal Updated Updated pod template of forwarder deployment external-services/ns1-es1-76a7fed5",
+			},
+		},
+		{
+			name: "Normal case (forwarder service drifted)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{es, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcDrifted, svc, ep}, fwdRBAC...),
 			expected:    reconcile.Result{},
 			expectedErr: nil,
 			expectedFwd: fwd,
 			expectedGw:  gw,
+			expectedSvc: fwdSvcWithIP,
+			expectedEvents: []string{
+				"Normal Updated Updated forwarder service external-services/ns1-es1-76a7fed5",
+			},
 		},
-	}
-
-	s := runtime.NewScheme()
-	corev1.AddToScheme(s)
-	v1alpha1.AddToScheme(s)
-
-	for _, tc := range testCases {
-		t.Logf("test case: %s", tc.name)
-
-		cl := fake.NewFakeClientWithScheme(s, tc.objs...)
-		r := &ReconcileExternalService{client: cl, scheme: s}
-
-		result, err := r.Reconcile(tc.req)
-
-		if !reflect.DeepEqual(tc.expectedErr, err) {
-			t.Errorf("expected err:%v , but got err:%v", tc.expectedErr, err)
-		}
-		if !reflect.DeepEqual(tc.expected, result) {
-			t.Errorf("expected:%v, but got:%v", tc.expected, result)
-		}
-
-		// Check forwarder
-		if tc.expectedFwd != nil {
-			fwd := &submarinerv1alpha1.Forwarder{}
-			if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: tc.expectedFwd.Namespace, Name: tc.expectedFwd.Name}, fwd); err != nil {
-				t.Fatalf("failed to get forwarder")
-			}
-			if err := compareForwarder(tc.expectedFwd, fwd); err != nil {
-				t.Errorf("%v", err)
-			}
-		}
-
-		if tc.expectedGw != nil {
-			gw := &submarinerv1alpha1.Gateway{}
-			if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: tc.expectedGw.Namespace, Name: tc.expectedGw.Name}, gw); err != nil {
-				t.Fatalf("failed to get gateway")
-			}
-
-			if err := compareGateway(tc.expectedGw, gw); err != nil {
-				t.Errorf("%v", err)
-			}
+		{
+			name: "Normal case (multiple forwarder pods and forwarder for deleted pod)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:                append([]runtime.Object{es, fwdDeploy, fwdPDB, fwdPodWithIP, fwdPod2WithIP, staleFwd, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:            reconcile.Result{},
+			expectedErr:         nil,
+			expectedFwd:         fwd,
+			expectedGw:          gwForPods,
+			expectedDeletedFwds: []string{"es1-stale"},
+			expectedRoleNames:   []string{"es1", "es1-2"},
+			expectedEvents:      []string{},
+		},
+		{
+			name: "Normal case (client service is created and stale one is deleted)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:                append([]runtime.Object{esWithClientSvc, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, staleClientSvc, svc, ep}, fwdRBAC...),
+			expected:            reconcile.Result{},
+			expectedErr:         nil,
+			expectedFwd:         fwd,
+			expectedGw:          gw,
+			expectedSvc:         genClientServiceSpec(esWithClientSvc),
+			expectedEp:          genClientEndpointsSpec(esWithClientSvc, []corev1.Pod{*fwdPodWithIP}),
+			expectedDeletedSvcs: []string{"es1"},
+			expectedEvents: []string{
+				"Normal Created Created forwarder client service ns1/es1-client",
+				"Normal Created Created forwarder client endpoints ns1/es1-client",
+			},
+		},
+		{
+			name: "Error case (Fails and requeued, due to service not managed by external service)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithClientSvc, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, unmanagedClientSvc, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: fmt.Errorf("service ns1/es1-client already exists and isn't managed by the external service"),
+			expectedFwd: nil,
+			expectedGw:  nil,
+			expectedEvents: []string{
+				"Warning FailedCreate service ns1/es1-client already exists and isn't managed by the external service",
+			},
+		},
+		{
+			name: "Normal case (target host resolved)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithHost, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{RequeueAfter: 30 * time.Second},
+			expectedErr: nil,
+			expectedFwd: fwdForTargets("RoundRobin", nil),
+			expectedGw:  gwFor(fwdForTargets("RoundRobin", nil)),
+			expectedStatus: &v1alpha1.ExternalServiceStatus{
+				ResolvedAddresses: []string{"192.168.122.139", "192.168.122.140"},
+				TargetIP:          "192.168.122.139",
+			},
+			expectedEvents: []string{
+				"Normal Resolved Resolved target host db.example.com to 192.168.122.139",
+			},
+		},
+		{
+			name: "Normal case (current target kept while resolved)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithHostResolved, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{RequeueAfter: 30 * time.Second},
+			expectedErr: nil,
+			expectedStatus: &v1alpha1.ExternalServiceStatus{
+				ResolvedAddresses: []string{"192.168.122.139", "192.168.122.140"},
+				TargetIP:          "192.168.122.140",
+			},
+			expectedEvents: []string{},
+		},
+		{
+			name: "Error case (Fails and requeued, due to target host not resolved)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithUnknownHost, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: fmt.Errorf("no such host %q", "unknown.example.com"),
+			expectedEvents: []string{
+				"Warning FailedResolve no such host \"unknown.example.com\"",
+			},
+		},
+		{
+			name: "Normal case (last resolved target kept, when target host fails to be resolved)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithUnknownHostResolved, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{RequeueAfter: MinResolveInterval},
+			expectedErr: nil,
+			expectedStatus: &v1alpha1.ExternalServiceStatus{
+				ResolvedAddresses: []string{"192.168.122.140"},
+				TargetIP:          "192.168.122.140",
+			},
+			expectedEvents: []string{
+				"Warning FailedResolve no such host \"unknown.example.com\"",
+			},
+		},
+		{
+			name: "Normal case (last resolved port target kept, when it fails to be resolved)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithUnknownPortTargetResolved, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{RequeueAfter: MinResolveInterval},
+			expectedErr: nil,
+			expectedStatus: &v1alpha1.ExternalServiceStatus{
+				PortTargets: []v1alpha1.PortTargetStatus{
+					{TargetHost: "unknown.example.com", ResolvedAddresses: []string{"192.168.122.140"}},
+				},
+			},
+			expectedEvents: []string{
+				"Warning FailedResolve no such host \"unknown.example.com\"",
+				"Normal Updated Updated forwarder service external-services/ns1-es1-76a7fed5",
+			},
+		},
+		{
+			name: "Normal case (multiple target IPs)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:           append([]runtime.Object{esWithTargetIPs, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:       reconcile.Result{},
+			expectedErr:    nil,
+			expectedFwd:    fwdForTargets("Failover", &v1alpha1.TargetHealthCheck{PeriodSeconds: 5, TimeoutSeconds: 2}),
+			expectedGw:     gwFor(fwdForTargets("Failover", &v1alpha1.TargetHealthCheck{PeriodSeconds: 5, TimeoutSeconds: 2})),
+			expectedEvents: []string{},
+		},
+		{
+			name: "Normal case (port ranges with target host)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithPortRanges, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{RequeueAfter: 30 * time.Second},
+			expectedErr: nil,
+			expectedFwd: fwdForPortRanges(),
+			expectedGw:  gwFor(fwdForPortRanges()),
+			expectedStatus: &v1alpha1.ExternalServiceStatus{
+				PortTargets: []v1alpha1.PortTargetStatus{
+					{
+						TargetHost:        "db.example.com",
+						ResolvedAddresses: []string{"192.168.122.139", "192.168.122.140"},
+					},
+				},
+			},
+			expectedEvents: []string{
+				"Normal Resolved Resolved target host db.example.com to 192.168.122.139,192.168.122.140",
+				"Normal Updated Updated forwarder service external-services/ns1-es1-76a7fed5",
+			},
+		},
+		{
+			name: "Normal case (egress only source)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:           append([]runtime.Object{esEgressOnly, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:       reconcile.Result{},
+			expectedErr:    nil,
+			expectedFwd:    fwdForDirection(v1alpha1.DirectionEgress),
+			expectedGw:     gwFor(fwdForDirection(v1alpha1.DirectionEgress)),
+			expectedEvents: []string{},
+		},
+		{
+			name: "Normal case (ingress only source)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:           append([]runtime.Object{esIngressOnly, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:       reconcile.Result{},
+			expectedErr:    nil,
+			expectedFwd:    fwdForDirection(v1alpha1.DirectionIngress),
+			expectedGw:     gwFor(fwdForDirection(v1alpha1.DirectionIngress)),
+			expectedEvents: []string{},
+		},
+		{
+			name: "Error case (Fails and requeued, due to invalid direction)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithInvalidDirection, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: fmt.Errorf("invalid direction %q of source %s/%s", "Outbound", "ns1", "svc1"),
+			expectedEvents: []string{
+				"Warning FailedUpdateRules invalid direction \"Outbound\" of source ns1/svc1",
+			},
+		},
+		{
+			name: "Normal case (selected ingress ports and allowed CIDRs)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:           append([]runtime.Object{esWithIngress, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svcWithMetricsPort, ep}, fwdRBAC...),
+			expected:       reconcile.Result{},
+			expectedErr:    nil,
+			expectedFwd:    fwdForIngress(),
+			expectedGw:     gwFor(fwdForIngress()),
+			expectedEvents: []string{},
+		},
+		{
+			name: "Error case (Fails and requeued, due to ingress on the port of mTLS relay server)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithTLSIngress, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: fmt.Errorf("external port %d of source %s/%s conflicts with mTLS relay server of gateway %s", 443, "ns1", "svc1", "gwrulec0a87ac8"),
+			expectedEvents: []string{
+				"Warning FailedUpdateRules external port 443 of source ns1/svc1 conflicts with mTLS relay server of gateway gwrulec0a87ac8",
+			},
+		},
+		{
+			name: "Error case (Fails and requeued, due to ingress on the port of mTLS relay server for other external services)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithIngress, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep, gwWithTLSRules}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: fmt.Errorf("external port %d of source %s/%s conflicts with mTLS relay server of gateway %s", 443, "ns1", "svc1", "gwrulec0a87ac8"),
+			expectedEvents: []string{
+				"Warning FailedUpdateRules external port 443 of source ns1/svc1 conflicts with mTLS relay server of gateway gwrulec0a87ac8",
+			},
+		},
+		{
+			name: "Error case (Fails and requeued, due to proxy protocol for ingress in Direct mode)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithIngress, gwDirect, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, epWithPorts}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: fmt.Errorf("proxy protocol for ingress port %d of source %s/%s is not supported by gateway %s in %s mode", 8443, "ns1", "svc1", "gwrulec0a87ac8", v1alpha1.GatewayModeDirect),
+			expectedEvents: []string{
+				"Warning FailedUpdateRules proxy protocol for ingress port 8443 of source ns1/svc1 is not supported by gateway gwrulec0a87ac8 in Direct mode",
+			},
+		},
+		{
+			name: "Error case (Fails and requeued, due to invalid allowed CIDR)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithInvalidCIDR, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: fmt.Errorf("invalid allowed CIDR %q of source %s/%s", "203.0.113.0", "ns1", "svc1"),
+			expectedEvents: []string{
+				"Warning FailedUpdateRules invalid allowed CIDR \"203.0.113.0\" of source ns1/svc1",
+			},
+		},
+		{
+			name: "Error case (Fails and requeued, due to invalid proxy protocol)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithInvalidProxyProtocol, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: fmt.Errorf("invalid proxy protocol %q for ingress port %d of source %s/%s", "v3", 8443, "ns1", "svc1"),
+			expectedEvents: []string{
+				"Warning FailedUpdateRules invalid proxy protocol \"v3\" for ingress port 8443 of source ns1/svc1",
+			},
+		},
+		{
+			name: "Normal case (proxy protocol for egress)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:           append([]runtime.Object{esWithEgressProxyProtocol, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, epWithPod}, fwdRBAC...),
+			expected:       reconcile.Result{},
+			expectedErr:    nil,
+			expectedFwd:    fwdForEgressProxyProtocol(),
+			expectedGw:     gwFor(fwdForEgressProxyProtocol()),
+			expectedEvents: []string{},
+		},
+		{
+			name: "Error case (Fails and requeued, due to proxy protocol for egress with wireguard)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithWireGuardProxyProtocol, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: fmt.Errorf("proxy protocol for egress of source %s/%s is not supported by %s transport", "ns1", "svc1", v1alpha1.TransportWireGuard),
+			expectedEvents: []string{
+				"Warning FailedUpdateRules proxy protocol for egress of source ns1/svc1 is not supported by WireGuard transport",
+			},
+		},
+		{
+			name: "Normal case (gateway in Direct mode to pods)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:           append([]runtime.Object{es, gwDirect, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, epWithPorts}, fwdRBAC...),
+			expected:       reconcile.Result{},
+			expectedErr:    nil,
+			expectedFwd:    fwdForDirection(v1alpha1.DirectionEgress),
+			expectedGw:     gwForDirect("10.0.0.4"),
+			expectedEvents: []string{},
+		},
+		{
+			name: "Normal case (gateway in Direct mode for ingress only source)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esIngressOnly, gwDirect, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, epWithPorts}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: nil,
+			expectedFwd: func() *v1alpha1.Forwarder {
+				f := fwd.DeepCopy()
+				f.Spec.EgressRules = []v1alpha1.ForwarderRule{}
+				f.Spec.IngressRules = []v1alpha1.ForwarderRule{}
+				return f
+			}(),
+			expectedGw: func() *v1alpha1.Gateway {
+				g := gwForDirect("10.0.0.4")
+				g.Spec.EgressRules = []v1alpha1.GatewayRule{}
+				return g
+			}(),
+			expectedEvents: []string{},
+		},
+		{
+			name: "Normal case (gateway in Direct mode to cluster IP)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:           append([]runtime.Object{es, gwDirectClusterIP, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, epWithPorts}, fwdRBAC...),
+			expected:       reconcile.Result{},
+			expectedErr:    nil,
+			expectedFwd:    fwdForDirection(v1alpha1.DirectionEgress),
+			expectedGw:     gwForDirect("10.20.0.8"),
+			expectedEvents: []string{},
+		},
+		{
+			name: "Normal case (wireguard transport)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithWireGuard, gwWithWireGuardKey, fwdWithWireGuardKey, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: nil,
+			expectedFwd: fwdForWireGuard(),
+			expectedGw:  gwFor(fwdForWireGuard()),
+			expectedEvents: []string{
+				"Normal Updated Updated pod template of forwarder deployment external-services/ns1-es1-76a7fed5",
+				"Normal Updated Updated forwarder service external-services/ns1-es1-76a7fed5",
+			},
+		},
+		{
+			name: "Error case (Fails and requeued, due to invalid transport)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithInvalidTransport, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: fmt.Errorf("invalid transport %q", "IPsec"),
+			expectedEvents: []string{
+				"Warning FailedUpdateRules invalid transport \"IPsec\"",
+			},
+		},
+		{
+			name: "Error case (Fails and requeued, due to port range shifted for wireguard transport)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithShiftedPortRange, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: fmt.Errorf("port range %d-%d with target port %d is not supported by %s transport", 30000, 30002, 31000, "WireGuard"),
+			expectedEvents: []string{
+				"Warning FailedUpdateRules port range 30000-30002 with target port 31000 is not supported by WireGuard transport",
+			},
+		},
+		{
+			name: "Error case (Fails and requeued, due to neither target IP nor target host)",
+			req: reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			objs:        append([]runtime.Object{esWithoutTarget, fwdDeploy, fwdPDB, fwdPodWithIP, fwdSvcWithIP, svc, ep}, fwdRBAC...),
+			expected:    reconcile.Result{},
+			expectedErr: errNoTarget,
+			expectedEvents: []string{
+				"Warning FailedUpdateRules either targetIP, targetIPs or targetHost must be specified",
+			},
+		},
+	}
+
+	s := runtime.NewScheme()
+	corev1.AddToScheme(s)
+	appsv1.AddToScheme(s)
+	policyv1beta1.AddToScheme(s)
+	rbacv1.AddToScheme(s)
+	v1alpha1.AddToScheme(s)
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		cl := fake.NewFakeClientWithScheme(s, tc.objs...)
+		recorder := record.NewFakeRecorder(20)
+		resolver := fakeResolver{"db.example.com": {"192.168.122.139", "192.168.122.140"}}
+		r := &ReconcileExternalService{client: cl, scheme: s, recorder: recorder, resolver: resolver}
+
+		result, err := r.Reconcile(tc.req)
+
+		if !reflect.DeepEqual(tc.expectedErr, err) {
+			t.Errorf("expected err:%v , but got err:%v", tc.expectedErr, err)
+		}
+		if !reflect.DeepEqual(tc.expected, result) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, result)
+		}
+		close(recorder.Events)
+		events := []string{}
+		for event := range recorder.Events {
+			events = append(events, event)
+		}
+		if !reflect.DeepEqual(tc.expectedEvents, events) {
+			t.Errorf("expected events:%v, but got events:%v", tc.expectedEvents, events)
+		}
+
+		// Check forwarder
+		if tc.expectedFwd != nil {
+			fwd := &submarinerv1alpha1.Forwarder{}
+			if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: tc.expectedFwd.Namespace, Name: tc.expectedFwd.Name}, fwd); err != nil {
+				t.Fatalf("failed to get forwarder")
+			}
+			if err := compareForwarder(tc.expectedFwd, fwd); err != nil {
+				t.Errorf("%v", err)
+			}
+		}
+
+		if tc.expectedDeploy != nil {
+			deploy := &appsv1.Deployment{}
+			if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: tc.expectedDeploy.Namespace, Name: tc.expectedDeploy.Name}, deploy); err != nil {
+				t.Fatalf("failed to get deployment")
+			}
+			if !reflect.DeepEqual(tc.expectedDeploy.Annotations, deploy.Annotations) {
+				t.Errorf("expected annotations:%v, but got annotations:%v", tc.expectedDeploy.Annotations, deploy.Annotations)
+			}
+			if !reflect.DeepEqual(tc.expectedDeploy.Spec, deploy.Spec) {
+				t.Errorf("expected spec:%v, but got spec:%v", tc.expectedDeploy.Spec, deploy.Spec)
+			}
+		}
+
+		if tc.expectedSvc != nil {
+			svc := &corev1.Service{}
+			if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: tc.expectedSvc.Namespace, Name: tc.expectedSvc.Name}, svc); err != nil {
+				t.Fatalf("failed to get service")
+			}
+			if !reflect.DeepEqual(tc.expectedSvc.Labels, svc.Labels) {
+				t.Errorf("expected labels:%v, but got labels:%v", tc.expectedSvc.Labels, svc.Labels)
+			}
+			if !reflect.DeepEqual(tc.expectedSvc.Spec.Selector, svc.Spec.Selector) {
+				t.Errorf("expected selector:%v, but got selector:%v", tc.expectedSvc.Spec.Selector, svc.Spec.Selector)
+			}
+			if !reflect.DeepEqual(tc.expectedSvc.Spec.Ports, svc.Spec.Ports) {
+				t.Errorf("expected ports:%v, but got ports:%v", tc.expectedSvc.Spec.Ports, svc.Spec.Ports)
+			}
+		}
+
+		if tc.expectedEp != nil {
+			ep := &corev1.Endpoints{}
+			if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: tc.expectedEp.Namespace, Name: tc.expectedEp.Name}, ep); err != nil {
+				t.Fatalf("failed to get endpoints")
+			}
+			if !reflect.DeepEqual(tc.expectedEp.Labels, ep.Labels) {
+				t.Errorf("expected labels:%v, but got labels:%v", tc.expectedEp.Labels, ep.Labels)
+			}
+			if !reflect.DeepEqual(tc.expectedEp.Subsets, ep.Subsets) {
+				t.Errorf("expected subsets:%v, but got subsets:%v", tc.expectedEp.Subsets, ep.Subsets)
+			}
+		}
+
+		if tc.expectedStatus != nil {
+			es := &v1alpha1.ExternalService{}
+			if err := cl.Get(context.TODO(), tc.req.NamespacedName, es); err != nil {
+				t.Fatalf("failed to get external service")
+			}
+			if !reflect.DeepEqual(*tc.expectedStatus, es.Status) {
+				t.Errorf("expected status:%v, but got status:%v", *tc.expectedStatus, es.Status)
+			}
+		}
+
+		if tc.expectedRoleNames != nil {
+			role := &rbacv1.Role{}
+			if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: "external-services", Name: "ns1-es1-76a7fed5"}, role); err != nil {
+				t.Fatalf("failed to get role")
+			}
+			for _, rule := range role.Rules {
+				if !reflect.DeepEqual(tc.expectedRoleNames, rule.ResourceNames) {
+					t.Errorf("expected resource names:%v, but got resource names:%v", tc.expectedRoleNames, rule.ResourceNames)
+				}
+			}
+		}
+
+		for _, name := range tc.expectedDeletedFwds {
+			fwd := &submarinerv1alpha1.Forwarder{}
+			if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: "external-services", Name: name}, fwd); !errors.IsNotFound(err) {
+				t.Errorf("expected forwarder %s to be deleted, but got err:%v", name, err)
+			}
+		}
+
+		for _, name := range tc.expectedDeletedSvcs {
+			svc := &corev1.Service{}
+			if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: "ns1", Name: name}, svc); !errors.IsNotFound(err) {
+				t.Errorf("expected service %s to be deleted, but got err:%v", name, err)
+			}
+		}
+
+		if tc.expectedGw != nil {
+			gw := &submarinerv1alpha1.Gateway{}
+			if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: tc.expectedGw.Namespace, Name: tc.expectedGw.Name}, gw); err != nil {
+				t.Fatalf("failed to get gateway")
+			}
+
+			if err := compareGateway(tc.expectedGw, gw); err != nil {
+				t.Errorf("%v", err)
+			}
+		}
+	}
+}
+
+func TestGetGatewayTLSRelay(t *testing.T) {
+	ref := v1alpha1.GatewayRef{Namespace: "external-services", Name: "gwrulec0a87ac8"}
+	testCases := []struct {
+		name          string
+		objs          []runtime.Object
+		expectedPort  string
+		expectedRules bool
+	}{
+		{
+			name:          "Normal case (default port for gateway not created yet)",
+			objs:          []runtime.Object{},
+			expectedPort:  util.TLSRelayPort,
+			expectedRules: false,
+		},
+		{
+			name:          "Normal case (port specified in gateway)",
+			objs:          []runtime.Object{gwWithTLSRelayPort},
+			expectedPort:  "8443",
+			expectedRules: false,
+		},
+		{
+			name:          "Normal case (gateway with rules for TLS transport)",
+			objs:          []runtime.Object{gwWithTLSRules},
+			expectedPort:  util.TLSRelayPort,
+			expectedRules: true,
+		},
+	}
+
+	s := runtime.NewScheme()
+	v1alpha1.AddToScheme(s)
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		cl := fake.NewFakeClientWithScheme(s, tc.objs...)
+
+		port, rules, err := getGatewayTLSRelay(cl, ref)
+		if err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+		if port != tc.expectedPort || rules != tc.expectedRules {
+			t.Errorf("expected %q and %v, but got %q and %v", tc.expectedPort, tc.expectedRules, port, rules)
+		}
+	}
+}
+
+func TestEnsureRelayCertificates(t *testing.T) {
+	caCert, caKey, err := util.GenerateCA(RelayCASecretName, RelayCAValidity)
+	if err != nil {
+		t.Fatalf("failed to generate CA: %v", err)
+	}
+	otherCACert, otherCAKey, err := util.GenerateCA("other-ca", RelayCAValidity)
+	if err != nil {
+		t.Fatalf("failed to generate CA: %v", err)
+	}
+	fwdName, gwName := "ns1-es1-76a7fed5", "gwrulec0a87ac8"
+	esWithTLS := es.DeepCopy()
+	esWithTLS.Spec.Transport = v1alpha1.TransportTLS
+
+	// certSecret returns the secret of a certificate for {name} issued by the CA of {caCert} and {caKey} for {validity}
+	certSecret := func(name string, caCert, caKey []byte, validity time.Duration) *corev1.Secret {
+		cert, key, err := util.IssueCertificate(caCert, caKey, name, nil, x509.ExtKeyUsageClientAuth, validity)
+		if err != nil {
+			t.Fatalf("failed to issue certificate: %v", err)
+		}
+		return genRelayCertSecretSpec(name, cert, key, caCert)
+	}
+	validFwdSecret := certSecret(fwdName, caCert, caKey, RelayCertValidity)
+	validGwSecret := certSecret(gwName, caCert, caKey, RelayCertValidity)
+
+	testCases := []struct {
+		name    string
+		es      *v1alpha1.ExternalService
+		objs    []runtime.Object
+		renewed []string
+		// expectedRenewAfter is the minimum duration expected to be returned
+		expectedRenewAfter time.Duration
+		expectedEvents     []string
+	}{
+		{
+			name:               "Normal case (ssh transport)",
+			es:                 es,
+			objs:               []runtime.Object{},
+			renewed:            []string{},
+			expectedRenewAfter: 0,
+			expectedEvents:     []string{},
+		},
+		{
+			name:               "Normal case (certificates issued with a new CA)",
+			es:                 esWithTLS,
+			objs:               []runtime.Object{},
+			renewed:            []string{fwdName, gwName},
+			expectedRenewAfter: RelayCertValidity * 2 / 3,
+			expectedEvents: []string{
+				"Normal Created Created certificate external-services/ns1-es1-76a7fed5-tls for TLS transport",
+				"Normal Created Created certificate external-services/gwrulec0a87ac8-tls for TLS transport",
+			},
+		},
+		{
+			name:               "Normal case (valid certificates kept)",
+			es:                 esWithTLS,
+			objs:               []runtime.Object{genRelayCASecretSpec(caCert, caKey), validFwdSecret, validGwSecret},
+			renewed:            []string{},
+			expectedRenewAfter: RelayCertValidity * 2 / 3,
+			expectedEvents:     []string{},
+		},
+		{
+			name: "Normal case (certificates renewed, due to expiring or issued by another CA)",
+			es:   esWithTLS,
+			objs: []runtime.Object{
+				genRelayCASecretSpec(caCert, caKey),
+				certSecret(fwdName, caCert, caKey, time.Minute),
+				certSecret(gwName, otherCACert, otherCAKey, RelayCertValidity),
+			},
+			renewed:            []string{fwdName, gwName},
+			expectedRenewAfter: RelayCertValidity * 2 / 3,
+			expectedEvents: []string{
+				"Normal Updated Renewed certificate external-services/ns1-es1-76a7fed5-tls for TLS transport",
+				"Normal Updated Renewed certificate external-services/gwrulec0a87ac8-tls for TLS transport",
+			},
+		},
+	}
+
+	s := runtime.NewScheme()
+	corev1.AddToScheme(s)
+	v1alpha1.AddToScheme(s)
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		cl := fake.NewFakeClientWithScheme(s, tc.objs...)
+		recorder := record.NewFakeRecorder(20)
+		r := &ReconcileExternalService{client: cl, scheme: s, recorder: recorder}
+
+		renewAfter, err := r.ensureRelayCertificates(tc.es)
+		if err != nil {
+			t.Errorf("expected no error, but got error %v", err)
+		}
+		// Certificates are issued some minutes before, to allow clock skew
+		if renewAfter > tc.expectedRenewAfter || renewAfter < tc.expectedRenewAfter-10*time.Minute {
+			t.Errorf("expected renew after %v, but got %v", tc.expectedRenewAfter, renewAfter)
+		}
+		close(recorder.Events)
+		events := []string{}
+		for event := range recorder.Events {
+			events = append(events, event)
+		}
+		if !reflect.DeepEqual(tc.expectedEvents, events) {
+			t.Errorf("expected events:%v, but got events:%v", tc.expectedEvents, events)
+		}
+		if !isTLS(tc.es) {
+			continue
+		}
+
+		// Certificates should be issued by the CA in the secret for the usages
+		ca := &corev1.Secret{}
+		if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: ConnectorNamespace, Name: RelayCASecretName}, ca); err != nil {
+			t.Fatalf("failed to get CA secret: %v", err)
+		}
+		for name, usage := range map[string]x509.ExtKeyUsage{fwdName: x509.ExtKeyUsageClientAuth, gwName: x509.ExtKeyUsageServerAuth} {
+			secret := &corev1.Secret{}
+			if err := cl.Get(context.TODO(), types.NamespacedName{Namespace: ConnectorNamespace, Name: util.TLSSecretName(name)}, secret); err != nil {
+				t.Fatalf("failed to get certificate secret: %v", err)
+			}
+			if !reflect.DeepEqual(ca.Data[util.TLSCAKey], secret.Data[util.TLSCAKey]) {
+				t.Errorf("expected certificate %s to have the CA in secret", name)
+			}
+			// Kept certificates are the ones given by the test case, which are issued for clients
+			if !contains(tc.renewed, name) {
+				usage = x509.ExtKeyUsageClientAuth
+			}
+			block, _ := pem.Decode(secret.Data[util.TLSCertKey])
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				t.Fatalf("failed to parse certificate %s: %v", name, err)
+			}
+			roots := x509.NewCertPool()
+			roots.AppendCertsFromPEM(ca.Data[util.TLSCAKey])
+			if _, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{usage}}); err != nil {
+				t.Errorf("expected certificate %s to be verified, but got error %v", name, err)
+			}
+		}
+	}
+}
+
+// contains returns true if {list} contains {s}
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
 		}
 	}
+	return false
 }
@@ -1,19 +1,66 @@
 package externalservice
 
 import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
 	submarinerv1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
+	"github.com/mkimuram/k8s-ext-connector/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
-// genForwardPodSpec returns a spec for a forwarder pod
-func genForwardPodSpec(cr *submarinerv1alpha1.ExternalService) *corev1.Pod {
+// ForwarderName returns the name of forwarder deployment, service and so on for {cr} in ConnectorNamespace.
+// The name is qualified by the namespace of {cr} and suffixed by hash of them to be unique among
+// external services in all namespaces. It is also truncated to be valid as a service name.
+func ForwarderName(cr *submarinerv1alpha1.ExternalService) string {
+	hasher := fnv.New32a()
+	hasher.Write([]byte(cr.Namespace + "/" + cr.Name))
+	suffix := fmt.Sprintf("%08x", hasher.Sum32())
+
+	// Dots are allowed in the name of external service, but not in service name
+	name := strings.Replace(cr.Namespace+"-"+cr.Name, ".", "-", -1)
+	if maxLen := validation.DNS1035LabelMaxLength - len(suffix) - 1; len(name) > maxLen {
+		name = name[:maxLen]
+	}
+
+	return strings.TrimRight(name, "-") + "-" + suffix
+}
+
+// genForwardDeploymentSpec returns a spec for a forwarder deployment.
+// Each forwarder pod handles the Forwarder CR that has the same name as the pod.
+// Defaults in the pod template are overridden by cr.Spec.ForwarderTemplate, and
+// hash of the pod template is set to ForwarderTemplateHashAnnotation to detect changes.
+func genForwardDeploymentSpec(cr *submarinerv1alpha1.ExternalService) *appsv1.Deployment {
 	labels := map[string]string{
 		ExternalServiceNamespaceLabel: cr.Namespace,
 		ExternalServiceNameLabel:      cr.Name,
 	}
-	isPrivileged := true
+	tmpl := getForwarderTemplate(cr)
 	var defaultMode int32 = 256
+	readOnlyRootFilesystem := true
+	allowPrivilegeEscalation := false
+
+	// Only capabilities required for ssh tunnels and iptables are given.
+	// It still runs as root, because capabilities are given to the processes of non-root users
+	// only via ambient capabilities, which aren't supported in k8s.
+	securityContext := &corev1.SecurityContext{
+		Capabilities: &corev1.Capabilities{
+			Add:  []corev1.Capability{"NET_ADMIN", "NET_RAW"},
+			Drop: []corev1.Capability{"ALL"},
+		},
+		ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+		AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+	}
 
 	env := []corev1.EnvVar{
 		{
@@ -21,8 +68,11 @@ func genForwardPodSpec(cr *submarinerv1alpha1.ExternalService) *corev1.Pod {
 			Value: ConnectorNamespace,
 		},
 		{
-			Name:  "FORWARDER_NAME",
-			Value: cr.Name,
+			Name: "FORWARDER_NAME",
+			ValueFrom: &corev1.EnvVarSource{
+				// APIVersion is set explicitly, because API server defaults it and it is compared to detect drift
+				FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.name"},
+			},
 		},
 	}
 
@@ -31,11 +81,25 @@ func genForwardPodSpec(cr *submarinerv1alpha1.ExternalService) *corev1.Pod {
 			Name: "ssh-key-volume",
 			VolumeSource: corev1.VolumeSource{
 				Secret: &corev1.SecretVolumeSource{
-					SecretName:  "my-ssh-key",
+					SecretName:  tmpl.SSHKeySecretName,
 					DefaultMode: &defaultMode,
 				},
 			},
 		},
+		// Writable directories for lock file of iptables and temporary files,
+		// because root filesystem is read-only
+		{
+			Name: "run",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		},
+		{
+			Name: "tmp",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		},
 	}
 
 	volumeMounts := []corev1.VolumeMount{
@@ -44,47 +108,213 @@ func genForwardPodSpec(cr *submarinerv1alpha1.ExternalService) *corev1.Pod {
 			MountPath: "/etc/ssh-key",
 			ReadOnly:  true,
 		},
+		{
+			Name:      "run",
+			MountPath: "/run",
+		},
+		{
+			Name:      "tmp",
+			MountPath: "/tmp",
+		},
+	}
+
+	// Certificate for TLS transport is mounted, and rotated certificates are loaded on each connection
+	if cr.Spec.Transport == submarinerv1alpha1.TransportTLS {
+		volumes = append(volumes, corev1.Volume{
+			Name: "relay-tls",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  util.TLSSecretName(ForwarderName(cr)),
+					DefaultMode: &defaultMode,
+				},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      "relay-tls",
+			MountPath: util.RelayTLSDir,
+			ReadOnly:  true,
+		})
+	}
+
+	// Prefer to spread forwarder pods across nodes to survive node failures
+	affinity := &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+				{
+					Weight: 100,
+					PodAffinityTerm: corev1.PodAffinityTerm{
+						LabelSelector: &metav1.LabelSelector{MatchLabels: labels},
+						TopologyKey:   corev1.LabelHostname,
+					},
+				},
+			},
+		},
+	}
+
+	podSpec := corev1.PodSpec{
+		ServiceAccountName: tmpl.ServiceAccountName,
+		ImagePullSecrets:   tmpl.ImagePullSecrets,
+		Containers: []corev1.Container{
+			{
+				Name:            "forwarder",
+				Image:           tmpl.Image,
+				ImagePullPolicy: tmpl.ImagePullPolicy,
+				SecurityContext: securityContext,
+				Resources:       tmpl.Resources,
+				Env:             env,
+				VolumeMounts:    volumeMounts,
+				Ports: []corev1.ContainerPort{
+					{
+						Name:          "metrics",
+						ContainerPort: util.MetricsPort,
+						Protocol:      corev1.ProtocolTCP,
+					},
+					{
+						Name:          "health",
+						ContainerPort: util.HealthPort,
+						Protocol:      corev1.ProtocolTCP,
+					},
+				},
+				LivenessProbe:  genProbe(util.HealthzPath, 10 /* periodSeconds */, 3 /* failureThreshold */),
+				ReadinessProbe: genProbe(util.ReadyzPath, 5 /* periodSeconds */, 1 /* failureThreshold */),
+			},
+		},
+		Volumes:           volumes,
+		Affinity:          affinity,
+		NodeSelector:      tmpl.NodeSelector,
+		Tolerations:       tmpl.Tolerations,
+		PriorityClassName: tmpl.PriorityClassName,
+	}
+
+	// Forwarder pods route packets to WireGuard tunnels for WireGuard transport, which requires IP forwarding
+	if cr.Spec.Transport == submarinerv1alpha1.TransportWireGuard {
+		podSpec.SecurityContext = &corev1.PodSecurityContext{
+			Sysctls: []corev1.Sysctl{
+				{
+					Name:  "net.ipv4.ip_forward",
+					Value: "1",
+				},
+			},
+		}
+	}
+
+	podTemplate := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      labels,
+			Annotations: tmpl.Annotations,
+		},
+		Spec: podSpec,
 	}
 
-	return &corev1.Pod{
+	return &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      cr.Name,
+			Name:      ForwarderName(cr),
 			Namespace: ConnectorNamespace,
 			Labels:    labels,
+			Annotations: map[string]string{
+				ForwarderTemplateHashAnnotation: hashPodTemplate(&podTemplate),
+			},
 		},
-		Spec: corev1.PodSpec{
-			// TODO: consider to restrict minimal access permissions
-			ServiceAccountName: "k8s-ext-connector",
-			Containers: []corev1.Container{
-				{
-					Name:            "forwarder",
-					Image:           "docker.io/mkimuram/forwarder:v0.3.0",
-					SecurityContext: &corev1.SecurityContext{Privileged: &isPrivileged},
-					Env:             env,
-					VolumeMounts:    volumeMounts,
-				},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: getReplicas(cr),
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: podTemplate,
+		},
+	}
+}
+
+// getForwarderTemplate returns cr.Spec.ForwarderTemplate merged over defaults
+func getForwarderTemplate(cr *submarinerv1alpha1.ExternalService) *submarinerv1alpha1.ForwarderTemplate {
+	tmpl := &submarinerv1alpha1.ForwarderTemplate{}
+	if cr.Spec.ForwarderTemplate != nil {
+		tmpl = cr.Spec.ForwarderTemplate.DeepCopy()
+	}
+
+	if tmpl.Image == "" {
+		tmpl.Image = DefaultForwarderImage
+	}
+	if tmpl.ServiceAccountName == "" {
+		tmpl.ServiceAccountName = ForwarderName(cr)
+	}
+	if tmpl.SSHKeySecretName == "" {
+		tmpl.SSHKeySecretName = DefaultSSHKeySecretName
+	}
+
+	return tmpl
+}
+
+// hashPodTemplate returns hash of {tmpl}, which changes if {tmpl} is changed
+func hashPodTemplate(tmpl *corev1.PodTemplateSpec) string {
+	// json.Marshal sorts keys of maps, so the same template always gets the same hash
+	data, err := json.Marshal(tmpl)
+	if err != nil {
+		// Pod template generated by operator can always be marshaled
+		return ""
+	}
+	hasher := fnv.New32a()
+	hasher.Write(data)
+
+	return strconv.FormatUint(uint64(hasher.Sum32()), 16)
+}
+
+// getReplicas returns the number of forwarder pods for {cr}
+func getReplicas(cr *submarinerv1alpha1.ExternalService) *int32 {
+	var replicas int32 = 1
+	if cr.Spec.Replicas != nil {
+		replicas = *cr.Spec.Replicas
+	}
+
+	return &replicas
+}
+
+// genForwardPDBSpec returns a spec for a pod disruption budget for forwarder pods,
+// which allows only one of them to be evicted at a time
+func genForwardPDBSpec(cr *submarinerv1alpha1.ExternalService) *policyv1beta1.PodDisruptionBudget {
+	labels := map[string]string{
+		ExternalServiceNamespaceLabel: cr.Namespace,
+		ExternalServiceNameLabel:      cr.Name,
+	}
+	maxUnavailable := intstr.FromInt(1)
+
+	return &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ForwarderName(cr),
+			Namespace: ConnectorNamespace,
+			Labels:    labels,
+		},
+		Spec: policyv1beta1.PodDisruptionBudgetSpec{
+			MaxUnavailable: &maxUnavailable,
+			Selector:       &metav1.LabelSelector{MatchLabels: labels},
+		},
+	}
+}
+
+// genProbe returns a probe that checks {path} on the health port of forwarder
+func genProbe(path string, periodSeconds, failureThreshold int32) *corev1.Probe {
+	return &corev1.Probe{
+		Handler: corev1.Handler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: path,
+				Port: intstr.FromString("health"),
 			},
-			Volumes: volumes,
 		},
+		PeriodSeconds:    periodSeconds,
+		FailureThreshold: failureThreshold,
 	}
 }
 
 // genForwardServiceSpec returns a spec for a forwarder service
 func genForwardServiceSpec(cr *submarinerv1alpha1.ExternalService) *corev1.Service {
-	var ports []corev1.ServicePort
-
 	labels := map[string]string{
 		ExternalServiceNamespaceLabel: cr.Namespace,
 		ExternalServiceNameLabel:      cr.Name,
 	}
 
-	for _, port := range cr.Spec.Ports {
-		ports = append(ports, port)
-	}
+	ports := servicePorts(cr)
 
 	return &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      cr.Name,
+			Name:      ForwarderName(cr),
 			Namespace: ConnectorNamespace,
 			Labels:    labels,
 		},
@@ -94,3 +324,351 @@ func genForwardServiceSpec(cr *submarinerv1alpha1.ExternalService) *corev1.Servi
 		},
 	}
 }
+
+// servicePorts returns ports of services for {cr}, which are Ports and a port for each port in PortRanges.
+// Ports in PortRanges are named by their protocol and port, like "tcp-30000", because services
+// that have multiple ports require names.
+func servicePorts(cr *submarinerv1alpha1.ExternalService) []corev1.ServicePort {
+	var ports []corev1.ServicePort
+	for _, port := range cr.Spec.Ports {
+		ports = append(ports, port)
+	}
+
+	for _, pr := range cr.Spec.PortRanges {
+		start, end, tStart, _ := portRangeBounds(pr)
+		protocol := portRangeProtocol(pr)
+		for port := start; port <= end; port++ {
+			ports = append(ports, corev1.ServicePort{
+				Name:       fmt.Sprintf("%s-%d", strings.ToLower(string(protocol)), port),
+				Protocol:   protocol,
+				Port:       port,
+				TargetPort: intstr.FromInt(int(tStart + port - start)),
+			})
+		}
+	}
+
+	return ports
+}
+
+// clientServiceName returns the name of the client service for {cr}
+func clientServiceName(cr *submarinerv1alpha1.ExternalService) string {
+	if cr.Spec.ClientService != nil && cr.Spec.ClientService.Name != "" {
+		return cr.Spec.ClientService.Name
+	}
+
+	return cr.Name
+}
+
+// genClientServiceLabels returns labels to identify the client service and endpoints for {cr}
+func genClientServiceLabels(cr *submarinerv1alpha1.ExternalService) map[string]string {
+	return map[string]string{
+		ExternalServiceNamespaceLabel: cr.Namespace,
+		ExternalServiceNameLabel:      cr.Name,
+		ClientServiceLabel:            "true",
+	}
+}
+
+// genClientServiceSpec returns a spec for a client service in the namespace of {cr}.
+// The service has no selector, because forwarder pods are in ConnectorNamespace,
+// so its endpoints are managed by genClientEndpointsSpec.
+func genClientServiceSpec(cr *submarinerv1alpha1.ExternalService) *corev1.Service {
+	ports := servicePorts(cr)
+
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clientServiceName(cr),
+			Namespace: cr.Namespace,
+			Labels:    genClientServiceLabels(cr),
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: ports,
+		},
+	}
+}
+
+// genClientEndpointsSpec returns a spec for endpoints of the client service, which point to {pods}.
+// Pods that aren't ready are set to NotReadyAddresses, and pods without IP are skipped.
+func genClientEndpointsSpec(cr *submarinerv1alpha1.ExternalService, pods []corev1.Pod) *corev1.Endpoints {
+	var addrs, notReadyAddrs []corev1.EndpointAddress
+	for _, pod := range pods {
+		if pod.Status.PodIP == "" {
+			continue
+		}
+		addr := corev1.EndpointAddress{
+			IP: pod.Status.PodIP,
+			TargetRef: &corev1.ObjectReference{
+				Kind:      "Pod",
+				Namespace: pod.Namespace,
+				Name:      pod.Name,
+				UID:       pod.UID,
+			},
+		}
+		if isPodReady(&pod) {
+			addrs = append(addrs, addr)
+		} else {
+			notReadyAddrs = append(notReadyAddrs, addr)
+		}
+	}
+
+	var subsets []corev1.EndpointSubset
+	if len(addrs) > 0 || len(notReadyAddrs) > 0 {
+		var ports []corev1.EndpointPort
+		for _, port := range servicePorts(cr) {
+			// Forwarder pods listen on target port
+			portNum := int32(port.TargetPort.IntValue())
+			if portNum == 0 {
+				portNum = port.Port
+			}
+			protocol := port.Protocol
+			if protocol == "" {
+				protocol = corev1.ProtocolTCP
+			}
+			ports = append(ports, corev1.EndpointPort{
+				Name:     port.Name,
+				Port:     portNum,
+				Protocol: protocol,
+			})
+		}
+		subsets = []corev1.EndpointSubset{
+			{
+				Addresses:         addrs,
+				NotReadyAddresses: notReadyAddrs,
+				Ports:             ports,
+			},
+		}
+	}
+
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clientServiceName(cr),
+			Namespace: cr.Namespace,
+			Labels:    genClientServiceLabels(cr),
+		},
+		Subsets: subsets,
+	}
+}
+
+// isPodReady returns true if {pod} has Ready condition with true
+func isPodReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// genForwardServiceAccountSpec returns a spec for a service account for forwarder pods,
+// which is used unless cr.Spec.ForwarderTemplate specifies another one
+func genForwardServiceAccountSpec(cr *submarinerv1alpha1.ExternalService) *corev1.ServiceAccount {
+	labels := map[string]string{
+		ExternalServiceNamespaceLabel: cr.Namespace,
+		ExternalServiceNameLabel:      cr.Name,
+	}
+
+	return &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ForwarderName(cr),
+			Namespace: ConnectorNamespace,
+			Labels:    labels,
+		},
+	}
+}
+
+// genForwardRoleSpec returns a spec for a role for forwarder pods, which allows
+// access only to forwarder CRs named {fwdNames}. All forwarder pods share the
+// role through the service account, so a pod can also access its siblings' CRs
+func genForwardRoleSpec(cr *submarinerv1alpha1.ExternalService, fwdNames []string) *rbacv1.Role {
+	labels := map[string]string{
+		ExternalServiceNamespaceLabel: cr.Namespace,
+		ExternalServiceNameLabel:      cr.Name,
+	}
+
+	// Empty resourceNames allows access to all, so no rules are given until forwarder pods exist
+	rules := []rbacv1.PolicyRule{}
+	if len(fwdNames) > 0 {
+		rules = []rbacv1.PolicyRule{
+			{
+				APIGroups:     []string{submarinerv1alpha1.SchemeGroupVersion.Group},
+				Resources:     []string{"forwarders"},
+				ResourceNames: fwdNames,
+				Verbs:         []string{"get", "list", "watch"},
+			},
+			{
+				APIGroups:     []string{submarinerv1alpha1.SchemeGroupVersion.Group},
+				Resources:     []string{"forwarders/status"},
+				ResourceNames: fwdNames,
+				Verbs:         []string{"update"},
+			},
+		}
+	}
+
+	return &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ForwarderName(cr),
+			Namespace: ConnectorNamespace,
+			Labels:    labels,
+		},
+		Rules: rules,
+	}
+}
+
+// genForwardEventsRoleSpec returns a spec for a role for forwarder pods,
+// which allows recording events on {cr} in its namespace
+func genForwardEventsRoleSpec(cr *submarinerv1alpha1.ExternalService) *rbacv1.Role {
+	labels := map[string]string{
+		ExternalServiceNamespaceLabel: cr.Namespace,
+		ExternalServiceNameLabel:      cr.Name,
+	}
+
+	return &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      forwarderEventsRoleName(cr),
+			Namespace: cr.Namespace,
+			Labels:    labels,
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{corev1.GroupName},
+				Resources: []string{"events"},
+				Verbs:     []string{"create", "patch"},
+			},
+		},
+	}
+}
+
+// genForwardRoleBindingSpec returns a spec for a role binding that binds {role}
+// to the service account of forwarder pods
+func genForwardRoleBindingSpec(cr *submarinerv1alpha1.ExternalService, role *rbacv1.Role) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      role.Name,
+			Namespace: role.Namespace,
+			Labels:    role.Labels,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      getForwarderTemplate(cr).ServiceAccountName,
+				Namespace: ConnectorNamespace,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role.Name,
+		},
+	}
+}
+
+// forwarderEventsRoleName returns the name of role and role binding for forwarder pods to record events on {cr}
+func forwarderEventsRoleName(cr *submarinerv1alpha1.ExternalService) string {
+	return "externalservice-forwarder-" + cr.Name
+}
+
+// podTemplateDrifted returns true if image, env or volumes in {found} pod template differ from {expected}
+func podTemplateDrifted(found, expected *corev1.PodTemplateSpec) bool {
+	if !isSubset(expected.Labels, found.Labels) {
+		return true
+	}
+	if len(found.Spec.Containers) != len(expected.Spec.Containers) {
+		return true
+	}
+	for i := range expected.Spec.Containers {
+		if found.Spec.Containers[i].Image != expected.Spec.Containers[i].Image {
+			return true
+		}
+		if !equality.Semantic.DeepEqual(found.Spec.Containers[i].Env, expected.Spec.Containers[i].Env) {
+			return true
+		}
+	}
+
+	return !equality.Semantic.DeepEqual(found.Spec.Volumes, expected.Spec.Volumes)
+}
+
+// serviceDrifted returns true if ports, selector or labels of {found} service differ from {expected}
+func serviceDrifted(found, expected *corev1.Service) bool {
+	if !isSubset(expected.Labels, found.Labels) {
+		return true
+	}
+	if !equality.Semantic.DeepEqual(found.Spec.Selector, expected.Spec.Selector) {
+		return true
+	}
+
+	return !equality.Semantic.DeepEqual(defaultServicePorts(found.Spec.Ports), defaultServicePorts(expected.Spec.Ports))
+}
+
+// defaultServicePorts returns a copy of {ports} with fields defaulted like API server does,
+// so that ports can be compared with those read from API server
+func defaultServicePorts(ports []corev1.ServicePort) []corev1.ServicePort {
+	defaulted := []corev1.ServicePort{}
+	for _, port := range ports {
+		if port.Protocol == "" {
+			port.Protocol = corev1.ProtocolTCP
+		}
+		if port.TargetPort.Type == intstr.Int && port.TargetPort.IntVal == 0 {
+			port.TargetPort = intstr.FromInt(int(port.Port))
+		}
+		defaulted = append(defaulted, port)
+	}
+
+	return defaulted
+}
+
+// endpointsDrifted returns true if subsets or labels of {found} endpoints differ from {expected}
+func endpointsDrifted(found, expected *corev1.Endpoints) bool {
+	if !isSubset(expected.Labels, found.Labels) {
+		return true
+	}
+
+	return !equality.Semantic.DeepEqual(found.Subsets, expected.Subsets)
+}
+
+// isManagedBy returns true if {obj} has the labels for {cr}
+func isManagedBy(obj metav1.Object, cr *submarinerv1alpha1.ExternalService) bool {
+	return isSubset(genLabels(cr), obj.GetLabels())
+}
+
+// isSubset returns true if all the keys and values in {sub} are in {m}
+func isSubset(sub, m map[string]string) bool {
+	for k, v := range sub {
+		if val, ok := m[k]; !ok || val != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// genRelayCASecretSpec returns a spec for the secret of the CA for TLS transport with {caCert} and {caKey}
+func genRelayCASecretSpec(caCert, caKey []byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      RelayCASecretName,
+			Namespace: ConnectorNamespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			util.TLSCAKey:           caCert,
+			util.TLSCAPrivateKeyKey: caKey,
+		},
+	}
+}
+
+// genRelayCertSecretSpec returns a spec for the secret of the certificate for TLS transport of the forwarder
+// or the gateway named {name}, with {certPEM}, {keyPEM} and the CA certificate {caCert} to verify the peer
+func genRelayCertSecretSpec(name string, certPEM, keyPEM, caCert []byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      util.TLSSecretName(name),
+			Namespace: ConnectorNamespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			util.TLSCertKey:       certPEM,
+			util.TLSPrivateKeyKey: keyPEM,
+			util.TLSCAKey:         caCert,
+		},
+	}
+}
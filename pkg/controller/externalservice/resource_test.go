@@ -2,57 +2,75 @@ package externalservice
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	"github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
 )
 
 var (
-	isPrivileged       = true
-	defaultMode  int32 = 256
+	readOnlyRootFilesystem         = true
+	allowPrivilegeEscalation       = false
+	defaultMode              int32 = 256
+	esLabels                       = map[string]string{
+		ExternalServiceNamespaceLabel: "ns1",
+		ExternalServiceNameLabel:      "es1",
+	}
 )
 
-func TestGenForwardPodSpec(t *testing.T) {
-	testCases := []struct {
-		name     string
-		es       *v1alpha1.ExternalService
-		expected *corev1.Pod
-	}{
-		{
-			name: "Normal case",
-			es: &v1alpha1.ExternalService{
-				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "es1",
-				},
-			},
-			expected: &corev1.Pod{
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+// expectedDeployment returns the forwarder deployment expected for es1 in ns1 with {replicas}.
+// {modify} is called to customize the pod template before its hash is calculated, if not nil.
+func expectedDeployment(replicas int32, modify func(*corev1.PodTemplateSpec)) *appsv1.Deployment {
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "ns1-es1-76a7fed5",
+			Namespace: "external-services",
+			Labels:    esLabels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(replicas),
+			Selector: &metav1.LabelSelector{MatchLabels: esLabels},
+			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Name:      "es1",
-					Namespace: "external-services",
-					Labels: map[string]string{
-						ExternalServiceNamespaceLabel: "ns1",
-						ExternalServiceNameLabel:      "es1",
-					},
+					Labels: esLabels,
 				},
 				Spec: corev1.PodSpec{
-					ServiceAccountName: "k8s-ext-connector",
+					ServiceAccountName: "ns1-es1-76a7fed5",
 					Containers: []corev1.Container{
 						{
-							Name:            "forwarder",
-							Image:           "docker.io/mkimuram/forwarder:v0.3.0",
-							SecurityContext: &corev1.SecurityContext{Privileged: &isPrivileged},
+							Name:  "forwarder",
+							Image: "docker.io/mkimuram/forwarder:v0.3.0",
+							SecurityContext: &corev1.SecurityContext{
+								Capabilities: &corev1.Capabilities{
+									Add:  []corev1.Capability{"NET_ADMIN", "NET_RAW"},
+									Drop: []corev1.Capability{"ALL"},
+								},
+								ReadOnlyRootFilesystem:   &readOnlyRootFilesystem,
+								AllowPrivilegeEscalation: &allowPrivilegeEscalation,
+							},
 							Env: []corev1.EnvVar{
 								{
 									Name:  "FORWARDER_NAMESPACE",
 									Value: "external-services",
 								},
 								{
-									Name:  "FORWARDER_NAME",
-									Value: "es1",
+									Name: "FORWARDER_NAME",
+									ValueFrom: &corev1.EnvVarSource{
+										FieldRef: &corev1.ObjectFieldSelector{APIVersion: "v1", FieldPath: "metadata.name"},
+									},
 								},
 							},
 							VolumeMounts: []corev1.VolumeMount{
@@ -61,6 +79,46 @@ func TestGenForwardPodSpec(t *testing.T) {
 									MountPath: "/etc/ssh-key",
 									ReadOnly:  true,
 								},
+								{
+									Name:      "run",
+									MountPath: "/run",
+								},
+								{
+									Name:      "tmp",
+									MountPath: "/tmp",
+								},
+							},
+							Ports: []corev1.ContainerPort{
+								{
+									Name:          "metrics",
+									ContainerPort: 2021,
+									Protocol:      corev1.ProtocolTCP,
+								},
+								{
+									Name:          "health",
+									ContainerPort: 2020,
+									Protocol:      corev1.ProtocolTCP,
+								},
+							},
+							LivenessProbe: &corev1.Probe{
+								Handler: corev1.Handler{
+									HTTPGet: &corev1.HTTPGetAction{
+										Path: "/healthz",
+										Port: intstr.FromString("health"),
+									},
+								},
+								PeriodSeconds:    10,
+								FailureThreshold: 3,
+							},
+							ReadinessProbe: &corev1.Probe{
+								Handler: corev1.Handler{
+									HTTPGet: &corev1.HTTPGetAction{
+										Path: "/readyz",
+										Port: intstr.FromString("health"),
+									},
+								},
+								PeriodSeconds:    5,
+								FailureThreshold: 1,
 							},
 						},
 					},
@@ -74,7 +132,193 @@ func TestGenForwardPodSpec(t *testing.T) {
 								},
 							},
 						},
+						{
+							Name: "run",
+							VolumeSource: corev1.VolumeSource{
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
+							},
+						},
+						{
+							Name: "tmp",
+							VolumeSource: corev1.VolumeSource{
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
+							},
+						},
+					},
+					Affinity: &corev1.Affinity{
+						PodAntiAffinity: &corev1.PodAntiAffinity{
+							PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+								{
+									Weight: 100,
+									PodAffinityTerm: corev1.PodAffinityTerm{
+										LabelSelector: &metav1.LabelSelector{MatchLabels: esLabels},
+										TopologyKey:   "kubernetes.io/hostname",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if modify != nil {
+		modify(&deploy.Spec.Template)
+	}
+	deploy.Annotations = map[string]string{
+		ForwarderTemplateHashAnnotation: hashPodTemplate(&deploy.Spec.Template),
+	}
+
+	return deploy
+}
+
+func TestGenForwardDeploymentSpec(t *testing.T) {
+	testCases := []struct {
+		name     string
+		es       *v1alpha1.ExternalService
+		expected *appsv1.Deployment
+	}{
+		{
+			name: "Normal case (default replicas)",
+			es: &v1alpha1.ExternalService{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			expected: expectedDeployment(1, nil),
+		},
+		{
+			name: "Normal case (3 replicas)",
+			es: &v1alpha1.ExternalService{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+				Spec: v1alpha1.ExternalServiceSpec{
+					Replicas: int32Ptr(3),
+				},
+			},
+			expected: expectedDeployment(3, nil),
+		},
+		{
+			name: "Normal case (forwarder template)",
+			es: &v1alpha1.ExternalService{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+				Spec: v1alpha1.ExternalServiceSpec{
+					ForwarderTemplate: &v1alpha1.ForwarderTemplate{
+						Image:            "registry.example.com/forwarder:v0.4.0",
+						ImagePullSecrets: []corev1.LocalObjectReference{{Name: "pull-secret"}},
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+						},
+						NodeSelector:      map[string]string{"node-role.kubernetes.io/edge": ""},
+						Tolerations:       []corev1.Toleration{{Key: "edge", Operator: corev1.TolerationOpExists}},
+						PriorityClassName: "system-cluster-critical",
+						Annotations:       map[string]string{"example.com/owner": "network"},
+						SSHKeySecretName:  "ssh-key",
+					},
+				},
+			},
+			expected: expectedDeployment(1, func(tmpl *corev1.PodTemplateSpec) {
+				tmpl.Annotations = map[string]string{"example.com/owner": "network"}
+				tmpl.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "pull-secret"}}
+				tmpl.Spec.NodeSelector = map[string]string{"node-role.kubernetes.io/edge": ""}
+				tmpl.Spec.Tolerations = []corev1.Toleration{{Key: "edge", Operator: corev1.TolerationOpExists}}
+				tmpl.Spec.PriorityClassName = "system-cluster-critical"
+				tmpl.Spec.Volumes[0].Secret.SecretName = "ssh-key"
+				tmpl.Spec.Containers[0].Image = "registry.example.com/forwarder:v0.4.0"
+				tmpl.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				}
+			}),
+		},
+		{
+			name: "Normal case (wireguard transport)",
+			es: &v1alpha1.ExternalService{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+				Spec: v1alpha1.ExternalServiceSpec{
+					Transport: v1alpha1.TransportWireGuard,
+				},
+			},
+			expected: expectedDeployment(1, func(tmpl *corev1.PodTemplateSpec) {
+				tmpl.Spec.SecurityContext = &corev1.PodSecurityContext{
+					Sysctls: []corev1.Sysctl{{Name: "net.ipv4.ip_forward", Value: "1"}},
+				}
+			}),
+		},
+		{
+			name: "Normal case (tls transport)",
+			es: &v1alpha1.ExternalService{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+				Spec: v1alpha1.ExternalServiceSpec{
+					Transport: v1alpha1.TransportTLS,
+				},
+			},
+			expected: expectedDeployment(1, func(tmpl *corev1.PodTemplateSpec) {
+				defaultMode := int32(256)
+				tmpl.Spec.Volumes = append(tmpl.Spec.Volumes, corev1.Volume{
+					Name: "relay-tls",
+					VolumeSource: corev1.VolumeSource{
+						Secret: &corev1.SecretVolumeSource{
+							SecretName:  "ns1-es1-76a7fed5-tls",
+							DefaultMode: &defaultMode,
+						},
 					},
+				})
+				tmpl.Spec.Containers[0].VolumeMounts = append(tmpl.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+					Name:      "relay-tls",
+					MountPath: "/etc/relay-tls",
+					ReadOnly:  true,
+				})
+			}),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		spec := genForwardDeploymentSpec(tc.es)
+
+		if !reflect.DeepEqual(tc.expected, spec) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, spec)
+		}
+	}
+}
+
+func TestGenForwardPDBSpec(t *testing.T) {
+	maxUnavailable := intstr.FromInt(1)
+
+	testCases := []struct {
+		name     string
+		es       *v1alpha1.ExternalService
+		expected *policyv1beta1.PodDisruptionBudget
+	}{
+		{
+			name: "Normal case",
+			es: &v1alpha1.ExternalService{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			expected: &policyv1beta1.PodDisruptionBudget{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "ns1-es1-76a7fed5",
+					Namespace: "external-services",
+					Labels:    esLabels,
+				},
+				Spec: policyv1beta1.PodDisruptionBudgetSpec{
+					MaxUnavailable: &maxUnavailable,
+					Selector:       &metav1.LabelSelector{MatchLabels: esLabels},
 				},
 			},
 		},
@@ -82,7 +326,7 @@ func TestGenForwardPodSpec(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Logf("test case: %s", tc.name)
-		spec := genForwardPodSpec(tc.es)
+		spec := genForwardPDBSpec(tc.es)
 
 		if !reflect.DeepEqual(tc.expected, spec) {
 			t.Errorf("expected:%v, but got:%v", tc.expected, spec)
@@ -124,7 +368,63 @@ func TestGenForwardServiceSpec(t *testing.T) {
 			},
 			expected: &corev1.Service{
 				ObjectMeta: metav1.ObjectMeta{
+					Name:      "ns1-es1-76a7fed5",
+					Namespace: "external-services",
+					Labels: map[string]string{
+						ExternalServiceNamespaceLabel: "ns1",
+						ExternalServiceNameLabel:      "es1",
+					},
+				},
+				Spec: corev1.ServiceSpec{
+					Ports: []corev1.ServicePort{
+						{
+							Protocol: "TCP",
+							Port:     80,
+						},
+					},
+					Selector: map[string]string{
+						ExternalServiceNamespaceLabel: "ns1",
+						ExternalServiceNameLabel:      "es1",
+					},
+				},
+			},
+		},
+		{
+			name: "Port range",
+			es: &v1alpha1.ExternalService{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
 					Name:      "es1",
+				},
+				Spec: v1alpha1.ExternalServiceSpec{
+					Sources: []v1alpha1.Source{
+						{
+							Service: v1alpha1.ServiceRef{
+								Name:      "svc1",
+								Namespace: "es1",
+							},
+							SourceIP: "192.168.122.200",
+						},
+					},
+					Ports: []corev1.ServicePort{
+						{
+							Name:     "http",
+							Protocol: "TCP",
+							Port:     80,
+						},
+					},
+					PortRanges: []v1alpha1.PortRange{
+						{
+							Port:       30000,
+							EndPort:    30002,
+							TargetPort: 40000,
+						},
+					},
+				},
+			},
+			expected: &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "ns1-es1-76a7fed5",
 					Namespace: "external-services",
 					Labels: map[string]string{
 						ExternalServiceNamespaceLabel: "ns1",
@@ -134,9 +434,28 @@ func TestGenForwardServiceSpec(t *testing.T) {
 				Spec: corev1.ServiceSpec{
 					Ports: []corev1.ServicePort{
 						{
+							Name:     "http",
 							Protocol: "TCP",
 							Port:     80,
 						},
+						{
+							Name:       "tcp-30000",
+							Protocol:   "TCP",
+							Port:       30000,
+							TargetPort: intstr.FromInt(40000),
+						},
+						{
+							Name:       "tcp-30001",
+							Protocol:   "TCP",
+							Port:       30001,
+							TargetPort: intstr.FromInt(40001),
+						},
+						{
+							Name:       "tcp-30002",
+							Protocol:   "TCP",
+							Port:       30002,
+							TargetPort: intstr.FromInt(40002),
+						},
 					},
 					Selector: map[string]string{
 						ExternalServiceNamespaceLabel: "ns1",
@@ -156,3 +475,417 @@ func TestGenForwardServiceSpec(t *testing.T) {
 		}
 	}
 }
+
+func TestForwarderName(t *testing.T) {
+	testCases := []struct {
+		name      string
+		namespace string
+		esName    string
+		// conflicting is another namespace and name, whose forwarder name should differ
+		conflicting [2]string
+	}{
+		{
+			name:        "Normal case",
+			namespace:   "ns1",
+			esName:      "es1",
+			conflicting: [2]string{"ns2", "es1"},
+		},
+		{
+			name:        "Normal case (dashes in namespace and name)",
+			namespace:   "ns-1",
+			esName:      "es",
+			conflicting: [2]string{"ns", "1-es"},
+		},
+		{
+			name:        "Normal case (dots in name)",
+			namespace:   "ns1",
+			esName:      "es.1",
+			conflicting: [2]string{"ns1", "es-1"},
+		},
+		{
+			name:        "Normal case (long namespace and name)",
+			namespace:   strings.Repeat("n", 63),
+			esName:      strings.Repeat("e", 253),
+			conflicting: [2]string{strings.Repeat("n", 63), strings.Repeat("e", 252)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		name := ForwarderName(&v1alpha1.ExternalService{ObjectMeta: metav1.ObjectMeta{Namespace: tc.namespace, Name: tc.esName}})
+		conflicting := ForwarderName(&v1alpha1.ExternalService{ObjectMeta: metav1.ObjectMeta{Namespace: tc.conflicting[0], Name: tc.conflicting[1]}})
+
+		if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+			t.Errorf("expected valid name, but got %q: %v", name, errs)
+		}
+		if name == conflicting {
+			t.Errorf("expected different names, but got %q for both", name)
+		}
+	}
+
+	if name := ForwarderName(es); name != "ns1-es1-76a7fed5" {
+		t.Errorf("expected:%q, but got:%q", "ns1-es1-76a7fed5", name)
+	}
+}
+
+func TestGenClientServiceSpec(t *testing.T) {
+	clientLabels := map[string]string{
+		ExternalServiceNamespaceLabel: "ns1",
+		ExternalServiceNameLabel:      "es1",
+		ClientServiceLabel:            "true",
+	}
+
+	testCases := []struct {
+		name          string
+		clientService *v1alpha1.ClientService
+		expected      *corev1.Service
+	}{
+		{
+			name:          "Normal case (default name)",
+			clientService: &v1alpha1.ClientService{},
+			expected: &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "es1",
+					Namespace: "ns1",
+					Labels:    clientLabels,
+				},
+				Spec: corev1.ServiceSpec{
+					Ports: es.Spec.Ports,
+				},
+			},
+		},
+		{
+			name:          "Normal case (name specified)",
+			clientService: &v1alpha1.ClientService{Name: "my-svc"},
+			expected: &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "my-svc",
+					Namespace: "ns1",
+					Labels:    clientLabels,
+				},
+				Spec: corev1.ServiceSpec{
+					Ports: es.Spec.Ports,
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		cr := es.DeepCopy()
+		cr.Spec.ClientService = tc.clientService
+		spec := genClientServiceSpec(cr)
+
+		if !reflect.DeepEqual(tc.expected, spec) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, spec)
+		}
+	}
+}
+
+func TestGenClientEndpointsSpec(t *testing.T) {
+	readyPod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "es1-a", Namespace: "external-services", UID: "uid-a"},
+		Status: corev1.PodStatus{
+			PodIP:      "10.0.0.3",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	notReadyPod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "es1-b", Namespace: "external-services", UID: "uid-b"},
+		Status: corev1.PodStatus{
+			PodIP:      "10.0.0.4",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}},
+		},
+	}
+	noIPPod := corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "es1-c", Namespace: "external-services", UID: "uid-c"},
+	}
+	ports := []corev1.EndpointPort{
+		{
+			Port:     8080,
+			Protocol: corev1.ProtocolTCP,
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		pods     []corev1.Pod
+		expected []corev1.EndpointSubset
+	}{
+		{
+			name:     "Normal case (no pods)",
+			pods:     []corev1.Pod{},
+			expected: nil,
+		},
+		{
+			name:     "Normal case (pod without IP)",
+			pods:     []corev1.Pod{noIPPod},
+			expected: nil,
+		},
+		{
+			name: "Normal case (ready and not ready pods)",
+			pods: []corev1.Pod{readyPod, notReadyPod, noIPPod},
+			expected: []corev1.EndpointSubset{
+				{
+					Addresses: []corev1.EndpointAddress{
+						{
+							IP:        "10.0.0.3",
+							TargetRef: &corev1.ObjectReference{Kind: "Pod", Namespace: "external-services", Name: "es1-a", UID: "uid-a"},
+						},
+					},
+					NotReadyAddresses: []corev1.EndpointAddress{
+						{
+							IP:        "10.0.0.4",
+							TargetRef: &corev1.ObjectReference{Kind: "Pod", Namespace: "external-services", Name: "es1-b", UID: "uid-b"},
+						},
+					},
+					Ports: ports,
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		cr := es.DeepCopy()
+		cr.Spec.ClientService = &v1alpha1.ClientService{}
+		spec := genClientEndpointsSpec(cr, tc.pods)
+
+		if spec.Namespace != "ns1" || spec.Name != "es1" {
+			t.Errorf("expected endpoints ns1/es1, but got %s/%s", spec.Namespace, spec.Name)
+		}
+		if !reflect.DeepEqual(tc.expected, spec.Subsets) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, spec.Subsets)
+		}
+	}
+}
+
+func TestGenForwardRoleSpec(t *testing.T) {
+	es := &v1alpha1.ExternalService{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns1",
+			Name:      "es1",
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		fwdNames []string
+		expected []rbacv1.PolicyRule
+	}{
+		{
+			name:     "Normal case (no forwarder pods)",
+			fwdNames: []string{},
+			expected: []rbacv1.PolicyRule{},
+		},
+		{
+			name:     "Normal case (forwarder pods)",
+			fwdNames: []string{"es1-a", "es1-b"},
+			expected: []rbacv1.PolicyRule{
+				{
+					APIGroups:     []string{"submariner.io"},
+					Resources:     []string{"forwarders"},
+					ResourceNames: []string{"es1-a", "es1-b"},
+					Verbs:         []string{"get", "list", "watch"},
+				},
+				{
+					APIGroups:     []string{"submariner.io"},
+					Resources:     []string{"forwarders/status"},
+					ResourceNames: []string{"es1-a", "es1-b"},
+					Verbs:         []string{"update"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		role := genForwardRoleSpec(es, tc.fwdNames)
+
+		if role.Namespace != "external-services" || role.Name != "ns1-es1-76a7fed5" {
+			t.Errorf("expected external-services/ns1-es1-76a7fed5, but got %s/%s", role.Namespace, role.Name)
+		}
+		if !reflect.DeepEqual(tc.expected, role.Rules) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, role.Rules)
+		}
+	}
+}
+
+func TestGenForwardRoleBindingSpec(t *testing.T) {
+	testCases := []struct {
+		name     string
+		es       *v1alpha1.ExternalService
+		expected []rbacv1.Subject
+	}{
+		{
+			name: "Normal case (default service account)",
+			es: &v1alpha1.ExternalService{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+			},
+			expected: []rbacv1.Subject{
+				{Kind: "ServiceAccount", Name: "ns1-es1-76a7fed5", Namespace: "external-services"},
+			},
+		},
+		{
+			name: "Normal case (service account in forwarder template)",
+			es: &v1alpha1.ExternalService{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "es1",
+				},
+				Spec: v1alpha1.ExternalServiceSpec{
+					ForwarderTemplate: &v1alpha1.ForwarderTemplate{ServiceAccountName: "sa1"},
+				},
+			},
+			expected: []rbacv1.Subject{
+				{Kind: "ServiceAccount", Name: "sa1", Namespace: "external-services"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		role := genForwardEventsRoleSpec(tc.es)
+		rb := genForwardRoleBindingSpec(tc.es, role)
+
+		if rb.Namespace != "ns1" || rb.Name != "externalservice-forwarder-es1" {
+			t.Errorf("expected ns1/externalservice-forwarder-es1, but got %s/%s", rb.Namespace, rb.Name)
+		}
+		if rb.RoleRef.Name != role.Name {
+			t.Errorf("expected role %s, but got %s", role.Name, rb.RoleRef.Name)
+		}
+		if !reflect.DeepEqual(tc.expected, rb.Subjects) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, rb.Subjects)
+		}
+	}
+}
+
+func TestServiceDrifted(t *testing.T) {
+	es := &v1alpha1.ExternalService{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns1",
+			Name:      "es1",
+		},
+		Spec: v1alpha1.ExternalServiceSpec{
+			Ports: []corev1.ServicePort{
+				{
+					Port: 80,
+				},
+			},
+		},
+	}
+	expected := genForwardServiceSpec(es)
+
+	testCases := []struct {
+		name     string
+		modify   func(*corev1.Service)
+		expected bool
+	}{
+		{
+			name: "Normal case (only defaulted by API server)",
+			modify: func(svc *corev1.Service) {
+				svc.Labels = map[string]string{
+					ExternalServiceNamespaceLabel: "ns1",
+					ExternalServiceNameLabel:      "es1",
+					"app":                         "forwarder",
+				}
+				svc.Spec.ClusterIP = "10.96.0.10"
+				svc.Spec.Type = corev1.ServiceTypeClusterIP
+				svc.Spec.Ports[0].Protocol = corev1.ProtocolTCP
+				svc.Spec.Ports[0].TargetPort = intstr.FromInt(80)
+			},
+			expected: false,
+		},
+		{
+			name: "Normal case (ports changed)",
+			modify: func(svc *corev1.Service) {
+				svc.Spec.Ports[0].Port = 8080
+			},
+			expected: true,
+		},
+		{
+			name: "Normal case (selector changed)",
+			modify: func(svc *corev1.Service) {
+				svc.Spec.Selector = map[string]string{"app": "forwarder"}
+			},
+			expected: true,
+		},
+		{
+			name: "Normal case (label removed)",
+			modify: func(svc *corev1.Service) {
+				delete(svc.Labels, ExternalServiceNameLabel)
+			},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		found := genForwardServiceSpec(es)
+		tc.modify(found)
+
+		if drifted := serviceDrifted(found, expected); tc.expected != drifted {
+			t.Errorf("expected %v, but got %v", tc.expected, drifted)
+		}
+	}
+}
+
+func TestPodTemplateDrifted(t *testing.T) {
+	es := &v1alpha1.ExternalService{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns1",
+			Name:      "es1",
+		},
+	}
+	expected := genForwardDeploymentSpec(es)
+
+	testCases := []struct {
+		name     string
+		modify   func(*corev1.PodTemplateSpec)
+		expected bool
+	}{
+		{
+			name: "Normal case (only defaulted by API server)",
+			modify: func(tmpl *corev1.PodTemplateSpec) {
+				tmpl.Spec.RestartPolicy = corev1.RestartPolicyAlways
+				tmpl.Spec.Containers[0].TerminationMessagePath = corev1.TerminationMessagePathDefault
+				tmpl.Spec.Containers[0].LivenessProbe.TimeoutSeconds = 1
+			},
+			expected: false,
+		},
+		{
+			name: "Normal case (image changed)",
+			modify: func(tmpl *corev1.PodTemplateSpec) {
+				tmpl.Spec.Containers[0].Image = "docker.io/mkimuram/forwarder:latest"
+			},
+			expected: true,
+		},
+		{
+			name: "Normal case (env changed)",
+			modify: func(tmpl *corev1.PodTemplateSpec) {
+				tmpl.Spec.Containers[0].Env[0].Value = "default"
+			},
+			expected: true,
+		},
+		{
+			name: "Normal case (volumes changed)",
+			modify: func(tmpl *corev1.PodTemplateSpec) {
+				tmpl.Spec.Volumes[0].Secret.SecretName = "other-ssh-key"
+			},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		found := genForwardDeploymentSpec(es)
+		tc.modify(&found.Spec.Template)
+
+		if drifted := podTemplateDrifted(&found.Spec.Template, &expected.Spec.Template); tc.expected != drifted {
+			t.Errorf("expected %v, but got %v", tc.expected, drifted)
+		}
+	}
+}
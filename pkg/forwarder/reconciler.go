@@ -2,16 +2,68 @@ package forwarder
 
 import (
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
 	clv1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned/typed/submariner/v1alpha1"
 	"github.com/mkimuram/k8s-ext-connector/pkg/util"
 	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 )
 
+const (
+	// tunnelDownGracePeriod is the time that a tunnel is allowed to be connecting or backing off
+	// before rules are regarded as not synced and the tunnel is recreated
+	tunnelDownGracePeriod = 2 * time.Minute
+	// limitStatusInterval is the minimum interval to publish counters of limiters,
+	// which change frequently, to the forwarder's status
+	limitStatusInterval = 30 * time.Second
+)
+
+// TunnelOptions represents options for tunnels created by Reconciler
+type TunnelOptions struct {
+	// DrainTimeout is the grace period for connections on deleted tunnels to finish
+	DrainTimeout time.Duration
+	// KeepAliveInterval is the interval to send keepalive to gateway. Zero disables keepalive.
+	KeepAliveInterval time.Duration
+	// KeepAliveCountMax is the number of keepalive that gateway can miss before reconnecting
+	KeepAliveCountMax int
+}
+
+// egressGateway is the state of egress through a gateway, which is used to check readiness
+type egressGateway struct {
+	// tunnels are the tunnels for egress rules through the gateway
+	tunnels []*util.Tunnel
+	// noWireGuardKey is true if there are rules for WireGuard, but the gateway hasn't published its key
+	noWireGuardKey bool
+}
+
+// ready returns error describing why egress through the gateway isn't ready, or nil if all the tunnels
+// are established and the key for WireGuard is published
+func (e *egressGateway) ready() error {
+	for _, tunnel := range e.tunnels {
+		if tunnel == nil {
+			return fmt.Errorf("tunnel for rules is not created")
+		}
+		if st := tunnel.Status(); st.State != util.TunnelEstablished {
+			return fmt.Errorf("tunnel %q is %s", tunnel.String(), st.State)
+		}
+	}
+	if e.noWireGuardKey {
+		return fmt.Errorf("gateway hasn't published wireguard key")
+	}
+
+	return nil
+}
+
 // Reconciler represents a reconciler for forwarder
 type Reconciler struct {
 	clientset     clv1alpha1.SubmarinerV1alpha1Interface
@@ -20,14 +72,42 @@ type Reconciler struct {
 	tunnels       map[string]*util.Tunnel
 	remoteTunnels map[string]*util.Tunnel
 	config        *ssh.ClientConfig
+	tunnelOptions TunnelOptions
+	recorder      record.EventRecorder
+	// limiters are limiters for sources keyed by GatewayIP
+	limiters        map[string]*util.Limiter
+	limitStatusTime time.Time
+	// tunnelLabels are labels of metrics for tunnels keyed by the same keys as tunnels
+	tunnelLabels map[string]util.TunnelLabels
+	// stoppingTunnels are deleted tunnels that are draining connections.
+	// They are kept until they stop, because new tunnels may reuse the same relay ports.
+	stoppingTunnels []*util.Tunnel
+	// readyMutex protects below fields used to check readiness, because it is checked concurrently.
+	// syncedFwd is the forwarder whose rules are synced with syncedEgress, or nil if not synced.
+	// syncedEgress are the states of egress through gateways keyed by GatewayIP, which are cached
+	// when the rules are synced. generation is the latest metadata.generation observed.
+	readyMutex   sync.Mutex
+	syncedFwd    *v1alpha1.Forwarder
+	syncedEgress map[string]*egressGateway
+	generation   int64
+	// wireGuardPrivateKey and wireGuardPublicKey are the key pair of the forwarder for WireGuard,
+	// which is generated on start and published to the forwarder's status.
+	// wireGuards are indexes of WireGuard interfaces created keyed by their names.
+	wireGuardPrivateKey string
+	wireGuardPublicKey  string
+	wireGuards          map[string]int
+	// driftCheckInterval is the interval to requeue the forwarder to check drift of the synced rules
+	driftCheckInterval time.Duration
 }
 
 var _ util.ReconcilerInterface = &Reconciler{}
 
-// NewReconciler returns a Reconciler instance
-func NewReconciler(cl clv1alpha1.SubmarinerV1alpha1Interface, namespace, name string) *Reconciler {
+// NewReconciler returns a Reconciler instance.
+// Events are recorded by {recorder} on the external service that the forwarder belongs to.
+func NewReconciler(cl clv1alpha1.SubmarinerV1alpha1Interface, namespace, name string, tunnelOptions TunnelOptions, recorder record.EventRecorder) *Reconciler {
+	// Gateways identify the forwarder by the user, because its address can be translated on the way
 	// TODO: Create clientconfig properly
-	user := "root"
+	user := util.ForwarderID(namespace, name)
 	password := "password"
 
 	return &Reconciler{
@@ -36,6 +116,9 @@ func NewReconciler(cl clv1alpha1.SubmarinerV1alpha1Interface, namespace, name st
 		name:          name,
 		tunnels:       map[string]*util.Tunnel{},
 		remoteTunnels: map[string]*util.Tunnel{},
+		limiters:      map[string]*util.Limiter{},
+		tunnelLabels:  map[string]util.TunnelLabels{},
+		wireGuards:    map[string]int{},
 		config: &ssh.ClientConfig{
 			User: user,
 			Auth: []ssh.AuthMethod{
@@ -43,92 +126,438 @@ func NewReconciler(cl clv1alpha1.SubmarinerV1alpha1Interface, namespace, name st
 			},
 			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 		},
+		tunnelOptions: tunnelOptions,
+		recorder:      recorder,
 	}
 }
 
-// Reconcile reconciles forwarder
-func (f *Reconciler) Reconcile(namespace, name string) error {
+// Reconcile reconciles forwarder.
+// It requeues the forwarder after the drift check interval to repair rules that drift without events.
+func (f *Reconciler) Reconcile(namespace, name string) (util.Result, error) {
 	// Check if the resource needs to be handled
 	if f.namespace != namespace || f.name != name {
 		// no need to handle this resource
-		return nil
+		return util.Result{}, nil
 	}
 	fwd, err := f.clientset.Forwarders(namespace).Get(name, metav1.GetOptions{})
 	if err != nil {
-		return err
+		return util.Result{}, err
+	}
+	f.observeGeneration(fwd)
+
+	// Public key is published first, because the operator passes it to gateways for WireGuard
+	if err := f.ensureWireGuardKey(); err != nil {
+		return util.Result{}, err
+	}
+	fwd, err = setWireGuardPublicKey(f.clientset, namespace, fwd, f.wireGuardPublicKey)
+	if err != nil {
+		return util.Result{}, err
+	}
+
+	if needCheckSync(fwd) && !f.ruleSynced(fwd) {
+		glog.Errorf("rule for %s/%s is not synced any more", namespace, name)
+		f.eventf(fwd, corev1.EventTypeWarning, util.ReasonNotSynced, "Rules in forwarder %s/%s are not synced any more", namespace, name)
+		// Set to not synced, so that the rules are repaired below right away
+		fwd, err = setSyncing(f.clientset, namespace, fwd, util.ReasonNotSynced, "Rules are not synced any more")
+		if err != nil {
+			return util.Result{}, err
+		}
+		f.setSyncedRules(nil)
 	}
 
 	if needSync(fwd) {
-		if err := setSyncing(f.clientset, namespace, fwd); err != nil {
-			return err
+		fwd, err = setSyncing(f.clientset, namespace, fwd, util.ReasonSyncing, fmt.Sprintf("Rules of generation %d are being synced", fwd.Generation))
+		if err != nil {
+			return util.Result{}, err
 		}
+		f.setSyncedRules(nil)
 
 		if err := f.syncRule(fwd); err != nil {
 			glog.Errorf("failed to sync rule: %v", err)
-			return err
+			return util.Result{}, err
 		}
 
-		if err := setSynced(f.clientset, namespace, fwd); err != nil {
-			return err
+		fwd, err = setSynced(f.clientset, namespace, fwd)
+		if err != nil {
+			return util.Result{}, err
+		}
+		f.setSyncedRules(fwd)
+		f.eventf(fwd, corev1.EventTypeNormal, util.ReasonSynced, "Forwarder %s/%s synced rules", namespace, name)
+	}
+
+	if err := f.updateRuleStatuses(namespace, fwd); err != nil {
+		return util.Result{}, err
+	}
+
+	return util.Result{RequeueAfter: f.driftCheckInterval}, nil
+}
+
+// SetDriftCheckInterval sets the interval to check that the synced rules haven't drifted.
+// Drift is checked only on events of the forwarder if {interval} is 0.
+func (f *Reconciler) SetDriftCheckInterval(interval time.Duration) {
+	f.driftCheckInterval = interval
+}
+
+// ensureWireGuardKey generates the key pair for WireGuard, if not generated yet
+func (f *Reconciler) ensureWireGuardKey() error {
+	if f.wireGuardPrivateKey != "" {
+		return nil
+	}
+	private, public, err := util.GenerateWireGuardKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate key for wireguard: %v", err)
+	}
+	f.wireGuardPrivateKey, f.wireGuardPublicKey = private, public
+
+	return nil
+}
+
+// observeGeneration records metadata.generation of {fwd} to check readiness
+func (f *Reconciler) observeGeneration(fwd *v1alpha1.Forwarder) {
+	f.readyMutex.Lock()
+	defer f.readyMutex.Unlock()
+
+	f.generation = fwd.Generation
+}
+
+// setSyncedRules records that rules in {fwd} are synced with the current tunnels to check readiness.
+// Nil {fwd} means that rules are being synced.
+func (f *Reconciler) setSyncedRules(fwd *v1alpha1.Forwarder) {
+	egress := map[string]*egressGateway{}
+	if fwd != nil {
+		for k, spec := range getExpectedSSHTunnel(fwd) {
+			if _, ok := egress[spec.gatewayIP]; !ok {
+				egress[spec.gatewayIP] = &egressGateway{}
+			}
+			egress[spec.gatewayIP].tunnels = append(egress[spec.gatewayIP].tunnels, f.tunnels[k])
 		}
-	} else if needCheckSync(fwd) {
-		if !f.ruleSynced(fwd) {
-			glog.Errorf("rule for %s/%s is not synced any more", namespace, name)
-			// Set to syncing and return error to requeue and sync
-			if err := setSyncing(f.clientset, namespace, fwd); err != nil {
-				return err
+		for _, rule := range fwd.Spec.EgressRules {
+			if !isWireGuard(rule) {
+				continue
+			}
+			if _, ok := egress[rule.GatewayIP]; !ok {
+				egress[rule.GatewayIP] = &egressGateway{}
+			}
+			if rule.WireGuardPublicKey == "" {
+				egress[rule.GatewayIP].noWireGuardKey = true
 			}
 		}
+		fwd = fwd.DeepCopy()
 	}
 
-	return nil
+	f.readyMutex.Lock()
+	defer f.readyMutex.Unlock()
+
+	f.syncedFwd = fwd
+	f.syncedEgress = egress
+}
+
+// Ready checks that the rules for the latest metadata.generation are synced, and that egress through
+// any of the gateways is ready. Egress through one gateway that is down doesn't make the forwarder
+// not ready for the others, and tunnels for ingress are not checked, because ingress doesn't come
+// through the forwarder service. It only uses the states cached when the rules are synced, because
+// iptables rules and wireguard interfaces are checked on sync and on drift check, which clears them.
+// It returns error describing why it isn't ready, otherwise.
+func (f *Reconciler) Ready() error {
+	f.readyMutex.Lock()
+	fwd, egress, generation := f.syncedFwd, f.syncedEgress, f.generation
+	f.readyMutex.Unlock()
+
+	if fwd == nil {
+		return fmt.Errorf("rules are not synced yet")
+	}
+	if fwd.Generation != generation {
+		return fmt.Errorf("rules for generation %d are not synced yet", generation)
+	}
+	if len(egress) == 0 {
+		return nil
+	}
+
+	gwIPs := []string{}
+	for gwIP := range egress {
+		gwIPs = append(gwIPs, gwIP)
+	}
+	sort.Strings(gwIPs)
+	errs := []string{}
+	for _, gwIP := range gwIPs {
+		err := egress[gwIP].ready()
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", gwIP, err))
+	}
+
+	return fmt.Errorf("egress through no gateway is ready: %s", strings.Join(errs, ", "))
+}
+
+// updateRuleStatuses publishes the current states of tunnels for the rules and
+// the counters of limits for the sources to the status of {fwd}, which needs to be
+// the latest one returned by the status updates in the reconcile
+func (f *Reconciler) updateRuleStatuses(namespace string, fwd *v1alpha1.Forwarder) error {
+	egress := []v1alpha1.ForwarderRuleStatus{}
+	for _, rule := range fwd.Spec.EgressRules {
+		if tunnel, ok := f.tunnels[sshTunnelKey(fwd, rule)]; ok {
+			egress = append(egress, toRuleStatus(rule, tunnel.Status()))
+		}
+	}
+	ingress := []v1alpha1.ForwarderRuleStatus{}
+	for _, rule := range fwd.Spec.IngressRules {
+		if tunnel, ok := f.remoteTunnels[remoteSSHTunnelKey(rule)]; ok {
+			ingress = append(ingress, toRuleStatus(rule, tunnel.Status()))
+		}
+	}
+
+	// Counters are published at most once in limitStatusInterval,
+	// because updating status triggers reconcile again
+	sources := fwd.Status.SourceStatuses
+	if time.Since(f.limitStatusTime) >= limitStatusInterval {
+		sources = f.sourceStatuses()
+		f.limitStatusTime = time.Now()
+	}
+
+	// Tunnels fail asynchronously, so report them on publishing their states
+	for _, rs := range newlyFailedRules(fwd.Status.EgressRuleStatuses, egress) {
+		f.eventf(fwd, corev1.EventTypeWarning, util.ReasonTunnelFailed, "Egress tunnel to %s:%s via gateway %s failed: %s", rs.DestinationIP, rs.DestinationPort, rs.GatewayIP, rs.LastError)
+	}
+	for _, rs := range newlyFailedRules(fwd.Status.IngressRuleStatuses, ingress) {
+		f.eventf(fwd, corev1.EventTypeWarning, util.ReasonTunnelFailed, "Ingress tunnel to %s:%s via gateway %s failed: %s", rs.DestinationIP, rs.DestinationPort, rs.GatewayIP, rs.LastError)
+	}
+
+	ready := util.ReadyCondition(corev1.ConditionTrue, util.ReasonReady, "")
+	if err := f.Ready(); err != nil {
+		ready = util.ReadyCondition(corev1.ConditionFalse, util.ReasonNotReady, err.Error())
+	}
+
+	return setRuleStatuses(f.clientset, namespace, fwd, egress, ingress, sources, ready)
+}
+
+// newlyFailedRules returns rule statuses in {current} that are failed but weren't in {previous}
+func newlyFailedRules(previous, current []v1alpha1.ForwarderRuleStatus) []v1alpha1.ForwarderRuleStatus {
+	failed := map[string]bool{}
+	for _, rs := range previous {
+		if rs.State == string(util.TunnelFailed) {
+			failed[ruleStatusKey(rs)] = true
+		}
+	}
+
+	newlyFailed := []v1alpha1.ForwarderRuleStatus{}
+	for _, rs := range current {
+		if rs.State == string(util.TunnelFailed) && !failed[ruleStatusKey(rs)] {
+			newlyFailed = append(newlyFailed, rs)
+		}
+	}
+
+	return newlyFailed
+}
+
+// ruleStatusKey formats {rs} to {GatewayIP}:{RelayPort}:{DestinationIP}:{DestinationPort}
+func ruleStatusKey(rs v1alpha1.ForwarderRuleStatus) string {
+	return fmt.Sprintf("%s:%s:%s:%s", rs.GatewayIP, rs.RelayPort, rs.DestinationIP, rs.DestinationPort)
+}
+
+// eventf records an event on the external service that {fwd} belongs to
+func (f *Reconciler) eventf(fwd *v1alpha1.Forwarder, eventtype, reason, messageFmt string, args ...interface{}) {
+	ref := util.ExternalServiceRef(fwd)
+	if ref == nil {
+		glog.Warningf("forwarder %s/%s has no reference to external service, skip recording event %q", fwd.Namespace, fwd.Name, reason)
+		return
+	}
+	f.recorder.Eventf(ref, eventtype, reason, messageFmt, args...)
+}
+
+// sourceStatuses returns ForwarderSourceStatus for each source that has limits
+func (f *Reconciler) sourceStatuses() []v1alpha1.ForwarderSourceStatus {
+	sources := []v1alpha1.ForwarderSourceStatus{}
+	for gwIP, limiter := range f.limiters {
+		if limiter.Limits() == (util.Limits{}) {
+			// Unlimited
+			continue
+		}
+		sources = append(sources, v1alpha1.ForwarderSourceStatus{
+			GatewayIP:   gwIP,
+			LimitStatus: util.ToLimitStatus(limiter.Counters()),
+		})
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].GatewayIP < sources[j].GatewayIP })
+
+	return sources
+}
+
+// toRuleStatus returns ForwarderRuleStatus for {rule} whose tunnel has {st}
+func toRuleStatus(rule v1alpha1.ForwarderRule, st util.TunnelStatus) v1alpha1.ForwarderRuleStatus {
+	rs := v1alpha1.ForwarderRuleStatus{
+		GatewayIP:       rule.GatewayIP,
+		RelayPort:       rule.RelayPort,
+		DestinationIP:   rule.DestinationIP,
+		DestinationPort: rule.DestinationPort,
+		State:           string(st.State),
+		// Status is serialized in seconds, so drop the rest to compare with the stored one
+		LastTransitionTime: metav1.NewTime(st.LastTransitionTime).Rfc3339Copy(),
+	}
+	if st.LastError != nil {
+		rs.LastError = st.LastError.Error()
+		rs.LastErrorTime = metav1.NewTime(st.LastErrorTime).Rfc3339Copy()
+	}
+
+	return rs
 }
 
 func (f *Reconciler) syncRule(fwd *v1alpha1.Forwarder) error {
-	f.updateSSHTunnel(getExpectedSSHTunnel(fwd))
-	f.updateRemoteSSHTunnel(getExpectedRemoteSSHTunnel(fwd))
+	externalService := externalServiceLabel(fwd)
+	// Limiters need to be updated before tunnels, so that new tunnels can use them
+	util.SyncLimiters(f.limiters, getExpectedLimits(fwd))
+	for gwIP, limiter := range f.limiters {
+		limiter.SetMetricsLabels(externalService, gwIP)
+	}
+	// Publish counters for the new set of limiters on next status update
+	f.limitStatusTime = time.Time{}
 
-	if err := updateIptablesRule(getExpectedIptablesRule(fwd)); err != nil {
+	// Labels also need to be updated before tunnels, and metrics for deleted tunnels are deleted after them
+	oldLabels := f.tunnelLabels
+	f.tunnelLabels = getExpectedTunnelLabels(externalService, fwd)
+	defer deleteUnusedTunnelMetrics(oldLabels, f.tunnelLabels)
+
+	if err := f.updateSSHTunnel(getExpectedSSHTunnel(fwd)); err != nil {
+		glog.Errorf("failed to update ssh tunnel: %v", err)
+		f.eventf(fwd, corev1.EventTypeWarning, util.ReasonFailedSyncTunnel, "failed to update ssh tunnel: %v", err)
+		return err
+	}
+	if err := f.updateRemoteSSHTunnel(getExpectedRemoteSSHTunnel(fwd)); err != nil {
+		glog.Errorf("failed to update remote ssh tunnel: %v", err)
+		f.eventf(fwd, corev1.EventTypeWarning, util.ReasonFailedSyncTunnel, "failed to update remote ssh tunnel: %v", err)
+		return err
+	}
+
+	// WireGuard interfaces need to exist before iptables rules route packets to them
+	if err := f.updateWireGuard(getExpectedWireGuards(fwd, f.wireGuardPrivateKey)); err != nil {
+		glog.Errorf("failed to update wireguard: %v", err)
+		f.eventf(fwd, corev1.EventTypeWarning, util.ReasonFailedSyncTunnel, "failed to update wireguard: %v", err)
+		return err
+	}
+
+	start := time.Now()
+	err := updateIptablesRule(getExpectedIptablesRule(fwd), getExpectedMangleRule(fwd))
+	util.ObserveIptablesSync(externalService, "" /* gateway */, start, err)
+	if err != nil {
 		glog.Errorf("failed to update iptables rule: %v", err)
+		f.eventf(fwd, corev1.EventTypeWarning, util.ReasonFailedSyncIptables, "failed to update iptables rule: %v", err)
 		return err
 	}
 
 	return nil
 }
 
-func (f *Reconciler) toTunnel(tun string) *util.Tunnel {
-	s := strings.Split(tun, ":")
-	local := fmt.Sprintf("%s:%s", s[0], s[1])
-	server := fmt.Sprintf("%s:%s", s[2], s[3])
-	remote := fmt.Sprintf("%s:%s", s[4], s[5])
+// deleteUnusedTunnelMetrics deletes metrics with labels in {old} that are not in {expected}
+func deleteUnusedTunnelMetrics(old, expected map[string]util.TunnelLabels) {
+	for k, labels := range old {
+		if _, ok := expected[k]; !ok {
+			util.DeleteTunnelMetrics(labels)
+		}
+	}
+}
+
+// tunnelSpec represents how to create a tunnel for a rule. It is kept with the key of the tunnel,
+// so that the key is only used to identify the tunnel.
+type tunnelSpec struct {
+	// local, server and remote are the endpoints of the tunnel in host:port format
+	local  string
+	server string
+	remote string
+	// gatewayIP is the IP of the server, which identifies the limiter for the source
+	gatewayIP string
+	// tls is true if the server is mTLS relay server instead of ssh server
+	tls bool
+	// portRangeStart and portRangeEnd are the range of original ports for an egress rule for a port range
+	portRangeStart int
+	portRangeEnd   int
+	// originProxyProtocol is the version of PROXY protocol header that the gateway is asked to send for
+	// an egress rule, telling the original pod and originPod, namespace/name of it
+	originProxyProtocol string
+	originPod           string
+	// proxyProtocol is the version of PROXY protocol header that an ingress tunnel sends telling
+	// proxyDestination as the destination. Empty proxyProtocol means no header.
+	proxyProtocol    string
+	proxyDestination string
+}
+
+func (f *Reconciler) toTunnel(key string, spec tunnelSpec) *util.Tunnel {
+	var tunnel *util.Tunnel
+	if spec.tls {
+		// Certificates in the secret are rotated by the operator, so they are loaded on each connection
+		tunnel = util.NewTLSTunnel(spec.local, spec.server, spec.remote, f.config.User, util.LoadTLSConfig(util.RelayTLSDir))
+	} else {
+		tunnel = util.NewTunnel(spec.local, spec.server, spec.remote, f.config)
+	}
+	if err := tunnel.SetProxyProtocol(spec.proxyProtocol, spec.proxyDestination); err != nil {
+		glog.Errorf("invalid proxy protocol in ssh tunnel %q: %v", key, err)
+	}
+	if err := tunnel.SetOriginProxyProtocol(spec.originProxyProtocol, spec.originPod); err != nil {
+		glog.Errorf("invalid proxy protocol in ssh tunnel %q: %v", key, err)
+	}
+	tunnel.SetPortRange(spec.portRangeStart, spec.portRangeEnd)
+	tunnel.SetDrainTimeout(f.tunnelOptions.DrainTimeout)
+	tunnel.SetKeepAlive(f.tunnelOptions.KeepAliveInterval, f.tunnelOptions.KeepAliveCountMax)
+	// Both egress and ingress tunnels have GatewayIP as server, which identifies the source
+	tunnel.SetLimiter(f.limiters[spec.gatewayIP])
+	tunnel.SetMetricsLabels(f.tunnelLabels[key])
 
-	return util.NewTunnel(local, server, remote, f.config)
+	return tunnel
 }
 
-func (f *Reconciler) deleteUnusedSSHTunnel(expected map[string]bool) {
+// checkTunnelsStopped adds canceled {tunnels} to the stopping ones, and forgets the ones that have stopped.
+// It returns error if any of them is still stopping. It doesn't wait for them,
+// so that draining connections doesn't block reconciling, which is retried later.
+func (f *Reconciler) checkTunnelsStopped(tunnels []*util.Tunnel) error {
+	stopping := []*util.Tunnel{}
+	for _, tunnel := range append(f.stoppingTunnels, tunnels...) {
+		select {
+		case <-tunnel.Done():
+		default:
+			stopping = append(stopping, tunnel)
+		}
+	}
+	f.stoppingTunnels = stopping
+
+	if len(stopping) > 0 {
+		return fmt.Errorf("waiting for %d deleted tunnels to stop, including %q", len(stopping), stopping[0].String())
+	}
+
+	return nil
+}
+
+func (f *Reconciler) deleteUnusedSSHTunnel(expected map[string]tunnelSpec) []*util.Tunnel {
 	deleted := []string{}
+	canceled := []*util.Tunnel{}
 	for k, tunnel := range f.tunnels {
 		if _, ok := expected[k]; !ok {
 			glog.Infof("delete ssh tunnel for: %v", k)
 			tunnel.Cancel()
 			deleted = append(deleted, k)
+			canceled = append(canceled, tunnel)
 		}
 	}
 
 	for _, d := range deleted {
 		delete(f.tunnels, d)
 	}
+
+	return canceled
 }
 
-func (f *Reconciler) ensureSSHTunnel(expected map[string]bool) {
+func (f *Reconciler) ensureSSHTunnel(expected map[string]tunnelSpec) {
 	created := map[string]*util.Tunnel{}
-	for k := range expected {
-		if _, ok := f.tunnels[k]; ok {
-			// Already exists, skip creating tunnel
-			continue
+	for k, spec := range expected {
+		if tunnel, ok := f.tunnels[k]; ok {
+			if isUp(tunnel.Status(), time.Now()) {
+				// Already exists, skip creating tunnel
+				continue
+			}
+			// Gave up retrying or has been down too long, so recreate tunnel
+			glog.Infof("recreate ssh tunnel that is down for: %v", k)
+			tunnel.Cancel()
 		}
 		glog.Infof("create new ssh tunnel for: %v", k)
-		tunnel := f.toTunnel(k)
+		tunnel := f.toTunnel(k, spec)
 		tunnel.ForwardNB()
 
 		created[k] = tunnel
@@ -139,30 +568,39 @@ func (f *Reconciler) ensureSSHTunnel(expected map[string]bool) {
 	}
 }
 
-func (f *Reconciler) deleteUnusedRemoteSSHTunnel(expected map[string]bool) {
+func (f *Reconciler) deleteUnusedRemoteSSHTunnel(expected map[string]tunnelSpec) []*util.Tunnel {
 	deleted := []string{}
+	canceled := []*util.Tunnel{}
 	for k, tunnel := range f.remoteTunnels {
 		if _, ok := expected[k]; !ok {
 			glog.Infof("delete remote ssh tunnel for: %v", k)
 			tunnel.Cancel()
 			deleted = append(deleted, k)
+			canceled = append(canceled, tunnel)
 		}
 	}
 
 	for _, d := range deleted {
 		delete(f.remoteTunnels, d)
 	}
+
+	return canceled
 }
 
-func (f *Reconciler) ensureRemoteSSHTunnel(expected map[string]bool) {
+func (f *Reconciler) ensureRemoteSSHTunnel(expected map[string]tunnelSpec) {
 	created := map[string]*util.Tunnel{}
-	for k := range expected {
-		if _, ok := f.remoteTunnels[k]; ok {
-			// Already exists, skip creating tunnel
-			continue
+	for k, spec := range expected {
+		if tunnel, ok := f.remoteTunnels[k]; ok {
+			if isUp(tunnel.Status(), time.Now()) {
+				// Already exists, skip creating tunnel
+				continue
+			}
+			// Gave up retrying or has been down too long, so recreate tunnel
+			glog.Infof("recreate remote ssh tunnel that is down for: %v", k)
+			tunnel.Cancel()
 		}
 		glog.Infof("create new remote ssh tunnel for: %v", k)
-		tunnel := f.toTunnel(k)
+		tunnel := f.toTunnel(k, spec)
 		tunnel.RemoteForwardNB()
 
 		created[k] = tunnel
@@ -173,48 +611,237 @@ func (f *Reconciler) ensureRemoteSSHTunnel(expected map[string]bool) {
 	}
 }
 
-func (f *Reconciler) updateSSHTunnel(expected map[string]bool) {
-	f.deleteUnusedSSHTunnel(expected)
+func (f *Reconciler) updateSSHTunnel(expected map[string]tunnelSpec) error {
+	// Deleted tunnels need to stop before creating new ones,
+	// because new ones may reuse the same relay ports
+	if err := f.checkTunnelsStopped(f.deleteUnusedSSHTunnel(expected)); err != nil {
+		return err
+	}
 	f.ensureSSHTunnel(expected)
+
+	return nil
 }
 
-func (f *Reconciler) updateRemoteSSHTunnel(expected map[string]bool) {
-	f.deleteUnusedRemoteSSHTunnel(expected)
+func (f *Reconciler) updateRemoteSSHTunnel(expected map[string]tunnelSpec) error {
+	// Deleted tunnels need to stop before creating new ones,
+	// because new ones may reuse the same relay ports
+	if err := f.checkTunnelsStopped(f.deleteUnusedRemoteSSHTunnel(expected)); err != nil {
+		return err
+	}
 	f.ensureRemoteSSHTunnel(expected)
+
+	return nil
 }
 
-func updateIptablesRule(expected map[string][][]string) error {
+func updateIptablesRule(expected, expectedMangle map[string][][]string) error {
+	if err := util.ReplaceChains(util.TableMangle, expectedMangle); err != nil {
+		return err
+	}
+
 	return util.ReplaceChains(util.TableNAT, expected)
 }
 
-func getExpectedSSHTunnel(fwd *v1alpha1.Forwarder) map[string]bool {
-	st := map[string]bool{}
-	// Format fwd.Spec.EgressRules to
-	// {ForwarderIP}:{RelayPort}:{GatewayIP}:2022:{DestinationIp}:{DestinationPort}
-	// TODO: make 2022 a variable
-	// ex)
-	//   "10.0.0.2:2049:192.168.122.201:2022:192.168.122.140:8000"
+// updateWireGuard creates or updates {expected} WireGuard interfaces keyed by their names,
+// and deletes ones no longer expected
+func (f *Reconciler) updateWireGuard(expected map[string]util.WireGuardConfig) error {
+	for name, index := range f.wireGuards {
+		if cfg, ok := expected[name]; ok && cfg.Index == index {
+			continue
+		}
+		// Policy routing for the old index is deleted with the interface, even if only the index changes
+		glog.Infof("delete wireguard interface %s", name)
+		if err := util.DeleteWireGuard(name, index); err != nil {
+			return err
+		}
+		delete(f.wireGuards, name)
+	}
+
+	for name, cfg := range expected {
+		if err := util.EnsureWireGuard(cfg); err != nil {
+			return err
+		}
+		f.wireGuards[name] = cfg.Index
+	}
+
+	return nil
+}
+
+// sshTunnelKey formats an egress rule to
+// {ForwarderIP}:{RelayPort}:{GatewayIP}:2022:{DestinationIp}:{DestinationPort}
+// and an egress rule for a port range to
+// {ForwarderIP}:{RelayPort}:{GatewayIP}:2022:{DestinationIp}:{DestinationPort}:{TargetPort}-{TargetEndPort}
+// where 2022 is the port of the mTLS relay server (443 by default) for TLS transport, and it is followed by :{ProxyProtocol}:{SourcePod}
+// if the gateway sends PROXY protocol header for the rule. IPv6 addresses are enclosed in brackets.
+// ex)
+//   "10.0.0.2:2049:192.168.122.201:2022:192.168.122.140:8000"
+//   "10.0.0.2:2050:192.168.122.201:2022:192.168.122.140:30000:30000-30100"
+//   "10.0.0.2:2049:192.168.122.201:2022:192.168.122.140:8000:v2:ns1/pod1"
+func sshTunnelKey(fwd *v1alpha1.Forwarder, rule v1alpha1.ForwarderRule) string {
+	spec := egressTunnelSpec(fwd, rule)
+	key := fmt.Sprintf("%s:%s:%s", spec.local, spec.server, spec.remote)
+	if rule.TargetEndPort != "" {
+		key += ":" + util.FormatPortRange(rule.TargetPort, rule.TargetEndPort)
+	}
+	if rule.ProxyProtocol != "" {
+		key += ":" + rule.ProxyProtocol + ":" + rule.SourcePod
+	}
+
+	return key
+}
+
+// egressTunnelSpec returns tunnelSpec for an egress {rule} of {fwd}
+func egressTunnelSpec(fwd *v1alpha1.Forwarder, rule v1alpha1.ForwarderRule) tunnelSpec {
+	spec := tunnelSpec{
+		local:               net.JoinHostPort(fwd.Spec.ForwarderIP, rule.RelayPort),
+		server:              net.JoinHostPort(rule.GatewayIP, serverPort(rule)),
+		remote:              net.JoinHostPort(rule.DestinationIP, rule.DestinationPort),
+		gatewayIP:           rule.GatewayIP,
+		tls:                 rule.Transport == v1alpha1.TransportTLS,
+		originProxyProtocol: rule.ProxyProtocol,
+		originPod:           rule.SourcePod,
+	}
+	if rule.TargetEndPort != "" {
+		start, end, err := util.ParsePortRange(util.FormatPortRange(rule.TargetPort, rule.TargetEndPort))
+		if err != nil {
+			glog.Errorf("invalid port range in egress rule to %s: %v", spec.remote, err)
+		} else {
+			spec.portRangeStart, spec.portRangeEnd = start, end
+		}
+	}
+
+	return spec
+}
+
+// remoteSSHTunnelKey formats an ingress rule to
+// {DestinationIp}:{DestinationPort}:{GatewayIP}:2022:{GatewayIP}:{RelayPort}
+// where 2022 is the port of the mTLS relay server (443 by default) for TLS transport, and it is followed by :{ProxyProtocol}:{TargetPort}
+// if the rule sends PROXY protocol header. IPv6 addresses are enclosed in brackets.
+// ex)
+//   "10.96.218.78:80:192.168.122.201:2022:192.168.122.201:2049"
+//   "10.96.218.78:80:192.168.122.201:2022:192.168.122.201:2049:v2:8080"
+func remoteSSHTunnelKey(rule v1alpha1.ForwarderRule) string {
+	spec := ingressTunnelSpec(rule)
+	key := fmt.Sprintf("%s:%s:%s", spec.local, spec.server, spec.remote)
+	if rule.ProxyProtocol != "" {
+		key += ":" + rule.ProxyProtocol + ":" + rule.TargetPort
+	}
+
+	return key
+}
+
+// ingressTunnelSpec returns tunnelSpec for an ingress {rule}.
+// PROXY protocol header tells the original client accessing {TargetPort} of the gateway.
+func ingressTunnelSpec(rule v1alpha1.ForwarderRule) tunnelSpec {
+	spec := tunnelSpec{
+		local:     net.JoinHostPort(rule.DestinationIP, rule.DestinationPort),
+		server:    net.JoinHostPort(rule.GatewayIP, serverPort(rule)),
+		remote:    net.JoinHostPort(rule.GatewayIP, rule.RelayPort),
+		gatewayIP: rule.GatewayIP,
+		tls:       rule.Transport == v1alpha1.TransportTLS,
+	}
+	if rule.ProxyProtocol != "" {
+		spec.proxyProtocol = rule.ProxyProtocol
+		spec.proxyDestination = net.JoinHostPort(rule.GatewayIP, rule.TargetPort)
+	}
+
+	return spec
+}
+
+// serverPort returns the port of the server in the gateway to connect for {rule},
+// which is the port of mTLS relay server for TLS transport or ssh server otherwise
+func serverPort(rule v1alpha1.ForwarderRule) string {
+	if rule.Transport == v1alpha1.TransportTLS {
+		if rule.TLSRelayPort != "" {
+			return rule.TLSRelayPort
+		}
+		return util.TLSRelayPort
+	}
+
+	return util.SSHPort
+}
+
+// isWireGuard returns true if {rule} is routed through WireGuard instead of ssh tunnel
+func isWireGuard(rule v1alpha1.ForwarderRule) bool {
+	return rule.Transport == v1alpha1.TransportWireGuard
+}
+
+// getExpectedSSHTunnel returns specs of tunnels for egress rules of {fwd} keyed by sshTunnelKey
+func getExpectedSSHTunnel(fwd *v1alpha1.Forwarder) map[string]tunnelSpec {
+	st := map[string]tunnelSpec{}
 	for _, rule := range fwd.Spec.EgressRules {
-		st[fmt.Sprintf("%s:%s:%s:%s:%s:%s", fwd.Spec.ForwarderIP, rule.RelayPort, rule.GatewayIP, util.SSHPort, rule.DestinationIP, rule.DestinationPort)] = true
+		if isWireGuard(rule) {
+			continue
+		}
+		st[sshTunnelKey(fwd, rule)] = egressTunnelSpec(fwd, rule)
 	}
 
 	return st
 }
 
-func getExpectedRemoteSSHTunnel(fwd *v1alpha1.Forwarder) map[string]bool {
-	rt := map[string]bool{}
-	// Format fwd.Spec.IngressRules to
-	// {DestinationIp}:{DestinationPort}:{GatewayIP}:2022:{GatewayIP}:{RelayPort}
-	// TODO: make 2022 a variable
-	// ex)
-	//   "10.96.218.78:80:192.168.122.201:2022:192.168.122.201:2049"
+// getExpectedRemoteSSHTunnel returns specs of tunnels for ingress rules of {fwd} keyed by remoteSSHTunnelKey
+func getExpectedRemoteSSHTunnel(fwd *v1alpha1.Forwarder) map[string]tunnelSpec {
+	rt := map[string]tunnelSpec{}
 	for _, rule := range fwd.Spec.IngressRules {
-		rt[fmt.Sprintf("%s:%s:%s:%s:%s:%s", rule.DestinationIP, rule.DestinationPort, rule.GatewayIP, util.SSHPort, rule.GatewayIP, rule.RelayPort)] = true
+		rt[remoteSSHTunnelKey(rule)] = ingressTunnelSpec(rule)
 	}
 
 	return rt
 }
 
+// externalServiceLabel returns the value of externalservice label of metrics for {fwd},
+// which is the external service that the operator labels the forwarder with
+func externalServiceLabel(fwd *v1alpha1.Forwarder) string {
+	return util.ExternalServiceLabel(fwd.Labels[util.ExternalServiceNamespaceLabel], fwd.Labels[util.ExternalServiceNameLabel])
+}
+
+// getExpectedTunnelLabels returns labels of metrics for each tunnel of {externalService}
+// keyed by the same keys as tunnels. Metrics for egress rules are labeled by the source pod,
+// and those for ingress rules are labeled by the external server.
+func getExpectedTunnelLabels(externalService string, fwd *v1alpha1.Forwarder) map[string]util.TunnelLabels {
+	labels := map[string]util.TunnelLabels{}
+	for _, rule := range fwd.Spec.EgressRules {
+		if isWireGuard(rule) {
+			continue
+		}
+		labels[sshTunnelKey(fwd, rule)] = util.TunnelLabels{
+			ExternalService: externalService,
+			Direction:       util.DirectionEgress,
+			SourceIP:        rule.SourceIP,
+			Port:            util.FormatPortRange(rule.TargetPort, rule.TargetEndPort),
+			Gateway:         rule.GatewayIP,
+		}
+	}
+	for _, rule := range fwd.Spec.IngressRules {
+		labels[remoteSSHTunnelKey(rule)] = util.TunnelLabels{
+			ExternalService: externalService,
+			Direction:       util.DirectionIngress,
+			SourceIP:        rule.SourceIP,
+			Port:            rule.TargetPort,
+			Gateway:         rule.GatewayIP,
+		}
+	}
+
+	return labels
+}
+
+// getExpectedLimits returns limits for each source keyed by GatewayIP.
+// All the rules for the same source have the same limits, which are shared among
+// both egress and ingress tunnels for the source. Rules for WireGuard aren't limited.
+func getExpectedLimits(fwd *v1alpha1.Forwarder) map[string]util.Limits {
+	limits := map[string]util.Limits{}
+	for _, rule := range fwd.Spec.EgressRules {
+		if isWireGuard(rule) {
+			continue
+		}
+		limits[rule.GatewayIP] = util.ToLimits(rule.Limits)
+	}
+	for _, rule := range fwd.Spec.IngressRules {
+		limits[rule.GatewayIP] = util.ToLimits(rule.Limits)
+	}
+
+	return limits
+}
+
 func getExpectedIptablesRule(fwd *v1alpha1.Forwarder) map[string][][]string {
 	it := map[string][][]string{util.ChainPrerouting: [][]string{}, util.ChainPostrouting: [][]string{}}
 	// Format fwd.Spec.EgressRules to
@@ -227,48 +854,190 @@ func getExpectedIptablesRule(fwd *v1alpha1.Forwarder) map[string][][]string {
 	//     "-m tcp -p tcp --dst 10.244.0.34 --src 10.244.0.11 --dport 8000 -j DNAT --to-destination 10.244.0.34:2049"
 	//   POSTROUTING:
 	//     "-m tcp -p tcp --dst 192.168.122.139 --dport 2049 -j SNAT --to-source 10.244.0.34"
+	// Rules for a port range match all the ports by {TargetPort}:{TargetEndPort} and DNAT them to the same
+	// {RelayPort}, where the tunnel finds the original port of each connection, like below:
+	//     "-m tcp -p tcp --dst 10.244.0.34 --src 10.244.0.11 --dport 30000:30100 -j DNAT --to-destination 10.244.0.34:2050"
+	// Rules for WireGuard are DNATed to {DestinationIP}:{DestinationPort} directly, and SNATed to {ForwarderIP}
+	// on the WireGuard interface for {GatewayIP}, which the packets are routed to by the mark, like below:
+	//   PREROUTING:
+	//     "-m tcp -p tcp --dst 10.244.0.34 --src 10.244.0.11 --dport 8000 -j DNAT --to-destination 192.168.122.139:8000"
+	//   POSTROUTING:
+	//     "-o wgc0a87ac9 -m tcp -p tcp --dst 192.168.122.139 --dport 8000 -j SNAT --to-source 10.244.0.34"
+	// Port ranges for WireGuard are forwarded to the same ports, so they are DNATed only to {DestinationIP}.
 	// TODO: Also handle UDP properly
+	indexes := wireGuardIndexes(fwd)
 	for _, rule := range fwd.Spec.EgressRules {
-		it[util.ChainPrerouting] = append(it[util.ChainPrerouting], util.DNATRuleSpec(fwd.Spec.ForwarderIP, rule.SourceIP, rule.TargetPort, fwd.Spec.ForwarderIP, rule.RelayPort))
+		dPort := util.IptablesPortRange(rule.TargetPort, rule.TargetEndPort)
+		if isWireGuard(rule) {
+			if _, ok := indexes[rule.GatewayIP]; !ok {
+				continue
+			}
+			iface, err := util.WireGuardInterfaceName(rule.GatewayIP)
+			if err != nil {
+				glog.Errorf("invalid gateway IP %q for wireguard: %v", rule.GatewayIP, err)
+				continue
+			}
+			if rule.TargetEndPort != "" {
+				it[util.ChainPrerouting] = append(it[util.ChainPrerouting], util.DNATToIPRuleSpec(fwd.Spec.ForwarderIP, rule.SourceIP, dPort, rule.DestinationIP))
+			} else {
+				it[util.ChainPrerouting] = append(it[util.ChainPrerouting], util.DNATRuleSpec(fwd.Spec.ForwarderIP, rule.SourceIP, dPort, rule.DestinationIP, rule.DestinationPort))
+				dPort = rule.DestinationPort
+			}
+			it[util.ChainPostrouting] = append(it[util.ChainPostrouting], append([]string{"-o", iface}, util.SNATRuleSpec(rule.DestinationIP, fwd.Spec.ForwarderIP, dPort)...))
+			continue
+		}
+		it[util.ChainPrerouting] = append(it[util.ChainPrerouting], util.DNATRuleSpec(fwd.Spec.ForwarderIP, rule.SourceIP, dPort, fwd.Spec.ForwarderIP, rule.RelayPort))
 		it[util.ChainPostrouting] = append(it[util.ChainPostrouting], util.SNATRuleSpec(rule.DestinationIP, fwd.Spec.ForwarderIP, rule.RelayPort))
 	}
 
 	return it
 }
 
+// getExpectedMangleRule returns rules in mangle table to mark packets for WireGuard with the mark for {GatewayIP},
+// which routes them to the WireGuard interface for {GatewayIP} by policy routing. It formats fwd.Spec.EgressRules to
+//   PREROUTING:
+//     -m tcp -p tcp --dst {ForwarderIP} --src {SourceIP} --dport {TargetPort} -j MARK --set-xmark {Mark}
+// ex)
+//   PREROUTING:
+//     "-m tcp -p tcp --dst 10.244.0.34 --src 10.244.0.11 --dport 8000 -j MARK --set-xmark 0x1000000/0xff000000"
+func getExpectedMangleRule(fwd *v1alpha1.Forwarder) map[string][][]string {
+	it := map[string][][]string{util.ChainPrerouting: [][]string{}}
+	indexes := wireGuardIndexes(fwd)
+	for _, rule := range fwd.Spec.EgressRules {
+		index, ok := indexes[rule.GatewayIP]
+		if !isWireGuard(rule) || !ok {
+			continue
+		}
+		dPort := util.IptablesPortRange(rule.TargetPort, rule.TargetEndPort)
+		it[util.ChainPrerouting] = append(it[util.ChainPrerouting], util.MarkRuleSpec(fwd.Spec.ForwarderIP, rule.SourceIP, dPort, util.WireGuardMark(index)))
+	}
+
+	return it
+}
+
+// wireGuardIndexes returns indexes of WireGuard interfaces keyed by GatewayIP of egress rules for WireGuard,
+// which identify their marks and routing tables. Gateways that haven't published their public keys yet
+// are ignored, so the rules for them aren't applied until the operator passes the keys.
+func wireGuardIndexes(fwd *v1alpha1.Forwarder) map[string]int {
+	gwIPs := []string{}
+	seen := map[string]bool{}
+	for _, rule := range fwd.Spec.EgressRules {
+		if !isWireGuard(rule) || rule.WireGuardPublicKey == "" || seen[rule.GatewayIP] {
+			continue
+		}
+		seen[rule.GatewayIP] = true
+		gwIPs = append(gwIPs, rule.GatewayIP)
+	}
+	sort.Strings(gwIPs)
+
+	indexes := map[string]int{}
+	for i, gwIP := range gwIPs {
+		if i >= util.WireGuardMaxInterfaces {
+			glog.Warningf("too many gateways for wireguard, ignore %s", gwIP)
+			continue
+		}
+		indexes[gwIP] = i
+	}
+
+	return indexes
+}
+
+// getExpectedWireGuards returns WireGuard interfaces keyed by their names, one for each gateway of egress rules
+// for WireGuard. The interface has the gateway as the only peer, and all the packets routed to it are sent to
+// the gateway. All the interfaces share the forwarder's {privateKey}, and listen on random ports.
+func getExpectedWireGuards(fwd *v1alpha1.Forwarder, privateKey string) map[string]util.WireGuardConfig {
+	cfgs := map[string]util.WireGuardConfig{}
+	indexes := wireGuardIndexes(fwd)
+	for _, rule := range fwd.Spec.EgressRules {
+		index, ok := indexes[rule.GatewayIP]
+		if !isWireGuard(rule) || !ok {
+			continue
+		}
+		name, err := util.WireGuardInterfaceName(rule.GatewayIP)
+		if err != nil {
+			glog.Errorf("invalid gateway IP %q for wireguard: %v", rule.GatewayIP, err)
+			continue
+		}
+		if _, ok := cfgs[name]; ok {
+			continue
+		}
+		port := rule.WireGuardPort
+		if port == "" {
+			port = strconv.Itoa(util.WireGuardPort)
+		}
+		cfgs[name] = util.WireGuardConfig{
+			Name:       name,
+			PrivateKey: privateKey,
+			Index:      index,
+			Peers: []util.WireGuardPeer{
+				{
+					PublicKey:  rule.WireGuardPublicKey,
+					Endpoint:   net.JoinHostPort(rule.GatewayIP, port),
+					AllowedIPs: []string{"0.0.0.0/0"},
+				},
+			},
+		}
+	}
+
+	return cfgs
+}
+
 func (f *Reconciler) ruleSynced(fwd *v1alpha1.Forwarder) bool {
-	return f.isTunnelRunning(fwd) && f.isIptablesRulesApplied(fwd)
+	return f.isTunnelRunning(fwd) && f.isIptablesRulesApplied(fwd) && f.isWireGuardConfigured(fwd)
 }
 
+// isTunnelRunning checks that all the expected tunnels exist and are established.
+// Tunnels that are connecting or backing off are regarded as running within tunnelDownGracePeriod,
+// because they are retrying by themselves. Their actual states are published as rule statuses.
 func (f *Reconciler) isTunnelRunning(fwd *v1alpha1.Forwarder) bool {
 	for k := range getExpectedSSHTunnel(fwd) {
-		if _, ok := f.tunnels[k]; !ok {
+		if !isRunning(f.tunnels, k) {
 			return false
 		}
 	}
 	for k := range getExpectedRemoteSSHTunnel(fwd) {
-		if _, ok := f.remoteTunnels[k]; !ok {
+		if !isRunning(f.remoteTunnels, k) {
 			return false
 		}
 	}
-	// TODO: consider checking actual open ports like below
-	/*
-		for _, rule := range fwd.Spec.EgressRules {
-			if !util.IsPortOpen(fwd.Spec.ForwarderIP, rule.RelayPort) {
-				return false
-			}
-		}
-		for _, rule := range fwd.Spec.IngressRules {
-			if !util.IsPortOpen(rule.GatewayIP, rule.RelayPort) {
-				return false
-			}
-		}
-	*/
 	return true
 }
 
+func isRunning(tunnels map[string]*util.Tunnel, key string) bool {
+	tunnel, ok := tunnels[key]
+	if !ok {
+		return false
+	}
+	return isUp(tunnel.Status(), time.Now())
+}
+
+// isUp checks that a tunnel with {st} is established at {now}, or hasn't been down longer than tunnelDownGracePeriod.
+// A failed tunnel is never up, because it gave up retrying.
+func isUp(st util.TunnelStatus, now time.Time) bool {
+	switch st.State {
+	case util.TunnelEstablished:
+		return true
+	case util.TunnelFailed:
+		return false
+	default:
+		return now.Sub(st.NotEstablishedSince) < tunnelDownGracePeriod
+	}
+}
+
 func (f *Reconciler) isIptablesRulesApplied(fwd *v1alpha1.Forwarder) bool {
 	// TODO: consider checking exact match?
 	// below only check that rules in chains do exist, so unused rules might remain
-	return util.CheckChainsExist(util.TableNAT, getExpectedIptablesRule(fwd))
+	return util.CheckChainsExist(util.TableNAT, getExpectedIptablesRule(fwd)) &&
+		util.CheckChainsExist(util.TableMangle, getExpectedMangleRule(fwd))
+}
+
+// isWireGuardConfigured checks that all the expected WireGuard interfaces exist with the gateways as peers
+func (f *Reconciler) isWireGuardConfigured(fwd *v1alpha1.Forwarder) bool {
+	for _, cfg := range getExpectedWireGuards(fwd, f.wireGuardPrivateKey) {
+		if !util.CheckWireGuard(cfg) {
+			return false
+		}
+	}
+
+	return true
 }
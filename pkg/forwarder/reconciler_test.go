@@ -1,17 +1,72 @@
 package forwarder
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
+	"github.com/mkimuram/k8s-ext-connector/pkg/util"
+	"golang.org/x/crypto/ssh"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// fwdWithWireGuard has egress rules for ssh and WireGuard, and one for WireGuard whose gateway has no public key yet
+var fwdWithWireGuard = &v1alpha1.Forwarder{
+	Spec: v1alpha1.ForwarderSpec{
+		EgressRules: []v1alpha1.ForwarderRule{
+			{
+				Protocol:        "TCP",
+				SourceIP:        "10.244.0.12",
+				TargetPort:      "8000",
+				DestinationPort: "8001",
+				DestinationIP:   "192.168.122.139",
+				GatewayIP:       "192.168.122.200",
+				RelayPort:       "2049",
+			},
+			{
+				Protocol:           "TCP",
+				SourceIP:           "10.244.0.12",
+				TargetPort:         "8000",
+				DestinationPort:    "8001",
+				DestinationIP:      "192.168.122.139",
+				GatewayIP:          "192.168.122.201",
+				Transport:          v1alpha1.TransportWireGuard,
+				WireGuardPublicKey: "gwkey1",
+				WireGuardPort:      "51821",
+			},
+			{
+				Protocol:           "TCP",
+				SourceIP:           "10.244.0.12",
+				TargetPort:         "30000",
+				TargetEndPort:      "30100",
+				DestinationPort:    "30000",
+				DestinationIP:      "192.168.122.140",
+				GatewayIP:          "192.168.122.201",
+				Transport:          v1alpha1.TransportWireGuard,
+				WireGuardPublicKey: "gwkey1",
+				WireGuardPort:      "51821",
+			},
+			{
+				Protocol:        "TCP",
+				SourceIP:        "10.244.0.12",
+				TargetPort:      "8000",
+				DestinationPort: "8001",
+				DestinationIP:   "192.168.122.139",
+				GatewayIP:       "192.168.122.202",
+				Transport:       v1alpha1.TransportWireGuard,
+			},
+		},
+		ForwarderIP: "10.0.0.2",
+	},
+}
+
 func TestGetExpectedSSHTunnel(t *testing.T) {
 	testCases := []struct {
 		name     string
 		fwd      *v1alpha1.Forwarder
-		expected map[string]bool
+		expected map[string]tunnelSpec
 	}{
 		{
 			name: "Normal case",
@@ -35,8 +90,153 @@ func TestGetExpectedSSHTunnel(t *testing.T) {
 					ForwarderIP: "10.0.0.2",
 				},
 			},
-			expected: map[string]bool{
-				"10.0.0.2:2049:192.168.122.200:2022:192.168.122.139:8001": true,
+			expected: map[string]tunnelSpec{
+				"10.0.0.2:2049:192.168.122.200:2022:192.168.122.139:8001": {
+					local:     "10.0.0.2:2049",
+					server:    "192.168.122.200:2022",
+					remote:    "192.168.122.139:8001",
+					gatewayIP: "192.168.122.200",
+				},
+			},
+		},
+		{
+			name: "Normal case (port range)",
+			fwd: &v1alpha1.Forwarder{
+				Spec: v1alpha1.ForwarderSpec{
+					EgressRules: []v1alpha1.ForwarderRule{
+						{
+							Protocol:        "TCP",
+							SourceIP:        "10.244.0.12",
+							TargetPort:      "30000",
+							TargetEndPort:   "30100",
+							DestinationPort: "30000",
+							DestinationIP:   "192.168.122.139",
+							Gateway: v1alpha1.GatewayRef{
+								Namespace: "ns1",
+								Name:      "gw1",
+							},
+							GatewayIP: "192.168.122.200",
+							RelayPort: "2050",
+						},
+					},
+					ForwarderIP: "10.0.0.2",
+				},
+			},
+			expected: map[string]tunnelSpec{
+				"10.0.0.2:2050:192.168.122.200:2022:192.168.122.139:30000:30000-30100": {
+					local:          "10.0.0.2:2050",
+					server:         "192.168.122.200:2022",
+					remote:         "192.168.122.139:30000",
+					gatewayIP:      "192.168.122.200",
+					portRangeStart: 30000,
+					portRangeEnd:   30100,
+				},
+			},
+		},
+		{
+			name: "Normal case (port range with proxy protocol)",
+			fwd: &v1alpha1.Forwarder{
+				Spec: v1alpha1.ForwarderSpec{
+					EgressRules: []v1alpha1.ForwarderRule{
+						{
+							Protocol:        "TCP",
+							SourceIP:        "10.244.0.12",
+							TargetPort:      "30000",
+							TargetEndPort:   "30100",
+							DestinationPort: "30000",
+							DestinationIP:   "192.168.122.139",
+							Gateway: v1alpha1.GatewayRef{
+								Namespace: "ns1",
+								Name:      "gw1",
+							},
+							GatewayIP:     "192.168.122.200",
+							RelayPort:     "2050",
+							ProxyProtocol: "v2",
+							SourcePod:     "ns1/pod1",
+						},
+					},
+					ForwarderIP: "10.0.0.2",
+				},
+			},
+			expected: map[string]tunnelSpec{
+				"10.0.0.2:2050:192.168.122.200:2022:192.168.122.139:30000:30000-30100:v2:ns1/pod1": {
+					local:               "10.0.0.2:2050",
+					server:              "192.168.122.200:2022",
+					remote:              "192.168.122.139:30000",
+					gatewayIP:           "192.168.122.200",
+					portRangeStart:      30000,
+					portRangeEnd:        30100,
+					originProxyProtocol: util.ProxyProtocolV2,
+					originPod:           "ns1/pod1",
+				},
+			},
+		},
+		{
+			name: "Normal case (tls transport)",
+			fwd: &v1alpha1.Forwarder{
+				Spec: v1alpha1.ForwarderSpec{
+					EgressRules: []v1alpha1.ForwarderRule{
+						{
+							Protocol:        "TCP",
+							SourceIP:        "10.244.0.12",
+							TargetPort:      "8000",
+							DestinationPort: "8001",
+							DestinationIP:   "192.168.122.139",
+							GatewayIP:       "192.168.122.200",
+							RelayPort:       "2049",
+							Transport:       v1alpha1.TransportTLS,
+						},
+					},
+					ForwarderIP: "10.0.0.2",
+				},
+			},
+			expected: map[string]tunnelSpec{
+				"10.0.0.2:2049:192.168.122.200:443:192.168.122.139:8001": {
+					local:     "10.0.0.2:2049",
+					server:    "192.168.122.200:443",
+					remote:    "192.168.122.139:8001",
+					gatewayIP: "192.168.122.200",
+					tls:       true,
+				},
+			},
+		},
+		{
+			name: "Normal case (ipv6 destination)",
+			fwd: &v1alpha1.Forwarder{
+				Spec: v1alpha1.ForwarderSpec{
+					EgressRules: []v1alpha1.ForwarderRule{
+						{
+							Protocol:        "TCP",
+							SourceIP:        "10.244.0.12",
+							TargetPort:      "8000",
+							DestinationPort: "8001",
+							DestinationIP:   "fd00::139",
+							GatewayIP:       "192.168.122.200",
+							RelayPort:       "2049",
+						},
+					},
+					ForwarderIP: "10.0.0.2",
+				},
+			},
+			expected: map[string]tunnelSpec{
+				"10.0.0.2:2049:192.168.122.200:2022:[fd00::139]:8001": {
+					local:     "10.0.0.2:2049",
+					server:    "192.168.122.200:2022",
+					remote:    "[fd00::139]:8001",
+					gatewayIP: "192.168.122.200",
+				},
+			},
+		},
+		{
+			name: "Normal case (rules for wireguard are skipped)",
+			fwd:  fwdWithWireGuard,
+			expected: map[string]tunnelSpec{
+				"10.0.0.2:2049:192.168.122.200:2022:192.168.122.139:8001": {
+					local:     "10.0.0.2:2049",
+					server:    "192.168.122.200:2022",
+					remote:    "192.168.122.139:8001",
+					gatewayIP: "192.168.122.200",
+				},
 			},
 		},
 	}
@@ -55,7 +255,7 @@ func TestGetExpectedRemoteSSHTunnel(t *testing.T) {
 	testCases := []struct {
 		name     string
 		fwd      *v1alpha1.Forwarder
-		expected map[string]bool
+		expected map[string]tunnelSpec
 	}{
 		{
 			name: "Normal case",
@@ -79,8 +279,72 @@ func TestGetExpectedRemoteSSHTunnel(t *testing.T) {
 					ForwarderIP: "10.0.0.2",
 				},
 			},
-			expected: map[string]bool{
-				"10.104.205.241:80:192.168.122.200:2022:192.168.122.200:2050": true,
+			expected: map[string]tunnelSpec{
+				"10.104.205.241:80:192.168.122.200:2022:192.168.122.200:2050": {
+					local:     "10.104.205.241:80",
+					server:    "192.168.122.200:2022",
+					remote:    "192.168.122.200:2050",
+					gatewayIP: "192.168.122.200",
+				},
+			},
+		},
+		{
+			name: "Normal case (tls transport)",
+			fwd: &v1alpha1.Forwarder{
+				Spec: v1alpha1.ForwarderSpec{
+					IngressRules: []v1alpha1.ForwarderRule{
+						{
+							Protocol:        "TCP",
+							SourceIP:        "192.168.122.139",
+							TargetPort:      "80",
+							DestinationPort: "80",
+							DestinationIP:   "10.104.205.241",
+							GatewayIP:       "192.168.122.200",
+							RelayPort:       "2050",
+							Transport:       v1alpha1.TransportTLS,
+						},
+					},
+					ForwarderIP: "10.0.0.2",
+				},
+			},
+			expected: map[string]tunnelSpec{
+				"10.104.205.241:80:192.168.122.200:443:192.168.122.200:2050": {
+					local:     "10.104.205.241:80",
+					server:    "192.168.122.200:443",
+					remote:    "192.168.122.200:2050",
+					gatewayIP: "192.168.122.200",
+					tls:       true,
+				},
+			},
+		},
+		{
+			name: "Normal case (proxy protocol)",
+			fwd: &v1alpha1.Forwarder{
+				Spec: v1alpha1.ForwarderSpec{
+					IngressRules: []v1alpha1.ForwarderRule{
+						{
+							Protocol:        "TCP",
+							SourceIP:        "192.168.122.139",
+							TargetPort:      "8080",
+							DestinationPort: "80",
+							DestinationIP:   "10.104.205.241",
+							GatewayIP:       "192.168.122.200",
+							RelayPort:       "2050",
+							ProxyProtocol:   util.ProxyProtocolV2,
+						},
+					},
+					ForwarderIP: "10.0.0.2",
+				},
+			},
+			expected: map[string]tunnelSpec{
+				"10.104.205.241:80:192.168.122.200:2022:192.168.122.200:2050:v2:8080": {
+					local:            "10.104.205.241:80",
+					server:           "192.168.122.200:2022",
+					remote:           "192.168.122.200:2050",
+					gatewayIP:        "192.168.122.200",
+					proxyProtocol:    util.ProxyProtocolV2,
+					proxyDestination: "192.168.122.200:8080",
+				},
 			},
 		},
 	}
@@ -94,6 +358,140 @@ func TestGetExpectedRemoteSSHTunnel(t *testing.T) {
 		}
 	}
 }
+func TestGetExpectedLimits(t *testing.T) {
+	testCases := []struct {
+		name     string
+		fwd      *v1alpha1.Forwarder
+		expected map[string]util.Limits
+	}{
+		{
+			name: "Normal case (limits for egress and ingress)",
+			fwd: &v1alpha1.Forwarder{
+				Spec: v1alpha1.ForwarderSpec{
+					EgressRules: []v1alpha1.ForwarderRule{
+						{
+							DestinationPort: "8001",
+							DestinationIP:   "192.168.122.139",
+							GatewayIP:       "192.168.122.200",
+							RelayPort:       "2049",
+							Limits: &v1alpha1.SourceLimits{
+								MaxConnections: 10,
+								BytesPerSecond: 1024,
+							},
+						},
+					},
+					IngressRules: []v1alpha1.ForwarderRule{
+						{
+							DestinationPort: "80",
+							DestinationIP:   "10.96.218.78",
+							GatewayIP:       "192.168.122.201",
+							RelayPort:       "2049",
+							Limits: &v1alpha1.SourceLimits{
+								MaxConnections: 5,
+							},
+						},
+					},
+					ForwarderIP: "10.0.0.2",
+				},
+			},
+			expected: map[string]util.Limits{
+				"192.168.122.200": util.Limits{MaxConnections: 10, BytesPerSecond: 1024},
+				"192.168.122.201": util.Limits{MaxConnections: 5},
+			},
+		},
+		{
+			name: "Normal case (no limits)",
+			fwd: &v1alpha1.Forwarder{
+				Spec: v1alpha1.ForwarderSpec{
+					EgressRules: []v1alpha1.ForwarderRule{
+						{
+							DestinationPort: "8001",
+							DestinationIP:   "192.168.122.139",
+							GatewayIP:       "192.168.122.200",
+							RelayPort:       "2049",
+						},
+					},
+					ForwarderIP: "10.0.0.2",
+				},
+			},
+			expected: map[string]util.Limits{
+				"192.168.122.200": util.Limits{},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		limits := getExpectedLimits(tc.fwd)
+
+		if !reflect.DeepEqual(tc.expected, limits) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, limits)
+		}
+	}
+}
+
+func TestGetExpectedTunnelLabels(t *testing.T) {
+	testCases := []struct {
+		name     string
+		fwd      *v1alpha1.Forwarder
+		expected map[string]util.TunnelLabels
+	}{
+		{
+			name: "Normal case (egress and ingress)",
+			fwd: &v1alpha1.Forwarder{
+				Spec: v1alpha1.ForwarderSpec{
+					EgressRules: []v1alpha1.ForwarderRule{
+						{
+							SourceIP:        "10.244.0.11",
+							TargetPort:      "8000",
+							DestinationPort: "8001",
+							DestinationIP:   "192.168.122.139",
+							GatewayIP:       "192.168.122.200",
+							RelayPort:       "2049",
+						},
+					},
+					IngressRules: []v1alpha1.ForwarderRule{
+						{
+							SourceIP:        "192.168.122.139",
+							TargetPort:      "80",
+							DestinationPort: "80",
+							DestinationIP:   "10.96.218.78",
+							GatewayIP:       "192.168.122.201",
+							RelayPort:       "2049",
+						},
+					},
+					ForwarderIP: "10.0.0.2",
+				},
+			},
+			expected: map[string]util.TunnelLabels{
+				"10.0.0.2:2049:192.168.122.200:2022:192.168.122.139:8001": util.TunnelLabels{
+					ExternalService: "es1",
+					Direction:       util.DirectionEgress,
+					SourceIP:        "10.244.0.11",
+					Port:            "8000",
+					Gateway:         "192.168.122.200",
+				},
+				"10.96.218.78:80:192.168.122.201:2022:192.168.122.201:2049": util.TunnelLabels{
+					ExternalService: "es1",
+					Direction:       util.DirectionIngress,
+					SourceIP:        "192.168.122.139",
+					Port:            "80",
+					Gateway:         "192.168.122.201",
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		labels := getExpectedTunnelLabels("es1", tc.fwd)
+
+		if !reflect.DeepEqual(tc.expected, labels) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, labels)
+		}
+	}
+}
+
 func TestGetExpectedIptablesRule(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -131,6 +529,54 @@ func TestGetExpectedIptablesRule(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Normal case (port range)",
+			fwd: &v1alpha1.Forwarder{
+				Spec: v1alpha1.ForwarderSpec{
+					EgressRules: []v1alpha1.ForwarderRule{
+						{
+							Protocol:        "TCP",
+							SourceIP:        "10.244.0.12",
+							TargetPort:      "30000",
+							TargetEndPort:   "30100",
+							DestinationPort: "30000",
+							DestinationIP:   "192.168.122.139",
+							Gateway: v1alpha1.GatewayRef{
+								Namespace: "ns1",
+								Name:      "gw1",
+							},
+							GatewayIP: "192.168.122.200",
+							RelayPort: "2050",
+						},
+					},
+					ForwarderIP: "10.0.0.2",
+				},
+			},
+			expected: map[string][][]string{
+				"PREROUTING": [][]string{
+					{"-m", "tcp", "-p", "tcp", "--dst", "10.0.0.2", "--src", "10.244.0.12", "--dport", "30000:30100", "-j", "DNAT", "--to-destination", "10.0.0.2:2050"},
+				},
+				"POSTROUTING": [][]string{
+					{"-m", "tcp", "-p", "tcp", "--dst", "192.168.122.139", "--dport", "2050", "-j", "SNAT", "--to-source", "10.0.0.2"},
+				},
+			},
+		},
+		{
+			name: "Normal case (wireguard)",
+			fwd:  fwdWithWireGuard,
+			expected: map[string][][]string{
+				"PREROUTING": [][]string{
+					{"-m", "tcp", "-p", "tcp", "--dst", "10.0.0.2", "--src", "10.244.0.12", "--dport", "8000", "-j", "DNAT", "--to-destination", "10.0.0.2:2049"},
+					{"-m", "tcp", "-p", "tcp", "--dst", "10.0.0.2", "--src", "10.244.0.12", "--dport", "8000", "-j", "DNAT", "--to-destination", "192.168.122.139:8001"},
+					{"-m", "tcp", "-p", "tcp", "--dst", "10.0.0.2", "--src", "10.244.0.12", "--dport", "30000:30100", "-j", "DNAT", "--to-destination", "192.168.122.140"},
+				},
+				"POSTROUTING": [][]string{
+					{"-m", "tcp", "-p", "tcp", "--dst", "192.168.122.139", "--dport", "2049", "-j", "SNAT", "--to-source", "10.0.0.2"},
+					{"-o", "wgc0a87ac9", "-m", "tcp", "-p", "tcp", "--dst", "192.168.122.139", "--dport", "8001", "-j", "SNAT", "--to-source", "10.0.0.2"},
+					{"-o", "wgc0a87ac9", "-m", "tcp", "-p", "tcp", "--dst", "192.168.122.140", "--dport", "30000:30100", "-j", "SNAT", "--to-source", "10.0.0.2"},
+				},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -142,3 +588,443 @@ func TestGetExpectedIptablesRule(t *testing.T) {
 		}
 	}
 }
+
+func TestGetExpectedMangleRule(t *testing.T) {
+	testCases := []struct {
+		name     string
+		fwd      *v1alpha1.Forwarder
+		expected map[string][][]string
+	}{
+		{
+			name: "Normal case (no rules for wireguard)",
+			fwd: &v1alpha1.Forwarder{
+				Spec: v1alpha1.ForwarderSpec{
+					EgressRules: []v1alpha1.ForwarderRule{
+						{
+							Protocol:        "TCP",
+							SourceIP:        "10.244.0.12",
+							TargetPort:      "8000",
+							DestinationPort: "8001",
+							DestinationIP:   "192.168.122.139",
+							GatewayIP:       "192.168.122.200",
+							RelayPort:       "2049",
+						},
+					},
+					ForwarderIP: "10.0.0.2",
+				},
+			},
+			expected: map[string][][]string{
+				"PREROUTING": [][]string{},
+			},
+		},
+		{
+			name: "Normal case (wireguard)",
+			fwd:  fwdWithWireGuard,
+			expected: map[string][][]string{
+				"PREROUTING": [][]string{
+					{"-m", "tcp", "-p", "tcp", "--dst", "10.0.0.2", "--src", "10.244.0.12", "--dport", "8000", "-j", "MARK", "--set-xmark", "0x1000000/0xff000000"},
+					{"-m", "tcp", "-p", "tcp", "--dst", "10.0.0.2", "--src", "10.244.0.12", "--dport", "30000:30100", "-j", "MARK", "--set-xmark", "0x1000000/0xff000000"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		rules := getExpectedMangleRule(tc.fwd)
+
+		if !reflect.DeepEqual(tc.expected, rules) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, rules)
+		}
+	}
+}
+
+func TestGetExpectedWireGuards(t *testing.T) {
+	testCases := []struct {
+		name     string
+		fwd      *v1alpha1.Forwarder
+		expected map[string]util.WireGuardConfig
+	}{
+		{
+			name: "Normal case (no rules for wireguard)",
+			fwd: &v1alpha1.Forwarder{
+				Spec: v1alpha1.ForwarderSpec{
+					ForwarderIP: "10.0.0.2",
+				},
+			},
+			expected: map[string]util.WireGuardConfig{},
+		},
+		{
+			name: "Normal case (wireguard)",
+			fwd:  fwdWithWireGuard,
+			expected: map[string]util.WireGuardConfig{
+				"wgc0a87ac9": {
+					Name:       "wgc0a87ac9",
+					PrivateKey: "fwdkey",
+					Index:      0,
+					Peers: []util.WireGuardPeer{
+						{
+							PublicKey:  "gwkey1",
+							Endpoint:   "192.168.122.201:51821",
+							AllowedIPs: []string{"0.0.0.0/0"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		cfgs := getExpectedWireGuards(tc.fwd, "fwdkey")
+
+		if !reflect.DeepEqual(tc.expected, cfgs) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, cfgs)
+		}
+	}
+}
+
+func TestCheckTunnelsStopped(t *testing.T) {
+	testCases := []struct {
+		name      string
+		tunnels   []*util.Tunnel
+		cancel    bool
+		expectErr bool
+	}{
+		{
+			name:      "Normal case (no tunnels)",
+			tunnels:   []*util.Tunnel{},
+			cancel:    true,
+			expectErr: false,
+		},
+		{
+			name: "Normal case (canceled tunnels that never started)",
+			tunnels: []*util.Tunnel{
+				util.NewTunnel("127.0.0.1:2049", "127.0.0.1:2022", "127.0.0.1:80", &ssh.ClientConfig{}),
+				util.NewTunnel("127.0.0.1:2050", "127.0.0.1:2022", "127.0.0.1:80", &ssh.ClientConfig{}),
+			},
+			cancel:    true,
+			expectErr: false,
+		},
+		{
+			name: "Error case (tunnels that haven't stopped)",
+			tunnels: []*util.Tunnel{
+				util.NewTunnel("127.0.0.1:2049", "127.0.0.1:2022", "127.0.0.1:80", &ssh.ClientConfig{}),
+			},
+			cancel:    false,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		f := &Reconciler{}
+		if tc.cancel {
+			for _, tunnel := range tc.tunnels {
+				tunnel.Cancel()
+			}
+		}
+
+		err := f.checkTunnelsStopped(tc.tunnels)
+		if tc.expectErr && err == nil {
+			t.Errorf("expected error, but got no error")
+		}
+		if !tc.expectErr && err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+		if tc.cancel {
+			continue
+		}
+
+		// Tunnels still stopping are kept, and forgotten once they stop
+		if len(f.stoppingTunnels) != len(tc.tunnels) {
+			t.Errorf("expected %d stopping tunnels, but got %d", len(tc.tunnels), len(f.stoppingTunnels))
+		}
+		for _, tunnel := range tc.tunnels {
+			tunnel.Cancel()
+		}
+		if err := f.checkTunnelsStopped(nil); err != nil {
+			t.Errorf("expected no error after tunnels stopped, but got %v", err)
+		}
+		if len(f.stoppingTunnels) != 0 {
+			t.Errorf("expected no stopping tunnels, but got %d", len(f.stoppingTunnels))
+		}
+	}
+}
+
+func TestIsUp(t *testing.T) {
+	now := time.Date(2020, 4, 1, 10, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		st       util.TunnelStatus
+		expected bool
+	}{
+		{
+			name:     "Normal case (established)",
+			st:       util.TunnelStatus{State: util.TunnelEstablished},
+			expected: true,
+		},
+		{
+			name:     "Normal case (connecting within grace period)",
+			st:       util.TunnelStatus{State: util.TunnelConnecting, NotEstablishedSince: now.Add(-time.Minute)},
+			expected: true,
+		},
+		{
+			name:     "Normal case (backing off within grace period)",
+			st:       util.TunnelStatus{State: util.TunnelBackingOff, NotEstablishedSince: now.Add(-time.Minute)},
+			expected: true,
+		},
+		{
+			name:     "Error case (backing off past grace period)",
+			st:       util.TunnelStatus{State: util.TunnelBackingOff, NotEstablishedSince: now.Add(-tunnelDownGracePeriod)},
+			expected: false,
+		},
+		{
+			name:     "Error case (connecting past grace period)",
+			st:       util.TunnelStatus{State: util.TunnelConnecting, NotEstablishedSince: now.Add(-3 * time.Minute)},
+			expected: false,
+		},
+		{
+			name:     "Error case (failed)",
+			st:       util.TunnelStatus{State: util.TunnelFailed, NotEstablishedSince: now},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		if up := isUp(tc.st, now); up != tc.expected {
+			t.Errorf("expected %v, but got %v", tc.expected, up)
+		}
+	}
+}
+
+func TestToRuleStatus(t *testing.T) {
+	transitionTime := time.Date(2020, 4, 1, 10, 0, 0, 123, time.UTC)
+	errorTime := time.Date(2020, 4, 1, 9, 59, 0, 456, time.UTC)
+	rule := v1alpha1.ForwarderRule{
+		Protocol:        "TCP",
+		SourceIP:        "10.244.0.12",
+		TargetPort:      "8000",
+		DestinationPort: "8001",
+		DestinationIP:   "192.168.122.139",
+		Gateway: v1alpha1.GatewayRef{
+			Namespace: "ns1",
+			Name:      "gw1",
+		},
+		GatewayIP: "192.168.122.200",
+		RelayPort: "2049",
+	}
+
+	testCases := []struct {
+		name     string
+		rule     v1alpha1.ForwarderRule
+		status   util.TunnelStatus
+		expected v1alpha1.ForwarderRuleStatus
+	}{
+		{
+			name: "Normal case (established without error)",
+			rule: rule,
+			status: util.TunnelStatus{
+				State:              util.TunnelEstablished,
+				LastTransitionTime: transitionTime,
+			},
+			expected: v1alpha1.ForwarderRuleStatus{
+				GatewayIP:       "192.168.122.200",
+				RelayPort:       "2049",
+				DestinationIP:   "192.168.122.139",
+				DestinationPort: "8001",
+				State:           "Established",
+				// Truncated to seconds
+				LastTransitionTime: metav1.NewTime(time.Date(2020, 4, 1, 10, 0, 0, 0, time.UTC)),
+			},
+		},
+		{
+			name: "Normal case (backing off with error)",
+			rule: rule,
+			status: util.TunnelStatus{
+				State:              util.TunnelBackingOff,
+				LastTransitionTime: transitionTime,
+				LastError:          fmt.Errorf("connection refused"),
+				LastErrorTime:      errorTime,
+			},
+			expected: v1alpha1.ForwarderRuleStatus{
+				GatewayIP:          "192.168.122.200",
+				RelayPort:          "2049",
+				DestinationIP:      "192.168.122.139",
+				DestinationPort:    "8001",
+				State:              "BackingOff",
+				LastTransitionTime: metav1.NewTime(time.Date(2020, 4, 1, 10, 0, 0, 0, time.UTC)),
+				LastError:          "connection refused",
+				LastErrorTime:      metav1.NewTime(time.Date(2020, 4, 1, 9, 59, 0, 0, time.UTC)),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		rs := toRuleStatus(tc.rule, tc.status)
+
+		if !tc.expected.LastTransitionTime.Equal(&rs.LastTransitionTime) || !tc.expected.LastErrorTime.Equal(&rs.LastErrorTime) {
+			t.Errorf("expected times:%v,%v, but got:%v,%v", tc.expected.LastTransitionTime, tc.expected.LastErrorTime, rs.LastTransitionTime, rs.LastErrorTime)
+		}
+		// Times are compared above
+		rs.LastTransitionTime = tc.expected.LastTransitionTime
+		rs.LastErrorTime = tc.expected.LastErrorTime
+		if !reflect.DeepEqual(tc.expected, rs) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, rs)
+		}
+	}
+}
+
+func TestNewlyFailedRules(t *testing.T) {
+	established := v1alpha1.ForwarderRuleStatus{
+		GatewayIP:       "192.168.122.200",
+		RelayPort:       "2049",
+		DestinationIP:   "192.168.122.139",
+		DestinationPort: "8001",
+		State:           string(util.TunnelEstablished),
+	}
+	failed := *established.DeepCopy()
+	failed.State = string(util.TunnelFailed)
+	failed.LastError = "connection refused"
+	otherFailed := *failed.DeepCopy()
+	otherFailed.RelayPort = "2050"
+
+	testCases := []struct {
+		name     string
+		previous []v1alpha1.ForwarderRuleStatus
+		current  []v1alpha1.ForwarderRuleStatus
+		expected []v1alpha1.ForwarderRuleStatus
+	}{
+		{
+			name:     "Normal case (rule becomes failed)",
+			previous: []v1alpha1.ForwarderRuleStatus{established},
+			current:  []v1alpha1.ForwarderRuleStatus{failed},
+			expected: []v1alpha1.ForwarderRuleStatus{failed},
+		},
+		{
+			name:     "Normal case (rule is still failed)",
+			previous: []v1alpha1.ForwarderRuleStatus{failed},
+			current:  []v1alpha1.ForwarderRuleStatus{failed, otherFailed},
+			expected: []v1alpha1.ForwarderRuleStatus{otherFailed},
+		},
+		{
+			name:     "Normal case (rule recovers)",
+			previous: []v1alpha1.ForwarderRuleStatus{failed},
+			current:  []v1alpha1.ForwarderRuleStatus{established},
+			expected: []v1alpha1.ForwarderRuleStatus{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		rules := newlyFailedRules(tc.previous, tc.current)
+
+		if !reflect.DeepEqual(tc.expected, rules) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, rules)
+		}
+	}
+}
+
+func TestReady(t *testing.T) {
+	egressRule := v1alpha1.ForwarderRule{
+		DestinationPort: "8001",
+		DestinationIP:   "192.168.122.139",
+		GatewayIP:       "127.0.0.1",
+		RelayPort:       "2049",
+	}
+	ingressRule := v1alpha1.ForwarderRule{
+		SourceIP:        "192.168.122.139",
+		TargetPort:      "8443",
+		DestinationPort: "8443",
+		DestinationIP:   "10.96.0.10",
+		GatewayIP:       "127.0.0.1",
+		RelayPort:       "2050",
+	}
+	wireGuardRule := func(key string) v1alpha1.ForwarderRule {
+		return v1alpha1.ForwarderRule{
+			DestinationPort:    "8001",
+			DestinationIP:      "192.168.122.139",
+			GatewayIP:          "127.0.0.2",
+			Transport:          v1alpha1.TransportWireGuard,
+			WireGuardPublicKey: key,
+			WireGuardPort:      "51820",
+		}
+	}
+	newForwarder := func(egress, ingress []v1alpha1.ForwarderRule) *v1alpha1.Forwarder {
+		return &v1alpha1.Forwarder{
+			ObjectMeta: metav1.ObjectMeta{
+				Generation: 2,
+			},
+			Spec: v1alpha1.ForwarderSpec{
+				EgressRules:  egress,
+				IngressRules: ingress,
+				ForwarderIP:  "127.0.0.1",
+			},
+		}
+	}
+	fwd := newForwarder([]v1alpha1.ForwarderRule{egressRule}, nil)
+
+	testCases := []struct {
+		name        string
+		syncedFwd   *v1alpha1.Forwarder
+		generation  int64
+		expectReady bool
+	}{
+		{
+			name:        "Error case (not synced)",
+			syncedFwd:   nil,
+			generation:  2,
+			expectReady: false,
+		},
+		{
+			name:        "Error case (newer generation not synced)",
+			syncedFwd:   fwd,
+			generation:  3,
+			expectReady: false,
+		},
+		{
+			name:        "Error case (tunnel not established)",
+			syncedFwd:   fwd,
+			generation:  2,
+			expectReady: false,
+		},
+		{
+			name:        "Error case (gateway hasn't published wireguard key)",
+			syncedFwd:   newForwarder([]v1alpha1.ForwarderRule{wireGuardRule("")}, nil),
+			generation:  2,
+			expectReady: false,
+		},
+		{
+			name:        "Normal case (tunnel to a gateway not established, but egress through another gateway is ready)",
+			syncedFwd:   newForwarder([]v1alpha1.ForwarderRule{egressRule, wireGuardRule("gwkey")}, nil),
+			generation:  2,
+			expectReady: true,
+		},
+		{
+			name:        "Normal case (tunnel for ingress not established)",
+			syncedFwd:   newForwarder(nil, []v1alpha1.ForwarderRule{ingressRule}),
+			generation:  2,
+			expectReady: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		f := NewReconciler(nil, "ns1", "fwd1", TunnelOptions{}, nil)
+		// Tunnels that are created but not started yet
+		f.tunnels[sshTunnelKey(fwd, egressRule)] = util.NewTunnel("127.0.0.1:2049", "127.0.0.1:2022", "192.168.122.139:8001", &ssh.ClientConfig{})
+		f.remoteTunnels[remoteSSHTunnelKey(ingressRule)] = util.NewTunnel("10.96.0.10:8443", "127.0.0.1:2022", "127.0.0.1:2050", &ssh.ClientConfig{})
+		f.setSyncedRules(tc.syncedFwd)
+		f.observeGeneration(&v1alpha1.Forwarder{ObjectMeta: metav1.ObjectMeta{Generation: tc.generation}})
+
+		err := f.Ready()
+		if tc.expectReady && err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+		if !tc.expectReady && err == nil {
+			t.Errorf("expected error, but got no error")
+		}
+	}
+}
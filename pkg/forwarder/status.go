@@ -1,53 +1,107 @@
 package forwarder
 
 import (
+	"fmt"
+
 	"github.com/golang/glog"
 	"github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
 	clv1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned/typed/submariner/v1alpha1"
 	"github.com/mkimuram/k8s-ext-connector/pkg/util"
+	"github.com/operator-framework/operator-sdk/pkg/status"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 )
 
+// needSync returns true if rules in {fwd} need to be synced, which is when metadata.generation
+// of {fwd} is not observed yet or rules for the observed one are not synced
 func needSync(fwd *v1alpha1.Forwarder) bool {
 	// Sync is needed if
-	// - rule is not updating
-	// - generations are different between rule and sync || not is not synced
-	return fwd.Status.Conditions.IsFalseFor(v1alpha1.ConditionRuleUpdating) &&
-		(fwd.Status.RuleGeneration != fwd.Status.SyncGeneration ||
-			fwd.Status.Conditions.IsTrueFor(v1alpha1.ConditionRuleSyncing))
+	// - generation is not observed yet || rule is not synced
+	return fwd.Generation != fwd.Status.ObservedGeneration ||
+		!fwd.Status.Conditions.IsTrueFor(v1alpha1.ConditionSynced)
 }
 
+// needCheckSync returns true if rules in {fwd} need to be checked for drift
 func needCheckSync(fwd *v1alpha1.Forwarder) bool {
 	// CheckSync is needed if
-	// - rule is not updating
-	// - generations are the same between rule and sync
+	// - generation is observed
 	// - rule is synced
-	return fwd.Status.Conditions.IsFalseFor(v1alpha1.ConditionRuleUpdating) &&
-		fwd.Status.RuleGeneration == fwd.Status.SyncGeneration &&
-		fwd.Status.Conditions.IsFalseFor(v1alpha1.ConditionRuleSyncing)
+	return fwd.Generation == fwd.Status.ObservedGeneration &&
+		fwd.Status.Conditions.IsTrueFor(v1alpha1.ConditionSynced)
 }
 
-func setSyncing(clientset clv1alpha1.SubmarinerV1alpha1Interface, ns string, fwd *v1alpha1.Forwarder) error {
-	var err error
-	if fwd.Status.Conditions.SetCondition(util.RuleSyncingCondition(corev1.ConditionTrue)) {
-		fwd, err = clientset.Forwarders(ns).UpdateStatus(fwd)
-		if err != nil {
-			return err
-		}
-		glog.Infof("Update RuleSyncingCondition to true")
+// setSyncing sets Synced condition of {fwd} to False with {reason} and {message},
+// and returns the updated forwarder
+func setSyncing(clientset clv1alpha1.SubmarinerV1alpha1Interface, ns string, fwd *v1alpha1.Forwarder, reason, message string) (*v1alpha1.Forwarder, error) {
+	if !fwd.Status.Conditions.SetCondition(util.SyncedCondition(corev1.ConditionFalse, reason, message)) {
+		// No change
+		return fwd, nil
 	}
-	return nil
+
+	updated, err := clientset.Forwarders(ns).UpdateStatus(fwd)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Update SyncedCondition to false: %s", reason)
+
+	return updated, nil
+}
+
+// setSynced sets ObservedGeneration of {fwd} to its metadata.generation and Synced condition to True.
+// It returns the updated forwarder. Ready condition is set by setRuleStatuses instead, because tunnels
+// are established asynchronously after rules are synced.
+func setSynced(clientset clv1alpha1.SubmarinerV1alpha1Interface, ns string, fwd *v1alpha1.Forwarder) (*v1alpha1.Forwarder, error) {
+	message := fmt.Sprintf("Rules of generation %d are synced", fwd.Generation)
+	changed := fwd.Status.Conditions.SetCondition(util.SyncedCondition(corev1.ConditionTrue, util.ReasonSynced, message))
+	if !changed && fwd.Status.ObservedGeneration == fwd.Generation {
+		// No change
+		return fwd, nil
+	}
+
+	fwd.Status.ObservedGeneration = fwd.Generation
+	updated, err := clientset.Forwarders(ns).UpdateStatus(fwd)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Update SyncedCondition to true for generation %d", fwd.Generation)
+
+	return updated, nil
 }
 
-func setSynced(clientset clv1alpha1.SubmarinerV1alpha1Interface, ns string, fwd *v1alpha1.Forwarder) error {
-	var err error
-	if fwd.Status.Conditions.SetCondition(util.RuleSyncingCondition(corev1.ConditionFalse)) {
-		fwd.Status.SyncGeneration = fwd.Status.RuleGeneration
-		fwd, err = clientset.Forwarders(ns).UpdateStatus(fwd)
-		if err != nil {
-			return err
-		}
-		glog.Infof("Update RuleSyncingCondition to false")
+func setRuleStatuses(clientset clv1alpha1.SubmarinerV1alpha1Interface, ns string, fwd *v1alpha1.Forwarder, egress, ingress []v1alpha1.ForwarderRuleStatus, sources []v1alpha1.ForwarderSourceStatus, ready status.Condition) error {
+	readyChanged := fwd.Status.Conditions.SetCondition(ready)
+	if !readyChanged &&
+		equality.Semantic.DeepEqual(fwd.Status.EgressRuleStatuses, egress) &&
+		equality.Semantic.DeepEqual(fwd.Status.IngressRuleStatuses, ingress) &&
+		equality.Semantic.DeepEqual(fwd.Status.SourceStatuses, sources) {
+		// No change
+		return nil
+	}
+
+	fwd.Status.EgressRuleStatuses = egress
+	fwd.Status.IngressRuleStatuses = ingress
+	fwd.Status.SourceStatuses = sources
+	if _, err := clientset.Forwarders(ns).UpdateStatus(fwd); err != nil {
+		return err
 	}
+	glog.Infof("Update rule statuses")
+
 	return nil
 }
+
+// setWireGuardPublicKey publishes {key} to the status of {fwd}, and returns the updated forwarder
+func setWireGuardPublicKey(clientset clv1alpha1.SubmarinerV1alpha1Interface, ns string, fwd *v1alpha1.Forwarder, key string) (*v1alpha1.Forwarder, error) {
+	if fwd.Status.WireGuardPublicKey == key {
+		// No change
+		return fwd, nil
+	}
+
+	fwd.Status.WireGuardPublicKey = key
+	updated, err := clientset.Forwarders(ns).UpdateStatus(fwd)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Update wireguard public key")
+
+	return updated, nil
+}
@@ -7,102 +7,107 @@ import (
 	submarinerv1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
 	fakeversioned "github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned/fake"
 	fakev1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned/typed/submariner/v1alpha1/fake"
+	"github.com/mkimuram/k8s-ext-connector/pkg/util"
 	"github.com/operator-framework/operator-sdk/pkg/status"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func compareForwarder(t *testing.T, a, b *v1alpha1.Forwarder) {
-	if a.Status.Conditions[submarinerv1alpha1.ConditionRuleSyncing].Status !=
-		b.Status.Conditions[submarinerv1alpha1.ConditionRuleSyncing].Status {
-		t.Errorf("RuleSyncing: expected %v, but got %v",
-			a.Status.Conditions[submarinerv1alpha1.ConditionRuleSyncing].Status,
-			b.Status.Conditions[submarinerv1alpha1.ConditionRuleSyncing].Status)
+	if a.Status.Conditions[submarinerv1alpha1.ConditionSynced].Status !=
+		b.Status.Conditions[submarinerv1alpha1.ConditionSynced].Status {
+		t.Errorf("Synced: expected %v, but got %v",
+			a.Status.Conditions[submarinerv1alpha1.ConditionSynced].Status,
+			b.Status.Conditions[submarinerv1alpha1.ConditionSynced].Status)
 	}
-	if a.Status.Conditions[submarinerv1alpha1.ConditionRuleUpdating].Status !=
-		b.Status.Conditions[submarinerv1alpha1.ConditionRuleUpdating].Status {
-		t.Errorf("RuleUpdating: expected %v, but got %v",
-			a.Status.Conditions[submarinerv1alpha1.ConditionRuleUpdating].Status,
-			b.Status.Conditions[submarinerv1alpha1.ConditionRuleUpdating].Status)
+	if a.Status.Conditions[submarinerv1alpha1.ConditionSynced].Reason !=
+		b.Status.Conditions[submarinerv1alpha1.ConditionSynced].Reason {
+		t.Errorf("Synced reason: expected %v, but got %v",
+			a.Status.Conditions[submarinerv1alpha1.ConditionSynced].Reason,
+			b.Status.Conditions[submarinerv1alpha1.ConditionSynced].Reason)
 	}
-	if a.Status.RuleGeneration != b.Status.RuleGeneration {
-		t.Errorf("Rulegeneration: expected %v, but got %v", a.Status.RuleGeneration, b.Status.RuleGeneration)
+	if a.Status.Conditions[submarinerv1alpha1.ConditionReady].Status !=
+		b.Status.Conditions[submarinerv1alpha1.ConditionReady].Status {
+		t.Errorf("Ready: expected %v, but got %v",
+			a.Status.Conditions[submarinerv1alpha1.ConditionReady].Status,
+			b.Status.Conditions[submarinerv1alpha1.ConditionReady].Status)
 	}
-	if a.Status.SyncGeneration != b.Status.SyncGeneration {
-		t.Errorf("Rulegeneration: expected %v, but got %v", a.Status.SyncGeneration, b.Status.SyncGeneration)
+	if a.Status.ObservedGeneration != b.Status.ObservedGeneration {
+		t.Errorf("ObservedGeneration: expected %v, but got %v", a.Status.ObservedGeneration, b.Status.ObservedGeneration)
 	}
 }
 
 func TestNeedSync(t *testing.T) {
 	testCases := []struct {
-		name      string
-		namespace string
-		fwd       *v1alpha1.Forwarder
-		expected  bool
+		name     string
+		fwd      *v1alpha1.Forwarder
+		expected bool
 	}{
 		{
-			name: "Normal case (rule is not updating and generation is different)",
+			name: "Normal case (generation is not observed yet)",
 			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					// Spec is updated
+					Generation: 2,
+				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleSyncing,
-							Status: corev1.ConditionFalse,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleUpdating,
-							// not updating
-							Status: corev1.ConditionFalse,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type:   submarinerv1alpha1.ConditionSynced,
+							Status: corev1.ConditionTrue,
 						},
 					},
-					// Different generation
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
 			expected: true,
 		},
 		{
-			name: "Normal case (rule is not updating and syncing)",
+			name: "Normal case (rule is not synced)",
 			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Generation: 2,
+				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Syncing
-							Status: corev1.ConditionTrue,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Not synced
 							Status: corev1.ConditionFalse,
 						},
 					},
 					// same generation
-					RuleGeneration: 2,
-					SyncGeneration: 2,
+					ObservedGeneration: 2,
 				},
 			},
 			expected: true,
 		},
 		{
-			name: "Normal case (rule is updating)",
+			name: "Normal case (new forwarder without conditions)",
 			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Generation: 1,
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "Normal case (rule is synced for the generation)",
+			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Generation: 2,
+				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Syncing
-							Status: corev1.ConditionTrue,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleUpdating,
-							// Still rule is updating
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Synced
 							Status: corev1.ConditionTrue,
 						},
 					},
-					// different generation
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					// same generation
+					ObservedGeneration: 2,
 				},
 			},
 			expected: false,
@@ -126,70 +131,61 @@ func TestNeedCheckSync(t *testing.T) {
 		expected bool
 	}{
 		{
-			name: "Normal case (rule is not updating and generation is the same)",
+			name: "Normal case (rule is synced and generation is observed)",
 			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Generation: 1,
+				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
 							// synced
-							Status: corev1.ConditionFalse,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleUpdating,
-							// not updating
-							Status: corev1.ConditionFalse,
+							Status: corev1.ConditionTrue,
 						},
 					},
 					// same generation
-					RuleGeneration: 1,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
 			expected: true,
 		},
 		{
-			name: "Normal case (rule is not updating and generation is different)",
+			name: "Normal case (rule is synced but generation is not observed)",
 			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Generation: 2,
+				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
 							// synced
-							Status: corev1.ConditionFalse,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleUpdating,
-							// not updating
-							Status: corev1.ConditionFalse,
+							Status: corev1.ConditionTrue,
 						},
 					},
 					// different generation
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
 			expected: false,
 		},
 		{
-			name: "Normal case (rule is updating)",
+			name: "Normal case (rule is not synced)",
 			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Generation: 1,
+				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// synced
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// not synced
 							Status: corev1.ConditionFalse,
 						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleUpdating,
-							// updating
-							Status: corev1.ConditionTrue,
-						},
 					},
 					// same generation
-					RuleGeneration: 1,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
 			expected: false,
@@ -211,100 +207,118 @@ func TestSetSyncing(t *testing.T) {
 		name      string
 		namespace string
 		fwd       *v1alpha1.Forwarder
+		reason    string
 		expected  *v1alpha1.Forwarder
 		expectErr bool
 	}{
 		{
-			name:      "Normal case (Change RuleSyncing false to true)",
+			name:      "Normal case (Change Synced true to false for new generation)",
 			namespace: "ns1",
 			fwd: &v1alpha1.Forwarder{
 				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "fwd1",
+					Namespace:  "ns1",
+					Name:       "fwd1",
+					Generation: 2,
 				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleSyncing,
-							Status: corev1.ConditionFalse,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type:   submarinerv1alpha1.ConditionSynced,
+							Status: corev1.ConditionTrue,
+							Reason: util.ReasonSynced,
 						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
+					},
+					ObservedGeneration: 1,
+				},
+			},
+			reason: util.ReasonSyncing,
+			expected: &v1alpha1.Forwarder{
+				Status: v1alpha1.ForwarderStatus{
+					Conditions: status.Conditions{
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Changed to false
 							Status: corev1.ConditionFalse,
+							Reason: util.ReasonSyncing,
 						},
 					},
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					// No change
+					ObservedGeneration: 1,
 				},
 			},
-			expected: &v1alpha1.Forwarder{
+			expectErr: false,
+		},
+		{
+			name:      "Normal case (Change Synced true to false for drift)",
+			namespace: "ns1",
+			fwd: &v1alpha1.Forwarder{
 				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "fwd1",
+					Namespace:  "ns1",
+					Name:       "fwd1",
+					Generation: 2,
 				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Only this field wil be changed
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type:   submarinerv1alpha1.ConditionSynced,
 							Status: corev1.ConditionTrue,
+							Reason: util.ReasonSynced,
 						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
+					},
+					ObservedGeneration: 2,
+				},
+			},
+			reason: util.ReasonNotSynced,
+			expected: &v1alpha1.Forwarder{
+				Status: v1alpha1.ForwarderStatus{
+					Conditions: status.Conditions{
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Changed to false
 							Status: corev1.ConditionFalse,
+							Reason: util.ReasonNotSynced,
 						},
 					},
 					// No change
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					ObservedGeneration: 2,
 				},
 			},
 			expectErr: false,
 		},
 		{
-			name:      "Normal case (RuleSyncing is already true and try to set it to true)",
+			name:      "Normal case (Synced is already false and try to set it to false)",
 			namespace: "ns1",
 			fwd: &v1alpha1.Forwarder{
 				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "fwd1",
+					Namespace:  "ns1",
+					Name:       "fwd1",
+					Generation: 2,
 				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Already true
-							Status: corev1.ConditionTrue,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Already false
 							Status: corev1.ConditionFalse,
+							Reason: util.ReasonSyncing,
 						},
 					},
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
+			reason: util.ReasonSyncing,
 			expected: &v1alpha1.Forwarder{
-				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "fwd1",
-				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Remains true
-							Status: corev1.ConditionTrue,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Remains false
 							Status: corev1.ConditionFalse,
+							Reason: util.ReasonSyncing,
 						},
 					},
 					// No change
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
 			expectErr: false,
@@ -322,25 +336,26 @@ func TestSetSyncing(t *testing.T) {
 			t.Fatalf("creating fwd %s failed: %v", tc.fwd.Name, err)
 		}
 
-		err := setSyncing(cl, tc.namespace, tc.fwd)
-
-		fwd, err := cl.Forwarders(tc.namespace).Get(tc.fwd.Name, metav1.GetOptions{})
-		if err != nil {
-			t.Fatalf("getting fwd %s failed: %v", tc.fwd.Name, err)
-		}
-
+		updated, err := setSyncing(cl, tc.namespace, tc.fwd, tc.reason, "message")
 		if tc.expectErr {
 			if err == nil {
 				t.Errorf("expected error, but got no error")
 			}
-		} else {
-			if err != nil {
-				t.Errorf("expected no error, but got %v", err)
-			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected no error, but got %v", err)
+			continue
+		}
 
-			// Compare fwd with expected
-			compareForwarder(t, tc.expected, fwd)
+		fwd, err := cl.Forwarders(tc.namespace).Get(tc.fwd.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("getting fwd %s failed: %v", tc.fwd.Name, err)
 		}
+
+		// Compare both the stored and the returned fwd with expected
+		compareForwarder(t, tc.expected, fwd)
+		compareForwarder(t, tc.expected, updated)
 	}
 }
 
@@ -353,97 +368,76 @@ func TestSetSynced(t *testing.T) {
 		expectErr bool
 	}{
 		{
-			name:      "Normal case (Change RuleSyncing true to false)",
+			name:      "Normal case (Change Synced false to true)",
 			namespace: "ns1",
 			fwd: &v1alpha1.Forwarder{
 				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "fwd1",
+					Namespace:  "ns1",
+					Name:       "fwd1",
+					Generation: 2,
 				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// True
-							Status: corev1.ConditionTrue,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// False
 							Status: corev1.ConditionFalse,
+							Reason: util.ReasonSyncing,
 						},
 					},
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
 			expected: &v1alpha1.Forwarder{
-				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "fwd1",
-				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Changed to false
-							Status: corev1.ConditionFalse,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
-							Status: corev1.ConditionFalse,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Changed to true
+							Status: corev1.ConditionTrue,
+							Reason: util.ReasonSynced,
 						},
 					},
-					// Generations are also synced
-					RuleGeneration: 2,
-					SyncGeneration: 2,
+					// Generation is observed
+					ObservedGeneration: 2,
 				},
 			},
 			expectErr: false,
 		},
 		{
-			name:      "Normal case (RuleSyncing is already false and try to set it to false)",
+			name:      "Normal case (Synced is already true and try to set it to true)",
 			namespace: "ns1",
 			fwd: &v1alpha1.Forwarder{
 				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "fwd1",
+					Namespace:  "ns1",
+					Name:       "fwd1",
+					Generation: 2,
 				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Already false
-							Status: corev1.ConditionFalse,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
-							Status: corev1.ConditionFalse,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Already true
+							Status:  corev1.ConditionTrue,
+							Reason:  util.ReasonSynced,
+							Message: "Rules of generation 2 are synced",
 						},
 					},
-					RuleGeneration: 2,
-					SyncGeneration: 2,
+					ObservedGeneration: 2,
 				},
 			},
 			expected: &v1alpha1.Forwarder{
-				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "fwd1",
-				},
 				Status: v1alpha1.ForwarderStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Remains false
-							Status: corev1.ConditionFalse,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
-							Status: corev1.ConditionFalse,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Remains true
+							Status: corev1.ConditionTrue,
+							Reason: util.ReasonSynced,
 						},
 					},
-					// TODO: consider situation that generations need to be upated?
-					RuleGeneration: 2,
-					SyncGeneration: 2,
+					// No change
+					ObservedGeneration: 2,
 				},
 			},
 			expectErr: false,
@@ -461,24 +455,229 @@ func TestSetSynced(t *testing.T) {
 			t.Fatalf("creating fwd %s failed: %v", tc.fwd.Name, err)
 		}
 
-		err := setSynced(cl, tc.namespace, tc.fwd)
+		updated, err := setSynced(cl, tc.namespace, tc.fwd)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error, but got no error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected no error, but got %v", err)
+			continue
+		}
 
 		fwd, err := cl.Forwarders(tc.namespace).Get(tc.fwd.Name, metav1.GetOptions{})
 		if err != nil {
 			t.Fatalf("getting fwd %s failed: %v", tc.fwd.Name, err)
 		}
 
+		// Compare both the stored and the returned fwd with expected
+		compareForwarder(t, tc.expected, fwd)
+		compareForwarder(t, tc.expected, updated)
+	}
+}
+
+func TestSetRuleStatuses(t *testing.T) {
+	ruleStatus := v1alpha1.ForwarderRuleStatus{
+		GatewayIP:       "192.168.122.200",
+		RelayPort:       "2049",
+		DestinationIP:   "192.168.122.139",
+		DestinationPort: "8001",
+		State:           "Established",
+	}
+	sourceStatus := v1alpha1.ForwarderSourceStatus{
+		GatewayIP: "192.168.122.200",
+		LimitStatus: v1alpha1.LimitStatus{
+			ActiveConnections:   2,
+			RejectedConnections: 3,
+			ThrottledTransfers:  4,
+		},
+	}
+
+	testCases := []struct {
+		name      string
+		namespace string
+		fwd       *v1alpha1.Forwarder
+		egress    []v1alpha1.ForwarderRuleStatus
+		ingress   []v1alpha1.ForwarderRuleStatus
+		sources   []v1alpha1.ForwarderSourceStatus
+		ready     status.Condition
+		expectErr bool
+	}{
+		{
+			name:      "Normal case (Set rule statuses)",
+			namespace: "ns1",
+			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "fwd1",
+				},
+			},
+			egress:    []v1alpha1.ForwarderRuleStatus{ruleStatus},
+			ingress:   []v1alpha1.ForwarderRuleStatus{},
+			sources:   []v1alpha1.ForwarderSourceStatus{},
+			ready:     util.ReadyCondition(corev1.ConditionTrue, util.ReasonReady, ""),
+			expectErr: false,
+		},
+		{
+			name:      "Normal case (Set source statuses)",
+			namespace: "ns1",
+			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "fwd1",
+				},
+				Status: v1alpha1.ForwarderStatus{
+					EgressRuleStatuses: []v1alpha1.ForwarderRuleStatus{ruleStatus},
+				},
+			},
+			egress:    []v1alpha1.ForwarderRuleStatus{ruleStatus},
+			ingress:   []v1alpha1.ForwarderRuleStatus{},
+			sources:   []v1alpha1.ForwarderSourceStatus{sourceStatus},
+			ready:     util.ReadyCondition(corev1.ConditionFalse, util.ReasonNotReady, "iptables rules are not applied"),
+			expectErr: false,
+		},
+		{
+			name:      "Normal case (Rule statuses are already set)",
+			namespace: "ns1",
+			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "fwd1",
+				},
+				Status: v1alpha1.ForwarderStatus{
+					EgressRuleStatuses:  []v1alpha1.ForwarderRuleStatus{ruleStatus},
+					IngressRuleStatuses: []v1alpha1.ForwarderRuleStatus{ruleStatus},
+					SourceStatuses:      []v1alpha1.ForwarderSourceStatus{sourceStatus},
+					Conditions: status.Conditions{
+						submarinerv1alpha1.ConditionReady: util.ReadyCondition(corev1.ConditionTrue, util.ReasonReady, ""),
+					},
+				},
+			},
+			egress:    []v1alpha1.ForwarderRuleStatus{ruleStatus},
+			ingress:   []v1alpha1.ForwarderRuleStatus{ruleStatus},
+			sources:   []v1alpha1.ForwarderSourceStatus{sourceStatus},
+			ready:     util.ReadyCondition(corev1.ConditionTrue, util.ReasonReady, ""),
+			expectErr: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		vcl := fakeversioned.NewSimpleClientset()
+		cl := &fakev1alpha1.FakeSubmarinerV1alpha1{Fake: &vcl.Fake}
+
+		// Create tc.fwd
+		if _, err := cl.Forwarders(tc.namespace).Create(tc.fwd); err != nil {
+			t.Fatalf("creating fwd %s failed: %v", tc.fwd.Name, err)
+		}
+
+		err := setRuleStatuses(cl, tc.namespace, tc.fwd, tc.egress, tc.ingress, tc.sources, tc.ready)
 		if tc.expectErr {
 			if err == nil {
 				t.Errorf("expected error, but got no error")
 			}
-		} else {
-			if err != nil {
-				t.Errorf("expected no error, but got %v", err)
-			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
 
-			// Compare fwd with expected
-			compareForwarder(t, tc.expected, fwd)
+		fwd, err := cl.Forwarders(tc.namespace).Get(tc.fwd.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("getting fwd %s failed: %v", tc.fwd.Name, err)
+		}
+		if !equality.Semantic.DeepEqual(tc.egress, fwd.Status.EgressRuleStatuses) {
+			t.Errorf("EgressRuleStatuses: expected %v, but got %v", tc.egress, fwd.Status.EgressRuleStatuses)
+		}
+		if !equality.Semantic.DeepEqual(tc.ingress, fwd.Status.IngressRuleStatuses) {
+			t.Errorf("IngressRuleStatuses: expected %v, but got %v", tc.ingress, fwd.Status.IngressRuleStatuses)
+		}
+		if !equality.Semantic.DeepEqual(tc.sources, fwd.Status.SourceStatuses) {
+			t.Errorf("SourceStatuses: expected %v, but got %v", tc.sources, fwd.Status.SourceStatuses)
+		}
+		if ready := fwd.Status.Conditions[submarinerv1alpha1.ConditionReady]; ready.Status != tc.ready.Status || ready.Message != tc.ready.Message {
+			t.Errorf("Ready: expected %v, but got %v", tc.ready, ready)
+		}
+	}
+}
+
+func TestSetWireGuardPublicKey(t *testing.T) {
+	testCases := []struct {
+		name      string
+		namespace string
+		fwd       *v1alpha1.Forwarder
+		key       string
+	}{
+		{
+			name:      "Normal case (Set public key)",
+			namespace: "ns1",
+			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "fwd1",
+				},
+			},
+			key: "fwdkey",
+		},
+		{
+			name:      "Normal case (Update public key)",
+			namespace: "ns1",
+			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "fwd1",
+				},
+				Status: v1alpha1.ForwarderStatus{
+					WireGuardPublicKey: "oldkey",
+				},
+			},
+			key: "fwdkey",
+		},
+		{
+			name:      "Normal case (Public key is already set)",
+			namespace: "ns1",
+			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "fwd1",
+				},
+				Status: v1alpha1.ForwarderStatus{
+					WireGuardPublicKey: "fwdkey",
+				},
+			},
+			key: "fwdkey",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		vcl := fakeversioned.NewSimpleClientset()
+		cl := &fakev1alpha1.FakeSubmarinerV1alpha1{Fake: &vcl.Fake}
+
+		// Create tc.fwd
+		if _, err := cl.Forwarders(tc.namespace).Create(tc.fwd); err != nil {
+			t.Fatalf("creating fwd %s failed: %v", tc.fwd.Name, err)
+		}
+
+		updated, err := setWireGuardPublicKey(cl, tc.namespace, tc.fwd, tc.key)
+		if err != nil {
+			t.Errorf("expected no error, but got %v", err)
+			continue
+		}
+		if updated.Status.WireGuardPublicKey != tc.key {
+			t.Errorf("WireGuardPublicKey of returned fwd: expected %q, but got %q", tc.key, updated.Status.WireGuardPublicKey)
+		}
+
+		fwd, err := cl.Forwarders(tc.namespace).Get(tc.fwd.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("getting fwd %s failed: %v", tc.fwd.Name, err)
+		}
+		if fwd.Status.WireGuardPublicKey != tc.key {
+			t.Errorf("WireGuardPublicKey: expected %q, but got %q", tc.key, fwd.Status.WireGuardPublicKey)
 		}
 	}
 }
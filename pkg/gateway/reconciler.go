@@ -2,6 +2,14 @@ package gateway
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	backoffv4 "github.com/cenkalti/backoff/v4"
@@ -10,89 +18,427 @@ import (
 	"github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
 	clv1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned/typed/submariner/v1alpha1"
 	"github.com/mkimuram/k8s-ext-connector/pkg/util"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/record"
 )
 
 const (
 	prechainPrefix  = "pre"
 	postchainPrefix = "pst"
+	// limitStatusInterval is the minimum interval to publish counters of limiters,
+	// which change frequently, to the gateway's status
+	limitStatusInterval = 30 * time.Second
+	// defaultHealthCheckPeriod is the default interval of health checks of targets
+	defaultHealthCheckPeriod = 10 * time.Second
+	// defaultHealthCheckTimeout is the default timeout of each health check of targets
+	defaultHealthCheckTimeout = time.Second
+	// listenerOpenTimeout is the time that connections accepted by listeners in Listener mode
+	// wait for a forwarder session to be available
+	listenerOpenTimeout = 5 * time.Second
 )
 
+// forwarderInfo represents a forwarder that has egress rules in a gateway
+type forwarderInfo struct {
+	ref             v1alpha1.ForwarderRef
+	ip              string
+	externalService string
+}
+
+// relayServer is a mTLS relay server running on a GatewayIP, whose retries to serve are stopped by cancel
+type relayServer struct {
+	server *util.TLSRelayServer
+	port   string
+	cancel context.CancelFunc
+}
+
 // Reconciler represents a reconciler for gateway
 type Reconciler struct {
-	clientset clv1alpha1.SubmarinerV1alpha1Interface
-	namespace string
-	ssh       map[string]*glssh.Server
+	clientset   clv1alpha1.SubmarinerV1alpha1Interface
+	secrets     corelisters.SecretLister
+	namespace   string
+	ssh         map[string]*glssh.Server
+	relays      map[string]*relayServer
+	idleTimeout time.Duration
+	recorder    record.EventRecorder
+	// sessions are sessions of forwarders for remote forwarding keyed by GatewayIP, which are shared by
+	// ssh servers and mTLS relay servers, and used by listeners for ingress in Listener mode.
+	// listeners are the listeners for ingress in Listener mode keyed by GatewayIP and the address to listen on.
+	sessions  map[string]*util.ReverseSessions
+	listeners map[string]map[string]net.Listener
+	// limiters are limiters for forwarders keyed by GatewayIP and the IDs of forwarders, which they send by themselves.
+	// forwarders are forwarders that have egress rules keyed the same way.
+	// targetGroups are target groups for destinations with multiple targets keyed by GatewayIP,
+	// and by the ID of forwarder and destination.
+	// tlsConfigs are tls.Configs of mTLS relay servers keyed by GatewayIP.
+	// listenerRules are ingress rules for listeners keyed by GatewayIP and the address to listen on.
+	// externalServices are references to the external services that have rules keyed by GatewayIP,
+	// which errors of servers are recorded on.
+	// mutex protects limiters, forwarders, targetGroups, tlsConfigs, listenerRules and externalServices,
+	// because they are looked up by ssh servers, mTLS relay servers and listeners.
+	mutex            sync.Mutex
+	limiters         map[string]map[string]*util.Limiter
+	forwarders       map[string]map[string]forwarderInfo
+	targetGroups     map[string]map[string]*util.TargetGroup
+	tlsConfigs       map[string]*tls.Config
+	listenerRules    map[string]map[string][]v1alpha1.GatewayRule
+	externalServices map[string][]*corev1.ObjectReference
+	limitStatusTimes map[string]time.Time
+	// wireGuardPrivateKey and wireGuardPublicKey are the key pair of the gateway for WireGuard,
+	// which is generated on start and published to the statuses of gateways.
+	// wireGuardIndexes are indexes of WireGuard interfaces keyed by GatewayIP, which identify
+	// their ports, marks and routing tables. wireGuards are GatewayIPs that have WireGuard interfaces created.
+	wireGuardPrivateKey string
+	wireGuardPublicKey  string
+	wireGuardIndexes    map[string]int
+	wireGuards          map[string]bool
+	// driftCheckInterval is the interval to requeue gateways to check drift of the synced rules
+	driftCheckInterval time.Duration
 }
 
 var _ util.ReconcilerInterface = &Reconciler{}
 
 // NewReconciler returns a Reconciler instance
-func NewReconciler(cl clv1alpha1.SubmarinerV1alpha1Interface, ns string) *Reconciler {
+// ssh connections that have no activity for {idleTimeout} are closed. Zero {idleTimeout} disables it.
+// Certificates for TLS transport are read from secrets in {ns} via {secrets}, which lists them from the cache of
+// an informer, not to get them from the API server on every reconcile.
+// Events are recorded by {recorder} on the external services that have rules in the gateway.
+func NewReconciler(cl clv1alpha1.SubmarinerV1alpha1Interface, secrets corelisters.SecretLister, ns string, idleTimeout time.Duration, recorder record.EventRecorder) *Reconciler {
 	return &Reconciler{
-		clientset: cl,
-		namespace: ns,
-		ssh:       map[string]*glssh.Server{},
+		clientset:        cl,
+		secrets:          secrets,
+		namespace:        ns,
+		ssh:              map[string]*glssh.Server{},
+		relays:           map[string]*relayServer{},
+		idleTimeout:      idleTimeout,
+		recorder:         recorder,
+		sessions:         map[string]*util.ReverseSessions{},
+		listeners:        map[string]map[string]net.Listener{},
+		limiters:         map[string]map[string]*util.Limiter{},
+		forwarders:       map[string]map[string]forwarderInfo{},
+		targetGroups:     map[string]map[string]*util.TargetGroup{},
+		tlsConfigs:       map[string]*tls.Config{},
+		listenerRules:    map[string]map[string][]v1alpha1.GatewayRule{},
+		externalServices: map[string][]*corev1.ObjectReference{},
+		limitStatusTimes: map[string]time.Time{},
+		wireGuardIndexes: map[string]int{},
+		wireGuards:       map[string]bool{},
 	}
 }
 
-// Reconcile reconciles gateway
-func (g *Reconciler) Reconcile(namespace, name string) error {
+// Reconcile reconciles gateway.
+// It requeues the gateway after the drift check interval to repair rules that drift without events.
+func (g *Reconciler) Reconcile(namespace, name string) (util.Result, error) {
 	// Check if the resource is in namespace to be handled
 	if g.namespace != namespace {
 		// no need to handle this resource
-		return nil
+		return util.Result{}, nil
 	}
 
 	gw, err := g.clientset.Gateways(namespace).Get(name, metav1.GetOptions{})
 	if err != nil {
-		return err
+		return util.Result{}, err
 	}
+
+	// Public key and port are published first, because the operator passes them to forwarders for WireGuard.
+	// They are published only if there are rules for WireGuard, and cleared otherwise.
+	if gw.Spec.GatewayIP != "" {
+		key, port := "", ""
+		if hasWireGuardRules(gw) {
+			if err := g.ensureWireGuardKey(); err != nil {
+				return util.Result{}, err
+			}
+			index, err := g.wireGuardIndex(gw.Spec.GatewayIP)
+			if err != nil {
+				return util.Result{}, err
+			}
+			key, port = g.wireGuardPublicKey, wireGuardPort(index)
+		}
+		gw, err = setWireGuardStatus(g.clientset, namespace, gw, key, port)
+		if err != nil {
+			return util.Result{}, err
+		}
+	}
+
+	// Certificate for TLS transport is loaded from the cache every time, because it is renewed by the operator
+	if err := g.updateTLSConfig(gw); err != nil {
+		return util.Result{}, err
+	}
+
+	if needCheckSync(gw) && !g.ruleSynced(gw) {
+		glog.Errorf("rule for %s/%s is not synced any more", namespace, name)
+		g.eventf(gw, corev1.EventTypeWarning, util.ReasonNotSynced, "Rules in gateway %s/%s are not synced any more", namespace, name)
+		// Set to not synced, so that the rules are repaired below right away
+		gw, err = setSyncing(g.clientset, namespace, gw, util.ReasonNotSynced, "Rules are not synced any more")
+		if err != nil {
+			return util.Result{}, err
+		}
+	}
+
 	if needSync(gw) {
-		if err := setSyncing(g.clientset, namespace, gw); err != nil {
-			return err
+		gw, err = setSyncing(g.clientset, namespace, gw, util.ReasonSyncing, fmt.Sprintf("Rules of generation %d are being synced", gw.Generation))
+		if err != nil {
+			return util.Result{}, err
 		}
 
 		if err := g.syncRule(gw); err != nil {
 			glog.Errorf("failed to sync rule for %s/%s: %v", namespace, name, err)
-			return err
+			return util.Result{}, err
 		}
 
-		if err := setSynced(g.clientset, namespace, gw); err != nil {
-			return err
+		gw, err = setSynced(g.clientset, namespace, gw)
+		if err != nil {
+			return util.Result{}, err
 		}
-	} else if needCheckSync(gw) {
-		if !g.ruleSynced(gw) {
-			glog.Errorf("rule for %s/%s is not synced any more", namespace, name)
-			// Set to syncing and return error to requeue and sync
-			if err := setSyncing(g.clientset, namespace, gw); err != nil {
-				return err
-			}
+		g.eventf(gw, corev1.EventTypeNormal, util.ReasonSynced, "Gateway %s/%s synced rules", namespace, name)
+	}
+
+	if err := g.updateForwarderStatuses(namespace, gw); err != nil {
+		return util.Result{}, err
+	}
+
+	return util.Result{RequeueAfter: g.driftCheckInterval}, nil
+}
+
+// SetDriftCheckInterval sets the interval to check that the synced rules haven't drifted.
+// Drift is checked only on events of the gateways if {interval} is 0.
+func (g *Reconciler) SetDriftCheckInterval(interval time.Duration) {
+	g.driftCheckInterval = interval
+}
+
+// ensureWireGuardKey generates the key pair for WireGuard, if not generated yet
+func (g *Reconciler) ensureWireGuardKey() error {
+	if g.wireGuardPrivateKey != "" {
+		return nil
+	}
+	private, public, err := util.GenerateWireGuardKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate key for wireguard: %v", err)
+	}
+	g.wireGuardPrivateKey, g.wireGuardPublicKey = private, public
+
+	return nil
+}
+
+// wireGuardIndex returns the index of WireGuard interface for {gwIP}, which is allocated if not allocated yet
+func (g *Reconciler) wireGuardIndex(gwIP string) (int, error) {
+	if index, ok := g.wireGuardIndexes[gwIP]; ok {
+		return index, nil
+	}
+
+	used := map[int]bool{}
+	for _, index := range g.wireGuardIndexes {
+		used[index] = true
+	}
+	for index := 0; index < util.WireGuardMaxInterfaces; index++ {
+		if !used[index] {
+			g.wireGuardIndexes[gwIP] = index
+			return index, nil
 		}
 	}
 
+	return 0, fmt.Errorf("no index left for wireguard interface for %s", gwIP)
+}
+
+// wireGuardIndexFor returns the index of WireGuard interface for {gw}. It is allocated only if {gw} has rules
+// for WireGuard, otherwise the index allocated before is returned, which is still needed to delete the interface.
+func (g *Reconciler) wireGuardIndexFor(gw *v1alpha1.Gateway) (int, error) {
+	if !hasWireGuardRules(gw) {
+		return g.wireGuardIndexes[gw.Spec.GatewayIP], nil
+	}
+
+	return g.wireGuardIndex(gw.Spec.GatewayIP)
+}
+
+// wireGuardPort returns the port that WireGuard interface with {index} listens on
+func wireGuardPort(index int) string {
+	return strconv.Itoa(util.WireGuardPort + index)
+}
+
+// updateForwarderStatuses publishes the counters of limits for the forwarders to the status of {gw},
+// which needs to be the latest one returned by the status updates in the reconcile
+func (g *Reconciler) updateForwarderStatuses(namespace string, gw *v1alpha1.Gateway) error {
+	// Counters are published at most once in limitStatusInterval,
+	// because updating status triggers reconcile again
+	if time.Since(g.limitStatusTimes[gw.Name]) < limitStatusInterval {
+		return nil
+	}
+
+	gw, err := setListenerStatuses(g.clientset, namespace, gw, g.listenerStatuses(gw.Spec.GatewayIP))
+	if err != nil {
+		return err
+	}
+	if err := setForwarderStatuses(g.clientset, namespace, gw, g.forwarderStatuses(gw.Spec.GatewayIP)); err != nil {
+		return err
+	}
+	g.limitStatusTimes[gw.Name] = time.Now()
+
 	return nil
 }
 
+// forwarderStatuses returns GatewayForwarderStatus for each forwarder that has limits on {gwIP}
+func (g *Reconciler) forwarderStatuses(gwIP string) []v1alpha1.GatewayForwarderStatus {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	forwarders := []v1alpha1.GatewayForwarderStatus{}
+	for id, limiter := range g.limiters[gwIP] {
+		if limiter.Limits() == (util.Limits{}) {
+			// Unlimited
+			continue
+		}
+		info := g.forwarders[gwIP][id]
+		forwarders = append(forwarders, v1alpha1.GatewayForwarderStatus{
+			Forwarder:   info.ref,
+			ForwarderIP: info.ip,
+			LimitStatus: util.ToLimitStatus(limiter.Counters()),
+		})
+	}
+	sort.Slice(forwarders, func(i, j int) bool {
+		return forwarderID(forwarders[i].Forwarder) < forwarderID(forwarders[j].Forwarder)
+	})
+
+	return forwarders
+}
+
 func (g *Reconciler) syncRule(gw *v1alpha1.Gateway) error {
+	g.updateExternalServiceRefs(gw)
+	g.updateLimiters(gw)
+	g.updateTargetGroups(gw)
 	if err := g.ensureSshdRunning(gw.Spec.GatewayIP); err != nil {
 		return err
 	}
+	if err := g.ensureRelayRunning(gw); err != nil {
+		return err
+	}
+	if err := g.updateListeners(gw); err != nil {
+		g.eventf(gw, corev1.EventTypeWarning, util.ReasonFailedListener, "failed to update listeners in gateway %s/%s: %v", gw.Namespace, gw.Name, err)
+		return err
+	}
+	// WireGuard interface needs to exist before iptables rules route packets to it
+	if err := g.updateWireGuard(gw); err != nil {
+		g.eventf(gw, corev1.EventTypeWarning, util.ReasonFailedSyncTunnel, "failed to update wireguard in gateway %s/%s: %v", gw.Namespace, gw.Name, err)
+		return err
+	}
 	// Apply iptables rules for gw
-	if err := g.applyIptablesRules(gw); err != nil {
+	start := time.Now()
+	err := g.applyIptablesRules(gw)
+	util.ObserveIptablesSync("" /* externalService */, gw.Spec.GatewayIP, start, err)
+	if err != nil {
+		g.eventf(gw, corev1.EventTypeWarning, util.ReasonFailedSyncIptables, "failed to apply iptables rules in gateway %s/%s: %v", gw.Namespace, gw.Name, err)
+		return err
+	}
+
+	return nil
+}
+
+// updateWireGuard creates or updates the WireGuard interface for {gw} with the forwarders as peers,
+// or deletes it if there are no rules for WireGuard. The index of the interface is released
+// once {gw} has no rules for WireGuard, so that it can be allocated for other gateway IPs.
+func (g *Reconciler) updateWireGuard(gw *v1alpha1.Gateway) error {
+	index, err := g.wireGuardIndexFor(gw)
+	if err != nil {
+		return err
+	}
+	cfg, ok, err := getExpectedWireGuard(gw, g.wireGuardPrivateKey, index)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if g.wireGuards[gw.Spec.GatewayIP] {
+			glog.Infof("delete wireguard interface %s", cfg.Name)
+			if err := util.DeleteWireGuard(cfg.Name, index); err != nil {
+				return err
+			}
+			delete(g.wireGuards, gw.Spec.GatewayIP)
+		}
+		if !hasWireGuardRules(gw) {
+			delete(g.wireGuardIndexes, gw.Spec.GatewayIP)
+		}
+		return nil
+	}
+
+	if err := util.EnsureWireGuard(cfg); err != nil {
 		return err
 	}
+	g.wireGuards[gw.Spec.GatewayIP] = true
 
 	return nil
 }
 
+// getExpectedWireGuard returns the WireGuard interface for {gw}, which has forwarders of egress rules
+// for WireGuard as peers. Packets from each forwarder are accepted only from ForwarderIP.
+// It returns false if there are no rules for WireGuard, but the name is still set to delete the interface.
+func getExpectedWireGuard(gw *v1alpha1.Gateway, privateKey string, index int) (util.WireGuardConfig, bool, error) {
+	name, err := util.WireGuardInterfaceName(gw.Spec.GatewayIP)
+	if err != nil {
+		return util.WireGuardConfig{}, false, err
+	}
+	cfg := util.WireGuardConfig{
+		Name:       name,
+		PrivateKey: privateKey,
+		ListenPort: util.WireGuardPort + index,
+		Index:      index,
+		Peers:      []util.WireGuardPeer{},
+	}
+
+	peers := map[string]int{}
+	for _, rule := range gw.Spec.EgressRules {
+		if !isWireGuard(rule) {
+			continue
+		}
+		allowedIP := rule.ForwarderIP + "/32"
+		i, ok := peers[rule.WireGuardPublicKey]
+		if !ok {
+			peers[rule.WireGuardPublicKey] = len(cfg.Peers)
+			cfg.Peers = append(cfg.Peers, util.WireGuardPeer{PublicKey: rule.WireGuardPublicKey, AllowedIPs: []string{allowedIP}})
+			continue
+		}
+		if !containsString(cfg.Peers[i].AllowedIPs, allowedIP) {
+			cfg.Peers[i].AllowedIPs = append(cfg.Peers[i].AllowedIPs, allowedIP)
+		}
+	}
+
+	return cfg, len(cfg.Peers) > 0, nil
+}
+
+// hasWireGuardRules returns true if {gw} has any rules for WireGuard, including ones whose forwarders
+// haven't published keys yet, which need the key and the port of the gateway to be published
+func hasWireGuardRules(gw *v1alpha1.Gateway) bool {
+	for _, rule := range gw.Spec.EgressRules {
+		if rule.Transport == v1alpha1.TransportWireGuard {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isWireGuard returns true if {rule} is routed from the forwarder through WireGuard, and the forwarder
+// has published its public key. Rules whose forwarders haven't published keys are ignored until they do.
+func isWireGuard(rule v1alpha1.GatewayRule) bool {
+	return rule.Transport == v1alpha1.TransportWireGuard && rule.WireGuardPublicKey != ""
+}
+
+// containsString returns true if {list} contains {s}
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (g *Reconciler) ensureSshdRunning(ip string) error {
 	if _, ok := g.ssh[ip]; ok {
 		// Already running, skip creating new server
 		return nil
 	}
 
-	srv := util.NewSSHServer(ip + ":" + util.SSHPort)
+	srv := util.NewSSHServer(ip+":"+util.SSHPort, g.serverOptions(ip))
 	b := backoffv4.WithContext(backoffv4.NewExponentialBackOff(), context.Background())
 	go backoffv4.RetryNotify(
 		func() error {
@@ -104,6 +450,7 @@ func (g *Reconciler) ensureSshdRunning(ip string) error {
 		b,
 		func(err error, tm time.Duration) {
 			glog.Errorf("error in sshd for %q in duration %v: %v", ip, tm, err)
+			g.recordServerError(ip, util.ReasonFailedSshd, "ssh server on %s:%s failed: %v", ip, util.SSHPort, err)
 		},
 	)
 
@@ -112,6 +459,524 @@ func (g *Reconciler) ensureSshdRunning(ip string) error {
 	return nil
 }
 
+// ensureRelayRunning runs mTLS relay server on the GatewayIP and the TLSRelayPort of {gw}, if {gw} has
+// rules for TLS transport. The server is stopped if there are no rules for TLS transport any more,
+// and restarted if the port is changed.
+func (g *Reconciler) ensureRelayRunning(gw *v1alpha1.Gateway) error {
+	ip := gw.Spec.GatewayIP
+	port := util.GetTLSRelayPort(gw)
+	if relay, ok := g.relays[ip]; ok {
+		if hasTLSRules(gw) && relay.port == port {
+			// Already running, skip creating new server
+			return nil
+		}
+		glog.Infof("stop relay server on %s:%s", ip, relay.port)
+		relay.cancel()
+		if err := relay.server.Close(); err != nil {
+			glog.Errorf("failed to close relay server on %s:%s: %v", ip, relay.port, err)
+		}
+		delete(g.relays, ip)
+	}
+	if !hasTLSRules(gw) {
+		// Not needed, skip creating new server
+		return nil
+	}
+
+	srv := util.NewTLSRelayServer(net.JoinHostPort(ip, port), g.tlsConfigLookup(ip), g.serverOptions(ip))
+	ctx, cancel := context.WithCancel(context.Background())
+	b := backoffv4.WithContext(backoffv4.NewExponentialBackOff(), ctx)
+	go backoffv4.RetryNotify(
+		func() error {
+			return srv.ListenAndServe()
+		},
+		b,
+		func(err error, tm time.Duration) {
+			glog.Errorf("error in relay server for %q in duration %v: %v", ip, tm, err)
+			g.recordServerError(ip, util.ReasonFailedRelay, "mTLS relay server on %s:%s failed: %v", ip, port, err)
+		},
+	)
+
+	g.relays[ip] = &relayServer{server: srv, port: port, cancel: cancel}
+
+	return nil
+}
+
+// serverOptions returns options for ssh server and mTLS relay server on {ip},
+// which look up the latest rules for forwarders on {ip}
+func (g *Reconciler) serverOptions(ip string) util.ServerOptions {
+	return util.ServerOptions{
+		IdleTimeout: g.idleTimeout,
+		Lookup:      g.forwardingLookup(ip),
+		Targets:     g.targetLookup(ip),
+		Sessions:    g.reverseSessions(ip),
+	}
+}
+
+// reverseSessions returns sessions of forwarders on {ip}, which are created if not created yet
+func (g *Reconciler) reverseSessions(ip string) *util.ReverseSessions {
+	if _, ok := g.sessions[ip]; !ok {
+		g.sessions[ip] = util.NewReverseSessions()
+	}
+
+	return g.sessions[ip]
+}
+
+// updateListeners starts listeners for ingress in Listener mode of {gw}, and stops the ones that are no longer needed
+func (g *Reconciler) updateListeners(gw *v1alpha1.Gateway) error {
+	ip := gw.Spec.GatewayIP
+	expected := getExpectedListeners(gw)
+
+	g.mutex.Lock()
+	g.listenerRules[ip] = expected
+	g.mutex.Unlock()
+
+	if _, ok := g.listeners[ip]; !ok {
+		g.listeners[ip] = map[string]net.Listener{}
+	}
+	for addr, ln := range g.listeners[ip] {
+		if _, ok := expected[addr]; ok {
+			continue
+		}
+		glog.Infof("stop listener on %q", addr)
+		ln.Close()
+		delete(g.listeners[ip], addr)
+	}
+	for addr := range expected {
+		if _, ok := g.listeners[ip][addr]; ok {
+			// Already listening
+			continue
+		}
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		glog.Infof("start listener on %q", addr)
+		g.listeners[ip][addr] = ln
+		go g.serveListener(ip, ln, g.reverseSessions(ip))
+	}
+
+	return nil
+}
+
+// getExpectedListeners returns ingress rules of {gw} keyed by the addresses to listen on, which are
+// {GatewayIP}:{TargetPort}. It returns no listeners if {gw} isn't in Listener mode.
+func getExpectedListeners(gw *v1alpha1.Gateway) map[string][]v1alpha1.GatewayRule {
+	listeners := map[string][]v1alpha1.GatewayRule{}
+	if gw.Spec.Mode != v1alpha1.GatewayModeListener {
+		return listeners
+	}
+	for _, rule := range gw.Spec.IngressRules {
+		addr := net.JoinHostPort(gw.Spec.GatewayIP, rule.TargetPort)
+		listeners[addr] = append(listeners[addr], rule)
+	}
+
+	return listeners
+}
+
+// serveListener accepts connections on {ln} for {gwIP}, and relays them to forwarders through {sessions}
+func (g *Reconciler) serveListener(gwIP string, ln net.Listener, sessions *util.ReverseSessions) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			glog.Infof("listener on %q closed: %v", ln.Addr(), err)
+			return
+		}
+		go g.relayIngress(gwIP, conn, sessions)
+	}
+}
+
+// relayIngress relays {conn} accepted by a listener on {gwIP} to one of the forwarders of the rules that
+// match the client, through their sessions in {sessions}. {conn} is closed if no rule matches, or no
+// session is available for a while.
+func (g *Reconciler) relayIngress(gwIP string, conn net.Conn, sessions *util.ReverseSessions) {
+	defer conn.Close()
+
+	addrs := g.relayAddrs(gwIP, conn.LocalAddr().String(), conn.RemoteAddr())
+	if len(addrs) == 0 {
+		glog.Warningf("refused connection from %q to %q: no ingress rule for the client", conn.RemoteAddr(), conn.LocalAddr())
+		return
+	}
+	rConn, err := sessions.Open(addrs, conn.RemoteAddr(), listenerOpenTimeout)
+	if err != nil {
+		glog.Errorf("refused connection from %q to %q: %v", conn.RemoteAddr(), conn.LocalAddr(), err)
+		return
+	}
+	defer rConn.Close()
+
+	done := make(chan struct{}, 2)
+	copyConn := func(dst, src net.Conn) {
+		if _, err := io.Copy(dst, src); err != nil {
+			glog.Errorf("copying io failed: %v", err)
+		}
+		done <- struct{}{}
+	}
+	go copyConn(rConn, conn)
+	go copyConn(conn, rConn)
+	// Both connections are closed when either side finishes
+	<-done
+}
+
+// relayAddrs returns the addresses of forwarder sessions, which are {GatewayIP}:{RelayPort}, for the ingress
+// rules of the listener on {addr} of {gwIP} that allow the client of {clientAddr}
+func (g *Reconciler) relayAddrs(gwIP, addr string, clientAddr net.Addr) []string {
+	clientIP, _, err := net.SplitHostPort(clientAddr.String())
+	if err != nil {
+		return nil
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	addrs := []string{}
+	for _, rule := range g.listenerRules[gwIP][addr] {
+		if matchSourceIP(rule.SourceIP, clientIP) {
+			addrs = append(addrs, net.JoinHostPort(gwIP, rule.RelayPort))
+		}
+	}
+
+	return addrs
+}
+
+// matchSourceIP returns true if {clientIP} is {sourceIP}, or is in {sourceIP} if it is a CIDR
+func matchSourceIP(sourceIP, clientIP string) bool {
+	if !strings.Contains(sourceIP, "/") {
+		return sourceIP == clientIP
+	}
+	_, cidr, err := net.ParseCIDR(sourceIP)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(clientIP)
+
+	return ip != nil && cidr.Contains(ip)
+}
+
+// listenerStatuses returns GatewayListenerStatus for each listener on {gwIP} with the number of forwarder
+// sessions available for it
+func (g *Reconciler) listenerStatuses(gwIP string) []v1alpha1.GatewayListenerStatus {
+	sessions := g.reverseSessions(gwIP)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	listeners := []v1alpha1.GatewayListenerStatus{}
+	for addr, rules := range g.listenerRules[gwIP] {
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		relayAddrs := map[string]bool{}
+		for _, rule := range rules {
+			relayAddrs[net.JoinHostPort(gwIP, rule.RelayPort)] = true
+		}
+		forwarders := 0
+		for relayAddr := range relayAddrs {
+			forwarders += sessions.Count(relayAddr)
+		}
+		listeners = append(listeners, v1alpha1.GatewayListenerStatus{Port: port, Forwarders: forwarders})
+	}
+	sort.Slice(listeners, func(i, j int) bool { return listeners[i].Port < listeners[j].Port })
+
+	return listeners
+}
+
+// hasTLSRules returns true if {gw} has any rules for TLS transport
+func hasTLSRules(gw *v1alpha1.Gateway) bool {
+	for _, rules := range [][]v1alpha1.GatewayRule{gw.Spec.EgressRules, gw.Spec.IngressRules} {
+		for _, rule := range rules {
+			if rule.Transport == v1alpha1.TransportTLS {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// updateTLSConfig loads tls.Config for the mTLS relay server of {gw} from the secret of its certificate,
+// if {gw} has rules for TLS transport
+func (g *Reconciler) updateTLSConfig(gw *v1alpha1.Gateway) error {
+	if !hasTLSRules(gw) {
+		return nil
+	}
+
+	name := util.TLSSecretName(gw.Name)
+	secret, err := g.secrets.Secrets(g.namespace).Get(name)
+	if err != nil {
+		return fmt.Errorf("failed to get certificate for TLS transport: %v", err)
+	}
+	config, err := util.NewTLSConfig(secret.Data[util.TLSCertKey], secret.Data[util.TLSPrivateKeyKey], secret.Data[util.TLSCAKey])
+	if err != nil {
+		return fmt.Errorf("invalid certificate in secret %s/%s: %v", g.namespace, name, err)
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.tlsConfigs[gw.Spec.GatewayIP] = config
+
+	return nil
+}
+
+// tlsConfigLookup returns TLSConfigLoader for the mTLS relay server on {gwIP},
+// which returns tls.Config loaded by the latest reconcile
+func (g *Reconciler) tlsConfigLookup(gwIP string) util.TLSConfigLoader {
+	return func() (*tls.Config, error) {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+
+		config, ok := g.tlsConfigs[gwIP]
+		if !ok {
+			return nil, fmt.Errorf("no certificate loaded for %s", gwIP)
+		}
+		return config, nil
+	}
+}
+
+// recordServerError records an event with {reason} for an error of a server on {ip}.
+// Servers run in background, so the external services cached when the rules are synced are used.
+func (g *Reconciler) recordServerError(ip, reason, messageFmt string, args ...interface{}) {
+	g.mutex.Lock()
+	refs := g.externalServices[ip]
+	g.mutex.Unlock()
+
+	for _, ref := range refs {
+		g.recorder.Eventf(ref, corev1.EventTypeWarning, reason, messageFmt, args...)
+	}
+}
+
+// updateExternalServiceRefs caches references to the external services that have rules in {gw},
+// which errors of servers on the GatewayIP of {gw} are recorded on
+func (g *Reconciler) updateExternalServiceRefs(gw *v1alpha1.Gateway) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.externalServices[gw.Spec.GatewayIP] = externalServiceRefs(gw)
+}
+
+// eventf records an event on all the external services that have rules in {gw}
+func (g *Reconciler) eventf(gw *v1alpha1.Gateway, eventtype, reason, messageFmt string, args ...interface{}) {
+	for _, ref := range externalServiceRefs(gw) {
+		g.recorder.Eventf(ref, eventtype, reason, messageFmt, args...)
+	}
+}
+
+// externalServiceRefs returns references to the external services that have rules in {gw},
+// which are passed in the rules by the operator
+func externalServiceRefs(gw *v1alpha1.Gateway) []*corev1.ObjectReference {
+	refs := []*corev1.ObjectReference{}
+	seen := map[v1alpha1.ExternalServiceRef]bool{}
+	for _, rule := range append(append([]v1alpha1.GatewayRule{}, gw.Spec.EgressRules...), gw.Spec.IngressRules...) {
+		es := rule.ExternalService
+		if seen[es] || es.Name == "" {
+			continue
+		}
+		seen[es] = true
+		refs = append(refs, util.ExternalServiceObjectRef(es.Namespace, es.Name, es.UID))
+	}
+
+	return refs
+}
+
+// updateLimiters updates limiters for forwarders with the limits in {gw}
+func (g *Reconciler) updateLimiters(gw *v1alpha1.Gateway) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if _, ok := g.limiters[gw.Spec.GatewayIP]; !ok {
+		g.limiters[gw.Spec.GatewayIP] = map[string]*util.Limiter{}
+	}
+	util.SyncLimiters(g.limiters[gw.Spec.GatewayIP], getExpectedLimits(gw))
+	old := g.forwarders[gw.Spec.GatewayIP]
+	g.forwarders[gw.Spec.GatewayIP] = getForwarders(gw)
+	deleteUnusedRelayMetrics(gw.Spec.GatewayIP, old, g.forwarders[gw.Spec.GatewayIP])
+	for id, limiter := range g.limiters[gw.Spec.GatewayIP] {
+		limiter.SetMetricsLabels(g.forwarders[gw.Spec.GatewayIP][id].externalService, gw.Spec.GatewayIP)
+	}
+	// Publish counters for the new set of limiters on next status update
+	delete(g.limitStatusTimes, gw.Name)
+}
+
+// deleteUnusedRelayMetrics deletes metrics of relays on {gwIP} for the external services of {old} forwarders
+// that none of {current} forwarders are for, so that they won't remain after the rules are deleted
+func deleteUnusedRelayMetrics(gwIP string, old, current map[string]forwarderInfo) {
+	used := map[string]bool{}
+	for _, info := range current {
+		used[info.externalService] = true
+	}
+	for _, info := range old {
+		if !used[info.externalService] {
+			util.DeleteRelayMetrics(util.TunnelLabels{ExternalService: info.externalService, Direction: util.DirectionEgress, Gateway: gwIP})
+		}
+	}
+}
+
+// forwardingLookup returns ForwardingLookup for ssh server on {gwIP}.
+// Clients of the ssh server are forwarders, which are identified by the IDs that they send,
+// because their addresses can be translated on the way, for example, by masquerade of the cluster.
+// Source IPs and ports are not labeled, because connections from all sources come from the forwarder.
+func (g *Reconciler) forwardingLookup(gwIP string) util.ForwardingLookup {
+	return func(client string) (*util.Limiter, util.TunnelLabels) {
+		labels := util.TunnelLabels{Direction: util.DirectionEgress, Gateway: gwIP}
+
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+
+		labels.ExternalService = g.forwarders[gwIP][client].externalService
+
+		return g.limiters[gwIP][client], labels
+	}
+}
+
+// updateTargetGroups updates target groups for the destinations that have multiple targets in {gw}
+func (g *Reconciler) updateTargetGroups(gw *v1alpha1.Gateway) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if _, ok := g.targetGroups[gw.Spec.GatewayIP]; !ok {
+		g.targetGroups[gw.Spec.GatewayIP] = map[string]*util.TargetGroup{}
+	}
+	util.SyncTargetGroups(g.targetGroups[gw.Spec.GatewayIP], getExpectedTargetGroups(gw))
+}
+
+// targetLookup returns TargetLookup for ssh server on {gwIP}.
+// Destinations of forwarders are identified by the IDs of the forwarders, because the same destination
+// can have different targets for different external services.
+func (g *Reconciler) targetLookup(gwIP string) util.TargetLookup {
+	return func(client, dest string) *util.TargetGroup {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+
+		if group, ok := g.targetGroups[gwIP][targetGroupKey(client, dest)]; ok {
+			return group
+		}
+
+		return lookupPortRange(g.targetGroups[gwIP], client, dest)
+	}
+}
+
+// targetGroupKey returns a key of target group for {dest} in host:port format of forwarder with {id}.
+// Port of {dest} is a port range like "30000-30100" for the target group of a port range.
+func targetGroupKey(id, dest string) string {
+	return id + "/" + dest
+}
+
+// lookupPortRange returns the target group in {groups} for a port range that includes {dest}
+// of forwarder with {id}, or nil if not found
+func lookupPortRange(groups map[string]*util.TargetGroup, id, dest string) *util.TargetGroup {
+	host, port, err := net.SplitHostPort(dest)
+	if err != nil {
+		return nil
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return nil
+	}
+
+	prefix := targetGroupKey(id, "")
+	for key, group := range groups {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		h, portRange, err := net.SplitHostPort(strings.TrimPrefix(key, prefix))
+		if err != nil || h != host {
+			continue
+		}
+		start, end, err := util.ParsePortRange(portRange)
+		if err != nil {
+			continue
+		}
+		if start <= portNum && portNum <= end {
+			return group
+		}
+	}
+
+	return nil
+}
+
+// destinationPortRange returns the range of destination ports of {rule} like "30000-30100",
+// or DestinationPort if {rule} isn't for a port range
+func destinationPortRange(rule v1alpha1.GatewayRule) string {
+	if rule.TargetEndPort == "" {
+		return rule.DestinationPort
+	}
+	tStart, tEnd, err := util.ParsePortRange(util.FormatPortRange(rule.TargetPort, rule.TargetEndPort))
+	if err != nil {
+		return rule.DestinationPort
+	}
+	dStart, err := strconv.Atoi(rule.DestinationPort)
+	if err != nil {
+		return rule.DestinationPort
+	}
+
+	return util.FormatPortRange(rule.DestinationPort, strconv.Itoa(dStart+tEnd-tStart))
+}
+
+// getExpectedTargetGroups returns specs of target groups for egress rules that have multiple destinations.
+// Health checks are done from GatewayIP, which is the source IP for the targets.
+// A target group for a port range is shared by all the ports, and checks health by the first port.
+func getExpectedTargetGroups(gw *v1alpha1.Gateway) map[string]util.TargetGroupSpec {
+	specs := map[string]util.TargetGroupSpec{}
+	for _, rule := range gw.Spec.EgressRules {
+		// Rules for WireGuard are routed to DestinationIP in kernel, so targets are never selected
+		if len(rule.DestinationIPs) < 2 || rule.Transport == v1alpha1.TransportWireGuard {
+			continue
+		}
+		spec := util.TargetGroupSpec{
+			Targets:  rule.DestinationIPs,
+			Policy:   rule.TargetPolicy,
+			SourceIP: gw.Spec.GatewayIP,
+			Port:     rule.DestinationPort,
+		}
+		if rule.HealthCheck != nil {
+			spec.HealthCheckInterval = defaultHealthCheckPeriod
+			if rule.HealthCheck.PeriodSeconds > 0 {
+				spec.HealthCheckInterval = time.Duration(rule.HealthCheck.PeriodSeconds) * time.Second
+			}
+			spec.HealthCheckTimeout = defaultHealthCheckTimeout
+			if rule.HealthCheck.TimeoutSeconds > 0 {
+				spec.HealthCheckTimeout = time.Duration(rule.HealthCheck.TimeoutSeconds) * time.Second
+			}
+		}
+		specs[targetGroupKey(forwarderID(rule.Forwarder), net.JoinHostPort(rule.DestinationIP, destinationPortRange(rule)))] = spec
+	}
+
+	return specs
+}
+
+// getExpectedLimits returns limits for each forwarder keyed by its ID.
+// Only egress rules are limited in gateway, because connections for ingress rules
+// are limited in forwarder. Rules for WireGuard aren't limited.
+func getExpectedLimits(gw *v1alpha1.Gateway) map[string]util.Limits {
+	limits := map[string]util.Limits{}
+	for _, rule := range gw.Spec.EgressRules {
+		if rule.Transport == v1alpha1.TransportWireGuard {
+			continue
+		}
+		limits[forwarderID(rule.Forwarder)] = util.ToLimits(rule.Limits)
+	}
+
+	return limits
+}
+
+// getForwarders returns forwarders of egress rules keyed by their IDs
+func getForwarders(gw *v1alpha1.Gateway) map[string]forwarderInfo {
+	forwarders := map[string]forwarderInfo{}
+	for _, rule := range gw.Spec.EgressRules {
+		forwarders[forwarderID(rule.Forwarder)] = forwarderInfo{
+			ref:             rule.Forwarder,
+			ip:              rule.ForwarderIP,
+			externalService: util.ExternalServiceLabel(rule.ExternalService.Namespace, rule.ExternalService.Name),
+		}
+	}
+
+	return forwarders
+}
+
+// forwarderID returns the ID that the forwarder of {ref} sends to identify itself
+func forwarderID(ref v1alpha1.ForwarderRef) string {
+	return util.ForwarderID(ref.Namespace, ref.Name)
+}
+
 // TODO: check that this works well
 func (g *Reconciler) stopSshd(ip string) error {
 	srv, ok := g.ssh[ip]
@@ -128,7 +993,7 @@ func (g *Reconciler) stopSshd(ip string) error {
 	return nil
 }
 
-func getExpectedIptablesRule(gw *v1alpha1.Gateway) (map[string][][]string, map[string][][]string, error) {
+func getExpectedIptablesRule(gw *v1alpha1.Gateway, wgIndex int) (map[string][][]string, map[string][][]string, error) {
 	hexIP, err := util.GetHexIP(gw.Spec.GatewayIP)
 	if err != nil {
 		return nil, nil, err
@@ -154,17 +1019,125 @@ func getExpectedIptablesRule(gw *v1alpha1.Gateway) (map[string][][]string, map[s
 	//     -m tcp -p tcp --dst 192.168.122.200 --src 192.168.122.140 --dport 80 -j DNAT --to-destination 192.168.122.200:2049
 	//   POSTROUTING:
 	//     -m tcp -p tcp --dst 192.168.122.140 --dport 2049 -j SNAT --to-source 192.168.122.200
+	// {SourceIP} can also be a CIDR of allowed clients, and {TargetPort} is the external port published, like below:
+	//     -m tcp -p tcp --dst 192.168.122.200 --src 203.0.113.0/24 --dport 443 -j DNAT --to-destination 192.168.122.200:2050
+	// If there are multiple forwarder pods for the same {SourceIP} and {TargetPort},
+	// connections are spread across them by statistic module, like below:
+	//     ... --dport 80 -m statistic --mode random --probability 0.50000 -j DNAT --to-destination 192.168.122.200:2049
+	//     ... --dport 80 -j DNAT --to-destination 192.168.122.200:2050
+	// Ingress rules in Direct mode have no {RelayPort}, and they are DNATed to {DestinationIP}:{DestinationPort}
+	// in the cluster directly, which are spread across pods in the same way, like below:
+	//   PREROUTING:
+	//     -m tcp -p tcp --dst 192.168.122.200 --src 192.168.122.140 --dport 80 -j DNAT --to-destination 10.244.0.20:8080
+	//   POSTROUTING:
+	//     -m tcp -p tcp --dst 10.244.0.20 --dport 8080 -j SNAT --to-source 192.168.122.200
+	// Ingress rules in Listener mode have no iptables rules, because they are accepted by listeners on
+	// {GatewayIP}:{TargetPort} in userspace and relayed to forwarders directly.
 	// TODO: Also handle UDP properly
-	for _, rule := range gw.Spec.IngressRules {
-		chains[preChain] = append(chains[preChain], util.DNATRuleSpec(gw.Spec.GatewayIP, rule.SourceIP, rule.TargetPort, gw.Spec.GatewayIP, rule.RelayPort))
-		chains[postChain] = append(chains[postChain], util.SNATRuleSpec(rule.DestinationIP, gw.Spec.GatewayIP, rule.RelayPort))
+	ingressRules := gw.Spec.IngressRules
+	if gw.Spec.Mode == v1alpha1.GatewayModeListener {
+		ingressRules = nil
+	}
+	remaining := map[string]int{}
+	for _, rule := range ingressRules {
+		remaining[rule.SourceIP+":"+rule.TargetPort]++
+	}
+	for _, rule := range ingressRules {
+		key := rule.SourceIP + ":" + rule.TargetPort
+		toIP, toPort := gw.Spec.GatewayIP, rule.RelayPort
+		if rule.RelayPort == "" {
+			toIP, toPort = rule.DestinationIP, rule.DestinationPort
+		}
+		if remaining[key] > 1 {
+			chains[preChain] = append(chains[preChain], util.DNATRuleSpecWithProbability(gw.Spec.GatewayIP, rule.SourceIP, rule.TargetPort, toIP, toPort, 1/float64(remaining[key])))
+		} else {
+			chains[preChain] = append(chains[preChain], util.DNATRuleSpec(gw.Spec.GatewayIP, rule.SourceIP, rule.TargetPort, toIP, toPort))
+		}
+		remaining[key]--
+		chains[postChain] = append(chains[postChain], util.SNATRuleSpec(rule.DestinationIP, gw.Spec.GatewayIP, toPort))
+	}
+	// Format gw.Spec.EgressRules for WireGuard to
+	//   POSTROUTING:
+	//     -m connmark --mark {Mark} --src {ForwarderIP} -m tcp -p tcp --dst {DestinationIP} --dport {DestinationPort} -j SNAT --to-source {GatewayIP}
+	// ex)
+	//   POSTROUTING:
+	//     -m connmark --mark 0x1000000/0xff000000 --src 10.244.0.157 -m tcp -p tcp --dst 192.168.122.139 --dport 8001 -j SNAT --to-source 192.168.122.200
+	// Connections from the WireGuard interface for {GatewayIP} are marked in mangle table, so that only they are SNATed
+	// to {GatewayIP}, even if the same forwarder accesses the same destination via other gateway IPs.
+	// Port ranges match all the ports of the destination by {DestinationPort}:{DestinationEndPort}.
+	mark := util.WireGuardMark(wgIndex)
+	for _, rule := range gw.Spec.EgressRules {
+		if !isWireGuard(rule) {
+			continue
+		}
+		dPort := strings.Replace(destinationPortRange(rule), "-", ":", 1)
+		spec := append([]string{"-m", "connmark", "--mark", mark, "--src", rule.ForwarderIP}, util.SNATRuleSpec(rule.DestinationIP, gw.Spec.GatewayIP, dPort)...)
+		chains[postChain] = append(chains[postChain], spec)
+	}
+
+	return jumpChains, chains, nil
+}
+
+// getExpectedMangleRule returns rules in mangle table to route replies for WireGuard back to the WireGuard interface
+// for {GatewayIP}. Connections from the interface are marked, and replies for them are marked to be routed to it
+// by policy routing. The chain has the same name as the one in nat table, and it is empty if there are no rules
+// for WireGuard.
+func getExpectedMangleRule(gw *v1alpha1.Gateway, wgIndex int) (map[string][][]string, map[string][][]string, error) {
+	hexIP, err := util.GetHexIP(gw.Spec.GatewayIP)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	preChain := prechainPrefix + hexIP
+	jumpChains := map[string][][]string{
+		util.ChainPrerouting: [][]string{[]string{"-j", preChain}},
+	}
+	chains := map[string][][]string{
+		preChain: [][]string{},
+	}
+	cfg, ok, err := getExpectedWireGuard(gw, "" /* privateKey */, wgIndex)
+	if err != nil || !ok {
+		return jumpChains, chains, err
 	}
 
+	// Format rules for WireGuard to
+	//   pre{hexIP}:
+	//     -i {Interface} -j CONNMARK --set-xmark {Mark}
+	//     ! -i {Interface} -m connmark --mark {Mark} -j MARK --set-xmark {Mark}
+	// ex)
+	//   pre{hexIP}:
+	//     -i wgc0a87ac8 -j CONNMARK --set-xmark 0x1000000/0xff000000
+	//     ! -i wgc0a87ac8 -m connmark --mark 0x1000000/0xff000000 -j MARK --set-xmark 0x1000000/0xff000000
+	iface := cfg.Name
+	mark := util.WireGuardMark(wgIndex)
+	chains[preChain] = append(chains[preChain],
+		[]string{"-i", iface, "-j", "CONNMARK", "--set-xmark", mark},
+		[]string{"!", "-i", iface, "-m", "connmark", "--mark", mark, "-j", "MARK", "--set-xmark", mark},
+	)
+
 	return jumpChains, chains, nil
 }
 
 func (g *Reconciler) applyIptablesRules(gw *v1alpha1.Gateway) error {
-	jumpChains, chains, err := getExpectedIptablesRule(gw)
+	index, err := g.wireGuardIndexFor(gw)
+	if err != nil {
+		return err
+	}
+
+	mangleJumpChains, mangleChains, err := getExpectedMangleRule(gw, index)
+	if err != nil {
+		return err
+	}
+
+	if err := util.ReplaceChains(util.TableMangle, mangleChains); err != nil {
+		return err
+	}
+
+	if err := util.AddChains(util.TableMangle, mangleJumpChains); err != nil {
+		return err
+	}
+
+	jumpChains, chains, err := getExpectedIptablesRule(gw, index)
 	if err != nil {
 		return err
 	}
@@ -181,7 +1154,48 @@ func (g *Reconciler) applyIptablesRules(gw *v1alpha1.Gateway) error {
 }
 
 func (g *Reconciler) ruleSynced(gw *v1alpha1.Gateway) bool {
-	return g.checkSshdRunning(gw.Spec.GatewayIP) && g.checkIptablesRulesApplied(gw)
+	return g.checkSshdRunning(gw.Spec.GatewayIP) && g.checkRelayRunning(gw) && g.checkListenersRunning(gw) &&
+		g.checkIptablesRulesApplied(gw) && g.checkWireGuardConfigured(gw)
+}
+
+// checkListenersRunning checks that the ports of listeners for ingress are open, if {gw} is in Listener mode
+func (g *Reconciler) checkListenersRunning(gw *v1alpha1.Gateway) bool {
+	for addr := range getExpectedListeners(gw) {
+		ip, port, err := net.SplitHostPort(addr)
+		if err != nil || !util.IsPortOpen(ip, port) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkRelayRunning checks that the port of mTLS relay server is open on the GatewayIP of {gw},
+// if there are any rules for TLS transport
+func (g *Reconciler) checkRelayRunning(gw *v1alpha1.Gateway) bool {
+	if !hasTLSRules(gw) {
+		return true
+	}
+
+	return util.IsPortOpen(gw.Spec.GatewayIP, util.GetTLSRelayPort(gw))
+}
+
+// checkWireGuardConfigured checks that the WireGuard interface for {gw} exists with the forwarders as peers,
+// if there are any rules for WireGuard
+func (g *Reconciler) checkWireGuardConfigured(gw *v1alpha1.Gateway) bool {
+	index, err := g.wireGuardIndexFor(gw)
+	if err != nil {
+		return false
+	}
+	cfg, ok, err := getExpectedWireGuard(gw, g.wireGuardPrivateKey, index)
+	if err != nil {
+		return false
+	}
+	if !ok {
+		return true
+	}
+
+	return util.CheckWireGuard(cfg)
 }
 
 func (g *Reconciler) checkSshdRunning(ip string) bool {
@@ -191,7 +1205,21 @@ func (g *Reconciler) checkSshdRunning(ip string) bool {
 }
 
 func (g *Reconciler) checkIptablesRulesApplied(gw *v1alpha1.Gateway) bool {
-	jumpChains, chains, err := getExpectedIptablesRule(gw)
+	index, err := g.wireGuardIndexFor(gw)
+	if err != nil {
+		return false
+	}
+	mangleJumpChains, mangleChains, err := getExpectedMangleRule(gw, index)
+	if err != nil {
+		return false
+	}
+	if !util.CheckChainsExist(util.TableMangle, mangleChains) {
+		return false
+	}
+	if !util.CheckChainsExist(util.TableMangle, mangleJumpChains) {
+		return false
+	}
+	jumpChains, chains, err := getExpectedIptablesRule(gw, index)
 	if err != nil {
 		return false
 	}
@@ -1,13 +1,26 @@
 package gateway
 
 import (
+	"crypto/x509"
+	"math/rand"
+	"net"
 	"reflect"
+	"sort"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
 	fakeversioned "github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned/fake"
 	fakev1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned/typed/submariner/v1alpha1/fake"
+	"github.com/mkimuram/k8s-ext-connector/pkg/util"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 )
 
 func TestEnsureSshdRunning(t *testing.T) {
@@ -29,7 +42,7 @@ func TestEnsureSshdRunning(t *testing.T) {
 		t.Logf("test case: %s", tc.name)
 		vcl := fakeversioned.NewSimpleClientset()
 		cl := &fakev1alpha1.FakeSubmarinerV1alpha1{Fake: &vcl.Fake}
-		g := NewReconciler(cl, "ns1")
+		g := NewReconciler(cl, nil, "ns1", 0, record.NewFakeRecorder(10))
 
 		// use func here to defer cancel sshd before waiting for stop
 		func() {
@@ -111,6 +124,177 @@ func TestGetExpectedIptablesRule(t *testing.T) {
 			},
 			expectErr: false,
 		},
+		{
+			name: "Normal case (ingress rule for allowed CIDR with external port)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					IngressRules: []v1alpha1.GatewayRule{
+						{
+							Protocol:        "TCP",
+							SourceIP:        "203.0.113.0/24",
+							TargetPort:      "443",
+							DestinationPort: "8443",
+							DestinationIP:   "10.104.205.241",
+							Forwarder: v1alpha1.ForwarderRef{
+								Namespace: "fwd1",
+								Name:      "ns1",
+							},
+							ForwarderIP: "10.244.0.157",
+							RelayPort:   "2049",
+						},
+					},
+					GatewayIP: "192.168.122.201",
+				},
+			},
+			expectedJumpChains: map[string][][]string{
+				"PREROUTING":  [][]string{{"-j", "prec0a87ac9"}},
+				"POSTROUTING": [][]string{{"-j", "pstc0a87ac9"}},
+			},
+			expectedChains: map[string][][]string{
+				"prec0a87ac9": [][]string{{"-m", "tcp", "-p", "tcp", "--dst", "192.168.122.201", "--src", "203.0.113.0/24", "--dport", "443", "-j", "DNAT", "--to-destination", "192.168.122.201:2049"}},
+				"pstc0a87ac9": [][]string{{"-m", "tcp", "-p", "tcp", "--dst", "10.104.205.241", "--dport", "2049", "-j", "SNAT", "--to-source", "192.168.122.201"}},
+			},
+			expectErr: false,
+		},
+		{
+			name: "Normal case (ingress rules in Direct mode for multiple pods)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					IngressRules: []v1alpha1.GatewayRule{
+						{
+							Protocol:        "TCP",
+							SourceIP:        "192.168.122.139",
+							TargetPort:      "80",
+							DestinationPort: "8080",
+							DestinationIP:   "10.244.0.20",
+						},
+						{
+							Protocol:        "TCP",
+							SourceIP:        "192.168.122.139",
+							TargetPort:      "80",
+							DestinationPort: "8080",
+							DestinationIP:   "10.244.1.21",
+						},
+					},
+					GatewayIP: "192.168.122.201",
+					Mode:      v1alpha1.GatewayModeDirect,
+				},
+			},
+			expectedJumpChains: map[string][][]string{
+				"PREROUTING":  [][]string{{"-j", "prec0a87ac9"}},
+				"POSTROUTING": [][]string{{"-j", "pstc0a87ac9"}},
+			},
+			expectedChains: map[string][][]string{
+				"prec0a87ac9": [][]string{
+					{"-m", "tcp", "-p", "tcp", "--dst", "192.168.122.201", "--src", "192.168.122.139", "--dport", "80", "-m", "statistic", "--mode", "random", "--probability", "0.50000", "-j", "DNAT", "--to-destination", "10.244.0.20:8080"},
+					{"-m", "tcp", "-p", "tcp", "--dst", "192.168.122.201", "--src", "192.168.122.139", "--dport", "80", "-j", "DNAT", "--to-destination", "10.244.1.21:8080"},
+				},
+				"pstc0a87ac9": [][]string{
+					{"-m", "tcp", "-p", "tcp", "--dst", "10.244.0.20", "--dport", "8080", "-j", "SNAT", "--to-source", "192.168.122.201"},
+					{"-m", "tcp", "-p", "tcp", "--dst", "10.244.1.21", "--dport", "8080", "-j", "SNAT", "--to-source", "192.168.122.201"},
+				},
+			},
+			expectErr: false,
+		},
+		{
+			name: "Normal case (ingress rules in Listener mode have no rules)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					IngressRules: []v1alpha1.GatewayRule{
+						{
+							Protocol:        "TCP",
+							SourceIP:        "192.168.122.139",
+							TargetPort:      "80",
+							DestinationPort: "80",
+							DestinationIP:   "10.104.205.241",
+							Forwarder: v1alpha1.ForwarderRef{
+								Namespace: "external-services",
+								Name:      "fwd1",
+							},
+							ForwarderIP: "10.244.0.157",
+							RelayPort:   "2049",
+						},
+					},
+					GatewayIP: "192.168.122.201",
+					Mode:      v1alpha1.GatewayModeListener,
+				},
+			},
+			expectedJumpChains: map[string][][]string{
+				"PREROUTING":  [][]string{{"-j", "prec0a87ac9"}},
+				"POSTROUTING": [][]string{{"-j", "pstc0a87ac9"}},
+			},
+			expectedChains: map[string][][]string{
+				"prec0a87ac9": [][]string{},
+				"pstc0a87ac9": [][]string{},
+			},
+			expectErr: false,
+		},
+		{
+			name: "Normal case (ingress rules for multiple forwarder pods)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					IngressRules: []v1alpha1.GatewayRule{
+						{
+							Protocol:        "TCP",
+							SourceIP:        "192.168.122.139",
+							TargetPort:      "80",
+							DestinationPort: "80",
+							DestinationIP:   "10.104.205.241",
+							Forwarder: v1alpha1.ForwarderRef{
+								Namespace: "external-services",
+								Name:      "fwd1-a",
+							},
+							ForwarderIP: "10.244.0.157",
+							RelayPort:   "2049",
+						},
+						{
+							Protocol:        "TCP",
+							SourceIP:        "192.168.122.139",
+							TargetPort:      "80",
+							DestinationPort: "80",
+							DestinationIP:   "10.104.205.241",
+							Forwarder: v1alpha1.ForwarderRef{
+								Namespace: "external-services",
+								Name:      "fwd1-b",
+							},
+							ForwarderIP: "10.244.0.158",
+							RelayPort:   "2050",
+						},
+						{
+							Protocol:        "TCP",
+							SourceIP:        "192.168.122.139",
+							TargetPort:      "80",
+							DestinationPort: "80",
+							DestinationIP:   "10.104.205.241",
+							Forwarder: v1alpha1.ForwarderRef{
+								Namespace: "external-services",
+								Name:      "fwd1-c",
+							},
+							ForwarderIP: "10.244.0.159",
+							RelayPort:   "2051",
+						},
+					},
+					GatewayIP: "192.168.122.201",
+				},
+			},
+			expectedJumpChains: map[string][][]string{
+				"PREROUTING":  [][]string{{"-j", "prec0a87ac9"}},
+				"POSTROUTING": [][]string{{"-j", "pstc0a87ac9"}},
+			},
+			expectedChains: map[string][][]string{
+				"prec0a87ac9": [][]string{
+					{"-m", "tcp", "-p", "tcp", "--dst", "192.168.122.201", "--src", "192.168.122.139", "--dport", "80", "-m", "statistic", "--mode", "random", "--probability", "0.33333", "-j", "DNAT", "--to-destination", "192.168.122.201:2049"},
+					{"-m", "tcp", "-p", "tcp", "--dst", "192.168.122.201", "--src", "192.168.122.139", "--dport", "80", "-m", "statistic", "--mode", "random", "--probability", "0.50000", "-j", "DNAT", "--to-destination", "192.168.122.201:2050"},
+					{"-m", "tcp", "-p", "tcp", "--dst", "192.168.122.201", "--src", "192.168.122.139", "--dport", "80", "-j", "DNAT", "--to-destination", "192.168.122.201:2051"},
+				},
+				"pstc0a87ac9": [][]string{
+					{"-m", "tcp", "-p", "tcp", "--dst", "10.104.205.241", "--dport", "2049", "-j", "SNAT", "--to-source", "192.168.122.201"},
+					{"-m", "tcp", "-p", "tcp", "--dst", "10.104.205.241", "--dport", "2050", "-j", "SNAT", "--to-source", "192.168.122.201"},
+					{"-m", "tcp", "-p", "tcp", "--dst", "10.104.205.241", "--dport", "2051", "-j", "SNAT", "--to-source", "192.168.122.201"},
+				},
+			},
+			expectErr: false,
+		},
 		{
 			name: "Error case (invalid gateway IP)",
 			gw: &v1alpha1.Gateway{
@@ -158,7 +342,7 @@ func TestGetExpectedIptablesRule(t *testing.T) {
 	for _, tc := range testCases {
 		t.Logf("test case: %s", tc.name)
 
-		jumpChains, chains, err := getExpectedIptablesRule(tc.gw)
+		jumpChains, chains, err := getExpectedIptablesRule(tc.gw, 0)
 		if tc.expectErr {
 			if err == nil {
 				t.Errorf("expected error, but got no error")
@@ -173,3 +357,1092 @@ func TestGetExpectedIptablesRule(t *testing.T) {
 		}
 	}
 }
+
+// gwWithWireGuard has egress rules for ssh and WireGuard from two forwarders,
+// and one for WireGuard whose forwarder has no public key yet
+var gwWithWireGuard = &v1alpha1.Gateway{
+	Spec: v1alpha1.GatewaySpec{
+		EgressRules: []v1alpha1.GatewayRule{
+			{
+				Protocol:        "TCP",
+				SourceIP:        "10.244.0.12",
+				TargetPort:      "8000",
+				DestinationPort: "8001",
+				DestinationIP:   "192.168.122.139",
+				ForwarderIP:     "10.244.0.157",
+				RelayPort:       "2050",
+			},
+			{
+				Protocol:           "TCP",
+				SourceIP:           "10.244.0.12",
+				TargetPort:         "8000",
+				DestinationPort:    "8001",
+				DestinationIP:      "192.168.122.139",
+				ForwarderIP:        "10.244.0.158",
+				Transport:          v1alpha1.TransportWireGuard,
+				WireGuardPublicKey: "fwdkey1",
+			},
+			{
+				Protocol:           "TCP",
+				SourceIP:           "10.244.0.12",
+				TargetPort:         "30000",
+				TargetEndPort:      "30100",
+				DestinationPort:    "30000",
+				DestinationIP:      "192.168.122.140",
+				ForwarderIP:        "10.244.0.158",
+				Transport:          v1alpha1.TransportWireGuard,
+				WireGuardPublicKey: "fwdkey1",
+			},
+			{
+				Protocol:           "TCP",
+				SourceIP:           "10.244.0.13",
+				TargetPort:         "8000",
+				DestinationPort:    "8001",
+				DestinationIP:      "192.168.122.139",
+				ForwarderIP:        "10.244.0.159",
+				Transport:          v1alpha1.TransportWireGuard,
+				WireGuardPublicKey: "fwdkey2",
+			},
+			{
+				Protocol:        "TCP",
+				SourceIP:        "10.244.0.14",
+				TargetPort:      "8000",
+				DestinationPort: "8001",
+				DestinationIP:   "192.168.122.139",
+				ForwarderIP:     "10.244.0.160",
+				Transport:       v1alpha1.TransportWireGuard,
+			},
+		},
+		IngressRules: []v1alpha1.GatewayRule{},
+		GatewayIP:    "192.168.122.200",
+	},
+}
+
+func TestGetExpectedIptablesRuleForWireGuard(t *testing.T) {
+	jumpChains, chains, err := getExpectedIptablesRule(gwWithWireGuard, 1)
+	if err != nil {
+		t.Fatalf("expected no error, but got %v", err)
+	}
+
+	expectedJumpChains := map[string][][]string{
+		"PREROUTING":  [][]string{{"-j", "prec0a87ac8"}},
+		"POSTROUTING": [][]string{{"-j", "pstc0a87ac8"}},
+	}
+	expectedChains := map[string][][]string{
+		"prec0a87ac8": [][]string{},
+		"pstc0a87ac8": [][]string{
+			{"-m", "connmark", "--mark", "0x2000000/0xff000000", "--src", "10.244.0.158", "-m", "tcp", "-p", "tcp", "--dst", "192.168.122.139", "--dport", "8001", "-j", "SNAT", "--to-source", "192.168.122.200"},
+			{"-m", "connmark", "--mark", "0x2000000/0xff000000", "--src", "10.244.0.158", "-m", "tcp", "-p", "tcp", "--dst", "192.168.122.140", "--dport", "30000:30100", "-j", "SNAT", "--to-source", "192.168.122.200"},
+			{"-m", "connmark", "--mark", "0x2000000/0xff000000", "--src", "10.244.0.159", "-m", "tcp", "-p", "tcp", "--dst", "192.168.122.139", "--dport", "8001", "-j", "SNAT", "--to-source", "192.168.122.200"},
+		},
+	}
+	if !reflect.DeepEqual(expectedJumpChains, jumpChains) {
+		t.Errorf("expected %v, but got %v", expectedJumpChains, jumpChains)
+	}
+	if !reflect.DeepEqual(expectedChains, chains) {
+		t.Errorf("expected %v, but got %v", expectedChains, chains)
+	}
+}
+
+func TestGetExpectedMangleRule(t *testing.T) {
+	testCases := []struct {
+		name               string
+		gw                 *v1alpha1.Gateway
+		expectedJumpChains map[string][][]string
+		expectedChains     map[string][][]string
+		expectErr          bool
+	}{
+		{
+			name: "Normal case (no rules for wireguard)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					GatewayIP: "192.168.122.200",
+				},
+			},
+			expectedJumpChains: map[string][][]string{
+				"PREROUTING": [][]string{{"-j", "prec0a87ac8"}},
+			},
+			expectedChains: map[string][][]string{
+				"prec0a87ac8": [][]string{},
+			},
+		},
+		{
+			name: "Normal case (wireguard)",
+			gw:   gwWithWireGuard,
+			expectedJumpChains: map[string][][]string{
+				"PREROUTING": [][]string{{"-j", "prec0a87ac8"}},
+			},
+			expectedChains: map[string][][]string{
+				"prec0a87ac8": [][]string{
+					{"-i", "wgc0a87ac8", "-j", "CONNMARK", "--set-xmark", "0x2000000/0xff000000"},
+					{"!", "-i", "wgc0a87ac8", "-m", "connmark", "--mark", "0x2000000/0xff000000", "-j", "MARK", "--set-xmark", "0x2000000/0xff000000"},
+				},
+			},
+		},
+		{
+			name: "Error case (invalid GatewayIP)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					GatewayIP: "",
+				},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		jumpChains, chains, err := getExpectedMangleRule(tc.gw, 1)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error, but got no error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+		if !reflect.DeepEqual(tc.expectedJumpChains, jumpChains) {
+			t.Errorf("expected %v, but got %v", tc.expectedJumpChains, jumpChains)
+		}
+		if !reflect.DeepEqual(tc.expectedChains, chains) {
+			t.Errorf("expected %v, but got %v", tc.expectedChains, chains)
+		}
+	}
+}
+
+func TestGetExpectedWireGuard(t *testing.T) {
+	testCases := []struct {
+		name       string
+		gw         *v1alpha1.Gateway
+		expected   util.WireGuardConfig
+		expectedOk bool
+	}{
+		{
+			name: "Normal case (no rules for wireguard)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					GatewayIP: "192.168.122.200",
+				},
+			},
+			expected: util.WireGuardConfig{
+				Name:       "wgc0a87ac8",
+				PrivateKey: "gwkey",
+				ListenPort: 51821,
+				Index:      1,
+				Peers:      []util.WireGuardPeer{},
+			},
+			expectedOk: false,
+		},
+		{
+			name: "Normal case (wireguard)",
+			gw:   gwWithWireGuard,
+			expected: util.WireGuardConfig{
+				Name:       "wgc0a87ac8",
+				PrivateKey: "gwkey",
+				ListenPort: 51821,
+				Index:      1,
+				Peers: []util.WireGuardPeer{
+					{PublicKey: "fwdkey1", AllowedIPs: []string{"10.244.0.158/32"}},
+					{PublicKey: "fwdkey2", AllowedIPs: []string{"10.244.0.159/32"}},
+				},
+			},
+			expectedOk: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		cfg, ok, err := getExpectedWireGuard(tc.gw, "gwkey", 1)
+		if err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+		if ok != tc.expectedOk {
+			t.Errorf("expected %v, but got %v", tc.expectedOk, ok)
+		}
+		if !reflect.DeepEqual(tc.expected, cfg) {
+			t.Errorf("expected %v, but got %v", tc.expected, cfg)
+		}
+	}
+}
+
+func TestUpdateTLSConfig(t *testing.T) {
+	caCert, caKey, err := util.GenerateCA("relay-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to generate CA: %v", err)
+	}
+	cert, key, err := util.IssueCertificate(caCert, caKey, "gwrulec0a87ac8", []string{"192.168.122.200"}, x509.ExtKeyUsageServerAuth, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %v", err)
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "gwrulec0a87ac8-tls"},
+		Data: map[string][]byte{
+			util.TLSCertKey:       cert,
+			util.TLSPrivateKeyKey: key,
+			util.TLSCAKey:         caCert,
+		},
+	}
+	invalidSecret := secret.DeepCopy()
+	invalidSecret.Data[util.TLSCAKey] = []byte("invalid")
+	gwWithTLS := func(transport string) *v1alpha1.Gateway {
+		return &v1alpha1.Gateway{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "gwrulec0a87ac8"},
+			Spec: v1alpha1.GatewaySpec{
+				GatewayIP: "192.168.122.200",
+				IngressRules: []v1alpha1.GatewayRule{
+					{
+						Protocol:   "TCP",
+						SourceIP:   "192.168.122.139",
+						TargetPort: "80",
+						RelayPort:  "2050",
+						Transport:  transport,
+					},
+				},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name         string
+		gw           *v1alpha1.Gateway
+		objs         []runtime.Object
+		expectLoaded bool
+		expectErr    bool
+	}{
+		{
+			name:         "Normal case",
+			gw:           gwWithTLS(v1alpha1.TransportTLS),
+			objs:         []runtime.Object{secret},
+			expectLoaded: true,
+			expectErr:    false,
+		},
+		{
+			name:         "Normal case (no rules for tls transport)",
+			gw:           gwWithTLS(""),
+			objs:         []runtime.Object{},
+			expectLoaded: false,
+			expectErr:    false,
+		},
+		{
+			name:         "Error case (no certificate issued yet)",
+			gw:           gwWithTLS(v1alpha1.TransportTLS),
+			objs:         []runtime.Object{},
+			expectLoaded: false,
+			expectErr:    true,
+		},
+		{
+			name:         "Error case (invalid CA certificate)",
+			gw:           gwWithTLS(v1alpha1.TransportTLS),
+			objs:         []runtime.Object{invalidSecret},
+			expectLoaded: false,
+			expectErr:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+		for _, obj := range tc.objs {
+			indexer.Add(obj)
+		}
+		g := NewReconciler(nil, corelisters.NewSecretLister(indexer), "ns1", 0, record.NewFakeRecorder(10))
+
+		err := g.updateTLSConfig(tc.gw)
+		if tc.expectErr && err == nil {
+			t.Errorf("expected error, but no error returned")
+		}
+		if !tc.expectErr && err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+		config, err := g.tlsConfigLookup(tc.gw.Spec.GatewayIP)()
+		if tc.expectLoaded {
+			if err != nil || len(config.Certificates) != 1 {
+				t.Errorf("expected certificate to be loaded, but got %v (error: %v)", config, err)
+			}
+		} else if err == nil {
+			t.Errorf("expected no certificate to be loaded, but got %v", config)
+		}
+	}
+}
+
+func TestWireGuardIndex(t *testing.T) {
+	g := NewReconciler(nil, nil, "ns1", 0, record.NewFakeRecorder(10))
+
+	for _, tc := range []struct {
+		gwIP     string
+		expected int
+	}{
+		{gwIP: "192.168.122.200", expected: 0},
+		{gwIP: "192.168.122.201", expected: 1},
+		{gwIP: "192.168.122.200", expected: 0},
+	} {
+		t.Logf("test case: %s", tc.gwIP)
+		index, err := g.wireGuardIndex(tc.gwIP)
+		if err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+		if index != tc.expected {
+			t.Errorf("expected %d, but got %d", tc.expected, index)
+		}
+	}
+}
+
+func TestWireGuardIndexFor(t *testing.T) {
+	sshGw := &v1alpha1.Gateway{
+		Spec: v1alpha1.GatewaySpec{
+			GatewayIP: "192.168.122.200",
+			EgressRules: []v1alpha1.GatewayRule{
+				{
+					DestinationPort: "8001",
+					DestinationIP:   "192.168.122.139",
+					ForwarderIP:     "10.244.0.157",
+					RelayPort:       "2050",
+				},
+			},
+		},
+	}
+	wgGw := &v1alpha1.Gateway{
+		Spec: v1alpha1.GatewaySpec{
+			GatewayIP: "192.168.122.201",
+			EgressRules: []v1alpha1.GatewayRule{
+				{
+					DestinationPort: "8001",
+					DestinationIP:   "192.168.122.139",
+					ForwarderIP:     "10.244.0.157",
+					Transport:       v1alpha1.TransportWireGuard,
+				},
+			},
+		},
+	}
+	noRuleGw := &v1alpha1.Gateway{
+		Spec: v1alpha1.GatewaySpec{
+			GatewayIP: "192.168.122.201",
+		},
+	}
+	g := NewReconciler(nil, nil, "ns1", 0, record.NewFakeRecorder(10))
+
+	for _, tc := range []struct {
+		name            string
+		gw              *v1alpha1.Gateway
+		update          bool
+		expected        int
+		expectAllocated bool
+	}{
+		{
+			name:            "Normal case (no index for gateway without rules for wireguard)",
+			gw:              sshGw,
+			expected:        0,
+			expectAllocated: false,
+		},
+		{
+			name:            "Normal case (index allocated for gateway with rules for wireguard)",
+			gw:              wgGw,
+			expected:        0,
+			expectAllocated: true,
+		},
+		{
+			name:            "Normal case (index released after rules for wireguard are deleted)",
+			gw:              noRuleGw,
+			update:          true,
+			expected:        0,
+			expectAllocated: false,
+		},
+	} {
+		t.Logf("test case: %s", tc.name)
+		if tc.update {
+			if err := g.updateWireGuard(tc.gw); err != nil {
+				t.Errorf("expected no error, but got %v", err)
+			}
+		}
+		index, err := g.wireGuardIndexFor(tc.gw)
+		if err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+		if index != tc.expected {
+			t.Errorf("expected %d, but got %d", tc.expected, index)
+		}
+		if _, ok := g.wireGuardIndexes[tc.gw.Spec.GatewayIP]; ok != tc.expectAllocated {
+			t.Errorf("expected allocated %v, but got %v", tc.expectAllocated, ok)
+		}
+	}
+}
+
+func TestForwardingLookup(t *testing.T) {
+	testCases := []struct {
+		name          string
+		gw            *v1alpha1.Gateway
+		client        string
+		expectLimits  util.Limits
+		expectLimiter bool
+		expectLabels  util.TunnelLabels
+	}{
+		{
+			name: "Normal case (forwarder with limits)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					EgressRules: []v1alpha1.GatewayRule{
+						{
+							DestinationPort: "8001",
+							DestinationIP:   "192.168.122.139",
+							Forwarder: v1alpha1.ForwarderRef{
+								Name:      "my-externalservice",
+								Namespace: "external-services",
+							},
+							ForwarderIP: "10.244.0.157",
+							RelayPort:   "2050",
+							Limits: &v1alpha1.SourceLimits{
+								MaxConnections: 10,
+								BytesPerSecond: 1024,
+							},
+							ExternalService: v1alpha1.ExternalServiceRef{
+								Name:      "my-externalservice",
+								Namespace: "ns1",
+							},
+						},
+					},
+					GatewayIP: "192.168.122.201",
+				},
+			},
+			client:        "external-services/my-externalservice",
+			expectLimits:  util.Limits{MaxConnections: 10, BytesPerSecond: 1024},
+			expectLimiter: true,
+			expectLabels: util.TunnelLabels{
+				ExternalService: "ns1/my-externalservice",
+				Direction:       util.DirectionEgress,
+				Gateway:         "192.168.122.201",
+			},
+		},
+		{
+			name: "Normal case (unknown forwarder)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					EgressRules: []v1alpha1.GatewayRule{
+						{
+							DestinationPort: "8001",
+							DestinationIP:   "192.168.122.139",
+							Forwarder: v1alpha1.ForwarderRef{
+								Name:      "my-externalservice",
+								Namespace: "external-services",
+							},
+							ForwarderIP: "10.244.0.157",
+							RelayPort:   "2050",
+							Limits: &v1alpha1.SourceLimits{
+								MaxConnections: 10,
+							},
+						},
+					},
+					GatewayIP: "192.168.122.201",
+				},
+			},
+			client:        "external-services/other-externalservice",
+			expectLimits:  util.Limits{},
+			expectLimiter: false,
+			expectLabels: util.TunnelLabels{
+				Direction: util.DirectionEgress,
+				Gateway:   "192.168.122.201",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		vcl := fakeversioned.NewSimpleClientset()
+		cl := &fakev1alpha1.FakeSubmarinerV1alpha1{Fake: &vcl.Fake}
+		g := NewReconciler(cl, nil, "ns1", 0, record.NewFakeRecorder(10))
+
+		g.updateLimiters(tc.gw)
+		limiter, labels := g.forwardingLookup(tc.gw.Spec.GatewayIP)(tc.client)
+		if tc.expectLimiter != (limiter != nil) {
+			t.Errorf("expected limiter %v, but got %v", tc.expectLimiter, limiter != nil)
+		}
+		if limits := limiter.Limits(); tc.expectLimits != limits {
+			t.Errorf("expected %v, but got %v", tc.expectLimits, limits)
+		}
+		if tc.expectLabels != labels {
+			t.Errorf("expected %v, but got %v", tc.expectLabels, labels)
+		}
+	}
+}
+
+func TestForwardingLookupBehindSNAT(t *testing.T) {
+	// Destination accepts connections and keeps them open
+	dest, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening failed: %v", err)
+	}
+	defer dest.Close()
+	go func() {
+		for {
+			if _, err := dest.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+	destIP, destPort, _ := net.SplitHostPort(dest.Addr().String())
+
+	// Forwarder connects from 127.0.0.1, which is different from ForwarderIP as if its address were translated
+	gw := &v1alpha1.Gateway{
+		Spec: v1alpha1.GatewaySpec{
+			EgressRules: []v1alpha1.GatewayRule{
+				{
+					DestinationPort: destPort,
+					DestinationIP:   destIP,
+					Forwarder: v1alpha1.ForwarderRef{
+						Name:      "my-externalservice",
+						Namespace: "external-services",
+					},
+					ForwarderIP: "10.244.0.157",
+					RelayPort:   "2050",
+					Limits: &v1alpha1.SourceLimits{
+						MaxConnections: 1,
+					},
+				},
+			},
+			GatewayIP: "127.0.0.1",
+		},
+	}
+
+	vcl := fakeversioned.NewSimpleClientset()
+	cl := &fakev1alpha1.FakeSubmarinerV1alpha1{Fake: &vcl.Fake}
+	g := NewReconciler(cl, nil, "ns1", 0, record.NewFakeRecorder(10))
+	g.updateLimiters(gw)
+	g.updateTargetGroups(gw)
+
+	serverAddr := "127.0.0.1:" + strconv.Itoa(20000+rand.Intn(10000))
+	server := util.NewSSHServer(serverAddr, g.serverOptions(gw.Spec.GatewayIP))
+	go server.ListenAndServe()
+	defer server.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	client, err := ssh.Dial("tcp", serverAddr, &ssh.ClientConfig{
+		User:            util.ForwarderID("external-services", "my-externalservice"),
+		Timeout:         time.Second * 5,
+		Auth:            []ssh.AuthMethod{},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("connecting to %s failed: %v", serverAddr, err)
+	}
+	defer client.Close()
+
+	// First connection uses up the limit of the forwarder and second one should be rejected
+	conn, err := client.Dial("tcp", dest.Addr().String())
+	if err != nil {
+		t.Fatalf("forwarding to %s failed: %v", dest.Addr(), err)
+	}
+	defer conn.Close()
+	if conn, err := client.Dial("tcp", dest.Addr().String()); err == nil {
+		conn.Close()
+		t.Errorf("expected error for connection exceeding limit, but no error returned")
+	}
+
+	status := g.forwarderStatuses(gw.Spec.GatewayIP)
+	if len(status) != 1 {
+		t.Fatalf("expected status for 1 forwarder, but got %v", status)
+	}
+	if status[0].ForwarderIP != "10.244.0.157" || status[0].Forwarder.Name != "my-externalservice" {
+		t.Errorf("expected status for forwarder %s, but got %v", "external-services/my-externalservice", status[0])
+	}
+	if rejected := status[0].RejectedConnections; rejected != 1 {
+		t.Errorf("expected 1 rejected connection, but got %d", rejected)
+	}
+}
+
+func TestExternalServiceRefs(t *testing.T) {
+	esRef := v1alpha1.ExternalServiceRef{Namespace: "ns1", Name: "es1", UID: "c1d2e3f4-0000-1111-2222-333344445555"}
+
+	testCases := []struct {
+		name           string
+		gw             *v1alpha1.Gateway
+		expected       []*corev1.ObjectReference
+		expectedEvents []string
+	}{
+		{
+			name: "Normal case (egress and ingress rules for the same external service)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					EgressRules:  []v1alpha1.GatewayRule{{ExternalService: esRef, RelayPort: "2049"}},
+					IngressRules: []v1alpha1.GatewayRule{{ExternalService: esRef, RelayPort: "2049"}},
+					GatewayIP:    "192.168.122.201",
+				},
+			},
+			expected: []*corev1.ObjectReference{
+				{
+					APIVersion: "submariner.io/v1alpha1",
+					Kind:       "ExternalService",
+					Namespace:  "ns1",
+					Name:       "es1",
+					UID:        "c1d2e3f4-0000-1111-2222-333344445555",
+				},
+			},
+			expectedEvents: []string{"Warning FailedSshd ssh server failed"},
+		},
+		{
+			name: "Normal case (rules without external service)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					EgressRules: []v1alpha1.GatewayRule{{RelayPort: "2049"}},
+					GatewayIP:   "192.168.122.201",
+				},
+			},
+			expected:       []*corev1.ObjectReference{},
+			expectedEvents: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		recorder := record.NewFakeRecorder(10)
+		g := NewReconciler(nil, nil, "ns1", 0, recorder)
+
+		refs := externalServiceRefs(tc.gw)
+		if !reflect.DeepEqual(tc.expected, refs) {
+			t.Errorf("expected %v, but got %v", tc.expected, refs)
+		}
+
+		// Errors of servers are recorded on the external services cached without looking up gateways
+		g.updateExternalServiceRefs(tc.gw)
+		g.recordServerError(tc.gw.Spec.GatewayIP, util.ReasonFailedSshd, "ssh server failed")
+		close(recorder.Events)
+		events := []string{}
+		for event := range recorder.Events {
+			events = append(events, event)
+		}
+		if !reflect.DeepEqual(tc.expectedEvents, events) {
+			t.Errorf("expected events %v, but got %v", tc.expectedEvents, events)
+		}
+	}
+}
+
+func TestGetExpectedTargetGroups(t *testing.T) {
+	testCases := []struct {
+		name     string
+		gw       *v1alpha1.Gateway
+		expected map[string]util.TargetGroupSpec
+	}{
+		{
+			name: "Normal case (single target)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					EgressRules: []v1alpha1.GatewayRule{
+						{DestinationIP: "192.168.122.139", DestinationPort: "80", ForwarderIP: "10.0.0.3", RelayPort: "2049"},
+					},
+					GatewayIP: "192.168.122.201",
+				},
+			},
+			expected: map[string]util.TargetGroupSpec{},
+		},
+		{
+			name: "Normal case (multiple targets with default health check)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					EgressRules: []v1alpha1.GatewayRule{
+						{
+							DestinationIP:   "192.168.122.139",
+							DestinationPort: "80",
+							Forwarder:       v1alpha1.ForwarderRef{Namespace: "ns1", Name: "es1"},
+							ForwarderIP:     "10.0.0.3",
+							RelayPort:       "2049",
+							DestinationIPs:  []string{"192.168.122.139", "192.168.122.140"},
+							TargetPolicy:    util.TargetPolicyFailover,
+							HealthCheck:     &v1alpha1.TargetHealthCheck{},
+						},
+						{
+							DestinationIP:   "192.168.122.139",
+							DestinationPort: "80",
+							Forwarder:       v1alpha1.ForwarderRef{Namespace: "ns1", Name: "es2"},
+							ForwarderIP:     "10.0.0.5",
+							RelayPort:       "2049",
+							DestinationIPs:  []string{"192.168.122.139", "192.168.122.140"},
+							TargetPolicy:    util.TargetPolicyRoundRobin,
+						},
+					},
+					GatewayIP: "192.168.122.201",
+				},
+			},
+			expected: map[string]util.TargetGroupSpec{
+				"ns1/es1/192.168.122.139:80": {
+					Targets:             []string{"192.168.122.139", "192.168.122.140"},
+					Policy:              util.TargetPolicyFailover,
+					SourceIP:            "192.168.122.201",
+					Port:                "80",
+					HealthCheckInterval: defaultHealthCheckPeriod,
+					HealthCheckTimeout:  defaultHealthCheckTimeout,
+				},
+				"ns1/es2/192.168.122.139:80": {
+					Targets:  []string{"192.168.122.139", "192.168.122.140"},
+					Policy:   util.TargetPolicyRoundRobin,
+					SourceIP: "192.168.122.201",
+					Port:     "80",
+				},
+			},
+		},
+		{
+			name: "Normal case (multiple targets with health check)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					EgressRules: []v1alpha1.GatewayRule{
+						{
+							DestinationIP:   "192.168.122.139",
+							DestinationPort: "80",
+							Forwarder:       v1alpha1.ForwarderRef{Namespace: "ns1", Name: "es1"},
+							ForwarderIP:     "10.0.0.3",
+							RelayPort:       "2049",
+							DestinationIPs:  []string{"192.168.122.139", "192.168.122.140"},
+							TargetPolicy:    util.TargetPolicyRoundRobin,
+							HealthCheck:     &v1alpha1.TargetHealthCheck{PeriodSeconds: 5, TimeoutSeconds: 2},
+						},
+					},
+					GatewayIP: "192.168.122.201",
+				},
+			},
+			expected: map[string]util.TargetGroupSpec{
+				"ns1/es1/192.168.122.139:80": {
+					Targets:             []string{"192.168.122.139", "192.168.122.140"},
+					Policy:              util.TargetPolicyRoundRobin,
+					SourceIP:            "192.168.122.201",
+					Port:                "80",
+					HealthCheckInterval: 5 * time.Second,
+					HealthCheckTimeout:  2 * time.Second,
+				},
+			},
+		},
+		{
+			name: "Normal case (multiple targets for port range)",
+			gw: &v1alpha1.Gateway{
+				Spec: v1alpha1.GatewaySpec{
+					EgressRules: []v1alpha1.GatewayRule{
+						{
+							TargetPort:      "40000",
+							TargetEndPort:   "40100",
+							DestinationIP:   "192.168.122.139",
+							DestinationPort: "30000",
+							Forwarder:       v1alpha1.ForwarderRef{Namespace: "ns1", Name: "es1"},
+							ForwarderIP:     "10.0.0.3",
+							RelayPort:       "2050",
+							DestinationIPs:  []string{"192.168.122.139", "192.168.122.140"},
+							TargetPolicy:    util.TargetPolicyRoundRobin,
+						},
+					},
+					GatewayIP: "192.168.122.201",
+				},
+			},
+			expected: map[string]util.TargetGroupSpec{
+				"ns1/es1/192.168.122.139:30000-30100": {
+					Targets:  []string{"192.168.122.139", "192.168.122.140"},
+					Policy:   util.TargetPolicyRoundRobin,
+					SourceIP: "192.168.122.201",
+					Port:     "30000",
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		specs := getExpectedTargetGroups(tc.gw)
+		if !reflect.DeepEqual(tc.expected, specs) {
+			t.Errorf("expected %v, but got %v", tc.expected, specs)
+		}
+	}
+}
+
+func TestTargetLookup(t *testing.T) {
+	vcl := fakeversioned.NewSimpleClientset()
+	cl := &fakev1alpha1.FakeSubmarinerV1alpha1{Fake: &vcl.Fake}
+	g := NewReconciler(cl, nil, "ns1", 0, record.NewFakeRecorder(10))
+	g.updateTargetGroups(&v1alpha1.Gateway{
+		Spec: v1alpha1.GatewaySpec{
+			EgressRules: []v1alpha1.GatewayRule{
+				{
+					TargetPort:      "80",
+					DestinationIP:   "192.168.122.139",
+					DestinationPort: "80",
+					Forwarder:       v1alpha1.ForwarderRef{Namespace: "ns1", Name: "es1"},
+					ForwarderIP:     "10.0.0.3",
+					RelayPort:       "2049",
+					DestinationIPs:  []string{"192.168.122.139", "192.168.122.140"},
+				},
+				{
+					TargetPort:      "30000",
+					TargetEndPort:   "30100",
+					DestinationIP:   "192.168.122.139",
+					DestinationPort: "30000",
+					Forwarder:       v1alpha1.ForwarderRef{Namespace: "ns1", Name: "es1"},
+					ForwarderIP:     "10.0.0.3",
+					RelayPort:       "2050",
+					DestinationIPs:  []string{"192.168.122.139", "192.168.122.140"},
+				},
+			},
+			GatewayIP: "192.168.122.201",
+		},
+	})
+	lookup := g.targetLookup("192.168.122.201")
+	groups := g.targetGroups["192.168.122.201"]
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 target groups, but got %d", len(groups))
+	}
+
+	testCases := []struct {
+		name     string
+		client   string
+		dest     string
+		expected *util.TargetGroup
+	}{
+		{
+			name:     "Normal case (port)",
+			client:   "ns1/es1",
+			dest:     "192.168.122.139:80",
+			expected: groups["ns1/es1/192.168.122.139:80"],
+		},
+		{
+			name:     "Normal case (port in range)",
+			client:   "ns1/es1",
+			dest:     "192.168.122.139:30050",
+			expected: groups["ns1/es1/192.168.122.139:30000-30100"],
+		},
+		{
+			name:     "Normal case (port out of range)",
+			client:   "ns1/es1",
+			dest:     "192.168.122.139:30101",
+			expected: nil,
+		},
+		{
+			name:     "Normal case (other forwarder)",
+			client:   "ns1/es2",
+			dest:     "192.168.122.139:30050",
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		group := lookup(tc.client, tc.dest)
+		if tc.expected != group {
+			t.Errorf("expected %v, but got %v", tc.expected, group)
+		}
+	}
+}
+
+func TestRelayAddrs(t *testing.T) {
+	gw := &v1alpha1.Gateway{
+		Spec: v1alpha1.GatewaySpec{
+			IngressRules: []v1alpha1.GatewayRule{
+				{
+					SourceIP:   "192.168.122.139",
+					TargetPort: "80",
+					RelayPort:  "2049",
+				},
+				{
+					SourceIP:   "203.0.113.0/24",
+					TargetPort: "80",
+					RelayPort:  "2050",
+				},
+				{
+					SourceIP:   "192.168.122.139",
+					TargetPort: "443",
+					RelayPort:  "2051",
+				},
+			},
+			GatewayIP: "192.168.122.201",
+			Mode:      v1alpha1.GatewayModeListener,
+		},
+	}
+
+	testCases := []struct {
+		name       string
+		addr       string
+		clientAddr net.Addr
+		expected   []string
+	}{
+		{
+			name:       "Normal case (client IP)",
+			addr:       "192.168.122.201:80",
+			clientAddr: &net.TCPAddr{IP: net.ParseIP("192.168.122.139"), Port: 43210},
+			expected:   []string{"192.168.122.201:2049"},
+		},
+		{
+			name:       "Normal case (client in CIDR)",
+			addr:       "192.168.122.201:80",
+			clientAddr: &net.TCPAddr{IP: net.ParseIP("203.0.113.10"), Port: 43210},
+			expected:   []string{"192.168.122.201:2050"},
+		},
+		{
+			name:       "Normal case (other port)",
+			addr:       "192.168.122.201:443",
+			clientAddr: &net.TCPAddr{IP: net.ParseIP("192.168.122.139"), Port: 43210},
+			expected:   []string{"192.168.122.201:2051"},
+		},
+		{
+			name:       "Normal case (client not allowed)",
+			addr:       "192.168.122.201:443",
+			clientAddr: &net.TCPAddr{IP: net.ParseIP("203.0.113.10"), Port: 43210},
+			expected:   []string{},
+		},
+	}
+
+	vcl := fakeversioned.NewSimpleClientset()
+	cl := &fakev1alpha1.FakeSubmarinerV1alpha1{Fake: &vcl.Fake}
+	g := NewReconciler(cl, nil, "ns1", 0, record.NewFakeRecorder(10))
+	g.listenerRules[gw.Spec.GatewayIP] = getExpectedListeners(gw)
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		addrs := g.relayAddrs(gw.Spec.GatewayIP, tc.addr, tc.clientAddr)
+		if !reflect.DeepEqual(tc.expected, addrs) {
+			t.Errorf("expected %v, but got %v", tc.expected, addrs)
+		}
+	}
+}
+
+func TestEnsureRelayRunning(t *testing.T) {
+	port1 := strconv.Itoa(40000 + rand.Intn(10000))
+	port2 := strconv.Itoa(50000 + rand.Intn(10000))
+	newGateway := func(transport, port string) *v1alpha1.Gateway {
+		return &v1alpha1.Gateway{
+			Spec: v1alpha1.GatewaySpec{
+				GatewayIP:    "127.0.0.1",
+				TLSRelayPort: port,
+				EgressRules: []v1alpha1.GatewayRule{
+					{DestinationPort: "8001", DestinationIP: "192.168.122.139", RelayPort: "2049", Transport: transport},
+				},
+			},
+		}
+	}
+
+	// Each case is applied to the same reconciler in order
+	testCases := []struct {
+		name         string
+		gw           *v1alpha1.Gateway
+		expectOpen   []string
+		expectClosed []string
+	}{
+		{
+			name:         "Normal case (start relay server)",
+			gw:           newGateway(v1alpha1.TransportTLS, port1),
+			expectOpen:   []string{port1},
+			expectClosed: []string{port2},
+		},
+		{
+			name:         "Normal case (restart relay server for changed port)",
+			gw:           newGateway(v1alpha1.TransportTLS, port2),
+			expectOpen:   []string{port2},
+			expectClosed: []string{port1},
+		},
+		{
+			name:         "Normal case (stop relay server without rules for TLS transport)",
+			gw:           newGateway(v1alpha1.TransportSSH, port2),
+			expectOpen:   []string{},
+			expectClosed: []string{port1, port2},
+		},
+	}
+
+	vcl := fakeversioned.NewSimpleClientset()
+	cl := &fakev1alpha1.FakeSubmarinerV1alpha1{Fake: &vcl.Fake}
+	g := NewReconciler(cl, nil, "ns1", 0, record.NewFakeRecorder(10))
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		if err := g.ensureRelayRunning(tc.gw); err != nil {
+			t.Errorf("expected no error, but got %v", err)
+			continue
+		}
+		// Ensure relay server to be running
+		time.Sleep(time.Millisecond * 100)
+
+		for _, port := range tc.expectOpen {
+			if !util.IsPortOpen("127.0.0.1", port) {
+				t.Errorf("expected port %s to be open, but it is closed", port)
+			}
+		}
+		for _, port := range tc.expectClosed {
+			if util.IsPortOpen("127.0.0.1", port) {
+				t.Errorf("expected port %s to be closed, but it is open", port)
+			}
+		}
+		if !g.checkRelayRunning(tc.gw) {
+			t.Errorf("expected relay server to be running, but it isn't")
+		}
+	}
+}
+
+func TestUpdateListeners(t *testing.T) {
+	port1 := strconv.Itoa(40000 + rand.Intn(10000))
+	port2 := strconv.Itoa(50000 + rand.Intn(10000))
+	newGateway := func(mode string, ports ...string) *v1alpha1.Gateway {
+		gw := &v1alpha1.Gateway{Spec: v1alpha1.GatewaySpec{GatewayIP: "127.0.0.1", Mode: mode}}
+		for _, port := range ports {
+			gw.Spec.IngressRules = append(gw.Spec.IngressRules, v1alpha1.GatewayRule{SourceIP: "127.0.0.1", TargetPort: port, RelayPort: "2049"})
+		}
+		return gw
+	}
+
+	// Each case is applied to the same reconciler in order
+	testCases := []struct {
+		name         string
+		gw           *v1alpha1.Gateway
+		expectOpen   []string
+		expectClosed []string
+		expected     []v1alpha1.GatewayListenerStatus
+	}{
+		{
+			name:         "Normal case (start listeners)",
+			gw:           newGateway(v1alpha1.GatewayModeListener, port1, port2),
+			expectOpen:   []string{port1, port2},
+			expectClosed: []string{},
+			expected:     []v1alpha1.GatewayListenerStatus{{Port: port1}, {Port: port2}},
+		},
+		{
+			name:         "Normal case (stop listener for removed rule)",
+			gw:           newGateway(v1alpha1.GatewayModeListener, port1),
+			expectOpen:   []string{port1},
+			expectClosed: []string{port2},
+			expected:     []v1alpha1.GatewayListenerStatus{{Port: port1}},
+		},
+		{
+			name:         "Normal case (stop listeners for Tunnel mode)",
+			gw:           newGateway(v1alpha1.GatewayModeTunnel, port1),
+			expectOpen:   []string{},
+			expectClosed: []string{port1},
+			expected:     []v1alpha1.GatewayListenerStatus{},
+		},
+	}
+
+	vcl := fakeversioned.NewSimpleClientset()
+	cl := &fakev1alpha1.FakeSubmarinerV1alpha1{Fake: &vcl.Fake}
+	g := NewReconciler(cl, nil, "ns1", 0, record.NewFakeRecorder(10))
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		if err := g.updateListeners(tc.gw); err != nil {
+			t.Errorf("expected no error, but got %v", err)
+			continue
+		}
+		for _, port := range tc.expectOpen {
+			if !util.IsPortOpen("127.0.0.1", port) {
+				t.Errorf("expected port %s to be open, but it is closed", port)
+			}
+		}
+		for _, port := range tc.expectClosed {
+			if util.IsPortOpen("127.0.0.1", port) {
+				t.Errorf("expected port %s to be closed, but it is open", port)
+			}
+		}
+		if !g.checkListenersRunning(tc.gw) {
+			t.Errorf("expected listeners to be running, but they aren't")
+		}
+		// No forwarder has sessions
+		sort.Slice(tc.expected, func(i, j int) bool { return tc.expected[i].Port < tc.expected[j].Port })
+		if listeners := g.listenerStatuses(tc.gw.Spec.GatewayIP); !reflect.DeepEqual(tc.expected, listeners) {
+			t.Errorf("expected %v, but got %v", tc.expected, listeners)
+		}
+	}
+}
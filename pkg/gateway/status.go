@@ -1,53 +1,120 @@
 package gateway
 
 import (
+	"fmt"
+
 	"github.com/golang/glog"
 	"github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
 	clv1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned/typed/submariner/v1alpha1"
 	"github.com/mkimuram/k8s-ext-connector/pkg/util"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 )
 
+// needSync returns true if rules in {gw} need to be synced, which is when metadata.generation
+// of {gw} is not observed yet or rules for the observed one are not synced
 func needSync(gw *v1alpha1.Gateway) bool {
 	// Sync is needed if
-	// - rule is not updating
-	// - generations are different between rule and sync || not is not synced
-	return gw.Status.Conditions.IsFalseFor(v1alpha1.ConditionRuleUpdating) &&
-		(gw.Status.RuleGeneration != gw.Status.SyncGeneration ||
-			gw.Status.Conditions.IsTrueFor(v1alpha1.ConditionRuleSyncing))
+	// - generation is not observed yet || rule is not synced
+	return gw.Generation != gw.Status.ObservedGeneration ||
+		!gw.Status.Conditions.IsTrueFor(v1alpha1.ConditionSynced)
 }
 
+// needCheckSync returns true if rules in {gw} need to be checked for drift
 func needCheckSync(gw *v1alpha1.Gateway) bool {
 	// CheckSync is needed if
-	// - rule is not updating
-	// - generations are the same between rule and sync
+	// - generation is observed
 	// - rule is synced
-	return gw.Status.Conditions.IsFalseFor(v1alpha1.ConditionRuleUpdating) &&
-		gw.Status.RuleGeneration == gw.Status.SyncGeneration &&
-		gw.Status.Conditions.IsFalseFor(v1alpha1.ConditionRuleSyncing)
+	return gw.Generation == gw.Status.ObservedGeneration &&
+		gw.Status.Conditions.IsTrueFor(v1alpha1.ConditionSynced)
 }
 
-func setSyncing(clientset clv1alpha1.SubmarinerV1alpha1Interface, ns string, gw *v1alpha1.Gateway) error {
-	var err error
-	if gw.Status.Conditions.SetCondition(util.RuleSyncingCondition(corev1.ConditionTrue)) {
-		gw, err = clientset.Gateways(ns).UpdateStatus(gw)
-		if err != nil {
-			return err
-		}
-		glog.Infof("Update RuleSyncingCondition to true")
+// setSyncing sets Synced and Ready conditions of {gw} to False with {reason} and {message},
+// and returns the updated gateway
+func setSyncing(clientset clv1alpha1.SubmarinerV1alpha1Interface, ns string, gw *v1alpha1.Gateway, reason, message string) (*v1alpha1.Gateway, error) {
+	changed := gw.Status.Conditions.SetCondition(util.SyncedCondition(corev1.ConditionFalse, reason, message))
+	changed = gw.Status.Conditions.SetCondition(util.ReadyCondition(corev1.ConditionFalse, reason, message)) || changed
+	if !changed {
+		// No change
+		return gw, nil
 	}
-	return nil
+
+	updated, err := clientset.Gateways(ns).UpdateStatus(gw)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Update SyncedCondition to false: %s", reason)
+
+	return updated, nil
 }
 
-func setSynced(clientset clv1alpha1.SubmarinerV1alpha1Interface, ns string, gw *v1alpha1.Gateway) error {
-	var err error
-	if gw.Status.Conditions.SetCondition(util.RuleSyncingCondition(corev1.ConditionFalse)) {
-		gw.Status.SyncGeneration = gw.Status.RuleGeneration
-		gw, err = clientset.Gateways(ns).UpdateStatus(gw)
-		if err != nil {
-			return err
-		}
-		glog.Infof("Update RuleSyncingCondition to false")
+// setSynced sets ObservedGeneration of {gw} to its metadata.generation and Synced and Ready conditions to True,
+// because servers and iptables rules are in place once rules are synced. It returns the updated gateway.
+func setSynced(clientset clv1alpha1.SubmarinerV1alpha1Interface, ns string, gw *v1alpha1.Gateway) (*v1alpha1.Gateway, error) {
+	message := fmt.Sprintf("Rules of generation %d are synced", gw.Generation)
+	changed := gw.Status.Conditions.SetCondition(util.SyncedCondition(corev1.ConditionTrue, util.ReasonSynced, message))
+	changed = gw.Status.Conditions.SetCondition(util.ReadyCondition(corev1.ConditionTrue, util.ReasonReady, "")) || changed
+	if !changed && gw.Status.ObservedGeneration == gw.Generation {
+		// No change
+		return gw, nil
+	}
+
+	gw.Status.ObservedGeneration = gw.Generation
+	updated, err := clientset.Gateways(ns).UpdateStatus(gw)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Update SyncedCondition to true for generation %d", gw.Generation)
+
+	return updated, nil
+}
+
+func setForwarderStatuses(clientset clv1alpha1.SubmarinerV1alpha1Interface, ns string, gw *v1alpha1.Gateway, forwarders []v1alpha1.GatewayForwarderStatus) error {
+	if equality.Semantic.DeepEqual(gw.Status.ForwarderStatuses, forwarders) {
+		// No change
+		return nil
+	}
+
+	gw.Status.ForwarderStatuses = forwarders
+	if _, err := clientset.Gateways(ns).UpdateStatus(gw); err != nil {
+		return err
 	}
+	glog.Infof("Update forwarder statuses")
+
 	return nil
 }
+
+// setWireGuardStatus publishes {key} and {port} for WireGuard to the status of {gw}, and returns the updated gateway
+func setWireGuardStatus(clientset clv1alpha1.SubmarinerV1alpha1Interface, ns string, gw *v1alpha1.Gateway, key, port string) (*v1alpha1.Gateway, error) {
+	if gw.Status.WireGuardPublicKey == key && gw.Status.WireGuardPort == port {
+		// No change
+		return gw, nil
+	}
+
+	gw.Status.WireGuardPublicKey = key
+	gw.Status.WireGuardPort = port
+	updated, err := clientset.Gateways(ns).UpdateStatus(gw)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Update wireguard public key and port")
+
+	return updated, nil
+}
+
+// setListenerStatuses publishes {listeners} to the status of {gw}, and returns the updated gateway
+func setListenerStatuses(clientset clv1alpha1.SubmarinerV1alpha1Interface, ns string, gw *v1alpha1.Gateway, listeners []v1alpha1.GatewayListenerStatus) (*v1alpha1.Gateway, error) {
+	if equality.Semantic.DeepEqual(gw.Status.IngressListeners, listeners) {
+		// No change
+		return gw, nil
+	}
+
+	gw.Status.IngressListeners = listeners
+	updated, err := clientset.Gateways(ns).UpdateStatus(gw)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Update ingress listener statuses")
+
+	return updated, nil
+}
@@ -7,102 +7,106 @@ import (
 	submarinerv1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
 	fakeversioned "github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned/fake"
 	fakev1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned/typed/submariner/v1alpha1/fake"
+	"github.com/mkimuram/k8s-ext-connector/pkg/util"
 	"github.com/operator-framework/operator-sdk/pkg/status"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func compareGateway(t *testing.T, a, b *v1alpha1.Gateway) {
-	if a.Status.Conditions[submarinerv1alpha1.ConditionRuleSyncing].Status !=
-		b.Status.Conditions[submarinerv1alpha1.ConditionRuleSyncing].Status {
-		t.Errorf("RuleSyncing: expected %v, but got %v",
-			a.Status.Conditions[submarinerv1alpha1.ConditionRuleSyncing].Status,
-			b.Status.Conditions[submarinerv1alpha1.ConditionRuleSyncing].Status)
+	if a.Status.Conditions[submarinerv1alpha1.ConditionSynced].Status !=
+		b.Status.Conditions[submarinerv1alpha1.ConditionSynced].Status {
+		t.Errorf("Synced: expected %v, but got %v",
+			a.Status.Conditions[submarinerv1alpha1.ConditionSynced].Status,
+			b.Status.Conditions[submarinerv1alpha1.ConditionSynced].Status)
 	}
-	if a.Status.Conditions[submarinerv1alpha1.ConditionRuleUpdating].Status !=
-		b.Status.Conditions[submarinerv1alpha1.ConditionRuleUpdating].Status {
-		t.Errorf("RuleUpdating: expected %v, but got %v",
-			a.Status.Conditions[submarinerv1alpha1.ConditionRuleUpdating].Status,
-			b.Status.Conditions[submarinerv1alpha1.ConditionRuleUpdating].Status)
+	if a.Status.Conditions[submarinerv1alpha1.ConditionSynced].Reason !=
+		b.Status.Conditions[submarinerv1alpha1.ConditionSynced].Reason {
+		t.Errorf("Synced reason: expected %v, but got %v",
+			a.Status.Conditions[submarinerv1alpha1.ConditionSynced].Reason,
+			b.Status.Conditions[submarinerv1alpha1.ConditionSynced].Reason)
 	}
-	if a.Status.RuleGeneration != b.Status.RuleGeneration {
-		t.Errorf("Rulegeneration: expected %v, but got %v", a.Status.RuleGeneration, b.Status.RuleGeneration)
+	if a.Status.Conditions[submarinerv1alpha1.ConditionReady].Status !=
+		b.Status.Conditions[submarinerv1alpha1.ConditionReady].Status {
+		t.Errorf("Ready: expected %v, but got %v",
+			a.Status.Conditions[submarinerv1alpha1.ConditionReady].Status,
+			b.Status.Conditions[submarinerv1alpha1.ConditionReady].Status)
 	}
-	if a.Status.SyncGeneration != b.Status.SyncGeneration {
-		t.Errorf("Rulegeneration: expected %v, but got %v", a.Status.SyncGeneration, b.Status.SyncGeneration)
+	if a.Status.ObservedGeneration != b.Status.ObservedGeneration {
+		t.Errorf("ObservedGeneration: expected %v, but got %v", a.Status.ObservedGeneration, b.Status.ObservedGeneration)
 	}
 }
 
 func TestNeedSync(t *testing.T) {
 	testCases := []struct {
-		name      string
-		namespace string
-		gw        *v1alpha1.Gateway
-		expected  bool
+		name     string
+		gw       *v1alpha1.Gateway
+		expected bool
 	}{
 		{
-			name: "Normal case (rule is not updating and generation is different)",
+			name: "Normal case (generation is not observed yet)",
 			gw: &v1alpha1.Gateway{
+				ObjectMeta: metav1.ObjectMeta{
+					// Spec is updated
+					Generation: 2,
+				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleSyncing,
-							Status: corev1.ConditionFalse,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleUpdating,
-							// not updating
-							Status: corev1.ConditionFalse,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type:   submarinerv1alpha1.ConditionSynced,
+							Status: corev1.ConditionTrue,
 						},
 					},
-					// Different generation
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
 			expected: true,
 		},
 		{
-			name: "Normal case (rule is not updating and syncing)",
+			name: "Normal case (rule is not synced)",
 			gw: &v1alpha1.Gateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Generation: 2,
+				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Syncing
-							Status: corev1.ConditionTrue,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Not synced
 							Status: corev1.ConditionFalse,
 						},
 					},
 					// same generation
-					RuleGeneration: 2,
-					SyncGeneration: 2,
+					ObservedGeneration: 2,
 				},
 			},
 			expected: true,
 		},
 		{
-			name: "Normal case (rule is updating)",
+			name: "Normal case (new gateway without conditions)",
 			gw: &v1alpha1.Gateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Generation: 1,
+				},
+			},
+			expected: true,
+		},
+		{
+			name: "Normal case (rule is synced for the generation)",
+			gw: &v1alpha1.Gateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Generation: 2,
+				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Syncing
-							Status: corev1.ConditionTrue,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleUpdating,
-							// Still rule is updating
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Synced
 							Status: corev1.ConditionTrue,
 						},
 					},
-					// different generation
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					// same generation
+					ObservedGeneration: 2,
 				},
 			},
 			expected: false,
@@ -126,70 +130,61 @@ func TestNeedCheckSync(t *testing.T) {
 		expected bool
 	}{
 		{
-			name: "Normal case (rule is not updating and generation is the same)",
+			name: "Normal case (rule is synced and generation is observed)",
 			gw: &v1alpha1.Gateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Generation: 1,
+				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
 							// synced
-							Status: corev1.ConditionFalse,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleUpdating,
-							// not updating
-							Status: corev1.ConditionFalse,
+							Status: corev1.ConditionTrue,
 						},
 					},
 					// same generation
-					RuleGeneration: 1,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
 			expected: true,
 		},
 		{
-			name: "Normal case (rule is not updating and generation is different)",
+			name: "Normal case (rule is synced but generation is not observed)",
 			gw: &v1alpha1.Gateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Generation: 2,
+				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
 							// synced
-							Status: corev1.ConditionFalse,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleUpdating,
-							// not updating
-							Status: corev1.ConditionFalse,
+							Status: corev1.ConditionTrue,
 						},
 					},
 					// different generation
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
 			expected: false,
 		},
 		{
-			name: "Normal case (rule is updating)",
+			name: "Normal case (rule is not synced)",
 			gw: &v1alpha1.Gateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Generation: 1,
+				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// synced
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// not synced
 							Status: corev1.ConditionFalse,
 						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleUpdating,
-							// updating
-							Status: corev1.ConditionTrue,
-						},
 					},
 					// same generation
-					RuleGeneration: 1,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
 			expected: false,
@@ -211,100 +206,133 @@ func TestSetSyncing(t *testing.T) {
 		name      string
 		namespace string
 		gw        *v1alpha1.Gateway
+		reason    string
 		expected  *v1alpha1.Gateway
 		expectErr bool
 	}{
 		{
-			name:      "Normal case (Change RuleSyncing false to true)",
+			name:      "Normal case (Change Synced true to false for new generation)",
 			namespace: "ns1",
 			gw: &v1alpha1.Gateway{
 				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "gw1",
+					Namespace:  "ns1",
+					Name:       "gw1",
+					Generation: 2,
 				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleSyncing,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type:   submarinerv1alpha1.ConditionSynced,
+							Status: corev1.ConditionTrue,
+							Reason: util.ReasonSynced,
+						},
+					},
+					ObservedGeneration: 1,
+				},
+			},
+			reason: util.ReasonSyncing,
+			expected: &v1alpha1.Gateway{
+				Status: v1alpha1.GatewayStatus{
+					Conditions: status.Conditions{
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Changed to false
 							Status: corev1.ConditionFalse,
+							Reason: util.ReasonSyncing,
 						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
+						submarinerv1alpha1.ConditionReady: status.Condition{
+							Type:   submarinerv1alpha1.ConditionReady,
 							Status: corev1.ConditionFalse,
+							Reason: util.ReasonSyncing,
 						},
 					},
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					// No change
+					ObservedGeneration: 1,
 				},
 			},
-			expected: &v1alpha1.Gateway{
+			expectErr: false,
+		},
+		{
+			name:      "Normal case (Change Synced true to false for drift)",
+			namespace: "ns1",
+			gw: &v1alpha1.Gateway{
 				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "gw1",
+					Namespace:  "ns1",
+					Name:       "gw1",
+					Generation: 2,
 				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Only this field wil be changed
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type:   submarinerv1alpha1.ConditionSynced,
 							Status: corev1.ConditionTrue,
+							Reason: util.ReasonSynced,
 						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
+					},
+					ObservedGeneration: 2,
+				},
+			},
+			reason: util.ReasonNotSynced,
+			expected: &v1alpha1.Gateway{
+				Status: v1alpha1.GatewayStatus{
+					Conditions: status.Conditions{
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Changed to false
+							Status: corev1.ConditionFalse,
+							Reason: util.ReasonNotSynced,
+						},
+						submarinerv1alpha1.ConditionReady: status.Condition{
+							Type:   submarinerv1alpha1.ConditionReady,
 							Status: corev1.ConditionFalse,
+							Reason: util.ReasonNotSynced,
 						},
 					},
 					// No change
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					ObservedGeneration: 2,
 				},
 			},
 			expectErr: false,
 		},
 		{
-			name:      "Normal case (RuleSyncing is already true and try to set it to true)",
+			name:      "Normal case (Synced is already false and try to set it to false)",
 			namespace: "ns1",
 			gw: &v1alpha1.Gateway{
 				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "gw1",
+					Namespace:  "ns1",
+					Name:       "gw1",
+					Generation: 2,
 				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Already true
-							Status: corev1.ConditionTrue,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Already false
 							Status: corev1.ConditionFalse,
+							Reason: util.ReasonSyncing,
 						},
 					},
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
+			reason: util.ReasonSyncing,
 			expected: &v1alpha1.Gateway{
-				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "gw1",
-				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Remains true
-							Status: corev1.ConditionTrue,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Remains false
+							Status: corev1.ConditionFalse,
+							Reason: util.ReasonSyncing,
 						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
+						submarinerv1alpha1.ConditionReady: status.Condition{
+							Type:   submarinerv1alpha1.ConditionReady,
 							Status: corev1.ConditionFalse,
+							Reason: util.ReasonSyncing,
 						},
 					},
 					// No change
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
 			expectErr: false,
@@ -322,25 +350,26 @@ func TestSetSyncing(t *testing.T) {
 			t.Fatalf("creating gw %s failed: %v", tc.gw.Name, err)
 		}
 
-		err := setSyncing(cl, tc.namespace, tc.gw)
-
-		gw, err := cl.Gateways(tc.namespace).Get(tc.gw.Name, metav1.GetOptions{})
-		if err != nil {
-			t.Fatalf("getting gw %s failed: %v", tc.gw.Name, err)
-		}
-
+		updated, err := setSyncing(cl, tc.namespace, tc.gw, tc.reason, "message")
 		if tc.expectErr {
 			if err == nil {
 				t.Errorf("expected error, but got no error")
 			}
-		} else {
-			if err != nil {
-				t.Errorf("expected no error, but got %v", err)
-			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected no error, but got %v", err)
+			continue
+		}
 
-			// Compare gw with expected
-			compareGateway(t, tc.expected, gw)
+		gw, err := cl.Gateways(tc.namespace).Get(tc.gw.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("getting gw %s failed: %v", tc.gw.Name, err)
 		}
+
+		// Compare both the stored and the returned gw with expected
+		compareGateway(t, tc.expected, gw)
+		compareGateway(t, tc.expected, updated)
 	}
 }
 
@@ -353,97 +382,86 @@ func TestSetSynced(t *testing.T) {
 		expectErr bool
 	}{
 		{
-			name:      "Normal case (Change RuleSyncing true to false)",
+			name:      "Normal case (Change Synced false to true)",
 			namespace: "ns1",
 			gw: &v1alpha1.Gateway{
 				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "gw1",
+					Namespace:  "ns1",
+					Name:       "gw1",
+					Generation: 2,
 				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// True
-							Status: corev1.ConditionTrue,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// False
 							Status: corev1.ConditionFalse,
+							Reason: util.ReasonSyncing,
 						},
 					},
-					RuleGeneration: 2,
-					SyncGeneration: 1,
+					ObservedGeneration: 1,
 				},
 			},
 			expected: &v1alpha1.Gateway{
-				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "gw1",
-				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Changed to false
-							Status: corev1.ConditionFalse,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Changed to true
+							Status: corev1.ConditionTrue,
+							Reason: util.ReasonSynced,
 						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
-							Status: corev1.ConditionFalse,
+						submarinerv1alpha1.ConditionReady: status.Condition{
+							Type:   submarinerv1alpha1.ConditionReady,
+							Status: corev1.ConditionTrue,
+							Reason: util.ReasonReady,
 						},
 					},
-					// Generations are also synced
-					RuleGeneration: 2,
-					SyncGeneration: 2,
+					// Generation is observed
+					ObservedGeneration: 2,
 				},
 			},
 			expectErr: false,
 		},
 		{
-			name:      "Normal case (RuleSyncing is already false and try to set it to false)",
+			name:      "Normal case (Synced is already true and try to set it to true)",
 			namespace: "ns1",
 			gw: &v1alpha1.Gateway{
 				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "gw1",
+					Namespace:  "ns1",
+					Name:       "gw1",
+					Generation: 2,
 				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Already false
-							Status: corev1.ConditionFalse,
-						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
-							Status: corev1.ConditionFalse,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Already true
+							Status:  corev1.ConditionTrue,
+							Reason:  util.ReasonSynced,
+							Message: "Rules of generation 2 are synced",
 						},
 					},
-					RuleGeneration: 2,
-					SyncGeneration: 2,
+					ObservedGeneration: 2,
 				},
 			},
 			expected: &v1alpha1.Gateway{
-				ObjectMeta: metav1.ObjectMeta{
-					Namespace: "ns1",
-					Name:      "gw1",
-				},
 				Status: v1alpha1.GatewayStatus{
 					Conditions: status.Conditions{
-						submarinerv1alpha1.ConditionRuleSyncing: status.Condition{
-							Type: submarinerv1alpha1.ConditionRuleSyncing,
-							// Remains false
-							Status: corev1.ConditionFalse,
+						submarinerv1alpha1.ConditionSynced: status.Condition{
+							Type: submarinerv1alpha1.ConditionSynced,
+							// Remains true
+							Status: corev1.ConditionTrue,
+							Reason: util.ReasonSynced,
 						},
-						submarinerv1alpha1.ConditionRuleUpdating: status.Condition{
-							Type:   submarinerv1alpha1.ConditionRuleUpdating,
-							Status: corev1.ConditionFalse,
+						submarinerv1alpha1.ConditionReady: status.Condition{
+							Type:   submarinerv1alpha1.ConditionReady,
+							Status: corev1.ConditionTrue,
+							Reason: util.ReasonReady,
 						},
 					},
-					// TODO: consider situation that generations need to be upated?
-					RuleGeneration: 2,
-					SyncGeneration: 2,
+					// No change
+					ObservedGeneration: 2,
 				},
 			},
 			expectErr: false,
@@ -461,24 +479,185 @@ func TestSetSynced(t *testing.T) {
 			t.Fatalf("creating gw %s failed: %v", tc.gw.Name, err)
 		}
 
-		err := setSynced(cl, tc.namespace, tc.gw)
+		updated, err := setSynced(cl, tc.namespace, tc.gw)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error, but got no error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected no error, but got %v", err)
+			continue
+		}
 
 		gw, err := cl.Gateways(tc.namespace).Get(tc.gw.Name, metav1.GetOptions{})
 		if err != nil {
 			t.Fatalf("getting gw %s failed: %v", tc.gw.Name, err)
 		}
 
-		if tc.expectErr {
-			if err == nil {
-				t.Errorf("expected error, but got no error")
-			}
-		} else {
-			if err != nil {
-				t.Errorf("expected no error, but got %v", err)
-			}
+		// Compare both the stored and the returned gw with expected
+		compareGateway(t, tc.expected, gw)
+		compareGateway(t, tc.expected, updated)
+	}
+}
+
+func TestSetWireGuardStatus(t *testing.T) {
+	testCases := []struct {
+		name      string
+		namespace string
+		gw        *v1alpha1.Gateway
+		key       string
+		port      string
+	}{
+		{
+			name:      "Normal case (Set public key and port)",
+			namespace: "ns1",
+			gw: &v1alpha1.Gateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "gw1",
+				},
+			},
+			key:  "gwkey",
+			port: "51820",
+		},
+		{
+			name:      "Normal case (Update port)",
+			namespace: "ns1",
+			gw: &v1alpha1.Gateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "gw1",
+				},
+				Status: v1alpha1.GatewayStatus{
+					WireGuardPublicKey: "gwkey",
+					WireGuardPort:      "51821",
+				},
+			},
+			key:  "gwkey",
+			port: "51820",
+		},
+		{
+			name:      "Normal case (Public key and port are already set)",
+			namespace: "ns1",
+			gw: &v1alpha1.Gateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "gw1",
+				},
+				Status: v1alpha1.GatewayStatus{
+					WireGuardPublicKey: "gwkey",
+					WireGuardPort:      "51820",
+				},
+			},
+			key:  "gwkey",
+			port: "51820",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		vcl := fakeversioned.NewSimpleClientset()
+		cl := &fakev1alpha1.FakeSubmarinerV1alpha1{Fake: &vcl.Fake}
+
+		// Create tc.gw
+		if _, err := cl.Gateways(tc.namespace).Create(tc.gw); err != nil {
+			t.Fatalf("creating gw %s failed: %v", tc.gw.Name, err)
+		}
+
+		if _, err := setWireGuardStatus(cl, tc.namespace, tc.gw, tc.key, tc.port); err != nil {
+			t.Errorf("expected no error, but got %v", err)
+			continue
+		}
+
+		gw, err := cl.Gateways(tc.namespace).Get(tc.gw.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("getting gw %s failed: %v", tc.gw.Name, err)
+		}
+		if gw.Status.WireGuardPublicKey != tc.key || gw.Status.WireGuardPort != tc.port {
+			t.Errorf("expected %q and %q, but got %q and %q", tc.key, tc.port, gw.Status.WireGuardPublicKey, gw.Status.WireGuardPort)
+		}
+	}
+}
+
+func TestSetListenerStatuses(t *testing.T) {
+	testCases := []struct {
+		name      string
+		namespace string
+		gw        *v1alpha1.Gateway
+		listeners []v1alpha1.GatewayListenerStatus
+	}{
+		{
+			name:      "Normal case (Set listeners)",
+			namespace: "ns1",
+			gw: &v1alpha1.Gateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "gw1",
+				},
+			},
+			listeners: []v1alpha1.GatewayListenerStatus{{Port: "80", Forwarders: 2}},
+		},
+		{
+			name:      "Normal case (Update forwarders to none)",
+			namespace: "ns1",
+			gw: &v1alpha1.Gateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "gw1",
+				},
+				Status: v1alpha1.GatewayStatus{
+					IngressListeners: []v1alpha1.GatewayListenerStatus{{Port: "80", Forwarders: 2}},
+				},
+			},
+			listeners: []v1alpha1.GatewayListenerStatus{{Port: "80", Forwarders: 0}},
+		},
+		{
+			name:      "Normal case (Remove listeners)",
+			namespace: "ns1",
+			gw: &v1alpha1.Gateway{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: "ns1",
+					Name:      "gw1",
+				},
+				Status: v1alpha1.GatewayStatus{
+					IngressListeners: []v1alpha1.GatewayListenerStatus{{Port: "80", Forwarders: 2}},
+				},
+			},
+			listeners: []v1alpha1.GatewayListenerStatus{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
 
-			// Compare gw with expected
-			compareGateway(t, tc.expected, gw)
+		vcl := fakeversioned.NewSimpleClientset()
+		cl := &fakev1alpha1.FakeSubmarinerV1alpha1{Fake: &vcl.Fake}
+
+		// Create tc.gw
+		if _, err := cl.Gateways(tc.namespace).Create(tc.gw); err != nil {
+			t.Fatalf("creating gw %s failed: %v", tc.gw.Name, err)
+		}
+
+		if _, err := setListenerStatuses(cl, tc.namespace, tc.gw, tc.listeners); err != nil {
+			t.Errorf("expected no error, but got %v", err)
+			continue
+		}
+
+		gw, err := cl.Gateways(tc.namespace).Get(tc.gw.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("getting gw %s failed: %v", tc.gw.Name, err)
+		}
+		if len(gw.Status.IngressListeners) != len(tc.listeners) {
+			t.Errorf("expected %v, but got %v", tc.listeners, gw.Status.IngressListeners)
+			continue
+		}
+		for i := range tc.listeners {
+			if gw.Status.IngressListeners[i] != tc.listeners[i] {
+				t.Errorf("expected %v, but got %v", tc.listeners, gw.Status.IngressListeners)
+			}
 		}
 	}
 }
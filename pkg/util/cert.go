@@ -0,0 +1,184 @@
+package util
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// TLSCertKey is the key of the certificate in secrets and the file name in the mounted directory
+	TLSCertKey = "tls.crt"
+	// TLSPrivateKeyKey is the key of the private key in secrets and the file name in the mounted directory
+	TLSPrivateKeyKey = "tls.key"
+	// TLSCAKey is the key of the CA certificate in secrets and the file name in the mounted directory
+	TLSCAKey = "ca.crt"
+	// TLSCAPrivateKeyKey is the key of the private key of the CA in the secret of the CA
+	TLSCAPrivateKeyKey = "ca.key"
+	// RelayTLSDir is the directory where the secret of the certificate is mounted in forwarder pods
+	RelayTLSDir = "/etc/relay-tls"
+)
+
+// TLSSecretName returns the name of the secret in the connector namespace that contains the certificate
+// for TLS transport of the forwarder or the gateway named {name}
+func TLSSecretName(name string) string {
+	return name + "-tls"
+}
+
+// GenerateCA returns a new self-signed CA certificate and its private key in PEM format,
+// which is valid for {validity}
+func GenerateCA(commonName string, validity time.Duration) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl, err := certificateTemplate(commonName, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encodeCertificate(der, key)
+}
+
+// IssueCertificate returns a new certificate for {commonName} and its private key in PEM format,
+// which is signed by the CA of {caCertPEM} and {caKeyPEM} and valid for {validity}.
+// The certificate is used for {usage}, and {ips} are set to its subject alternative names.
+func IssueCertificate(caCertPEM, caKeyPEM []byte, commonName string, ips []string, usage x509.ExtKeyUsage, validity time.Duration) ([]byte, []byte, error) {
+	ca, err := tls.X509KeyPair(caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CA: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CA certificate: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl, err := certificateTemplate(commonName, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	for _, ip := range ips {
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return nil, nil, fmt.Errorf("invalid IP %q", ip)
+		}
+		tmpl.IPAddresses = append(tmpl.IPAddresses, parsed)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, ca.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encodeCertificate(der, key)
+}
+
+// CertificateRenewTime returns the time when the certificate of {certPEM} needs to be renewed,
+// which is when two thirds of its validity have passed
+func CertificateRenewTime(certPEM []byte) (time.Time, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("no certificate found in PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+
+	return cert.NotBefore.Add(lifetime * 2 / 3), nil
+}
+
+// NewTLSConfig returns tls.Config that presents the certificate of {certPEM} and {keyPEM},
+// and trusts only the CA of {caPEM} for both servers and clients
+func NewTLSConfig(certPEM, keyPEM, caPEM []byte) (*tls.Config, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no CA certificate found in PEM")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// LoadTLSConfig returns TLSConfigLoader that loads tls.Config from the certificate, the private key
+// and the CA certificate in {dir}. Files are read on each call, so that rotated ones are used.
+func LoadTLSConfig(dir string) TLSConfigLoader {
+	return func() (*tls.Config, error) {
+		certPEM, err := ioutil.ReadFile(filepath.Join(dir, TLSCertKey))
+		if err != nil {
+			return nil, err
+		}
+		keyPEM, err := ioutil.ReadFile(filepath.Join(dir, TLSPrivateKeyKey))
+		if err != nil {
+			return nil, err
+		}
+		caPEM, err := ioutil.ReadFile(filepath.Join(dir, TLSCAKey))
+		if err != nil {
+			return nil, err
+		}
+
+		return NewTLSConfig(certPEM, keyPEM, caPEM)
+	}
+}
+
+// certificateTemplate returns a template of certificate for {commonName} valid for {validity} from now
+func certificateTemplate(commonName string, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	// Allow clock skew between the issuer and the peers
+	now := time.Now().Add(-5 * time.Minute)
+
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now,
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+// encodeCertificate returns {der} of a certificate and {key} in PEM format
+func encodeCertificate(der []byte, key *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
+}
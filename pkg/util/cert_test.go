@@ -0,0 +1,92 @@
+package util
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+)
+
+func TestIssueCertificate(t *testing.T) {
+	caCert, caKey, err := GenerateCA("test-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to generate CA: %v", err)
+	}
+
+	testCases := []struct {
+		name        string
+		ips         []string
+		usage       x509.ExtKeyUsage
+		validity    time.Duration
+		expectError bool
+	}{
+		{
+			name:        "Normal case (server certificate)",
+			ips:         []string{"192.168.122.200"},
+			usage:       x509.ExtKeyUsageServerAuth,
+			validity:    90 * 24 * time.Hour,
+			expectError: false,
+		},
+		{
+			name:        "Normal case (client certificate without ips)",
+			ips:         nil,
+			usage:       x509.ExtKeyUsageClientAuth,
+			validity:    90 * 24 * time.Hour,
+			expectError: false,
+		},
+		{
+			name:        "Error case (invalid ip)",
+			ips:         []string{"192.168.122"},
+			usage:       x509.ExtKeyUsageServerAuth,
+			validity:    90 * 24 * time.Hour,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		certPEM, keyPEM, err := IssueCertificate(caCert, caKey, "test", tc.ips, tc.usage, tc.validity)
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("expected error, but no error returned")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected no error, but got error %v", err)
+			continue
+		}
+
+		// The certificate should be verified by the CA for the usage and the ips
+		config, err := NewTLSConfig(certPEM, keyPEM, caCert)
+		if err != nil {
+			t.Errorf("expected no error on creating tls config, but got error %v", err)
+			continue
+		}
+		block, _ := pem.Decode(certPEM)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Errorf("expected no error on parsing certificate, but got error %v", err)
+			continue
+		}
+		opts := x509.VerifyOptions{Roots: config.RootCAs, KeyUsages: []x509.ExtKeyUsage{tc.usage}}
+		if _, err := cert.Verify(opts); err != nil {
+			t.Errorf("expected certificate to be verified, but got error %v", err)
+		}
+		for _, ip := range tc.ips {
+			if err := cert.VerifyHostname(ip); err != nil {
+				t.Errorf("expected certificate to be valid for %s, but got error %v", ip, err)
+			}
+		}
+
+		// Renew time should be two thirds of the validity
+		renew, err := CertificateRenewTime(certPEM)
+		if err != nil {
+			t.Errorf("expected no error on getting renew time, but got error %v", err)
+		}
+		if expect := cert.NotBefore.Add(tc.validity * 2 / 3); !renew.Equal(expect) {
+			t.Errorf("expected renew time %v, but got %v", expect, renew)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package util
 
 import (
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/glog"
 	clv1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned/typed/submariner/v1alpha1"
@@ -12,24 +14,40 @@ import (
 	"k8s.io/client-go/util/workqueue"
 )
 
+// Result is the result of reconciling an item
+type Result struct {
+	// RequeueAfter is the duration after which the item is reconciled again, regardless of
+	// the resync of the informer. The item isn't requeued if 0.
+	RequeueAfter time.Duration
+}
+
 // ReconcilerInterface is an interface for reconciler
 type ReconcilerInterface interface {
-	Reconcile(namespace, name string) error
+	Reconcile(namespace, name string) (Result, error)
 }
 
 // Controller represents a cotroller
 type Controller struct {
+	// processingSince is the time in unix nano that processing the current item started,
+	// or 0 if no item is processed. It is accessed atomically, so keep it 64-bit aligned.
+	processingSince int64
+	// stopped is set to 1 once the controller stops. It is accessed atomically.
+	stopped int32
+
+	name       string
 	clientset  clv1alpha1.SubmarinerV1alpha1Interface
 	informer   cache.SharedIndexInformer
 	workqueue  workqueue.RateLimitingInterface
 	reconciler ReconcilerInterface
 }
 
-// NewController returns a controller instance
-func NewController(cl clv1alpha1.SubmarinerV1alpha1Interface, informerFactory sbinformers.SharedInformerFactory, informer cache.SharedIndexInformer, reconciler ReconcilerInterface) *Controller {
+// NewController returns a controller instance.
+// {name} identifies the controller in metrics.
+func NewController(name string, cl clv1alpha1.SubmarinerV1alpha1Interface, informerFactory sbinformers.SharedInformerFactory, informer cache.SharedIndexInformer, reconciler ReconcilerInterface) *Controller {
 	wq := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 
 	controller := &Controller{
+		name:       name,
 		clientset:  cl,
 		informer:   informer,
 		workqueue:  wq,
@@ -74,6 +92,7 @@ func getKey(obj interface{}) string {
 func (c *Controller) Run() {
 	defer utilruntime.HandleCrash()
 	defer c.workqueue.ShutDown()
+	defer atomic.StoreInt32(&c.stopped, 1)
 
 	if ok := cache.WaitForCacheSync(wait.NeverStop, c.informer.HasSynced); !ok {
 		glog.Fatalf("time out while waiting cache to be synced")
@@ -94,6 +113,9 @@ func (c *Controller) processNext() bool {
 		return false
 	}
 
+	atomic.StoreInt64(&c.processingSince, time.Now().UnixNano())
+	defer atomic.StoreInt64(&c.processingSince, 0)
+
 	err := func(obj interface{}) error {
 		defer c.workqueue.Done(obj)
 		key, ok := obj.(string)
@@ -107,11 +129,16 @@ func (c *Controller) processNext() bool {
 			return err
 		}
 
-		if err := c.reconciler.Reconcile(namespace, name); err != nil {
+		result, err := c.reconciler.Reconcile(namespace, name)
+		observeReconcile(c.name, err)
+		if err != nil {
 			c.workqueue.AddRateLimited(key)
 			return fmt.Errorf("error syncing %q: %v", key, err)
 		}
 		c.workqueue.Forget(obj)
+		if result.RequeueAfter > 0 {
+			c.workqueue.AddAfter(key, result.RequeueAfter)
+		}
 		return nil
 	}(obj)
 
@@ -122,3 +149,21 @@ func (c *Controller) processNext() bool {
 
 	return true
 }
+
+// Healthz checks that the controller loop is alive.
+// It returns error if the controller is stopped or processing an item takes longer than {timeout}.
+func (c *Controller) Healthz(timeout time.Duration) error {
+	if atomic.LoadInt32(&c.stopped) != 0 {
+		return fmt.Errorf("controller %q is stopped", c.name)
+	}
+	since := atomic.LoadInt64(&c.processingSince)
+	if since == 0 {
+		// Waiting for next item
+		return nil
+	}
+	if elapsed := time.Since(time.Unix(0, since)); elapsed > timeout {
+		return fmt.Errorf("controller %q is stuck in processing an item for %v", c.name, elapsed)
+	}
+
+	return nil
+}
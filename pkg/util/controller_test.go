@@ -2,6 +2,7 @@ package util
 
 import (
 	"context"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,9 +19,20 @@ type FakeReconciler struct {
 
 var _ ReconcilerInterface = &FakeReconciler{}
 
-func (g *FakeReconciler) Reconcile(namespace, name string) error {
+func (g *FakeReconciler) Reconcile(namespace, name string) (Result, error) {
 	// Always succeeds
-	return nil
+	return Result{}, nil
+}
+
+// countingReconciler counts reconciles and requeues items after requeueAfter
+type countingReconciler struct {
+	requeueAfter time.Duration
+	count        int32
+}
+
+func (r *countingReconciler) Reconcile(namespace, name string) (Result, error) {
+	atomic.AddInt32(&r.count, 1)
+	return Result{RequeueAfter: r.requeueAfter}, nil
 }
 
 func newFakeController() *Controller {
@@ -28,7 +40,7 @@ func newFakeController() *Controller {
 	cl := &fakev1alpha1.FakeSubmarinerV1alpha1{Fake: &vcl.Fake}
 	informerFactory := sbinformers.NewSharedInformerFactory(vcl, time.Second*30)
 	informer := informerFactory.Submariner().V1alpha1().Gateways().Informer()
-	return NewController(cl, informerFactory, informer, &FakeReconciler{})
+	return NewController("fake", cl, informerFactory, informer, &FakeReconciler{})
 }
 
 func TestEnqueue(t *testing.T) {
@@ -173,7 +185,7 @@ func TestRun(t *testing.T) {
 		} else {
 			t.Fatalf("invalid objType %s specified", tc.objType)
 		}
-		controller := NewController(cl, informerFactory, informer, &FakeReconciler{})
+		controller := NewController("fake", cl, informerFactory, informer, &FakeReconciler{})
 
 		// Call controller run
 		ctx, cancel := context.WithCancel(context.Background())
@@ -204,3 +216,98 @@ func TestRun(t *testing.T) {
 		// TODO: Check if reconcile is called
 	}
 }
+
+func TestRequeueAfter(t *testing.T) {
+	testCases := []struct {
+		name         string
+		requeueAfter time.Duration
+		minCount     int32
+		maxCount     int32
+	}{
+		{
+			name:         "Normal case (requeued periodically)",
+			requeueAfter: 100 * time.Millisecond,
+			// Reconciled on add and requeued several times in a second
+			minCount: 3,
+			maxCount: 20,
+		},
+		{
+			name:         "Normal case (not requeued)",
+			requeueAfter: 0,
+			// Reconciled only on add, because informer resync is longer than the test
+			minCount: 1,
+			maxCount: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		vcl := fakeversioned.NewSimpleClientset()
+		cl := &fakev1alpha1.FakeSubmarinerV1alpha1{Fake: &vcl.Fake}
+		informerFactory := sbinformers.NewSharedInformerFactory(vcl, time.Second*30)
+		informer := informerFactory.Submariner().V1alpha1().Gateways().Informer()
+		reconciler := &countingReconciler{requeueAfter: tc.requeueAfter}
+		controller := NewController("fake", cl, informerFactory, informer, reconciler)
+		go controller.Run()
+
+		gw := &v1alpha1.Gateway{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "gw1"}}
+		if _, err := cl.Gateways("ns1").Create(gw); err != nil {
+			t.Fatalf("creating gateway %v failed: %v", gw, err)
+		}
+		time.Sleep(time.Second)
+		controller.workqueue.ShutDown()
+
+		if count := atomic.LoadInt32(&reconciler.count); count < tc.minCount || count > tc.maxCount {
+			t.Errorf("expected reconciled %d to %d times, but got %d", tc.minCount, tc.maxCount, count)
+		}
+	}
+}
+
+func TestControllerHealthz(t *testing.T) {
+	testCases := []struct {
+		name            string
+		processingSince time.Time
+		stopped         bool
+		expectErr       bool
+	}{
+		{
+			name:      "Normal case (waiting for next item)",
+			expectErr: false,
+		},
+		{
+			name:            "Normal case (processing an item)",
+			processingSince: time.Now(),
+			expectErr:       false,
+		},
+		{
+			name:            "Error case (stuck in processing an item)",
+			processingSince: time.Now().Add(-2 * time.Minute),
+			expectErr:       true,
+		},
+		{
+			name:      "Error case (stopped)",
+			stopped:   true,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		controller := &Controller{name: "fake"}
+		if !tc.processingSince.IsZero() {
+			controller.processingSince = tc.processingSince.UnixNano()
+		}
+		if tc.stopped {
+			controller.stopped = 1
+		}
+
+		err := controller.Healthz(time.Minute)
+		if tc.expectErr && err == nil {
+			t.Errorf("expected error, but got no error")
+		}
+		if !tc.expectErr && err != nil {
+			t.Errorf("expected no error, but got %v", err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package util
+
+import (
+	"github.com/golang/glog"
+	"github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
+	"github.com/mkimuram/k8s-ext-connector/pkg/client/clientset/versioned/scheme"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// ExternalServiceNamespaceLabel is the label for namespace of external service
+	ExternalServiceNamespaceLabel = "externalservice.submariner.io/namespace"
+	// ExternalServiceNameLabel is the label for name of external service
+	ExternalServiceNameLabel = "externalservice.submariner.io/name"
+	// ExternalServiceUIDAnnotation is the annotation for uid of external service
+	ExternalServiceUIDAnnotation = "externalservice.submariner.io/uid"
+
+	// Reasons of events recorded on external services
+	// ReasonCreated is used when resources for an external service are created
+	ReasonCreated = "Created"
+	// ReasonFailedCreate is used when resources for an external service fail to be created
+	ReasonFailedCreate = "FailedCreate"
+	// ReasonScaled is used when forwarder deployment for an external service is scaled
+	ReasonScaled = "Scaled"
+	// ReasonUpdated is used when resources for an external service are updated
+	ReasonUpdated = "Updated"
+	// ReasonFailedUpdate is used when resources for an external service fail to be updated
+	ReasonFailedUpdate = "FailedUpdate"
+	// ReasonForwarderNoIP is used when the forwarder pod has no IP address assigned yet
+	ReasonForwarderNoIP = "ForwarderNoIP"
+	// ReasonRelayPortExhausted is used when no relay port is available for a rule
+	ReasonRelayPortExhausted = "RelayPortExhausted"
+	// ReasonFailedUpdateRules is used when rules for forwarder or gateways fail to be updated
+	ReasonFailedUpdateRules = "FailedUpdateRules"
+	// ReasonResolved is used when the target host of an external service is resolved to a new address
+	ReasonResolved = "Resolved"
+	// ReasonFailedResolve is used when the target host of an external service fails to be resolved
+	ReasonFailedResolve = "FailedResolve"
+	// ReasonFailedCleanup is used when resources for a deleted external service fail to be cleaned up
+	ReasonFailedCleanup = "FailedCleanup"
+	// ReasonSynced is used when rules are synced in forwarder or gateway
+	ReasonSynced = "Synced"
+	// ReasonNotSynced is used when rules once synced are found not synced any more
+	ReasonNotSynced = "NotSynced"
+	// ReasonFailedSyncTunnel is used when ssh tunnels fail to be synced
+	ReasonFailedSyncTunnel = "FailedSyncTunnel"
+	// ReasonTunnelFailed is used when ssh tunnels give up retrying
+	ReasonTunnelFailed = "TunnelFailed"
+	// ReasonFailedSyncIptables is used when iptables rules fail to be synced
+	ReasonFailedSyncIptables = "FailedSyncIptables"
+	// ReasonFailedSshd is used when ssh server in gateway fails to run
+	ReasonFailedSshd = "FailedSshd"
+	// ReasonFailedRelay is used when mTLS relay server in gateway fails to run
+	ReasonFailedRelay = "FailedRelay"
+	// ReasonFailedListener is used when userspace listeners for ingress in gateway fail to listen
+	ReasonFailedListener = "FailedListener"
+
+	// Reasons of conditions in forwarder and gateway statuses, in addition to ReasonSynced and ReasonNotSynced
+	// ReasonSyncing is used while rules for a new generation are being synced
+	ReasonSyncing = "Syncing"
+	// ReasonReady is used when synced rules are ready to forward connections
+	ReasonReady = "Ready"
+	// ReasonNotReady is used when synced rules are not ready to forward connections yet
+	ReasonNotReady = "NotReady"
+)
+
+// NewEventRecorder returns an EventRecorder that records events as {component} via {kcl}
+func NewEventRecorder(kcl kubernetes.Interface, component string) record.EventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(glog.Infof)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kcl.CoreV1().Events("")})
+
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: component})
+}
+
+// ExternalServiceRef returns a reference to the external service that {fwd} belongs to,
+// so that events can be recorded on it. Nil is returned if {fwd} doesn't have the labels for it.
+func ExternalServiceRef(fwd *v1alpha1.Forwarder) *corev1.ObjectReference {
+	namespace, ok1 := fwd.Labels[ExternalServiceNamespaceLabel]
+	name, ok2 := fwd.Labels[ExternalServiceNameLabel]
+	if !ok1 || !ok2 {
+		return nil
+	}
+
+	return ExternalServiceObjectRef(namespace, name, types.UID(fwd.Annotations[ExternalServiceUIDAnnotation]))
+}
+
+// ExternalServiceObjectRef returns a reference to the external service {namespace}/{name} with {uid},
+// so that events can be recorded on it
+func ExternalServiceObjectRef(namespace, name string, uid types.UID) *corev1.ObjectReference {
+	return &corev1.ObjectReference{
+		APIVersion: v1alpha1.SchemeGroupVersion.String(),
+		Kind:       "ExternalService",
+		Namespace:  namespace,
+		Name:       name,
+		UID:        uid,
+	}
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExternalServiceRef(t *testing.T) {
+	testCases := []struct {
+		name     string
+		fwd      *v1alpha1.Forwarder
+		expected *corev1.ObjectReference
+	}{
+		{
+			name: "Normal case",
+			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "es1",
+					Namespace: "external-services",
+					Labels: map[string]string{
+						ExternalServiceNamespaceLabel: "ns1",
+						ExternalServiceNameLabel:      "es1",
+					},
+					Annotations: map[string]string{
+						ExternalServiceUIDAnnotation: "c1d2e3f4-0000-1111-2222-333344445555",
+					},
+				},
+			},
+			expected: &corev1.ObjectReference{
+				APIVersion: "submariner.io/v1alpha1",
+				Kind:       "ExternalService",
+				Namespace:  "ns1",
+				Name:       "es1",
+				UID:        "c1d2e3f4-0000-1111-2222-333344445555",
+			},
+		},
+		{
+			name: "Normal case (no labels)",
+			fwd: &v1alpha1.Forwarder{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "es1",
+					Namespace: "external-services",
+				},
+			},
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		ref := ExternalServiceRef(tc.fwd)
+
+		if !reflect.DeepEqual(tc.expected, ref) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, ref)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+)
+
+const (
+	// HealthzPath is the path to check liveness
+	HealthzPath = "/healthz"
+	// ReadyzPath is the path to check readiness
+	ReadyzPath = "/readyz"
+	// HealthPort is the default port to serve health checks, which needs to be out of the range of relay ports
+	HealthPort = 2020
+)
+
+// ServeHealth serves the results of {healthz} and {readyz} on {addr}.
+// It blocks until the server fails.
+func ServeHealth(addr string, healthz, readyz func() error) error {
+	mux := http.NewServeMux()
+	mux.Handle(HealthzPath, checkHandler(healthz))
+	mux.Handle(ReadyzPath, checkHandler(readyz))
+
+	return http.ListenAndServe(addr, mux)
+}
+
+// checkHandler returns a handler that responds 200 if {check} succeeds, or 503 with the error otherwise
+func checkHandler(check func() error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := check(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckHandler(t *testing.T) {
+	testCases := []struct {
+		name         string
+		check        func() error
+		expectStatus int
+	}{
+		{
+			name:         "Normal case (check succeeds)",
+			check:        func() error { return nil },
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "Normal case (check fails)",
+			check:        func() error { return fmt.Errorf("not ready") },
+			expectStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		rec := httptest.NewRecorder()
+		checkHandler(tc.check).ServeHTTP(rec, httptest.NewRequest("GET", ReadyzPath, nil))
+
+		if tc.expectStatus != rec.Code {
+			t.Errorf("expected status %d, but got %d", tc.expectStatus, rec.Code)
+		}
+	}
+}
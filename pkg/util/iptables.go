@@ -1,10 +1,16 @@
 package util
 
-import "github.com/coreos/go-iptables/iptables"
+import (
+	"strconv"
+
+	"github.com/coreos/go-iptables/iptables"
+)
 
 const (
 	// TableNAT represents nat table in iptables
 	TableNAT = "nat"
+	// TableMangle represents mangle table in iptables
+	TableMangle = "mangle"
 	// ChainPrerouting represents PREROUTING chain in iptables
 	ChainPrerouting = "PREROUTING"
 	// ChainPostrouting represents POSTROUTING chain in iptables
@@ -16,11 +22,28 @@ func DNATRuleSpec(dstIP, srcIP, dPort, destinationIP, destinationPort string) []
 	return []string{"-m", "tcp", "-p", "tcp", "--dst", dstIP, "--src", srcIP, "--dport", dPort, "-j", "DNAT", "--to-destination", destinationIP + ":" + destinationPort}
 }
 
+// DNATRuleSpecWithProbability returns ruleSpec to DNAT for the given arguments,
+// which matches only with {probability} by using statistic module
+func DNATRuleSpecWithProbability(dstIP, srcIP, dPort, destinationIP, destinationPort string, probability float64) []string {
+	return []string{"-m", "tcp", "-p", "tcp", "--dst", dstIP, "--src", srcIP, "--dport", dPort, "-m", "statistic", "--mode", "random", "--probability", strconv.FormatFloat(probability, 'f', 5, 64), "-j", "DNAT", "--to-destination", destinationIP + ":" + destinationPort}
+}
+
 // SNATRuleSpec returns ruleSpec to SNAT for the given arguments
 func SNATRuleSpec(dstIP, srcIP, dPort string) []string {
 	return []string{"-m", "tcp", "-p", "tcp", "--dst", dstIP, "--dport", dPort, "-j", "SNAT", "--to-source", srcIP}
 }
 
+// DNATToIPRuleSpec returns ruleSpec to DNAT for the given arguments, which keeps the original destination port.
+// It is used for port ranges, whose ports are DNATed to the same ports.
+func DNATToIPRuleSpec(dstIP, srcIP, dPort, destinationIP string) []string {
+	return []string{"-m", "tcp", "-p", "tcp", "--dst", dstIP, "--src", srcIP, "--dport", dPort, "-j", "DNAT", "--to-destination", destinationIP}
+}
+
+// MarkRuleSpec returns ruleSpec to set {mark} to packets for the given arguments
+func MarkRuleSpec(dstIP, srcIP, dPort, mark string) []string {
+	return []string{"-m", "tcp", "-p", "tcp", "--dst", dstIP, "--src", srcIP, "--dport", dPort, "-j", "MARK", "--set-xmark", mark}
+}
+
 // Defining used interfaces in iptables-go to use mock in unit test
 type iptInterface interface {
 	ClearChain(table, chain string) error
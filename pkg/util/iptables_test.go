@@ -100,6 +100,50 @@ func TestSNATRuleSpec(t *testing.T) {
 	}
 }
 
+func TestMarkRuleSpec(t *testing.T) {
+	testCases := []struct {
+		name     string
+		dstIP    string
+		srcIP    string
+		dPort    string
+		mark     string
+		spec     []string
+		expected bool
+	}{
+		{
+			name:     "Normal case (should return the same result)",
+			dstIP:    "10.244.0.34",
+			srcIP:    "10.244.0.11",
+			dPort:    "8000",
+			mark:     "0x1000000/0xff000000",
+			spec:     []string{"-m", "tcp", "-p", "tcp", "--dst", "10.244.0.34", "--src", "10.244.0.11", "--dport", "8000", "-j", "MARK", "--set-xmark", "0x1000000/0xff000000"},
+			expected: true,
+		},
+		{
+			name:  "Error case (should return the different result)",
+			dstIP: "10.244.0.34",
+			srcIP: "10.244.0.11",
+			dPort: "8000",
+			// mark is different
+			mark:     "0x2000000/0xff000000",
+			spec:     []string{"-m", "tcp", "-p", "tcp", "--dst", "10.244.0.34", "--src", "10.244.0.11", "--dport", "8000", "-j", "MARK", "--set-xmark", "0x1000000/0xff000000"},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		spec := MarkRuleSpec(tc.dstIP, tc.srcIP, tc.dPort, tc.mark)
+		if reflect.DeepEqual(spec, tc.spec) != tc.expected {
+			if tc.expected {
+				t.Errorf("expecting spec %s, but got %s", tc.spec, spec)
+			} else {
+				t.Errorf("not expecting spec %s, and got %s", tc.spec, spec)
+			}
+		}
+	}
+}
+
 var (
 	preRoutingRule1  = []string{"-m", "tcp", "-p", "tcp", "--dst", "192.168.122.201", "--src", "192.16    8.122.140", "--dport", "80", "-j", "DNAT", "--to-destination", "192.168.122.200:2049"}
 	preRoutingRule2  = []string{"-m", "tcp", "-p", "tcp", "--dst", "192.168.122.202", "--src", "192.16    8.122.140", "--dport", "80", "-j", "DNAT", "--to-destination", "192.168.122.200:2049"}
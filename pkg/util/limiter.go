@@ -0,0 +1,253 @@
+package util
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// copyBufferSize is the size of buffer used to copy data between connections,
+	// which is the same to io.Copy
+	copyBufferSize = 32 * 1024
+)
+
+// Limits represents limits on connections forwarded
+type Limits struct {
+	// MaxConnections is the maximum number of concurrent connections. Unlimited if 0.
+	MaxConnections int
+	// BytesPerSecond is the maximum bandwidth in bytes per second. Unlimited if 0.
+	BytesPerSecond int64
+}
+
+// LimiterCounters represents counters of a Limiter
+type LimiterCounters struct {
+	// ActiveConnections is the number of connections being forwarded
+	ActiveConnections int
+	// RejectedConnections is the number of connections rejected by MaxConnections
+	RejectedConnections int64
+	// ThrottledTransfers is the number of transfers delayed by BytesPerSecond
+	ThrottledTransfers int64
+}
+
+// Limiter limits the number of concurrent connections and the bandwidth for them.
+// A Limiter can be shared among multiple tunnels to apply the limits to them in total.
+// Nil Limiter is valid and means unlimited.
+type Limiter struct {
+	// rejected and throttled are accessed atomically, so keep them 64-bit aligned
+	rejected  int64
+	throttled int64
+
+	// mutex protects limits, active, bucket and metrics
+	mutex   sync.Mutex
+	limits  Limits
+	active  int
+	bucket  *rate.Limiter
+	metrics *limiterMetrics
+}
+
+// limiterMetrics is a set of metrics for a Limiter
+type limiterMetrics struct {
+	rejected  prometheus.Counter
+	throttled prometheus.Counter
+}
+
+// NewLimiter returns a Limiter instance with {limits}
+func NewLimiter(limits Limits) *Limiter {
+	l := &Limiter{}
+	l.SetLimits(limits)
+
+	return l
+}
+
+// SetLimits changes limits of the Limiter.
+// New limits are applied to existing connections as well, but connections
+// exceeding new MaxConnections are not closed.
+func (l *Limiter) SetLimits(limits Limits) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.limits = limits
+	if limits.BytesPerSecond <= 0 {
+		l.bucket = nil
+		return
+	}
+
+	// Burst is capped by the buffer size, so that it won't exceed
+	// bandwidth too much just after idle time
+	burst := copyBufferSize
+	if limits.BytesPerSecond < int64(burst) {
+		burst = int(limits.BytesPerSecond)
+	}
+	if l.bucket == nil {
+		l.bucket = rate.NewLimiter(rate.Limit(limits.BytesPerSecond), burst)
+		return
+	}
+	l.bucket.SetLimit(rate.Limit(limits.BytesPerSecond))
+	l.bucket.SetBurst(burst)
+}
+
+// SetMetricsLabels makes the Limiter record metrics with labels of {externalService} and {gateway}
+func (l *Limiter) SetMetricsLabels(externalService, gateway string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.metrics = &limiterMetrics{
+		rejected:  limiterRejected.WithLabelValues(externalService, gateway),
+		throttled: limiterThrottled.WithLabelValues(externalService, gateway),
+	}
+}
+
+// Limits returns the current limits of the Limiter
+func (l *Limiter) Limits() Limits {
+	if l == nil {
+		return Limits{}
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.limits
+}
+
+// Counters returns the current counters of the Limiter
+func (l *Limiter) Counters() LimiterCounters {
+	if l == nil {
+		return LimiterCounters{}
+	}
+	l.mutex.Lock()
+	active := l.active
+	l.mutex.Unlock()
+
+	return LimiterCounters{
+		ActiveConnections:   active,
+		RejectedConnections: atomic.LoadInt64(&l.rejected),
+		ThrottledTransfers:  atomic.LoadInt64(&l.throttled),
+	}
+}
+
+// acquire reserves a slot for a new connection.
+// It returns false and counts up rejected connections, if there is no slot available.
+// release() needs to be called once the connection is closed.
+func (l *Limiter) acquire() bool {
+	if l == nil {
+		return true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.limits.MaxConnections > 0 && l.active >= l.limits.MaxConnections {
+		atomic.AddInt64(&l.rejected, 1)
+		if l.metrics != nil {
+			l.metrics.rejected.Inc()
+		}
+		return false
+	}
+	l.active++
+
+	return true
+}
+
+// release releases a slot reserved by acquire()
+func (l *Limiter) release() {
+	if l == nil {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.active--
+}
+
+// getBucket returns the token bucket for bandwidth and the max size to take from it at once.
+// Nil is returned if bandwidth is unlimited.
+func (l *Limiter) getBucket() (*rate.Limiter, int) {
+	if l == nil {
+		return nil, copyBufferSize
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.bucket == nil {
+		return nil, copyBufferSize
+	}
+	return l.bucket, l.bucket.Burst()
+}
+
+// wait blocks until {n} bytes can be transferred within the bandwidth.
+// Waiting doesn't stop on cancel of the tunnel, so that draining connections are also limited.
+func (l *Limiter) wait(bucket *rate.Limiter, n int) {
+	r := bucket.ReserveN(time.Now(), n)
+	if !r.OK() {
+		// Burst is changed to smaller one after the bucket is taken, so just send it
+		return
+	}
+	if delay := r.Delay(); delay > 0 {
+		atomic.AddInt64(&l.throttled, 1)
+		l.mutex.Lock()
+		if l.metrics != nil {
+			l.metrics.throttled.Inc()
+		}
+		l.mutex.Unlock()
+		time.Sleep(delay)
+	}
+}
+
+// copy copies from {src} to {dst} like io.Copy, but within the bandwidth of the Limiter
+func (l *Limiter) copy(dst io.Writer, src io.Reader) (int64, error) {
+	if l == nil {
+		return io.Copy(dst, src)
+	}
+
+	buf := make([]byte, copyBufferSize)
+	var written int64
+	for {
+		bucket, size := l.getBucket()
+		nr, rerr := src.Read(buf[:size])
+		if nr > 0 {
+			if bucket != nil {
+				l.wait(bucket, nr)
+			}
+			nw, werr := dst.Write(buf[:nr])
+			if nw > 0 {
+				written += int64(nw)
+			}
+			if werr != nil {
+				return written, werr
+			}
+			if nr != nw {
+				return written, io.ErrShortWrite
+			}
+		}
+		if rerr != nil {
+			if rerr == io.EOF {
+				return written, nil
+			}
+			return written, rerr
+		}
+	}
+}
+
+// SyncLimiters makes {limiters} have Limiter with the limits in {expected} for each key.
+// Limits of existing Limiters are changed in place, so that tunnels using them keep working
+// with new limits. Limiters for keys not in {expected} are deleted.
+func SyncLimiters(limiters map[string]*Limiter, expected map[string]Limits) {
+	for k := range limiters {
+		if _, ok := expected[k]; !ok {
+			delete(limiters, k)
+		}
+	}
+
+	for k, limits := range expected {
+		if l, ok := limiters[k]; ok {
+			if l.Limits() != limits {
+				l.SetLimits(limits)
+			}
+			continue
+		}
+		limiters[k] = NewLimiter(limits)
+	}
+}
@@ -0,0 +1,188 @@
+package util
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLimiterAcquire(t *testing.T) {
+	testCases := []struct {
+		name           string
+		limiter        *Limiter
+		acquire        int
+		release        int
+		expectAccepted int
+		expectCounters LimiterCounters
+	}{
+		{
+			name:           "Normal case (nil limiter)",
+			limiter:        nil,
+			acquire:        3,
+			release:        0,
+			expectAccepted: 3,
+			expectCounters: LimiterCounters{},
+		},
+		{
+			name:           "Normal case (unlimited)",
+			limiter:        NewLimiter(Limits{}),
+			acquire:        3,
+			release:        0,
+			expectAccepted: 3,
+			expectCounters: LimiterCounters{ActiveConnections: 3},
+		},
+		{
+			name:           "Normal case (exceeding max connections)",
+			limiter:        NewLimiter(Limits{MaxConnections: 2}),
+			acquire:        3,
+			release:        0,
+			expectAccepted: 2,
+			expectCounters: LimiterCounters{ActiveConnections: 2, RejectedConnections: 1},
+		},
+		{
+			name:           "Normal case (acquire after release)",
+			limiter:        NewLimiter(Limits{MaxConnections: 2}),
+			acquire:        2,
+			release:        1,
+			expectAccepted: 3,
+			expectCounters: LimiterCounters{ActiveConnections: 2},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		accepted := 0
+		for i := 0; i < tc.acquire; i++ {
+			if tc.limiter.acquire() {
+				accepted++
+			}
+		}
+		for i := 0; i < tc.release; i++ {
+			tc.limiter.release()
+			if tc.limiter.acquire() {
+				accepted++
+			}
+		}
+
+		if tc.expectAccepted != accepted {
+			t.Errorf("expected %d accepted, but got %d", tc.expectAccepted, accepted)
+		}
+		if counters := tc.limiter.Counters(); !reflect.DeepEqual(tc.expectCounters, counters) {
+			t.Errorf("expected %v, but got %v", tc.expectCounters, counters)
+		}
+	}
+}
+
+func TestLimiterCopy(t *testing.T) {
+	testCases := []struct {
+		name            string
+		limiter         *Limiter
+		size            int
+		expectMinTime   time.Duration
+		expectThrottled bool
+	}{
+		{
+			name:            "Normal case (nil limiter)",
+			limiter:         nil,
+			size:            100 * 1024,
+			expectMinTime:   0,
+			expectThrottled: false,
+		},
+		{
+			name:            "Normal case (unlimited)",
+			limiter:         NewLimiter(Limits{MaxConnections: 1}),
+			size:            100 * 1024,
+			expectMinTime:   0,
+			expectThrottled: false,
+		},
+		{
+			name:    "Normal case (limited bandwidth)",
+			limiter: NewLimiter(Limits{BytesPerSecond: 64 * 1024}),
+			size:    96 * 1024,
+			// The first 32KiB is sent as burst, then the rest 64KiB takes a second
+			expectMinTime:   time.Second - 100*time.Millisecond,
+			expectThrottled: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		src := bytes.Repeat([]byte("a"), tc.size)
+		dst := &bytes.Buffer{}
+
+		start := time.Now()
+		n, err := tc.limiter.copy(dst, bytes.NewReader(src))
+		elapsed := time.Since(start)
+
+		if err != nil {
+			t.Errorf("expected no error, but got error %v", err)
+		}
+		if int64(tc.size) != n || !bytes.Equal(src, dst.Bytes()) {
+			t.Errorf("expected %d bytes copied, but got %d bytes", tc.size, n)
+		}
+		if elapsed < tc.expectMinTime {
+			t.Errorf("expected copy to take at least %v, but took %v", tc.expectMinTime, elapsed)
+		}
+		if throttled := tc.limiter.Counters().ThrottledTransfers > 0; tc.expectThrottled != throttled {
+			t.Errorf("expected throttled %v, but got %v", tc.expectThrottled, throttled)
+		}
+	}
+}
+
+func TestSyncLimiters(t *testing.T) {
+	unchanged := NewLimiter(Limits{MaxConnections: 1})
+	changed := NewLimiter(Limits{MaxConnections: 1})
+
+	testCases := []struct {
+		name          string
+		limiters      map[string]*Limiter
+		expected      map[string]Limits
+		expectReused  map[string]*Limiter
+		expectDeleted []string
+	}{
+		{
+			name: "Normal case (add, change and delete limiters)",
+			limiters: map[string]*Limiter{
+				"192.168.122.200": unchanged,
+				"192.168.122.201": changed,
+				"192.168.122.202": NewLimiter(Limits{MaxConnections: 1}),
+			},
+			expected: map[string]Limits{
+				"192.168.122.200": Limits{MaxConnections: 1},
+				"192.168.122.201": Limits{MaxConnections: 2, BytesPerSecond: 1024},
+				"192.168.122.203": Limits{},
+			},
+			// Existing limiters should be reused to keep counters and tunnels using them
+			expectReused: map[string]*Limiter{
+				"192.168.122.200": unchanged,
+				"192.168.122.201": changed,
+			},
+			expectDeleted: []string{"192.168.122.202"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		SyncLimiters(tc.limiters, tc.expected)
+
+		if len(tc.expected) != len(tc.limiters) {
+			t.Errorf("expected %d limiters, but got %d", len(tc.expected), len(tc.limiters))
+		}
+		for k, limits := range tc.expected {
+			if got := tc.limiters[k].Limits(); limits != got {
+				t.Errorf("%s: expected limits %v, but got %v", k, limits, got)
+			}
+		}
+		for k, l := range tc.expectReused {
+			if tc.limiters[k] != l {
+				t.Errorf("%s: expected limiter to be reused, but it was recreated", k)
+			}
+		}
+		for _, k := range tc.expectDeleted {
+			if _, ok := tc.limiters[k]; ok {
+				t.Errorf("%s: expected limiter to be deleted, but it remains", k)
+			}
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package util
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// metricsNamespace is the prefix of all the metrics names
+	metricsNamespace = "extconnector"
+	// MetricsPath is the path to serve metrics
+	MetricsPath = "/metrics"
+	// MetricsPort is the default port to serve metrics, which needs to be out of the range of relay ports
+	MetricsPort = 2021
+
+	// DirectionEgress is the direction label for tunnels from cluster to external server
+	DirectionEgress = "egress"
+	// DirectionIngress is the direction label for tunnels from external server to cluster
+	DirectionIngress = "ingress"
+
+	resultSuccess = "success"
+	resultError   = "error"
+)
+
+var (
+	tunnelLabelNames    = []string{"externalservice", "direction", "source_ip", "port", "gateway"}
+	relayLabelNames     = []string{"externalservice", "direction", "gateway"}
+	sourceLabelNames    = []string{"externalservice", "gateway"}
+	reconcileLabelNames = []string{"controller", "result"}
+
+	connectionsAccepted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "tunnel",
+		Name:      "connections_accepted_total",
+		Help:      "Number of connections accepted and connected to the other side.",
+	}, tunnelLabelNames)
+	connectionsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "tunnel",
+		Name:      "connections_failed_total",
+		Help:      "Number of connections failed to connect to the other side.",
+	}, tunnelLabelNames)
+	connectionsActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "tunnel",
+		Name:      "connections_active",
+		Help:      "Number of connections being forwarded.",
+	}, tunnelLabelNames)
+	bytesIn = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "tunnel",
+		Name:      "bytes_in_total",
+		Help:      "Bytes forwarded from the side that initiated connections to the other side.",
+	}, tunnelLabelNames)
+	bytesOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "tunnel",
+		Name:      "bytes_out_total",
+		Help:      "Bytes forwarded back to the side that initiated connections.",
+	}, tunnelLabelNames)
+	tunnelReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "tunnel",
+		Name:      "reconnects_total",
+		Help:      "Number of times tunnels are retried after failure.",
+	}, tunnelLabelNames)
+	sshHandshakeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "tunnel",
+		Name:      "ssh_handshake_duration_seconds",
+		Help:      "Time to connect to ssh server and complete handshake, including failed ones.",
+		Buckets:   prometheus.DefBuckets,
+	}, tunnelLabelNames)
+	relayConnectionsAccepted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "relay",
+		Name:      "connections_accepted_total",
+		Help:      "Number of connections from clients accepted and connected to destinations.",
+	}, relayLabelNames)
+	relayConnectionsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "relay",
+		Name:      "connections_failed_total",
+		Help:      "Number of connections from clients failed to connect to destinations.",
+	}, relayLabelNames)
+	relayConnectionsActive = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "relay",
+		Name:      "connections_active",
+		Help:      "Number of connections being relayed.",
+	}, relayLabelNames)
+	relayBytesIn = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "relay",
+		Name:      "bytes_in_total",
+		Help:      "Bytes relayed from clients to destinations.",
+	}, relayLabelNames)
+	relayBytesOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "relay",
+		Name:      "bytes_out_total",
+		Help:      "Bytes relayed back from destinations to clients.",
+	}, relayLabelNames)
+	limiterRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "limiter",
+		Name:      "connections_rejected_total",
+		Help:      "Number of connections rejected by max connections of sources.",
+	}, sourceLabelNames)
+	limiterThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "limiter",
+		Name:      "transfers_throttled_total",
+		Help:      "Number of transfers delayed by bandwidth of sources.",
+	}, sourceLabelNames)
+	iptablesSyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "iptables",
+		Name:      "sync_duration_seconds",
+		Help:      "Time to sync iptables rules, including failed ones.",
+		Buckets:   prometheus.DefBuckets,
+	}, sourceLabelNames)
+	iptablesSyncFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "iptables",
+		Name:      "sync_failures_total",
+		Help:      "Number of failures in syncing iptables rules.",
+	}, sourceLabelNames)
+	reconcileTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: "controller",
+		Name:      "reconcile_total",
+		Help:      "Number of reconciles per result.",
+	}, reconcileLabelNames)
+)
+
+func init() {
+	prometheus.MustRegister(
+		connectionsAccepted,
+		connectionsFailed,
+		connectionsActive,
+		bytesIn,
+		bytesOut,
+		tunnelReconnects,
+		sshHandshakeDuration,
+		relayConnectionsAccepted,
+		relayConnectionsFailed,
+		relayConnectionsActive,
+		relayBytesIn,
+		relayBytesOut,
+		limiterRejected,
+		limiterThrottled,
+		iptablesSyncDuration,
+		iptablesSyncFailures,
+		reconcileTotal,
+	)
+}
+
+// ServeMetrics serves metrics in prometheus format on {addr}.
+// It blocks until the server fails.
+func ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, promhttp.Handler())
+
+	return http.ListenAndServe(addr, mux)
+}
+
+// TunnelLabels represents labels of metrics for a tunnel, or forwarding rule
+type TunnelLabels struct {
+	// ExternalService is {namespace}/{name} of the external service that the rule belongs to
+	ExternalService string
+	// Direction is either of DirectionEgress or DirectionIngress
+	Direction string
+	// SourceIP is the IP of the client that the rule is for, which isn't labeled for relays in servers
+	SourceIP string
+	// Port is the port that the client connects to, which isn't labeled for relays in servers
+	Port string
+	// Gateway is the IP of the gateway that the rule goes through
+	Gateway string
+}
+
+func (l TunnelLabels) values() []string {
+	return []string{l.ExternalService, l.Direction, l.SourceIP, l.Port, l.Gateway}
+}
+
+// relayValues returns values of labels for relays in servers. SourceIP and Port aren't included,
+// because a client relays connections for all its sources and ports, which servers can't tell apart.
+func (l TunnelLabels) relayValues() []string {
+	return []string{l.ExternalService, l.Direction, l.Gateway}
+}
+
+// ExternalServiceLabel returns the value of externalservice label for the external service {namespace}/{name},
+// or empty string if {name} is empty
+func ExternalServiceLabel(namespace, name string) string {
+	if name == "" {
+		return ""
+	}
+
+	return namespace + "/" + name
+}
+
+// tunnelMetrics is a set of metrics for a tunnel
+type tunnelMetrics struct {
+	accepted   prometheus.Counter
+	failed     prometheus.Counter
+	active     prometheus.Gauge
+	bytesIn    prometheus.Counter
+	bytesOut   prometheus.Counter
+	reconnects prometheus.Counter
+	handshake  prometheus.Observer
+}
+
+func newTunnelMetrics(labels TunnelLabels) *tunnelMetrics {
+	values := labels.values()
+
+	return &tunnelMetrics{
+		accepted:   connectionsAccepted.WithLabelValues(values...),
+		failed:     connectionsFailed.WithLabelValues(values...),
+		active:     connectionsActive.WithLabelValues(values...),
+		bytesIn:    bytesIn.WithLabelValues(values...),
+		bytesOut:   bytesOut.WithLabelValues(values...),
+		reconnects: tunnelReconnects.WithLabelValues(values...),
+		handshake:  sshHandshakeDuration.WithLabelValues(values...),
+	}
+}
+
+// relayMetrics is a set of metrics for connections relayed by a server for a client
+type relayMetrics struct {
+	accepted prometheus.Counter
+	failed   prometheus.Counter
+	active   prometheus.Gauge
+	bytesIn  prometheus.Counter
+	bytesOut prometheus.Counter
+}
+
+func newRelayMetrics(labels TunnelLabels) *relayMetrics {
+	values := labels.relayValues()
+
+	return &relayMetrics{
+		accepted: relayConnectionsAccepted.WithLabelValues(values...),
+		failed:   relayConnectionsFailed.WithLabelValues(values...),
+		active:   relayConnectionsActive.WithLabelValues(values...),
+		bytesIn:  relayBytesIn.WithLabelValues(values...),
+		bytesOut: relayBytesOut.WithLabelValues(values...),
+	}
+}
+
+// DeleteRelayMetrics deletes metrics for connections relayed by a server for a client with {labels},
+// so that metrics for deleted clients won't remain
+func DeleteRelayMetrics(labels TunnelLabels) {
+	values := labels.relayValues()
+	for _, vec := range []interface {
+		DeleteLabelValues(lvs ...string) bool
+	}{
+		relayConnectionsAccepted,
+		relayConnectionsFailed,
+		relayConnectionsActive,
+		relayBytesIn,
+		relayBytesOut,
+	} {
+		vec.DeleteLabelValues(values...)
+	}
+}
+
+// DeleteTunnelMetrics deletes metrics for a tunnel with {labels},
+// so that metrics for deleted rules won't remain
+func DeleteTunnelMetrics(labels TunnelLabels) {
+	values := labels.values()
+	for _, vec := range []interface {
+		DeleteLabelValues(lvs ...string) bool
+	}{
+		connectionsAccepted,
+		connectionsFailed,
+		connectionsActive,
+		bytesIn,
+		bytesOut,
+		tunnelReconnects,
+		sshHandshakeDuration,
+	} {
+		vec.DeleteLabelValues(values...)
+	}
+}
+
+// ObserveIptablesSync records duration and failure of syncing iptables rules, which started at {start}
+// and resulted in {err}. {externalService} or {gateway} can be empty, if it is not specific to it.
+func ObserveIptablesSync(externalService, gateway string, start time.Time, err error) {
+	iptablesSyncDuration.WithLabelValues(externalService, gateway).Observe(time.Since(start).Seconds())
+	if err != nil {
+		iptablesSyncFailures.WithLabelValues(externalService, gateway).Inc()
+	}
+}
+
+// observeReconcile records the result of a reconcile by {controller}
+func observeReconcile(controller string, err error) {
+	result := resultSuccess
+	if err != nil {
+		result = resultError
+	}
+	reconcileTotal.WithLabelValues(controller, result).Inc()
+}
+
+// countingWriter counts bytes written to the underlying writer
+type countingWriter struct {
+	w       io.Writer
+	counter prometheus.Counter
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.counter.Add(float64(n))
+
+	return n, err
+}
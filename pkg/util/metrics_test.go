@@ -0,0 +1,207 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestObserveReconcile(t *testing.T) {
+	testCases := []struct {
+		name          string
+		controller    string
+		errs          []error
+		expectSuccess float64
+		expectError   float64
+	}{
+		{
+			name:          "Normal case (success and error)",
+			controller:    "test-reconcile1",
+			errs:          []error{nil, fmt.Errorf("error"), nil},
+			expectSuccess: 2,
+			expectError:   1,
+		},
+		{
+			name:          "Normal case (no error)",
+			controller:    "test-reconcile2",
+			errs:          []error{nil},
+			expectSuccess: 1,
+			expectError:   0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		for _, err := range tc.errs {
+			observeReconcile(tc.controller, err)
+		}
+
+		if success := testutil.ToFloat64(reconcileTotal.WithLabelValues(tc.controller, resultSuccess)); tc.expectSuccess != success {
+			t.Errorf("expected %v success, but got %v", tc.expectSuccess, success)
+		}
+		if errors := testutil.ToFloat64(reconcileTotal.WithLabelValues(tc.controller, resultError)); tc.expectError != errors {
+			t.Errorf("expected %v error, but got %v", tc.expectError, errors)
+		}
+	}
+}
+
+func TestObserveIptablesSync(t *testing.T) {
+	testCases := []struct {
+		name            string
+		externalService string
+		gateway         string
+		errs            []error
+		expectFailures  float64
+	}{
+		{
+			name:            "Normal case (forwarder)",
+			externalService: "test-iptables1",
+			gateway:         "",
+			errs:            []error{nil, fmt.Errorf("error")},
+			expectFailures:  1,
+		},
+		{
+			name:            "Normal case (gateway)",
+			externalService: "",
+			gateway:         "192.168.122.201",
+			errs:            []error{nil},
+			expectFailures:  0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		for _, err := range tc.errs {
+			ObserveIptablesSync(tc.externalService, tc.gateway, time.Now(), err)
+		}
+
+		if failures := testutil.ToFloat64(iptablesSyncFailures.WithLabelValues(tc.externalService, tc.gateway)); tc.expectFailures != failures {
+			t.Errorf("expected %v failures, but got %v", tc.expectFailures, failures)
+		}
+	}
+}
+
+func TestTunnelMetrics(t *testing.T) {
+	testCases := []struct {
+		name        string
+		labels      TunnelLabels
+		data        []byte
+		expectBytes float64
+	}{
+		{
+			name: "Normal case",
+			labels: TunnelLabels{
+				ExternalService: "test-tunnel1",
+				Direction:       DirectionEgress,
+				SourceIP:        "10.244.0.11",
+				Port:            "8000",
+				Gateway:         "192.168.122.201",
+			},
+			data:        []byte("hello"),
+			expectBytes: 5,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		metrics := newTunnelMetrics(tc.labels)
+		w := &countingWriter{w: &bytes.Buffer{}, counter: metrics.bytesIn}
+		if _, err := w.Write(tc.data); err != nil {
+			t.Errorf("expected no error, but got error %v", err)
+		}
+
+		if b := testutil.ToFloat64(bytesIn.WithLabelValues(tc.labels.values()...)); tc.expectBytes != b {
+			t.Errorf("expected %v bytes, but got %v", tc.expectBytes, b)
+		}
+
+		// Metrics should be reset after deleted
+		DeleteTunnelMetrics(tc.labels)
+		if b := testutil.ToFloat64(bytesIn.WithLabelValues(tc.labels.values()...)); b != 0 {
+			t.Errorf("expected 0 bytes after deleted, but got %v", b)
+		}
+	}
+}
+
+func TestRelayMetrics(t *testing.T) {
+	testCases := []struct {
+		name        string
+		labels      TunnelLabels
+		data        []byte
+		expectBytes float64
+	}{
+		{
+			name: "Normal case",
+			labels: TunnelLabels{
+				ExternalService: ExternalServiceLabel("ns1", "test-relay1"),
+				Direction:       DirectionIngress,
+				Gateway:         "192.168.122.201",
+			},
+			data:        []byte("hello"),
+			expectBytes: 5,
+		},
+		{
+			name: "Normal case (source IP and port are not labeled)",
+			labels: TunnelLabels{
+				ExternalService: ExternalServiceLabel("ns1", "test-relay2"),
+				Direction:       DirectionEgress,
+				SourceIP:        "10.244.0.11",
+				Port:            "8000",
+				Gateway:         "192.168.122.201",
+			},
+			data:        []byte("hello"),
+			expectBytes: 5,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		metrics := newRelayMetrics(tc.labels)
+		w := &countingWriter{w: &bytes.Buffer{}, counter: metrics.bytesIn}
+		if _, err := w.Write(tc.data); err != nil {
+			t.Errorf("expected no error, but got error %v", err)
+		}
+
+		values := []string{tc.labels.ExternalService, tc.labels.Direction, tc.labels.Gateway}
+		if b := testutil.ToFloat64(relayBytesIn.WithLabelValues(values...)); tc.expectBytes != b {
+			t.Errorf("expected %v bytes, but got %v", tc.expectBytes, b)
+		}
+
+		// Metrics should be reset after deleted
+		DeleteRelayMetrics(tc.labels)
+		if b := testutil.ToFloat64(relayBytesIn.WithLabelValues(values...)); b != 0 {
+			t.Errorf("expected 0 bytes after deleted, but got %v", b)
+		}
+	}
+}
+
+func TestExternalServiceLabel(t *testing.T) {
+	testCases := []struct {
+		name      string
+		namespace string
+		esName    string
+		expect    string
+	}{
+		{
+			name:      "Normal case",
+			namespace: "ns1",
+			esName:    "my-externalservice",
+			expect:    "ns1/my-externalservice",
+		},
+		{
+			name:      "Normal case (no external service)",
+			namespace: "",
+			esName:    "",
+			expect:    "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		if label := ExternalServiceLabel(tc.namespace, tc.esName); tc.expect != label {
+			t.Errorf("expected %q, but got %q", tc.expect, label)
+		}
+	}
+}
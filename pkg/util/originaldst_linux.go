@@ -0,0 +1,44 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// soOriginalDst is the socket option to get the original destination of a connection redirected by iptables,
+// which is defined in linux/netfilter_ipv4.h
+const soOriginalDst = 80
+
+// originalDstPort returns the original destination port of {conn}, which is redirected by DNAT of iptables
+func originalDstPort(conn net.Conn) (int, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0, fmt.Errorf("connection from %v is not TCP", conn.RemoteAddr())
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var port int
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		// The option returns struct sockaddr_in, which fits in struct ipv6_mreq.
+		// Its port is in network byte order after 2 bytes of the address family.
+		addr, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.IPPROTO_IP, soOriginalDst)
+		if err != nil {
+			sockErr = err
+			return
+		}
+		port = int(addr.Multiaddr[2])<<8 | int(addr.Multiaddr[3])
+	})
+	if err != nil {
+		return 0, err
+	}
+	if sockErr != nil {
+		return 0, sockErr
+	}
+
+	return port, nil
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+// +build !linux
+
+package util
+
+import (
+	"fmt"
+	"net"
+)
+
+// originalDstPort returns the original destination port of {conn}, which is redirected by DNAT of iptables.
+// It is supported only on linux.
+func originalDstPort(conn net.Conn) (int, error) {
+	return 0, fmt.Errorf("original destination of connection from %v is not supported on this platform", conn.RemoteAddr())
+}
@@ -0,0 +1,158 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+const (
+	// ProxyProtocolV1 is the human-readable version 1 of HAProxy PROXY protocol
+	ProxyProtocolV1 = "v1"
+	// ProxyProtocolV2 is the binary version 2 of HAProxy PROXY protocol
+	ProxyProtocolV2 = "v2"
+	// ProxyTLVTypePod is the custom type of TLV in PROXY protocol v2 header for namespace/name of the original pod
+	ProxyTLVTypePod = 0xe0
+)
+
+// proxyV2Signature is the signature that PROXY protocol v2 header starts with
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyTLV is a Type-Length-Value vector added to PROXY protocol v2 header
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// IsProxyProtocolVersion returns true if {version} is a supported version of PROXY protocol
+func IsProxyProtocolVersion(version string) bool {
+	return version == ProxyProtocolV1 || version == ProxyProtocolV2
+}
+
+// ProxyHeader returns PROXY protocol header of {version} for a TCP connection from {src} to {dst}.
+// {tlvs} are added only to v2 header, because v1 header has no room for them.
+func ProxyHeader(version string, src, dst net.Addr, tlvs ...ProxyTLV) ([]byte, error) {
+	srcAddr, ok := src.(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("source address %q is not tcp", src)
+	}
+	dstAddr, ok := dst.(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("destination address %q is not tcp", dst)
+	}
+
+	switch version {
+	case ProxyProtocolV1:
+		return proxyHeaderV1(srcAddr, dstAddr), nil
+	case ProxyProtocolV2:
+		return proxyHeaderV2(srcAddr, dstAddr, tlvs)
+	default:
+		return nil, fmt.Errorf("unknown version of proxy protocol %q", version)
+	}
+}
+
+// proxyHeaderV1 returns PROXY protocol v1 header like "PROXY TCP4 {src IP} {dst IP} {src port} {dst port}\r\n"
+func proxyHeaderV1(src, dst *net.TCPAddr) []byte {
+	proto := "TCP4"
+	if src.IP.To4() == nil || dst.IP.To4() == nil {
+		proto = "TCP6"
+	}
+
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, src.IP, dst.IP, src.Port, dst.Port))
+}
+
+// proxyHeaderV2 returns PROXY protocol v2 header for PROXY command over TCP with {tlvs}
+func proxyHeaderV2(src, dst *net.TCPAddr, tlvs []ProxyTLV) ([]byte, error) {
+	// Addresses are in the same family, so IPv4 ones are mapped to IPv6 if either is IPv6
+	family := byte(0x11)
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	if srcIP == nil || dstIP == nil {
+		family = 0x21
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+	}
+	if srcIP == nil || dstIP == nil {
+		return nil, fmt.Errorf("invalid addresses %q and %q", src, dst)
+	}
+
+	body := &bytes.Buffer{}
+	body.Write(srcIP)
+	body.Write(dstIP)
+	binary.Write(body, binary.BigEndian, uint16(src.Port))
+	binary.Write(body, binary.BigEndian, uint16(dst.Port))
+	for _, tlv := range tlvs {
+		if len(tlv.Value) > 0xffff {
+			return nil, fmt.Errorf("value of tlv %#x is too long", tlv.Type)
+		}
+		body.WriteByte(tlv.Type)
+		binary.Write(body, binary.BigEndian, uint16(len(tlv.Value)))
+		body.Write(tlv.Value)
+	}
+	if body.Len() > 0xffff {
+		return nil, fmt.Errorf("proxy protocol header is too long")
+	}
+
+	header := &bytes.Buffer{}
+	header.Write(proxyV2Signature)
+	// Version 2 and PROXY command
+	header.WriteByte(0x21)
+	// Address family and STREAM protocol
+	header.WriteByte(family)
+	binary.Write(header, binary.BigEndian, uint16(body.Len()))
+	header.Write(body.Bytes())
+
+	return header.Bytes(), nil
+}
+
+// dialForOrigin connects to {dest} for a connection with {origin} forwarded by {client} to the server
+// on {serverAddr}. The connection is made from {origin} if it is a local address, or from the IP of
+// {serverAddr} otherwise, so that clients can't make connections from addresses of other hosts.
+// If the client asks PROXY protocol header of {version}, {origin} is the original client behind the client,
+// so the connection is made from the IP of {serverAddr} and starts with the header telling {origin} and {pod}.
+func dialForOrigin(ctx context.Context, client string, serverAddr net.Addr, origin *net.TCPAddr, dest string, targets TargetLookup, version, pod string) (net.Conn, error) {
+	if version == "" && isLocalIP(origin.IP) {
+		return dialDestination(ctx, net.Dialer{LocalAddr: origin}, client, dest, targets)
+	}
+
+	local, ok := serverAddr.(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("server address %q is not tcp", serverAddr)
+	}
+	conn, err := dialDestination(ctx, net.Dialer{LocalAddr: &net.TCPAddr{IP: local.IP}}, client, dest, targets)
+	if err != nil || version == "" {
+		return conn, err
+	}
+	var tlvs []ProxyTLV
+	if pod != "" {
+		tlvs = append(tlvs, ProxyTLV{Type: ProxyTLVTypePod, Value: []byte(pod)})
+	}
+	header, err := ProxyHeader(version, origin, conn.RemoteAddr(), tlvs...)
+	if err == nil {
+		_, err = conn.Write(header)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send proxy protocol header to %q: %v", dest, err)
+	}
+
+	return conn, nil
+}
+
+// isLocalIP returns true if {ip} is unspecified, loopback or assigned to a local interface
+func isLocalIP(ip net.IP) bool {
+	if ip.IsUnspecified() || ip.IsLoopback() {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
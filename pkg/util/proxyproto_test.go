@@ -0,0 +1,353 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestProxyHeader(t *testing.T) {
+	testCases := []struct {
+		name        string
+		version     string
+		src         net.Addr
+		dst         net.Addr
+		tlvs        []ProxyTLV
+		expected    []byte
+		expectError bool
+	}{
+		{
+			name:        "Normal case (v1 for IPv4)",
+			version:     ProxyProtocolV1,
+			src:         &net.TCPAddr{IP: net.ParseIP("192.168.122.139"), Port: 43210},
+			dst:         &net.TCPAddr{IP: net.ParseIP("192.168.122.201"), Port: 80},
+			expected:    []byte("PROXY TCP4 192.168.122.139 192.168.122.201 43210 80\r\n"),
+			expectError: false,
+		},
+		{
+			name:        "Normal case (v1 for IPv6)",
+			version:     ProxyProtocolV1,
+			src:         &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 43210},
+			dst:         &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 80},
+			expected:    []byte("PROXY TCP6 2001:db8::1 2001:db8::2 43210 80\r\n"),
+			expectError: false,
+		},
+		{
+			name:    "Normal case (v2 for IPv4)",
+			version: ProxyProtocolV2,
+			src:     &net.TCPAddr{IP: net.ParseIP("192.168.122.139"), Port: 43210},
+			dst:     &net.TCPAddr{IP: net.ParseIP("192.168.122.201"), Port: 80},
+			expected: append(append([]byte{}, proxyV2Signature...),
+				0x21, 0x11, 0x00, 0x0c,
+				192, 168, 122, 139,
+				192, 168, 122, 201,
+				0xa8, 0xca, 0x00, 0x50),
+			expectError: false,
+		},
+		{
+			name:    "Normal case (v2 with tlv)",
+			version: ProxyProtocolV2,
+			src:     &net.TCPAddr{IP: net.ParseIP("192.168.122.139"), Port: 43210},
+			dst:     &net.TCPAddr{IP: net.ParseIP("192.168.122.201"), Port: 80},
+			tlvs:    []ProxyTLV{{Type: 0xe0, Value: []byte("ab")}},
+			expected: append(append([]byte{}, proxyV2Signature...),
+				0x21, 0x11, 0x00, 0x11,
+				192, 168, 122, 139,
+				192, 168, 122, 201,
+				0xa8, 0xca, 0x00, 0x50,
+				0xe0, 0x00, 0x02, 'a', 'b'),
+			expectError: false,
+		},
+		{
+			name:    "Error case (unknown version)",
+			version: "v3",
+			src:     &net.TCPAddr{IP: net.ParseIP("192.168.122.139"), Port: 43210},
+			dst:     &net.TCPAddr{IP: net.ParseIP("192.168.122.201"), Port: 80},
+			// Should return error
+			expectError: true,
+		},
+		{
+			name:    "Error case (not tcp)",
+			version: ProxyProtocolV1,
+			src:     &net.UDPAddr{IP: net.ParseIP("192.168.122.139"), Port: 43210},
+			dst:     &net.TCPAddr{IP: net.ParseIP("192.168.122.201"), Port: 80},
+			// Should return error
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		header, err := ProxyHeader(tc.version, tc.src, tc.dst, tc.tlvs...)
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("expected error, but no error returned")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected no error, but got error %v", err)
+			continue
+		}
+		if !bytes.Equal(tc.expected, header) {
+			t.Errorf("expected %q, but got %q", tc.expected, header)
+		}
+	}
+}
+
+func TestRemoteForwardProxyProtocol(t *testing.T) {
+	// Local endpoint records the header and echoes the message after it
+	localAddr := "127.0.0.1:" + genRandomPort()
+	l, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		t.Fatalf("listening on %s failed: %v", localAddr, err)
+	}
+	defer l.Close()
+	headers := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		header, _ := reader.ReadString('\n')
+		headers <- header
+		io.Copy(conn, reader)
+	}()
+
+	serverAddr := "127.0.0.1:" + genRandomPort()
+	sshServer := NewSSHServer(serverAddr, ServerOptions{})
+	go sshServer.ListenAndServe()
+	defer sshServer.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	remoteAddr := "127.0.0.1:" + genRandomPort()
+	tun := NewTunnel(localAddr, serverAddr, remoteAddr, &ssh.ClientConfig{
+		Auth:            []ssh.AuthMethod{},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err := tun.SetProxyProtocol(ProxyProtocolV1, "192.168.122.201:80"); err != nil {
+		t.Fatalf("expected no error, but got error %v", err)
+	}
+	tun.RemoteForwardNB()
+	defer tun.Cancel()
+
+	msg, err := echoClientWithRetry(remoteAddr, "hello", 5*time.Second)
+	if err != nil {
+		t.Fatalf("expected no error, but got error %v", err)
+	}
+	if msg != "hello" {
+		t.Errorf("expected msg hello, but got %s", msg)
+	}
+
+	// Source is the client connected to the remote endpoint
+	header := <-headers
+	expected := "PROXY TCP4 127.0.0.1 192.168.122.201 "
+	if !strings.HasPrefix(header, expected) || !strings.HasSuffix(header, " 80\r\n") {
+		t.Errorf("expected header like %q, but got %q", expected+"{port} 80\r\n", header)
+	}
+}
+
+func TestForwardProxyProtocol(t *testing.T) {
+	serverConfig, clientConfig, _ := genTestTLSConfigs(t)
+
+	testCases := []struct {
+		name      string
+		transport string
+		version   string
+		pod       string
+		msg       string
+	}{
+		{
+			name:      "Normal case (ssh with v2 and pod)",
+			transport: "ssh",
+			version:   ProxyProtocolV2,
+			pod:       "ns1/pod1",
+			msg:       "hello",
+		},
+		{
+			name:      "Normal case (tls with v2 and pod)",
+			transport: "tls",
+			version:   ProxyProtocolV2,
+			pod:       "ns1/pod1",
+			msg:       "hello",
+		},
+		{
+			name:      "Normal case (tls with v1)",
+			transport: "tls",
+			version:   ProxyProtocolV1,
+			msg:       "hello",
+		},
+		{
+			name:      "Normal case (no proxy protocol)",
+			transport: "ssh",
+			version:   "",
+			msg:       "hello",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		// Remote endpoint records bytes received before the message and echoes the message
+		remoteAddr := "127.0.0.1:" + genRandomPort()
+		l, err := net.Listen("tcp", remoteAddr)
+		if err != nil {
+			t.Fatalf("listening on %s failed: %v", remoteAddr, err)
+		}
+		received := make(chan []byte, 10)
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				// Binary header can include newlines, so read until the message
+				data := []byte{}
+				buf := make([]byte, 1)
+				for !bytes.HasSuffix(data, []byte(tc.msg+"\n")) {
+					if _, err := conn.Read(buf); err != nil {
+						break
+					}
+					data = append(data, buf[0])
+				}
+				received <- bytes.TrimSuffix(data, []byte(tc.msg+"\n"))
+				conn.Write([]byte(tc.msg + "\n"))
+				conn.Close()
+			}
+		}()
+
+		// Server sends the header only if the tunnel asks it
+		serverAddr := "127.0.0.1:" + genRandomPort()
+		localAddr := "127.0.0.1:" + genRandomPort()
+		var tun *Tunnel
+		var closeServer func() error
+		if tc.transport == "tls" {
+			server := NewTLSRelayServer(serverAddr, serverConfig, ServerOptions{})
+			go server.ListenAndServe()
+			closeServer = server.Close
+			tun = NewTLSTunnel(localAddr, serverAddr, remoteAddr, "", clientConfig)
+		} else {
+			server := NewSSHServer(serverAddr, ServerOptions{})
+			go server.ListenAndServe()
+			closeServer = server.Close
+			tun = NewTunnel(localAddr, serverAddr, remoteAddr, &ssh.ClientConfig{
+				Auth:            []ssh.AuthMethod{},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			})
+		}
+		if err := tun.SetOriginProxyProtocol(tc.version, tc.pod); err != nil {
+			t.Fatalf("expected no error, but got error %v", err)
+		}
+		tun.ForwardNB()
+
+		// Origin is the client of the local endpoint, which is known only after connecting
+		var origin net.Addr
+		var msg string
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			conn, err := net.Dial("tcp", localAddr)
+			if err == nil {
+				origin = conn.LocalAddr()
+				conn.SetDeadline(time.Now().Add(time.Second))
+				conn.Write([]byte(tc.msg + "\n"))
+				msg, err = bufio.NewReader(conn).ReadString('\n')
+				conn.Close()
+			}
+			if err == nil || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		if msg = strings.TrimSpace(msg); msg != tc.msg {
+			t.Errorf("expected msg %s, but got %s", tc.msg, msg)
+		} else {
+			// The last connection is the one echoed
+			var data []byte
+			for len(received) > 0 {
+				data = <-received
+			}
+
+			expected := []byte{}
+			if tc.version != "" {
+				dst, _ := net.ResolveTCPAddr("tcp", remoteAddr)
+				var tlvs []ProxyTLV
+				if tc.pod != "" {
+					tlvs = append(tlvs, ProxyTLV{Type: ProxyTLVTypePod, Value: []byte(tc.pod)})
+				}
+				expected, _ = ProxyHeader(tc.version, origin, dst, tlvs...)
+			}
+			if !bytes.Equal(expected, data) {
+				t.Errorf("expected header %q, but got %q", expected, data)
+			}
+		}
+
+		tun.Cancel()
+		closeServer()
+		l.Close()
+		// Wait for a millisecond just to be sure that all servers closed
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDialForOrigin(t *testing.T) {
+	// Destination records the sources of connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening failed: %v", err)
+	}
+	defer l.Close()
+	sources := make(chan net.Addr, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			sources <- conn.RemoteAddr()
+			conn.Close()
+		}
+	}()
+	serverAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 2022}
+
+	testCases := []struct {
+		name     string
+		origin   *net.TCPAddr
+		expected string
+	}{
+		{
+			name:     "Normal case (local origin)",
+			origin:   &net.TCPAddr{IP: net.ParseIP("127.0.0.2")},
+			expected: "127.0.0.2",
+		},
+		{
+			name:     "Normal case (non-local origin falls back to server)",
+			origin:   &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000},
+			expected: "127.0.0.1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		conn, err := dialForOrigin(context.Background(), "", serverAddr, tc.origin, l.Addr().String(), nil, "", "")
+		if err != nil {
+			t.Errorf("expected no error, but got error %v", err)
+			continue
+		}
+		conn.Close()
+		if source := <-sources; source.(*net.TCPAddr).IP.String() != tc.expected {
+			t.Errorf("expected source %s, but got %v", tc.expected, source)
+		}
+	}
+}
@@ -0,0 +1,602 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/http2"
+)
+
+const (
+	// TLSRelayPort is the default port number used for mTLS relay server
+	TLSRelayPort = "443"
+	// relayClientHeader is the header of requests that identifies the client, which is the same to the user of ssh
+	relayClientHeader = "Relay-Client"
+	// relayOriginHeader is the header of CONNECT requests that specifies the local address of the server
+	// to connect from, which is the same to the origin of direct-tcpip in ssh
+	relayOriginHeader = "Relay-Origin"
+	// relayProxyProtocolHeader is the header of CONNECT requests that asks the server to send PROXY protocol
+	// header of the version telling Relay-Origin, and relayPodHeader is namespace/name of the original pod in it
+	relayProxyProtocolHeader = "Relay-Proxy-Protocol"
+	relayPodHeader           = "Relay-Pod"
+	// relayListenHeader is the header of listen requests that specifies the address to listen on
+	relayListenHeader = "Relay-Listen"
+	// relayAcceptHeader is the header of CONNECT requests that specifies the ID of a connection
+	// accepted on a listener, which is relayed instead of connecting to the destination
+	relayAcceptHeader = "Relay-Accept"
+	// relayListenPath is the path of listen requests
+	relayListenPath = "/listen"
+	// relayAcceptTimeout is the time that connections accepted on listeners wait for clients to claim them
+	relayAcceptTimeout = 10 * time.Second
+)
+
+var errDeadlineNotSupported = errors.New("deadline is not supported for relayed connections")
+
+// TLSConfigLoader returns tls.Config with the certificates to use.
+// It is called for each connection, so that rotated certificates are used without restarting.
+type TLSConfigLoader func() (*tls.Config, error)
+
+// relayError represents an error response from mTLS relay server
+type relayError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *relayError) Error() string {
+	return fmt.Sprintf("relay server responded %d: %s", e.StatusCode, e.Message)
+}
+
+// NewTLSTunnel returns a Tunnel instance that connects to mTLS relay server on {server}
+// instead of ssh server. tls.Config to connect is loaded by {config} on each connection.
+// The tunnel identifies itself to the server as {client}, like the user of ssh.
+func NewTLSTunnel(local, server, remote, client string, config TLSConfigLoader) *Tunnel {
+	t := newTunnel(local, server, remote)
+	t.dial = func() (relayClient, error) {
+		cfg, err := config()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls config: %v", err)
+		}
+		return dialTLSRelay(server, client, cfg)
+	}
+
+	return t
+}
+
+// closeNotifyConn is net.Conn that notifies when reading from it fails, which means that it is closed.
+// HTTP/2 client always reads from the connection, so it is noticed soon.
+type closeNotifyConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+	err    error
+}
+
+func (c *closeNotifyConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		c.once.Do(func() {
+			c.err = err
+			close(c.closed)
+		})
+	}
+
+	return n, err
+}
+
+// tlsRelayClient is relayClient by mTLS relay, which relays each connection in a HTTP/2 stream
+type tlsRelayClient struct {
+	server string
+	client string
+	conn   *closeNotifyConn
+	cc     *http2.ClientConn
+}
+
+// dialTLSRelay connects to mTLS relay server on {server} with {config} as {client}
+func dialTLSRelay(server, client string, config *tls.Config) (*tlsRelayClient, error) {
+	config = config.Clone()
+	config.NextProtos = []string{http2.NextProtoTLS}
+	conn, err := tls.Dial("tcp", server, config)
+	if err != nil {
+		return nil, err
+	}
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != http2.NextProtoTLS {
+		conn.Close()
+		return nil, fmt.Errorf("server endpoint %q doesn't support %s", server, http2.NextProtoTLS)
+	}
+
+	nc := &closeNotifyConn{Conn: conn, closed: make(chan struct{})}
+	cc, err := (&http2.Transport{}).NewClientConn(nc)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &tlsRelayClient{server: server, client: client, conn: nc, cc: cc}, nil
+}
+
+func (c *tlsRelayClient) DialTCP(n string, laddr, raddr *net.TCPAddr) (net.Conn, error) {
+	req := newRelayRequest(http.MethodConnect, raddr.String(), "")
+	req.Header.Set(relayOriginHeader, laddr.String())
+
+	return c.open(req, laddr, raddr)
+}
+
+func (c *tlsRelayClient) DialProxy(origin, raddr *net.TCPAddr, version, pod string) (net.Conn, error) {
+	req := newRelayRequest(http.MethodConnect, raddr.String(), "")
+	req.Header.Set(relayOriginHeader, origin.String())
+	req.Header.Set(relayProxyProtocolHeader, version)
+	if pod != "" {
+		req.Header.Set(relayPodHeader, pod)
+	}
+
+	return c.open(req, origin, raddr)
+}
+
+func (c *tlsRelayClient) Listen(n, addr string) (net.Listener, error) {
+	laddr, err := net.ResolveTCPAddr(n, addr)
+	if err != nil {
+		return nil, err
+	}
+	req := newRelayRequest(http.MethodPost, c.server, relayListenPath)
+	req.Header.Set(relayListenHeader, addr)
+	stream, err := c.open(req, nil, laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &relayListener{client: c, addr: laddr, stream: stream, reader: bufio.NewReader(stream)}, nil
+}
+
+func (c *tlsRelayClient) KeepAlive(timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return c.cc.Ping(ctx) == nil
+}
+
+func (c *tlsRelayClient) Wait() error {
+	<-c.conn.closed
+
+	return c.conn.err
+}
+
+func (c *tlsRelayClient) Close() error {
+	return c.cc.Close()
+}
+
+// newRelayRequest returns a request to {host} with {method}. Path is used only if {method} isn't CONNECT.
+func newRelayRequest(method, host, path string) *http.Request {
+	u := &url.URL{Host: host}
+	if method != http.MethodConnect {
+		u.Scheme = "https"
+		u.Path = path
+	}
+
+	return &http.Request{
+		Method: method,
+		URL:    u,
+		Host:   host,
+		Header: http.Header{},
+	}
+}
+
+// open opens a stream for {req}, and returns it as a connection from {laddr} to {raddr}
+func (c *tlsRelayClient) open(req *http.Request, laddr, raddr net.Addr) (net.Conn, error) {
+	req.Header.Set(relayClientHeader, c.client)
+	// Request body is written while the stream is open
+	pr, pw := io.Pipe()
+	req.Body = pr
+	res, err := c.cc.RoundTrip(req)
+	if err != nil {
+		pw.Close()
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		pw.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body.Close()
+		return nil, &relayError{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+
+	return &streamConn{r: res.Body, w: pw, laddr: laddr, raddr: raddr}, nil
+}
+
+// streamConn is net.Conn over a HTTP/2 stream, which reads from the response and writes to the request
+type streamConn struct {
+	r     io.ReadCloser
+	w     io.WriteCloser
+	laddr net.Addr
+	raddr net.Addr
+}
+
+func (c *streamConn) Read(b []byte) (int, error)         { return c.r.Read(b) }
+func (c *streamConn) Write(b []byte) (int, error)        { return c.w.Write(b) }
+func (c *streamConn) LocalAddr() net.Addr                { return c.laddr }
+func (c *streamConn) RemoteAddr() net.Addr               { return c.raddr }
+func (c *streamConn) SetDeadline(t time.Time) error      { return errDeadlineNotSupported }
+func (c *streamConn) SetReadDeadline(t time.Time) error  { return errDeadlineNotSupported }
+func (c *streamConn) SetWriteDeadline(t time.Time) error { return errDeadlineNotSupported }
+
+func (c *streamConn) Close() error {
+	c.w.Close()
+
+	return c.r.Close()
+}
+
+// relayListener is net.Listener for connections accepted by mTLS relay server for a listen request.
+// The server notifies each connection in a line of "{id} {remote address}" in the response,
+// and the connection is relayed in a new stream by CONNECT request with the ID.
+type relayListener struct {
+	client *tlsRelayClient
+	addr   *net.TCPAddr
+	stream net.Conn
+	reader *bufio.Reader
+}
+
+func (l *relayListener) Accept() (net.Conn, error) {
+	for {
+		line, err := l.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid notification %q from relay server", line)
+		}
+		raddr, err := net.ResolveTCPAddr("tcp", fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid notification %q from relay server: %v", line, err)
+		}
+
+		req := newRelayRequest(http.MethodConnect, l.addr.String(), "")
+		req.Header.Set(relayAcceptHeader, fields[0])
+		conn, err := l.client.open(req, l.addr, raddr)
+		if _, ok := err.(*relayError); ok {
+			// Only the connection is lost, for example, by waiting for too long
+			glog.Warningf("failed to accept connection from %q on %q: %v", raddr, l.addr, err)
+			continue
+		}
+
+		return conn, err
+	}
+}
+
+func (l *relayListener) Close() error {
+	return l.stream.Close()
+}
+
+func (l *relayListener) Addr() net.Addr {
+	return l.addr
+}
+
+// TLSRelayServer is a server that relays connections for clients over HTTP/2 on mutual TLS,
+// in the same way as ssh server returned by NewSSHServer. Each connection is relayed in a stream:
+//   - CONNECT request to a destination connects to it from the address in Relay-Origin header,
+//     like direct-tcpip of ssh. If Relay-Proxy-Protocol header is set, it connects from the server and
+//     sends PROXY protocol header telling Relay-Origin and Relay-Pod instead,
+//   - POST request to /listen listens on the address in Relay-Listen header, like tcpip-forward of ssh,
+//     and notifies connections accepted on it in the response. Each of them is relayed by
+//     CONNECT request with its ID in Relay-Accept header.
+//
+// Clients are identified by Relay-Client header of each request, like the user of ssh.
+// Only clients that have certificates issued by the CA of the server are allowed.
+type TLSRelayServer struct {
+	addr   string
+	config TLSConfigLoader
+	opts   ServerOptions
+	server *http.Server
+
+	// mutex protects nextID and pending
+	mutex   sync.Mutex
+	nextID  uint64
+	pending map[string]*pendingConn
+}
+
+// pendingConn is a connection accepted on a listener, which waits for the client to claim it
+type pendingConn struct {
+	conn   net.Conn
+	client string
+	timer  *time.Timer
+}
+
+// NewTLSRelayServer returns mTLS relay server instance that will listen on {addr} with {opts}.
+// tls.Config for the server is loaded by {config} on each connection.
+// Sessions of clients for listen requests are registered to Sessions of {opts}, like remote forwarding of ssh.
+func NewTLSRelayServer(addr string, config TLSConfigLoader, opts ServerOptions) *TLSRelayServer {
+	s := &TLSRelayServer{
+		addr:    addr,
+		config:  config,
+		opts:    opts,
+		pending: map[string]*pendingConn{},
+	}
+	s.server = &http.Server{Handler: s}
+	if err := http2.ConfigureServer(s.server, &http2.Server{}); err != nil {
+		glog.Errorf("failed to configure http2 for relay server on %q: %v", addr, err)
+	}
+
+	return s
+}
+
+// ListenAndServe listens on the address of the server and serves relay
+func (s *TLSRelayServer) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+
+	return s.server.Serve(tls.NewListener(ln, s.tlsConfig()))
+}
+
+// Close closes the server and all the connections relayed by it
+func (s *TLSRelayServer) Close() error {
+	err := s.server.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for id, p := range s.pending {
+		p.timer.Stop()
+		p.conn.Close()
+		delete(s.pending, id)
+	}
+
+	return err
+}
+
+// tlsConfig returns tls.Config of the server, which loads the latest certificates on each connection
+func (s *TLSRelayServer) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg, err := s.config()
+			if err != nil {
+				glog.Errorf("failed to load tls config for relay server on %q: %v", s.addr, err)
+				return nil, err
+			}
+			cfg = cfg.Clone()
+			cfg.NextProtos = []string{http2.NextProtoTLS}
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			return cfg, nil
+		},
+	}
+}
+
+func (s *TLSRelayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodConnect && r.Header.Get(relayAcceptHeader) != "":
+		s.relayAccepted(w, r)
+	case r.Method == http.MethodConnect:
+		s.connect(w, r)
+	case r.Method == http.MethodPost && r.URL.Path == relayListenPath:
+		s.listen(w, r)
+	default:
+		http.Error(w, "unsupported request", http.StatusBadRequest)
+	}
+}
+
+// connect connects to the destination of {r} from its origin, and relays the stream to it
+func (s *TLSRelayServer) connect(w http.ResponseWriter, r *http.Request) {
+	clientAddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	client := r.Header.Get(relayClientHeader)
+	var limiter *Limiter
+	var labels TunnelLabels
+	if s.opts.Lookup != nil {
+		limiter, labels = s.opts.Lookup(client)
+	}
+
+	dest := r.Host
+	origin, err := toTCPAddr(r.Header.Get(relayOriginHeader), false /* portAny */)
+	if err != nil {
+		http.Error(w, "specified origin ip or port is invalid", http.StatusForbidden)
+		return
+	}
+	version := r.Header.Get(relayProxyProtocolHeader)
+	if version != "" && !IsProxyProtocolVersion(version) {
+		http.Error(w, "unknown version of proxy protocol", http.StatusForbidden)
+		return
+	}
+	serverAddr, _ := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+
+	if !limiter.acquire() {
+		glog.Warningf("rejected forwarding to %q from %q: too many connections", dest, clientAddr)
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+	defer limiter.release()
+
+	metrics := newRelayMetrics(labels)
+	dconn, err := dialForOrigin(r.Context(), client, serverAddr, origin, dest, s.opts.Targets, version, r.Header.Get(relayPodHeader))
+	if err != nil {
+		metrics.failed.Inc()
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	metrics.accepted.Inc()
+	metrics.active.Inc()
+	defer metrics.active.Dec()
+
+	relayStream(w, r, dconn, limiter, metrics.bytesOut, metrics.bytesIn)
+}
+
+// listen listens on the address of {r}, and notifies each connection accepted on it in the response.
+// Connections opened through the session registered to ReverseSessions are notified in the same way.
+// The listener is closed when the request is canceled.
+func (s *TLSRelayServer) listen(w http.ResponseWriter, r *http.Request) {
+	addr := r.Header.Get(relayListenHeader)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer ln.Close()
+	ctx := r.Context()
+	glog.Infof("listening on %q for %q", addr, r.RemoteAddr)
+
+	// Only this goroutine writes to the response, so connections are passed via conns
+	conns := make(chan net.Conn)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			select {
+			case conns <- conn:
+			case <-ctx.Done():
+				conn.Close()
+				return
+			}
+		}
+	}()
+	unregister := s.opts.Sessions.register(addr, func(origin net.Addr) (net.Conn, error) {
+		local, remote := net.Pipe()
+		select {
+		case conns <- &addrConn{Conn: remote, raddr: origin}:
+			return local, nil
+		case <-ctx.Done():
+			local.Close()
+			remote.Close()
+			return nil, fmt.Errorf("listener on %q for %q is closed", addr, r.RemoteAddr)
+		}
+	})
+	defer unregister()
+
+	w.WriteHeader(http.StatusOK)
+	fw := &flushWriter{w: w}
+	fw.Flush()
+	for {
+		var conn net.Conn
+		select {
+		case conn = <-conns:
+		case <-ctx.Done():
+			return
+		}
+		id := s.addPending(r.RemoteAddr, conn)
+		if _, err := fmt.Fprintf(fw, "%s %s\n", id, conn.RemoteAddr()); err != nil {
+			if conn := s.takePending(r.RemoteAddr, id); conn != nil {
+				conn.Close()
+			}
+			return
+		}
+	}
+}
+
+// relayAccepted relays the stream of {r} to the connection accepted on a listener for the same client
+func (s *TLSRelayServer) relayAccepted(w http.ResponseWriter, r *http.Request) {
+	conn := s.takePending(r.RemoteAddr, r.Header.Get(relayAcceptHeader))
+	if conn == nil {
+		http.Error(w, "no such connection", http.StatusNotFound)
+		return
+	}
+
+	relayStream(w, r, conn, nil /* limiter */, nil, nil)
+}
+
+// addPending adds {conn} accepted for {client} to wait for the client to claim it, and returns its ID.
+// It is closed if it isn't claimed in relayAcceptTimeout.
+func (s *TLSRelayServer) addPending(client string, conn net.Conn) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.nextID++
+	id := strconv.FormatUint(s.nextID, 10)
+	s.pending[id] = &pendingConn{
+		conn:   conn,
+		client: client,
+		timer: time.AfterFunc(relayAcceptTimeout, func() {
+			if conn := s.takePending(client, id); conn != nil {
+				glog.Warningf("closing connection from %q, which %q didn't claim in %v", conn.RemoteAddr(), client, relayAcceptTimeout)
+				conn.Close()
+			}
+		}),
+	}
+
+	return id
+}
+
+// takePending returns the connection of {id} accepted for {client} and stops waiting for it,
+// or nil if not found
+func (s *TLSRelayServer) takePending(client, id string) net.Conn {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.pending[id]
+	if !ok || p.client != client {
+		return nil
+	}
+	p.timer.Stop()
+	delete(s.pending, id)
+
+	return p.conn
+}
+
+// relayStream relays between the stream of {w} and {r} and {conn} until either of them is closed.
+// Bytes to the client and to {conn} are counted by {toClient} and {toConn}, if not nil.
+// Only the calling goroutine writes to {w}, because it must not be written after the handler returns.
+func relayStream(w http.ResponseWriter, r *http.Request, conn net.Conn, limiter *Limiter, toClient, toConn prometheus.Counter) {
+	defer conn.Close()
+
+	w.WriteHeader(http.StatusOK)
+	fw := &flushWriter{w: w}
+	fw.Flush()
+
+	go func() {
+		// Closing conn makes the copy below return
+		defer conn.Close()
+		limiter.copy(counted(conn, toConn), r.Body)
+	}()
+	limiter.copy(counted(fw, toClient), conn)
+}
+
+// counted returns {w} that counts bytes written by {counter}, or {w} as is if {counter} is nil
+func counted(w io.Writer, counter prometheus.Counter) io.Writer {
+	if counter == nil {
+		return w
+	}
+
+	return &countingWriter{w: w, counter: counter}
+}
+
+// flushWriter flushes each write to the response immediately, so that relayed data isn't delayed
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.Flush()
+
+	return n, err
+}
+
+func (f *flushWriter) Flush() {
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// addrConn is net.Conn whose remote address is {raddr}
+type addrConn struct {
+	net.Conn
+	raddr net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	return c.raddr
+}
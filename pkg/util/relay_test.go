@@ -0,0 +1,284 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// genTestTLSConfig returns TLSConfigLoader with a certificate for {usage} issued by the CA of {caCert} and {caKey}
+func genTestTLSConfig(t *testing.T, caCert, caKey []byte, usage x509.ExtKeyUsage) TLSConfigLoader {
+	cert, key, err := IssueCertificate(caCert, caKey, "test", []string{"127.0.0.1"}, usage, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to issue certificate: %v", err)
+	}
+	config, err := NewTLSConfig(cert, key, caCert)
+	if err != nil {
+		t.Fatalf("failed to create tls config: %v", err)
+	}
+
+	return func() (*tls.Config, error) {
+		return config, nil
+	}
+}
+
+// genTestTLSConfigs returns TLSConfigLoaders for a relay server, a client trusted by it and
+// a client that has a certificate issued by another CA
+func genTestTLSConfigs(t *testing.T) (TLSConfigLoader, TLSConfigLoader, TLSConfigLoader) {
+	caCert, caKey, err := GenerateCA("test-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to generate CA: %v", err)
+	}
+	otherCACert, otherCAKey, err := GenerateCA("other-ca", time.Hour)
+	if err != nil {
+		t.Fatalf("failed to generate CA: %v", err)
+	}
+	server := genTestTLSConfig(t, caCert, caKey, x509.ExtKeyUsageServerAuth)
+	client := genTestTLSConfig(t, caCert, caKey, x509.ExtKeyUsageClientAuth)
+	untrusted := genTestTLSConfig(t, otherCACert, otherCAKey, x509.ExtKeyUsageClientAuth)
+	// The untrusted client trusts the server, so that only the server can reject it
+	untrustedWithCA := func() (*tls.Config, error) {
+		cfg, _ := untrusted()
+		serverCfg, _ := client()
+		cfg = cfg.Clone()
+		cfg.RootCAs = serverCfg.RootCAs
+		return cfg, nil
+	}
+
+	return server, client, untrustedWithCA
+}
+
+func TestTLSRelayForward(t *testing.T) {
+	serverConfig, clientConfig, untrustedConfig := genTestTLSConfigs(t)
+
+	testCases := []struct {
+		name        string
+		localAddr   string
+		serverAddr  string
+		remoteAddr  string
+		relayDown   bool
+		config      TLSConfigLoader
+		msg         string
+		expectError bool
+	}{
+		{
+			name:        "Normal case",
+			localAddr:   "127.0.0.1:" + genRandomPort(),
+			serverAddr:  "127.0.0.1:" + genRandomPort(),
+			remoteAddr:  "127.0.0.1:" + genRandomPort(),
+			relayDown:   false,
+			config:      clientConfig,
+			msg:         "hello",
+			expectError: false,
+		},
+		{
+			name:       "Error case (client certificate not trusted)",
+			localAddr:  "127.0.0.1:" + genRandomPort(),
+			serverAddr: "127.0.0.1:" + genRandomPort(),
+			remoteAddr: "127.0.0.1:" + genRandomPort(),
+			relayDown:  false,
+			// Issued by another CA
+			config: untrustedConfig,
+			msg:    "hello",
+			// Should return error
+			expectError: true,
+		},
+		{
+			name:       "Error case (relay server down)",
+			localAddr:  "127.0.0.1:" + genRandomPort(),
+			serverAddr: "127.0.0.1:" + genRandomPort(),
+			remoteAddr: "127.0.0.1:" + genRandomPort(),
+			// Down
+			relayDown: true,
+			config:    clientConfig,
+			msg:       "hello",
+			// Should return error
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// start echo server on remoteAddr and relay server on serverAddr
+		go startEchoServer(ctx, tc.remoteAddr)
+		server := NewTLSRelayServer(tc.serverAddr, serverConfig, ServerOptions{})
+		if !tc.relayDown {
+			go server.ListenAndServe()
+		}
+
+		// start tunnel to forward remoteAddr to localAddr
+		tun := NewTLSTunnel(tc.localAddr, tc.serverAddr, tc.remoteAddr, "", tc.config)
+		tun.ForwardNB()
+
+		// Wait for two seconds for tunnel to be available
+		time.Sleep(2 * time.Second)
+		// test connecting to forwarded localAddr
+		msg, err := echoClient(tc.localAddr, tc.msg)
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("expected error, but no error returned")
+			}
+		} else {
+			if err != nil {
+				t.Errorf("expected no error, but got error %v", err)
+			}
+			if tc.msg != msg {
+				t.Errorf("expected msg %s, but got %s", tc.msg, msg)
+			}
+			if state := tun.Status().State; state != TunnelEstablished {
+				t.Errorf("expected tunnel to be %s, but got %s", TunnelEstablished, state)
+			}
+		}
+
+		// Cancel servers and tunnel
+		tun.Cancel()
+		server.Close()
+		cancel()
+		// Wait for a millisecond just to be sure that all servers closed
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTLSRelayRemoteForward(t *testing.T) {
+	serverConfig, clientConfig, _ := genTestTLSConfigs(t)
+
+	testCases := []struct {
+		name        string
+		localAddr   string
+		serverAddr  string
+		remoteAddr  string
+		echoDown    bool
+		msg         string
+		expectError bool
+	}{
+		{
+			name:        "Normal case",
+			localAddr:   "127.0.0.1:" + genRandomPort(),
+			serverAddr:  "127.0.0.1:" + genRandomPort(),
+			remoteAddr:  "127.0.0.1:" + genRandomPort(),
+			echoDown:    false,
+			msg:         "hello",
+			expectError: false,
+		},
+		{
+			name:       "Error case (echo server down)",
+			localAddr:  "127.0.0.1:" + genRandomPort(),
+			serverAddr: "127.0.0.1:" + genRandomPort(),
+			remoteAddr: "127.0.0.1:" + genRandomPort(),
+			// Down
+			echoDown: true,
+			msg:      "hello",
+			// Should return error
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// start echo server on localAddr and relay server on serverAddr
+		if !tc.echoDown {
+			go startEchoServer(ctx, tc.localAddr)
+		}
+		server := NewTLSRelayServer(tc.serverAddr, serverConfig, ServerOptions{})
+		go server.ListenAndServe()
+
+		// start tunnel to remoteForward localAddr to remoteAddr
+		tun := NewTLSTunnel(tc.localAddr, tc.serverAddr, tc.remoteAddr, "", clientConfig)
+		tun.RemoteForwardNB()
+
+		// Wait for two seconds for tunnel to be available
+		time.Sleep(2 * time.Second)
+
+		// test connecting to forwarded remoteAddr
+		msg, err := echoClient(tc.remoteAddr, tc.msg)
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("expected error, but no error returned")
+			}
+		} else {
+			if err != nil {
+				t.Errorf("expected no error, but got error %v", err)
+			}
+			if tc.msg != msg {
+				t.Errorf("expected msg %s, but got %s", tc.msg, msg)
+			}
+		}
+
+		// Cancel servers and tunnel
+		tun.Cancel()
+		server.Close()
+		cancel()
+		// Wait for a millisecond just to be sure that all servers closed
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestTLSRelayForwardLimits(t *testing.T) {
+	serverConfig, clientConfig, _ := genTestTLSConfigs(t)
+	localAddr := "127.0.0.1:" + genRandomPort()
+	serverAddr := "127.0.0.1:" + genRandomPort()
+	remoteAddr := "127.0.0.1:" + genRandomPort()
+	msg := "hello"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// start echo server on remoteAddr and relay server with limiter on serverAddr
+	go startEchoServer(ctx, remoteAddr)
+	limiter := NewLimiter(Limits{MaxConnections: 1})
+	server := NewTLSRelayServer(serverAddr, serverConfig, ServerOptions{
+		// Forwarder is identified by Relay-Client header, not by the address
+		Lookup: func(client string) (*Limiter, TunnelLabels) {
+			if client != ForwarderID("ns1", "fwd1") {
+				return nil, TunnelLabels{}
+			}
+			return limiter, TunnelLabels{}
+		},
+	})
+	go server.ListenAndServe()
+	defer server.Close()
+
+	// start tunnel to forward remoteAddr to localAddr
+	tun := NewTLSTunnel(localAddr, serverAddr, remoteAddr, ForwarderID("ns1", "fwd1"), clientConfig)
+	tun.ForwardNB()
+	defer tun.Cancel()
+
+	// Wait for two seconds for tunnel to be available
+	time.Sleep(2 * time.Second)
+
+	// First connection is kept open to use up the limit
+	conn, err := net.DialTimeout("tcp", localAddr, time.Second)
+	if err != nil {
+		t.Fatalf("connecting to %s failed: %v", localAddr, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	conn.Write([]byte(msg + "\n"))
+	if echo, err := bufio.NewReader(conn).ReadString('\n'); err != nil || msg != strings.TrimSpace(echo) {
+		t.Errorf("expected msg %s, but got %s (error: %v)", msg, strings.TrimSpace(echo), err)
+	}
+
+	// Second connection should be rejected
+	if _, err := echoClient(localAddr, msg); err == nil {
+		t.Errorf("expected error for connection exceeding limit, but no error returned")
+	}
+	if rejected := limiter.Counters().RejectedConnections; rejected != 1 {
+		t.Errorf("expected 1 rejected connection, but got %d", rejected)
+	}
+
+	// Rejecting connection shouldn't make the tunnel fail
+	if state := tun.Status().State; state != TunnelEstablished {
+		t.Errorf("expected tunnel to be kept %s, but got %s", TunnelEstablished, state)
+	}
+}
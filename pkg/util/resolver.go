@@ -0,0 +1,261 @@
+package util
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DefaultResolvConf is the default path to resolv.conf to read DNS servers from
+	DefaultResolvConf = "/etc/resolv.conf"
+	// dnsTimeout is the timeout for each DNS query
+	dnsTimeout = 5 * time.Second
+	// maxUDPSize is the maximum size of DNS responses over UDP
+	maxUDPSize = 512
+)
+
+var errNoServers = fmt.Errorf("no DNS servers configured")
+
+// Resolver resolves host names to IPv4 addresses with their TTL.
+// Unlike net.Resolver, it returns TTL of the records, and caches them until TTL expires.
+type Resolver struct {
+	// servers are DNS servers in host:port format, which are tried in order
+	servers []string
+	// exchange sends {query} to {server} and returns the response
+	exchange func(server string, query []byte) ([]byte, error)
+	// now returns the current time
+	now func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]resolverEntry
+}
+
+type resolverEntry struct {
+	addrs   []string
+	expires time.Time
+}
+
+// NewResolver returns a Resolver that queries {servers} in host:port format
+func NewResolver(servers []string) *Resolver {
+	return &Resolver{
+		servers:  servers,
+		exchange: exchangeDNS,
+		now:      time.Now,
+		cache:    map[string]resolverEntry{},
+	}
+}
+
+// NewResolverFromResolvConf returns a Resolver that queries nameservers in {path}
+func NewResolverFromResolvConf(path string) (*Resolver, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	servers := parseResolvConf(f)
+	if len(servers) == 0 {
+		return nil, errNoServers
+	}
+
+	return NewResolver(servers), nil
+}
+
+// parseResolvConf returns nameservers in resolv.conf read from {r} in host:port format
+func parseResolvConf(r io.Reader) []string {
+	servers := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if net.ParseIP(fields[1]) == nil {
+			continue
+		}
+		servers = append(servers, net.JoinHostPort(fields[1], "53"))
+	}
+
+	return servers
+}
+
+// Resolve returns IPv4 addresses of {host} sorted, and the duration until they expire.
+// {host} is always treated as a fully qualified domain name, and IP address is returned as is
+// with zero duration. Results are cached until TTL of the records expires.
+func (r *Resolver) Resolve(host string) ([]string, time.Duration, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, 0, nil
+	}
+
+	fqdn := host
+	if !strings.HasSuffix(fqdn, ".") {
+		fqdn += "."
+	}
+
+	now := r.now()
+	r.mu.Lock()
+	entry, ok := r.cache[fqdn]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.addrs, entry.expires.Sub(now), nil
+	}
+
+	if len(r.servers) == 0 {
+		return nil, 0, errNoServers
+	}
+
+	// The lock isn't held while querying servers, not to block resolving other hosts
+	var lastErr error
+	for _, server := range r.servers {
+		addrs, ttl, err := r.query(server, fqdn)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		r.mu.Lock()
+		r.cache[fqdn] = resolverEntry{addrs: addrs, expires: now.Add(ttl)}
+		r.mu.Unlock()
+		return addrs, ttl, nil
+	}
+
+	return nil, 0, lastErr
+}
+
+// query asks {server} for A records of {fqdn}, and returns the addresses sorted and the minimum TTL
+// of the records in the answer, including CNAME records
+func (r *Resolver) query(server, fqdn string) ([]string, time.Duration, error) {
+	name, err := dnsmessage.NewName(fqdn)
+	if err != nil {
+		return nil, 0, err
+	}
+	// ID is random, not to accept spoofed responses easily
+	var idBytes [2]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return nil, 0, err
+	}
+	id := binary.BigEndian.Uint16(idBytes[:])
+	question := dnsmessage.Question{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{question},
+	}
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp, err := r.exchange(server, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var res dnsmessage.Message
+	if err := res.Unpack(resp); err != nil {
+		return nil, 0, err
+	}
+	if !res.Header.Response || res.Header.ID != id {
+		return nil, 0, fmt.Errorf("DNS response from %s has unexpected id %d", server, res.Header.ID)
+	}
+	if len(res.Questions) != 1 || !sameQuestion(res.Questions[0], question) {
+		return nil, 0, fmt.Errorf("DNS response from %s has unexpected question %v", server, res.Questions)
+	}
+	if res.Header.RCode == dnsmessage.RCodeNameError {
+		return nil, 0, fmt.Errorf("no such host %q", strings.TrimSuffix(fqdn, "."))
+	}
+	if res.Header.RCode != dnsmessage.RCodeSuccess {
+		return nil, 0, fmt.Errorf("DNS server %s failed to resolve %q: %v", server, strings.TrimSuffix(fqdn, "."), res.Header.RCode)
+	}
+
+	addrs := []string{}
+	var ttl uint32
+	for i, answer := range res.Answers {
+		if i == 0 || answer.Header.TTL < ttl {
+			ttl = answer.Header.TTL
+		}
+		if a, ok := answer.Body.(*dnsmessage.AResource); ok {
+			addrs = append(addrs, net.IP(a.A[:]).String())
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, 0, fmt.Errorf("no IPv4 address found for %q", strings.TrimSuffix(fqdn, "."))
+	}
+	sort.Strings(addrs)
+
+	return addrs, time.Duration(ttl) * time.Second, nil
+}
+
+// sameQuestion returns true if {a} and {b} are the same question. Names are compared case-insensitively,
+// because servers may change the case of the name in the response.
+func sameQuestion(a, b dnsmessage.Question) bool {
+	return a.Type == b.Type && a.Class == b.Class && strings.EqualFold(a.Name.String(), b.Name.String())
+}
+
+// exchangeDNS sends {query} to {server} over UDP and returns the response.
+// The query is retried over TCP, if the response is truncated.
+func exchangeDNS(server string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("udp", server, dnsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, maxUDPSize)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var header dnsmessage.Header
+	var p dnsmessage.Parser
+	if header, err = p.Start(resp[:n]); err != nil {
+		return nil, err
+	}
+	if !header.Truncated {
+		return resp[:n], nil
+	}
+
+	return exchangeDNSOverTCP(server, query)
+}
+
+// exchangeDNSOverTCP sends {query} to {server} over TCP and returns the response
+func exchangeDNSOverTCP(server string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout("tcp", server, dnsTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+
+	// Messages over TCP are prefixed with 2 bytes length
+	buf := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(buf, uint16(len(query)))
+	copy(buf[2:], query)
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(buf[:2]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,267 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsRecord is a record in a fake DNS response
+type dnsRecord struct {
+	cname string
+	a     [4]byte
+	ttl   uint32
+}
+
+// fakeDNSServer returns an exchange function that responds with {records} and {rcode}, and counts queries
+func fakeDNSServer(t *testing.T, rcode dnsmessage.RCode, records []dnsRecord, count *int) func(string, []byte) ([]byte, error) {
+	return func(server string, query []byte) ([]byte, error) {
+		*count++
+
+		var q dnsmessage.Message
+		if err := q.Unpack(query); err != nil {
+			t.Fatalf("failed to unpack query: %v", err)
+		}
+
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: q.Header.ID, Response: true, RCode: rcode})
+		b.StartQuestions()
+		b.Question(q.Questions[0])
+		b.StartAnswers()
+		for _, record := range records {
+			header := dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Class: dnsmessage.ClassINET, TTL: record.ttl}
+			if record.cname != "" {
+				b.CNAMEResource(header, dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(record.cname)})
+			} else {
+				b.AResource(header, dnsmessage.AResource{A: record.a})
+			}
+		}
+
+		return b.Finish()
+	}
+}
+
+func TestResolve(t *testing.T) {
+	testCases := []struct {
+		name          string
+		host          string
+		rcode         dnsmessage.RCode
+		records       []dnsRecord
+		expected      []string
+		expectedTTL   time.Duration
+		expectedErr   error
+		expectedCount int
+	}{
+		{
+			name:          "Normal case (IP address)",
+			host:          "192.168.122.139",
+			expected:      []string{"192.168.122.139"},
+			expectedTTL:   0,
+			expectedCount: 0,
+		},
+		{
+			name:  "Normal case (A records)",
+			host:  "db.example.com",
+			rcode: dnsmessage.RCodeSuccess,
+			records: []dnsRecord{
+				{a: [4]byte{192, 168, 122, 140}, ttl: 60},
+				{a: [4]byte{192, 168, 122, 139}, ttl: 30},
+			},
+			expected:      []string{"192.168.122.139", "192.168.122.140"},
+			expectedTTL:   30 * time.Second,
+			expectedCount: 1,
+		},
+		{
+			name:  "Normal case (CNAME record with shorter TTL)",
+			host:  "db.example.com.",
+			rcode: dnsmessage.RCodeSuccess,
+			records: []dnsRecord{
+				{cname: "primary.example.com.", ttl: 10},
+				{a: [4]byte{192, 168, 122, 139}, ttl: 60},
+			},
+			expected:      []string{"192.168.122.139"},
+			expectedTTL:   10 * time.Second,
+			expectedCount: 1,
+		},
+		{
+			name:          "Error case (no such host)",
+			host:          "db.example.com",
+			rcode:         dnsmessage.RCodeNameError,
+			expectedErr:   fmt.Errorf("no such host %q", "db.example.com"),
+			expectedCount: 1,
+		},
+		{
+			name:  "Error case (no A records)",
+			host:  "db.example.com",
+			rcode: dnsmessage.RCodeSuccess,
+			records: []dnsRecord{
+				{cname: "primary.example.com.", ttl: 10},
+			},
+			expectedErr:   fmt.Errorf("no IPv4 address found for %q", "db.example.com"),
+			expectedCount: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		count := 0
+		r := NewResolver([]string{"10.96.0.10:53"})
+		r.exchange = fakeDNSServer(t, tc.rcode, tc.records, &count)
+
+		addrs, ttl, err := r.Resolve(tc.host)
+		if !reflect.DeepEqual(tc.expectedErr, err) {
+			t.Errorf("expected err:%v, but got err:%v", tc.expectedErr, err)
+		}
+		if tc.expectedErr == nil {
+			if !reflect.DeepEqual(tc.expected, addrs) {
+				t.Errorf("expected:%v, but got:%v", tc.expected, addrs)
+			}
+			if tc.expectedTTL != ttl {
+				t.Errorf("expected ttl:%v, but got ttl:%v", tc.expectedTTL, ttl)
+			}
+		}
+		if tc.expectedCount != count {
+			t.Errorf("expected %d queries, but got %d", tc.expectedCount, count)
+		}
+	}
+}
+
+func TestResolveCache(t *testing.T) {
+	count := 0
+	now := time.Unix(0, 0)
+	r := NewResolver([]string{"10.96.0.10:53"})
+	r.exchange = fakeDNSServer(t, dnsmessage.RCodeSuccess, []dnsRecord{{a: [4]byte{192, 168, 122, 139}, ttl: 30}}, &count)
+	r.now = func() time.Time { return now }
+
+	// First resolution queries the server
+	if _, ttl, err := r.Resolve("db.example.com"); err != nil || ttl != 30*time.Second || count != 1 {
+		t.Errorf("expected 30s ttl by 1 query, but got ttl:%v, err:%v, queries:%d", ttl, err, count)
+	}
+
+	// Cached until TTL expires, and the remaining duration is returned
+	now = now.Add(20 * time.Second)
+	if _, ttl, err := r.Resolve("db.example.com"); err != nil || ttl != 10*time.Second || count != 1 {
+		t.Errorf("expected 10s ttl from cache, but got ttl:%v, err:%v, queries:%d", ttl, err, count)
+	}
+
+	// Queried again after TTL expires
+	now = now.Add(10 * time.Second)
+	if _, ttl, err := r.Resolve("db.example.com"); err != nil || ttl != 30*time.Second || count != 2 {
+		t.Errorf("expected 30s ttl by 2 queries, but got ttl:%v, err:%v, queries:%d", ttl, err, count)
+	}
+}
+
+func TestResolveFallback(t *testing.T) {
+	count := 0
+	succeed := fakeDNSServer(t, dnsmessage.RCodeSuccess, []dnsRecord{{a: [4]byte{192, 168, 122, 139}, ttl: 30}}, &count)
+	r := NewResolver([]string{"10.96.0.10:53", "10.96.0.11:53"})
+	r.exchange = func(server string, query []byte) ([]byte, error) {
+		if server == "10.96.0.10:53" {
+			return nil, fmt.Errorf("timeout")
+		}
+		return succeed(server, query)
+	}
+
+	addrs, _, err := r.Resolve("db.example.com")
+	if err != nil {
+		t.Errorf("expected no error, but got err:%v", err)
+	}
+	if expected := []string{"192.168.122.139"}; !reflect.DeepEqual(expected, addrs) {
+		t.Errorf("expected:%v, but got:%v", expected, addrs)
+	}
+}
+
+func TestResolveUnexpectedResponse(t *testing.T) {
+	testCases := []struct {
+		name        string
+		header      func(q dnsmessage.Header) dnsmessage.Header
+		question    string
+		expectedErr bool
+	}{
+		{
+			name:        "Normal case (name in different case)",
+			header:      func(q dnsmessage.Header) dnsmessage.Header { return dnsmessage.Header{ID: q.ID, Response: true} },
+			question:    "DB.example.com.",
+			expectedErr: false,
+		},
+		{
+			name:        "Error case (different id)",
+			header:      func(q dnsmessage.Header) dnsmessage.Header { return dnsmessage.Header{ID: q.ID + 1, Response: true} },
+			question:    "db.example.com.",
+			expectedErr: true,
+		},
+		{
+			name:        "Error case (not a response)",
+			header:      func(q dnsmessage.Header) dnsmessage.Header { return dnsmessage.Header{ID: q.ID} },
+			question:    "db.example.com.",
+			expectedErr: true,
+		},
+		{
+			name:        "Error case (different question)",
+			header:      func(q dnsmessage.Header) dnsmessage.Header { return dnsmessage.Header{ID: q.ID, Response: true} },
+			question:    "evil.example.com.",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		r := NewResolver([]string{"10.96.0.10:53"})
+		r.exchange = func(server string, query []byte) ([]byte, error) {
+			var q dnsmessage.Message
+			if err := q.Unpack(query); err != nil {
+				t.Fatalf("failed to unpack query: %v", err)
+			}
+			name := dnsmessage.MustNewName(tc.question)
+			b := dnsmessage.NewBuilder(nil, tc.header(q.Header))
+			b.StartQuestions()
+			b.Question(dnsmessage.Question{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET})
+			b.StartAnswers()
+			b.AResource(dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: 30}, dnsmessage.AResource{A: [4]byte{192, 168, 122, 139}})
+			return b.Finish()
+		}
+
+		_, _, err := r.Resolve("db.example.com")
+		if tc.expectedErr != (err != nil) {
+			t.Errorf("expected error:%v, but got err:%v", tc.expectedErr, err)
+		}
+	}
+}
+
+func TestParseResolvConf(t *testing.T) {
+	testCases := []struct {
+		name     string
+		conf     string
+		expected []string
+	}{
+		{
+			name:     "Normal case",
+			conf:     "search default.svc.cluster.local svc.cluster.local\nnameserver 10.96.0.10\noptions ndots:5\n",
+			expected: []string{"10.96.0.10:53"},
+		},
+		{
+			name:     "Normal case (multiple nameservers and IPv6)",
+			conf:     "nameserver 10.96.0.10\n# comment\nnameserver fd00::10\n",
+			expected: []string{"10.96.0.10:53", "[fd00::10]:53"},
+		},
+		{
+			name:     "Normal case (invalid nameserver)",
+			conf:     "nameserver\nnameserver dns.example.com\n",
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		servers := parseResolvConf(strings.NewReader(tc.conf))
+		if !reflect.DeepEqual(tc.expected, servers) {
+			t.Errorf("expected:%v, but got:%v", tc.expected, servers)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package util
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// reverseRetryInterval is the interval to retry opening reverse channels. Sessions are registered
+// before clients receive the replies to their requests, so opening may fail just after registration.
+const reverseRetryInterval = 100 * time.Millisecond
+
+// ReverseOpener opens a reverse channel to the client of a session for a connection from {origin}
+type ReverseOpener func(origin net.Addr) (net.Conn, error)
+
+// ReverseSessions is a registry of sessions of clients that requested remote forwarding, keyed by
+// the address that they requested to listen on. It allows listeners other than the ones of the servers
+// to relay connections to the clients through reverse channels, even while the clients are reconnecting.
+type ReverseSessions struct {
+	// mutex protects sessions and registered
+	mutex    sync.Mutex
+	nextID   uint64
+	sessions map[string]map[uint64]ReverseOpener
+	// registered is closed and replaced when a session is registered, to wake up waiters
+	registered chan struct{}
+}
+
+// NewReverseSessions returns an empty ReverseSessions
+func NewReverseSessions() *ReverseSessions {
+	return &ReverseSessions{
+		sessions:   map[string]map[uint64]ReverseOpener{},
+		registered: make(chan struct{}),
+	}
+}
+
+// register registers {open} of a session for {addr}, and returns a function to unregister it.
+// It does nothing for nil ReverseSessions.
+func (r *ReverseSessions) register(addr string, open ReverseOpener) func() {
+	if r == nil {
+		return func() {}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.nextID++
+	id := r.nextID
+	if r.sessions[addr] == nil {
+		r.sessions[addr] = map[uint64]ReverseOpener{}
+	}
+	r.sessions[addr][id] = open
+	close(r.registered)
+	r.registered = make(chan struct{})
+
+	return func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		delete(r.sessions[addr], id)
+		if len(r.sessions[addr]) == 0 {
+			delete(r.sessions, addr)
+		}
+	}
+}
+
+// Count returns the number of sessions registered for {addr}
+func (r *ReverseSessions) Count(addr string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return len(r.sessions[addr])
+}
+
+// openers returns openers of the sessions registered for any of {addrs} in random order,
+// and a channel closed when another session is registered
+func (r *ReverseSessions) openers(addrs []string) ([]ReverseOpener, <-chan struct{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	openers := []ReverseOpener{}
+	for _, addr := range addrs {
+		for _, open := range r.sessions[addr] {
+			openers = append(openers, open)
+		}
+	}
+	rand.Shuffle(len(openers), func(i, j int) { openers[i], openers[j] = openers[j], openers[i] })
+
+	return openers, r.registered
+}
+
+// Open opens a reverse channel for a connection from {origin} through one of the sessions registered
+// for any of {addrs}, which is selected randomly. If none is registered or none can be opened,
+// it retries until a session is opened for up to {timeout}.
+func (r *ReverseSessions) Open(addrs []string, origin net.Addr, timeout time.Duration) (net.Conn, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		openers, registered := r.openers(addrs)
+		for _, open := range openers {
+			conn, err := open(origin)
+			if err == nil {
+				return conn, nil
+			}
+			glog.Warningf("failed to open reverse channel for %q via %v: %v", origin, addrs, err)
+		}
+
+		select {
+		case <-registered:
+		case <-time.After(reverseRetryInterval):
+		case <-timer.C:
+			return nil, fmt.Errorf("no session available for %v in %v", addrs, timeout)
+		}
+	}
+}
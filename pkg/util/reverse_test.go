@@ -0,0 +1,133 @@
+package util
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestReverseSessionsOpen(t *testing.T) {
+	serverConfig, clientConfig, _ := genTestTLSConfigs(t)
+
+	testCases := []struct {
+		name        string
+		localAddr   string
+		serverAddr  string
+		remoteAddr  string
+		transport   string
+		tunnelDown  bool
+		msg         string
+		expectError bool
+	}{
+		{
+			name:        "Normal case (ssh)",
+			localAddr:   "127.0.0.1:" + genRandomPort(),
+			serverAddr:  "127.0.0.1:" + genRandomPort(),
+			remoteAddr:  "127.0.0.1:" + genRandomPort(),
+			transport:   "ssh",
+			tunnelDown:  false,
+			msg:         "hello",
+			expectError: false,
+		},
+		{
+			name:        "Normal case (tls)",
+			localAddr:   "127.0.0.1:" + genRandomPort(),
+			serverAddr:  "127.0.0.1:" + genRandomPort(),
+			remoteAddr:  "127.0.0.1:" + genRandomPort(),
+			transport:   "tls",
+			tunnelDown:  false,
+			msg:         "hello",
+			expectError: false,
+		},
+		{
+			name:       "Error case (no session registered)",
+			localAddr:  "127.0.0.1:" + genRandomPort(),
+			serverAddr: "127.0.0.1:" + genRandomPort(),
+			remoteAddr: "127.0.0.1:" + genRandomPort(),
+			transport:  "ssh",
+			// Down
+			tunnelDown: true,
+			msg:        "hello",
+			// Should return error
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		sessions := NewReverseSessions()
+
+		// start echo server on localAddr and server with sessions on serverAddr
+		go startEchoServer(ctx, tc.localAddr)
+		var tun *Tunnel
+		var closeServer func() error
+		if tc.transport == "tls" {
+			server := NewTLSRelayServer(tc.serverAddr, serverConfig, ServerOptions{Sessions: sessions})
+			go server.ListenAndServe()
+			closeServer = server.Close
+			tun = NewTLSTunnel(tc.localAddr, tc.serverAddr, tc.remoteAddr, "", clientConfig)
+		} else {
+			server := NewSSHServer(tc.serverAddr, ServerOptions{Sessions: sessions})
+			go server.ListenAndServe()
+			closeServer = server.Close
+			tun = NewTunnel(tc.localAddr, tc.serverAddr, tc.remoteAddr, &ssh.ClientConfig{
+				Auth:            []ssh.AuthMethod{},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			})
+		}
+
+		// start tunnel to remoteForward localAddr to remoteAddr
+		if !tc.tunnelDown {
+			tun.RemoteForwardNB()
+		}
+
+		// Open waits for the session to be registered
+		origin := &net.TCPAddr{IP: net.ParseIP("192.168.0.10"), Port: 12345}
+		conn, err := sessions.Open([]string{tc.remoteAddr}, origin, 2*time.Second)
+		if tc.expectError {
+			if err == nil {
+				t.Errorf("expected error, but no error returned")
+				conn.Close()
+			}
+		} else {
+			if err != nil {
+				t.Errorf("expected no error, but got error %v", err)
+			} else {
+				if n := sessions.Count(tc.remoteAddr); n != 1 {
+					t.Errorf("expected 1 session registered, but got %d", n)
+				}
+				conn.Write([]byte(tc.msg + "\n"))
+				echo, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil || tc.msg != strings.TrimSpace(echo) {
+					t.Errorf("expected msg %s, but got %s (error: %v)", tc.msg, strings.TrimSpace(echo), err)
+				}
+				conn.Close()
+			}
+		}
+
+		// Cancel servers and tunnel
+		tun.Cancel()
+		closeServer()
+		cancel()
+
+		// Sessions should be unregistered when the tunnel is closed
+		if !tc.tunnelDown {
+			deadline := time.Now().Add(2 * time.Second)
+			for sessions.Count(tc.remoteAddr) != 0 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if n := sessions.Count(tc.remoteAddr); n != 0 {
+				t.Errorf("expected no session registered after closing, but got %d", n)
+			}
+		}
+		// Wait for a millisecond just to be sure that all servers closed
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"strconv"
 	"sync"
 	"time"
@@ -14,6 +15,7 @@ import (
 
 	glssh "github.com/gliderlabs/ssh"
 	"github.com/golang/glog"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -21,34 +23,536 @@ const (
 	// SSHPort is port number to used for ssh server
 	// TODO: change this to variable
 	SSHPort = "2022"
+	// DefaultIdleTimeout is the default timeout for ssh servers in gateways to close connections without any activity.
+	// Forwarders need to send keepalive more often than it.
+	DefaultIdleTimeout = 2 * time.Minute
+	// keepAliveRequest is the name of global request used for keepalive, which is the same to openssh
+	keepAliveRequest = "keepalive@openssh.com"
+	// proxyChannelType is the type of channels that are direct-tcpip asking the server
+	// to send PROXY protocol header to the destination
+	proxyChannelType = "direct-tcpip-proxy@submariner.io"
 )
 
-// Tunnel represents ssh tunnel
+// TunnelState represents a state of a tunnel
+type TunnelState string
+
+const (
+	// TunnelConnecting means that the tunnel is connecting to the server
+	TunnelConnecting TunnelState = "Connecting"
+	// TunnelEstablished means that the tunnel is connected and ready to forward
+	TunnelEstablished TunnelState = "Established"
+	// TunnelBackingOff means that the tunnel failed and is waiting to retry
+	TunnelBackingOff TunnelState = "BackingOff"
+	// TunnelFailed means that the tunnel failed and gave up retrying
+	TunnelFailed TunnelState = "Failed"
+)
+
+// TunnelStatus represents a status of a tunnel
+type TunnelStatus struct {
+	// State is the current state of the tunnel
+	State TunnelState
+	// LastTransitionTime is the time when State changed last
+	LastTransitionTime time.Time
+	// NotEstablishedSince is the time since when the tunnel hasn't been established.
+	// It's zero while the tunnel is established.
+	NotEstablishedSince time.Time
+	// LastError is the error that the tunnel failed with last
+	LastError error
+	// LastErrorTime is the time when LastError happened
+	LastErrorTime time.Time
+}
+
+// relayClient represents a connection to the server endpoint, which relays connections
+// to and from the remote endpoint. It is implemented by ssh and by mTLS relay.
+type relayClient interface {
+	// DialTCP connects to {raddr} from {laddr} of the server
+	DialTCP(n string, laddr, raddr *net.TCPAddr) (net.Conn, error)
+	// DialProxy connects to {raddr} from the server, and asks it to send PROXY protocol header of {version}
+	// telling {origin} as the source and {pod}, namespace/name of the original pod, if not empty
+	DialProxy(origin, raddr *net.TCPAddr, version, pod string) (net.Conn, error)
+	// Listen listens on {addr} of the server, and returns connections accepted on it
+	Listen(n, addr string) (net.Listener, error)
+	// KeepAlive sends a keepalive to the server and returns true if it responds within {timeout}
+	KeepAlive(timeout time.Duration) bool
+	// Wait blocks until the connection is closed and returns the reason
+	Wait() error
+	// Close closes the connection
+	Close() error
+}
+
+// sshRelayClient is relayClient by ssh
+type sshRelayClient struct {
+	*ssh.Client
+}
+
+func (c sshRelayClient) DialProxy(origin, raddr *net.TCPAddr, version, pod string) (net.Conn, error) {
+	payload := ssh.Marshal(&proxyForwardChannelData{
+		DestAddr:      raddr.IP.String(),
+		DestPort:      uint32(raddr.Port),
+		OriginAddr:    origin.IP.String(),
+		OriginPort:    uint32(origin.Port),
+		ProxyProtocol: version,
+		Pod:           pod,
+	})
+	ch, reqs, err := c.OpenChannel(proxyChannelType, payload)
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+
+	return &channelConn{Channel: ch, laddr: origin, raddr: raddr}, nil
+}
+
+func (c sshRelayClient) KeepAlive(timeout time.Duration) bool {
+	return sendKeepAlive(c.Client, timeout)
+}
+
+// Tunnel represents ssh tunnel, or mTLS relay tunnel created by NewTLSTunnel
 type Tunnel struct {
 	localEndpoint  string
 	serverEndpoint string
 	remoteEndpoint string
-	config         *ssh.ClientConfig
-	context        context.Context
-	backoff        backoffv4.BackOffContext
-	Cancel         context.CancelFunc
+	// dial connects to the server endpoint
+	dial         func() (relayClient, error)
+	context      context.Context
+	backoff      backoffv4.BackOffContext
+	cancel       context.CancelFunc
+	drainTimeout time.Duration
+
+	keepAliveInterval time.Duration
+	keepAliveCountMax int
+	limiter           *Limiter
+	metrics           *tunnelMetrics
+
+	// portRangeStart and portRangeEnd are the range of original local ports set by SetPortRange.
+	// originalDstPort returns the original local port of a connection redirected by iptables.
+	portRangeStart  int
+	portRangeEnd    int
+	originalDstPort func(conn net.Conn) (int, error)
+
+	// proxyProtocol is the version of PROXY protocol header sent to the local endpoint on remote forwarding,
+	// and proxyDestination is the destination address told in it. Empty proxyProtocol means no header.
+	proxyProtocol    string
+	proxyDestination net.Addr
+	// originProxyProtocol is the version of PROXY protocol header that the server is asked to send to
+	// the remote endpoint on forwarding, which tells the client of each connection accepted on the local
+	// endpoint and originPod, namespace/name of it. Empty originProxyProtocol means no header.
+	originProxyProtocol string
+	originPod           string
+
+	// mutex protects active, done and status
+	mutex  sync.Mutex
+	active int
+	done   chan struct{}
+	status TunnelStatus
 }
 
 // NewTunnel returns a Tunnel instance
 func NewTunnel(local, server, remote string, config *ssh.ClientConfig) *Tunnel {
+	t := newTunnel(local, server, remote)
+	t.dial = func() (relayClient, error) {
+		cli, err := ssh.Dial("tcp", server, config)
+		if err != nil {
+			return nil, err
+		}
+		return sshRelayClient{cli}, nil
+	}
+
+	return t
+}
+
+// newTunnel returns a Tunnel instance without the way to dial the server endpoint
+func newTunnel(local, server, remote string) *Tunnel {
 	ctx, cf := context.WithCancel(context.Background())
 	b := backoffv4.WithContext(backoffv4.NewExponentialBackOff(), ctx)
+	now := time.Now()
 	return &Tunnel{
-		localEndpoint:  local,
-		serverEndpoint: server,
-		remoteEndpoint: remote,
-		config:         config,
-		context:        ctx,
-		backoff:        b,
-		Cancel:         cf,
+		localEndpoint:   local,
+		serverEndpoint:  server,
+		remoteEndpoint:  remote,
+		context:         ctx,
+		backoff:         b,
+		cancel:          cf,
+		done:            make(chan struct{}),
+		metrics:         newTunnelMetrics(TunnelLabels{}),
+		originalDstPort: originalDstPort,
+		status: TunnelStatus{
+			State:               TunnelConnecting,
+			LastTransitionTime:  now,
+			NotEstablishedSince: now,
+		},
 	}
 }
 
+// SetDrainTimeout sets the grace period that connections being forwarded
+// are allowed to finish after the tunnel is canceled.
+// Connections that remain after the grace period are closed forcibly.
+// Zero, which is the default, means that connections are closed immediately.
+func (t *Tunnel) SetDrainTimeout(timeout time.Duration) {
+	t.drainTimeout = timeout
+}
+
+// SetKeepAlive makes the tunnel send keepalive requests to the server every {interval}.
+// If the server doesn't respond to {countMax} requests in a row, the tunnel regards the server
+// as dead, closes the connection and reconnects to it.
+// Zero {interval}, which is the default, disables keepalive.
+func (t *Tunnel) SetKeepAlive(interval time.Duration, countMax int) {
+	t.keepAliveInterval = interval
+	t.keepAliveCountMax = countMax
+}
+
+// SetLimiter makes the tunnel limit connections and bandwidth with {limiter}.
+// The same limiter can be set to multiple tunnels to limit them in total.
+// Nil {limiter}, which is the default, means unlimited.
+func (t *Tunnel) SetLimiter(limiter *Limiter) {
+	t.limiter = limiter
+}
+
+// SetPortRange makes the tunnel forward connections for a range of ports from {start} to {end},
+// which are redirected to the local endpoint by iptables. Each connection is forwarded to the port
+// of the remote endpoint with the same offset from the remote endpoint's port as its original port from {start}.
+// Zero {end}, which is the default, means that all connections are forwarded to the remote endpoint as is.
+func (t *Tunnel) SetPortRange(start, end int) {
+	t.portRangeStart = start
+	t.portRangeEnd = end
+}
+
+// SetProxyProtocol makes the tunnel send PROXY protocol header of {version} to the local endpoint
+// on remote forwarding, which tells the original client of the connection accepted on the remote endpoint
+// as the source and {destination} as the destination. Empty {version}, which is the default, sends no header.
+func (t *Tunnel) SetProxyProtocol(version string, destination string) error {
+	if version == "" {
+		t.proxyProtocol = ""
+		return nil
+	}
+	if !IsProxyProtocolVersion(version) {
+		return fmt.Errorf("unknown version of proxy protocol %q", version)
+	}
+	addr, err := net.ResolveTCPAddr("tcp", destination)
+	if err != nil {
+		return err
+	}
+	t.proxyProtocol = version
+	t.proxyDestination = addr
+
+	return nil
+}
+
+// SetOriginProxyProtocol makes the tunnel ask the server to send PROXY protocol header of {version}
+// to the remote endpoint on forwarding, which tells the client of each connection accepted on
+// the local endpoint as the source and {pod}, namespace/name of it, if not empty.
+// Empty {version}, which is the default, makes the server connect from the server endpoint without header.
+func (t *Tunnel) SetOriginProxyProtocol(version, pod string) error {
+	if version != "" && !IsProxyProtocolVersion(version) {
+		return fmt.Errorf("unknown version of proxy protocol %q", version)
+	}
+	t.originProxyProtocol = version
+	t.originPod = pod
+
+	return nil
+}
+
+// SetMetricsLabels sets {labels} to metrics recorded for the tunnel
+func (t *Tunnel) SetMetricsLabels(labels TunnelLabels) {
+	t.metrics = newTunnelMetrics(labels)
+}
+
+// Cancel stops the tunnel.
+// Listener and ssh client for the tunnel are closed, and no more retry happens.
+// Use Done() or Wait() to confirm that the tunnel is actually stopped.
+func (t *Tunnel) Cancel() {
+	t.cancel()
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.closeDoneIfStopped()
+}
+
+// Done returns a channel that is closed when the tunnel is canceled and
+// all the listeners and connections for the tunnel are closed.
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait blocks until the tunnel is canceled and stopped.
+func (t *Tunnel) Wait() {
+	<-t.done
+}
+
+// Status returns the current status of the tunnel
+func (t *Tunnel) Status() TunnelStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.status
+}
+
+// setState changes the state of the tunnel to {state}
+func (t *Tunnel) setState(state TunnelState) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.transition(state)
+}
+
+// transition changes the state of the tunnel to {state} and records the time of the transition.
+// The caller must hold the mutex.
+func (t *Tunnel) transition(state TunnelState) {
+	if t.status.State == state {
+		return
+	}
+	now := time.Now()
+	if state == TunnelEstablished {
+		t.status.NotEstablishedSince = time.Time{}
+	} else if t.status.State == TunnelEstablished {
+		t.status.NotEstablishedSince = now
+	}
+	t.status.State = state
+	t.status.LastTransitionTime = now
+}
+
+// setEstablished changes the state of the tunnel to established.
+// Backoff is also reset, so that the tunnel will be retried from the shortest interval
+// and without being limited by the max elapsed time when it fails later.
+func (t *Tunnel) setEstablished() {
+	t.backoff.Reset()
+	t.setState(TunnelEstablished)
+}
+
+// begin marks the tunnel active and connecting.
+// It returns false if the tunnel is already canceled.
+func (t *Tunnel) begin() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.context.Err() != nil {
+		return false
+	}
+	t.active++
+	t.transition(TunnelConnecting)
+
+	return true
+}
+
+// end marks the tunnel inactive and records {err} that the tunnel ended with.
+func (t *Tunnel) end(err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if err != nil {
+		t.status.LastError = err
+		t.status.LastErrorTime = time.Now()
+	}
+	t.active--
+	t.closeDoneIfStopped()
+}
+
+// closeDoneIfStopped closes done channel if the tunnel is canceled and is no longer active.
+// It must be called with mutex held.
+func (t *Tunnel) closeDoneIfStopped() {
+	if t.context.Err() == nil || t.active > 0 {
+		return
+	}
+	select {
+	case <-t.done:
+		// Already closed
+	default:
+		close(t.done)
+	}
+}
+
+// closeOnCancel closes {closer} when the tunnel is canceled.
+// Returned function needs to be called to release the goroutine,
+// once {closer} is no longer used.
+func (t *Tunnel) closeOnCancel(closer io.Closer) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-t.context.Done():
+			closer.Close()
+		case <-stop:
+		}
+	}()
+
+	return func() { close(stop) }
+}
+
+// connMonitor monitors liveness of a connection to server
+type connMonitor struct {
+	stopCh chan struct{}
+	deadCh chan struct{}
+	once   sync.Once
+	err    error
+}
+
+// startConnMonitor starts monitoring {cli}.
+// It sends keepalive via {cli} if keepalive is enabled, and also watches {cli} to be closed.
+// When the server is regarded as dead, {cli} and {closers} are closed
+// to make the tunnel using them fail.
+// stop() needs to be called to release the goroutines, once {cli} is no longer used.
+func (t *Tunnel) startConnMonitor(cli relayClient, closers ...io.Closer) *connMonitor {
+	cm := &connMonitor{
+		stopCh: make(chan struct{}),
+		deadCh: make(chan struct{}),
+	}
+
+	markDead := func(err error) {
+		cm.once.Do(func() {
+			cm.err = err
+			close(cm.deadCh)
+			cli.Close()
+			for _, c := range closers {
+				c.Close()
+			}
+		})
+	}
+
+	// Watch the connection to be closed, for example, by idle timeout on server side
+	go func() {
+		err := cli.Wait()
+		select {
+		case <-cm.stopCh:
+			// Closed by the tunnel itself
+		default:
+			markDead(fmt.Errorf("connection to server endpoint %q is closed: %v", t.serverEndpoint, err))
+		}
+	}()
+
+	if t.keepAliveInterval <= 0 {
+		// Keepalive is disabled
+		return cm
+	}
+
+	go func() {
+		ticker := time.NewTicker(t.keepAliveInterval)
+		defer ticker.Stop()
+
+		missed := 0
+		for {
+			select {
+			case <-cm.stopCh:
+				return
+			case <-cm.deadCh:
+				return
+			case <-ticker.C:
+			}
+
+			if cli.KeepAlive(t.keepAliveInterval) {
+				missed = 0
+				continue
+			}
+			missed++
+			glog.Warningf("server endpoint %q didn't respond to keepalive (%d/%d)", t.serverEndpoint, missed, t.keepAliveCountMax)
+			if missed >= t.keepAliveCountMax {
+				markDead(fmt.Errorf("server endpoint %q didn't respond to %d keepalive", t.serverEndpoint, missed))
+				return
+			}
+		}
+	}()
+
+	return cm
+}
+
+// stop stops monitoring the connection
+func (cm *connMonitor) stop() {
+	close(cm.stopCh)
+}
+
+// deadErr returns the reason why the server is regarded as dead, or nil if it is alive
+func (cm *connMonitor) deadErr() error {
+	select {
+	case <-cm.deadCh:
+		return cm.err
+	default:
+		return nil
+	}
+}
+
+// sendKeepAlive sends a keepalive request via {cli} and waits for the reply up to {timeout}.
+// It returns true if any reply is received in time.
+func sendKeepAlive(cli *ssh.Client, timeout time.Duration) bool {
+	res := make(chan error, 1)
+	go func() {
+		// Servers that don't know keepalive still reply with failure, so only check error
+		_, _, err := cli.SendRequest(keepAliveRequest, true /* wantReply */, nil)
+		res <- err
+	}()
+
+	select {
+	case err := <-res:
+		return err == nil
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// connTracker keeps track of connections being forwarded by a tunnel
+type connTracker struct {
+	mutex sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{
+		conns: map[net.Conn]struct{}{},
+	}
+}
+
+// add starts tracking a pair of connections
+func (c *connTracker) add(lCon, rCon net.Conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.conns[lCon] = struct{}{}
+	c.conns[rCon] = struct{}{}
+	c.wg.Add(1)
+}
+
+// remove closes a pair of connections and stops tracking them
+func (c *connTracker) remove(lCon, rCon net.Conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	lCon.Close()
+	rCon.Close()
+	delete(c.conns, lCon)
+	delete(c.conns, rCon)
+	c.wg.Done()
+}
+
+// closeAll closes all the tracked connections
+func (c *connTracker) closeAll() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for con := range c.conns {
+		con.Close()
+	}
+}
+
+// drain waits for all the tracked connections to be removed up to {timeout},
+// then closes the remaining connections and waits for them to be removed.
+func (c *connTracker) drain(timeout time.Duration) {
+	drained := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(drained)
+	}()
+
+	if timeout > 0 {
+		select {
+		case <-drained:
+			return
+		case <-time.After(timeout):
+			glog.Warningf("connections are not drained in %v, closing them", timeout)
+		}
+	}
+
+	c.closeAll()
+	<-drained
+}
+
 // toTCPAddr returns net.TCPAddr from specified {endpoint} and {portAny}
 // If {portAny} is true, Port is set to 0. Otherwise port will be endpoint's port.
 func toTCPAddr(endpoint string, portAny bool) (*net.TCPAddr, error) {
@@ -80,22 +584,23 @@ func toTCPAddr(endpoint string, portAny bool) (*net.TCPAddr, error) {
 func (t *Tunnel) doForward(lCon, rCon net.Conn) {
 	var wg sync.WaitGroup
 
-	copyCon := func(in, out net.Conn) {
+	copyCon := func(in, out net.Conn, counter prometheus.Counter) {
 		defer wg.Done()
 		select {
 		case <-t.context.Done():
 			return
 		default:
-			if _, err := io.Copy(in, out); err != nil {
+			if _, err := t.limiter.copy(&countingWriter{w: in, counter: counter}, out); err != nil {
 				glog.Errorf("copying io failed: %v", err)
 			}
 		}
 	}
 
+	// Connections are initiated from local side
 	wg.Add(1)
-	go copyCon(lCon, rCon)
+	go copyCon(lCon, rCon, t.metrics.bytesOut)
 	wg.Add(1)
-	go copyCon(rCon, lCon)
+	go copyCon(rCon, lCon, t.metrics.bytesIn)
 
 	wg.Wait()
 }
@@ -104,8 +609,20 @@ func (t *Tunnel) doForward(lCon, rCon net.Conn) {
 // It forwards remote endpoint to local endpoint via server endpoint where ssh forward server running.
 // Forward() can be canceled by calling Cancel().
 func (t *Tunnel) Forward() error {
+	if !t.begin() {
+		// Already canceled
+		return nil
+	}
+	err := t.forward()
+	t.end(err)
+
+	return err
+}
+
+// forward does actual forwarding logic inside Forward
+func (t *Tunnel) forward() error {
 	glog.Infof("starting forward for local%q:server%q:remote%q", t.localEndpoint, t.serverEndpoint, t.remoteEndpoint)
-	sCli, err := ssh.Dial("tcp", t.serverEndpoint, t.config)
+	sCli, err := t.dialServer()
 	if err != nil {
 		glog.Errorf("connecting to server endopoint %q failed: %v", t.serverEndpoint, err)
 		return err
@@ -118,6 +635,11 @@ func (t *Tunnel) Forward() error {
 		return err
 	}
 	defer lnr.Close()
+	// Closing listener makes Accept() below return on cancel
+	defer t.closeOnCancel(lnr)()
+	// Closing listener also on dead server
+	cm := t.startConnMonitor(sCli, lnr)
+	defer cm.stop()
 
 	laddr, err := toTCPAddr(t.serverEndpoint, true /* portAny */)
 	if err != nil {
@@ -128,34 +650,113 @@ func (t *Tunnel) Forward() error {
 	if err != nil {
 		return err
 	}
+	t.setEstablished()
+
+	conns := newConnTracker()
+	defer conns.closeAll()
 
 	for {
-		select {
-		case <-t.context.Done():
-			break
-		default:
-			lCon, err := lnr.Accept()
-			if err != nil {
-				glog.Errorf("accepting on local endopoint %q failed: %v", t.localEndpoint, err)
-				return err
+		lCon, err := lnr.Accept()
+		if err != nil {
+			if t.context.Err() != nil {
+				glog.Infof("stopping forward for %q", t.String())
+				conns.drain(t.drainTimeout)
+				return nil
 			}
-
-			// Use DialTCP and specify laddr to bind server's local endpoint as a source IP,
-			// instead of calling Dial without laddr
-			rCon, err := sCli.DialTCP("tcp", laddr, raddr)
-			if err != nil {
-				glog.Errorf("connecting to remote endopoint %q failed: %v", t.remoteEndpoint, err)
-				lCon.Close()
-				return err
+			if deadErr := cm.deadErr(); deadErr != nil {
+				err = deadErr
 			}
+			glog.Errorf("accepting on local endopoint %q failed: %v", t.localEndpoint, err)
+			return err
+		}
 
-			go func() {
-				defer lCon.Close()
-				defer rCon.Close()
-				t.doForward(lCon, rCon)
-			}()
+		if !t.limiter.acquire() {
+			glog.Warningf("rejected connection on local endpoint %q: too many connections", t.localEndpoint)
+			lCon.Close()
+			continue
+		}
+
+		connRaddr, err := t.remoteAddrFor(lCon, raddr)
+		if err != nil {
+			glog.Warningf("rejected connection on local endpoint %q: %v", t.localEndpoint, err)
+			lCon.Close()
+			t.limiter.release()
+			t.metrics.failed.Inc()
+			continue
 		}
+
+		rCon, err := t.dialRemote(sCli, lCon, laddr, connRaddr)
+		if err != nil {
+			lCon.Close()
+			t.limiter.release()
+			t.metrics.failed.Inc()
+			if isRejectedByLimits(err) {
+				// Server is alive, so keep the tunnel and other connections
+				glog.Warningf("connecting to remote endpoint %q is rejected by server: %v", t.remoteEndpoint, err)
+				continue
+			}
+			glog.Errorf("connecting to remote endopoint %q failed: %v", t.remoteEndpoint, err)
+			return err
+		}
+
+		conns.add(lCon, rCon)
+		t.metrics.accepted.Inc()
+		t.metrics.active.Inc()
+		go func() {
+			defer t.limiter.release()
+			defer t.metrics.active.Dec()
+			defer conns.remove(lCon, rCon)
+			t.doForward(lCon, rCon)
+		}()
+	}
+}
+
+// remoteAddrFor returns the remote address to forward {lCon} to, which is {raddr}
+// unless the tunnel forwards a range of ports
+func (t *Tunnel) remoteAddrFor(lCon net.Conn, raddr *net.TCPAddr) (*net.TCPAddr, error) {
+	if t.portRangeEnd == 0 {
+		return raddr, nil
+	}
+
+	port, err := t.originalDstPort(lCon)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get original port: %v", err)
+	}
+	if port < t.portRangeStart || port > t.portRangeEnd {
+		return nil, fmt.Errorf("original port %d is out of range %d-%d", port, t.portRangeStart, t.portRangeEnd)
+	}
+
+	return &net.TCPAddr{IP: raddr.IP, Port: raddr.Port + port - t.portRangeStart}, nil
+}
+
+// dialRemote connects to {raddr} via {sCli} for {lCon}. It uses DialTCP and specifies {laddr}
+// to bind server's local endpoint as a source IP, instead of calling Dial without laddr,
+// unless the server is asked to send PROXY protocol header telling the client of {lCon}.
+func (t *Tunnel) dialRemote(sCli relayClient, lCon net.Conn, laddr, raddr *net.TCPAddr) (net.Conn, error) {
+	origin, ok := lCon.RemoteAddr().(*net.TCPAddr)
+	if t.originProxyProtocol == "" || !ok {
+		return sCli.DialTCP("tcp", laddr, raddr)
+	}
+
+	return sCli.DialProxy(origin, raddr, t.originProxyProtocol, t.originPod)
+}
+
+// dialServer connects to the server endpoint and records the time taken for handshake
+func (t *Tunnel) dialServer() (relayClient, error) {
+	start := time.Now()
+	sCli, err := t.dial()
+	t.metrics.handshake.Observe(time.Since(start).Seconds())
+
+	return sCli, err
+}
+
+// isRejectedByLimits checks if {err} is caused by server rejecting a connection due to its limits
+func isRejectedByLimits(err error) bool {
+	if re, ok := err.(*relayError); ok {
+		return re.StatusCode == http.StatusTooManyRequests
 	}
+	oce, ok := err.(*ssh.OpenChannelError)
+	return ok && oce.Reason == ssh.ResourceShortage
 }
 
 // String returns string representation of Tunnel.
@@ -168,15 +769,26 @@ func (t *Tunnel) String() string {
 // ForwardNB is non-blocking version of Forward
 // It retries with exponential backoff on failure.
 func (t *Tunnel) ForwardNB() {
-	go backoffv4.RetryNotify(
-		func() error {
-			return t.Forward()
-		},
+	go t.retry(t.Forward, "forward")
+}
+
+// retry calls {operation} and retries it with exponential backoff on failure.
+// State of the tunnel becomes backing off while waiting to retry,
+// and becomes failed if it gives up retrying.
+func (t *Tunnel) retry(operation backoffv4.Operation, opName string) {
+	err := backoffv4.RetryNotify(
+		operation,
 		t.backoff,
 		func(err error, tm time.Duration) {
-			glog.Errorf("failed to forward for %q in duration %v: %v", t.String(), tm, err)
+			glog.Errorf("failed to %s for %q in duration %v: %v", opName, t.String(), tm, err)
+			t.setState(TunnelBackingOff)
+			t.metrics.reconnects.Inc()
 		},
 	)
+	if err != nil && t.context.Err() == nil {
+		glog.Errorf("gave up retrying to %s for %q: %v", opName, t.String(), err)
+		t.setState(TunnelFailed)
+	}
 }
 
 // doRemoteForward does actual remote forwarding logic inside RemoteForward
@@ -184,22 +796,23 @@ func (t *Tunnel) ForwardNB() {
 func (t *Tunnel) doRemoteForward(rCon, lCon net.Conn) {
 	var wg sync.WaitGroup
 
-	copyCon := func(in, out net.Conn) {
+	copyCon := func(in, out net.Conn, counter prometheus.Counter) {
 		defer wg.Done()
 		select {
 		case <-t.context.Done():
 			return
 		default:
-			if _, err := io.Copy(in, out); err != nil {
+			if _, err := t.limiter.copy(&countingWriter{w: in, counter: counter}, out); err != nil {
 				glog.Errorf("copying io failed: %v", err)
 			}
 		}
 	}
 
+	// Connections are initiated from remote side
 	wg.Add(1)
-	go copyCon(rCon, lCon)
+	go copyCon(rCon, lCon, t.metrics.bytesOut)
 	wg.Add(1)
-	go copyCon(lCon, rCon)
+	go copyCon(lCon, rCon, t.metrics.bytesIn)
 
 	wg.Wait()
 }
@@ -208,9 +821,21 @@ func (t *Tunnel) doRemoteForward(rCon, lCon net.Conn) {
 // It forwards local endpoint to remote endpoint via server endpoint where ssh forward server running.
 // RemoteForward() can be canceled by calling Cancel().
 func (t *Tunnel) RemoteForward() error {
+	if !t.begin() {
+		// Already canceled
+		return nil
+	}
+	err := t.remoteForward()
+	t.end(err)
+
+	return err
+}
+
+// remoteForward does actual remote forwarding logic inside RemoteForward
+func (t *Tunnel) remoteForward() error {
 	glog.Infof("starting remote forward for local%q:server%q:remote%q", t.localEndpoint, t.serverEndpoint, t.remoteEndpoint)
 
-	sCli, err := ssh.Dial("tcp", t.serverEndpoint, t.config)
+	sCli, err := t.dialServer()
 	if err != nil {
 		glog.Errorf("connecting to server endopoint %q failed: %v", t.serverEndpoint, err)
 		return err
@@ -223,46 +848,83 @@ func (t *Tunnel) RemoteForward() error {
 		return err
 	}
 	defer rlnr.Close()
+	// Closing listener makes Accept() below return on cancel
+	defer t.closeOnCancel(rlnr)()
+	cm := t.startConnMonitor(sCli)
+	defer cm.stop()
+	t.setEstablished()
+
+	conns := newConnTracker()
+	defer conns.closeAll()
 
 	for {
-		select {
-		case <-t.context.Done():
-			break
-		default:
-			rCon, err := rlnr.Accept()
-			if err != nil {
-				glog.Errorf("accepting on remote endopoint %q failed: %v", t.remoteEndpoint, err)
-				return err
+		rCon, err := rlnr.Accept()
+		if err != nil {
+			if t.context.Err() != nil {
+				glog.Infof("stopping remote forward for %q", t.String())
+				conns.drain(t.drainTimeout)
+				return nil
 			}
-
-			lCon, err := net.Dial("tcp", t.localEndpoint)
-			if err != nil {
-				glog.Errorf("connecting to local endopoint %q failed: %v", t.localEndpoint, err)
-				rCon.Close()
-				return err
+			if deadErr := cm.deadErr(); deadErr != nil {
+				err = deadErr
 			}
+			glog.Errorf("accepting on remote endopoint %q failed: %v", t.remoteEndpoint, err)
+			return err
+		}
 
-			go func() {
-				defer rCon.Close()
-				defer lCon.Close()
-				t.doRemoteForward(rCon, lCon)
-			}()
+		if !t.limiter.acquire() {
+			glog.Warningf("rejected connection on remote endpoint %q: too many connections", t.remoteEndpoint)
+			rCon.Close()
+			continue
 		}
+
+		lCon, err := net.Dial("tcp", t.localEndpoint)
+		if err != nil {
+			glog.Errorf("connecting to local endopoint %q failed: %v", t.localEndpoint, err)
+			rCon.Close()
+			t.limiter.release()
+			t.metrics.failed.Inc()
+			return err
+		}
+		if err := t.writeProxyHeader(lCon, rCon.RemoteAddr()); err != nil {
+			glog.Errorf("sending proxy protocol header to local endpoint %q failed: %v", t.localEndpoint, err)
+			lCon.Close()
+			rCon.Close()
+			t.limiter.release()
+			t.metrics.failed.Inc()
+			continue
+		}
+
+		conns.add(lCon, rCon)
+		t.metrics.accepted.Inc()
+		t.metrics.active.Inc()
+		go func() {
+			defer t.limiter.release()
+			defer t.metrics.active.Dec()
+			defer conns.remove(lCon, rCon)
+			t.doRemoteForward(rCon, lCon)
+		}()
 	}
 }
 
+// writeProxyHeader writes PROXY protocol header for a connection from {client} to {lCon}, if it is set to the tunnel
+func (t *Tunnel) writeProxyHeader(lCon net.Conn, client net.Addr) error {
+	if t.proxyProtocol == "" {
+		return nil
+	}
+	header, err := ProxyHeader(t.proxyProtocol, client, t.proxyDestination)
+	if err != nil {
+		return err
+	}
+	_, err = lCon.Write(header)
+
+	return err
+}
+
 // RemoteForwardNB is non-blocking version of RemoteForward
 // It retries with exponential backoff on failure.
 func (t *Tunnel) RemoteForwardNB() {
-	go backoffv4.RetryNotify(
-		func() error {
-			return t.RemoteForward()
-		},
-		t.backoff,
-		func(err error, tm time.Duration) {
-			glog.Errorf("failed to remote forward for %q in duration %v: %v", t.String(), tm, err)
-		},
-	)
+	go t.retry(t.RemoteForward, "remote forward")
 }
 
 // direct-tcpip data struct as specified in RFC4254, Section 7.2
@@ -274,14 +936,76 @@ type localForwardChannelData struct {
 	OriginPort uint32
 }
 
+// proxyForwardChannelData is the payload of proxyChannelType channels, which is direct-tcpip data
+// followed by the version of PROXY protocol header and namespace/name of the original pod
+type proxyForwardChannelData struct {
+	DestAddr string
+	DestPort uint32
+
+	OriginAddr string
+	OriginPort uint32
+
+	ProxyProtocol string
+	Pod           string
+}
+
+// unmarshalForwardData parses the payload of direct-tcpip or proxyChannelType channel {newChan} into {d}
+func unmarshalForwardData(newChan ssh.NewChannel, d *proxyForwardChannelData) error {
+	if newChan.ChannelType() == proxyChannelType {
+		return ssh.Unmarshal(newChan.ExtraData(), d)
+	}
+	l := localForwardChannelData{}
+	if err := ssh.Unmarshal(newChan.ExtraData(), &l); err != nil {
+		return err
+	}
+	*d = proxyForwardChannelData{DestAddr: l.DestAddr, DestPort: l.DestPort, OriginAddr: l.OriginAddr, OriginPort: l.OriginPort}
+
+	return nil
+}
+
+// ForwardingLookup returns a Limiter to apply to connections from a client identified by {client},
+// and labels of metrics for them. Nil Limiter is returned if connections from the client are unlimited.
+type ForwardingLookup func(client string) (*Limiter, TunnelLabels)
+
+// ForwarderID returns the ID that a forwarder identifies itself with to gateways, which is
+// {namespace}/{name} of its Forwarder CR. It is sent as the user of ssh and in Relay-Client header of
+// mTLS relay, instead of being found from the address of the forwarder, which can be translated on the way.
+func ForwarderID(namespace, name string) string {
+	return namespace + "/" + name
+}
+
 // DirectTCPIPHandler is a handler for direct-tcpip.
 // This is modified from gliderlabs original one so that it can reserve source ip.
 func DirectTCPIPHandler(srv *glssh.Server, conn *ssh.ServerConn, newChan ssh.NewChannel, ctx glssh.Context) {
-	d := localForwardChannelData{}
-	if err := ssh.Unmarshal(newChan.ExtraData(), &d); err != nil {
+	directTCPIP(srv, newChan, ctx, nil /* limiter */, TunnelLabels{}, nil /* targets */)
+}
+
+// NewDirectTCPIPHandler returns a handler for direct-tcpip that limits connections
+// with Limiter and records metrics with labels returned by Lookup of {opts} for each client.
+// Destinations are connected via TargetGroup returned by Targets of {opts}, if any. It also handles
+// channels that ask to send PROXY protocol header for origins that are the original clients of the connections.
+func NewDirectTCPIPHandler(opts ServerOptions) glssh.ChannelHandler {
+	return func(srv *glssh.Server, conn *ssh.ServerConn, newChan ssh.NewChannel, ctx glssh.Context) {
+		var limiter *Limiter
+		var labels TunnelLabels
+		if opts.Lookup != nil {
+			limiter, labels = opts.Lookup(ctx.User())
+		}
+		directTCPIP(srv, newChan, ctx, limiter, labels, opts.Targets)
+	}
+}
+
+// directTCPIP does actual logic inside DirectTCPIPHandler
+func directTCPIP(srv *glssh.Server, newChan ssh.NewChannel, ctx glssh.Context, limiter *Limiter, labels TunnelLabels, targets TargetLookup) {
+	d := proxyForwardChannelData{}
+	if err := unmarshalForwardData(newChan, &d); err != nil {
 		newChan.Reject(ssh.ConnectionFailed, "error parsing forward data: "+err.Error())
 		return
 	}
+	if d.ProxyProtocol != "" && !IsProxyProtocolVersion(d.ProxyProtocol) {
+		newChan.Reject(ssh.Prohibited, "unknown version of proxy protocol")
+		return
+	}
 
 	if srv.LocalPortForwardingCallback == nil || !srv.LocalPortForwardingCallback(ctx, d.DestAddr, d.DestPort) {
 		newChan.Reject(ssh.Prohibited, "port forwarding is disabled")
@@ -291,45 +1015,210 @@ func DirectTCPIPHandler(srv *glssh.Server, conn *ssh.ServerConn, newChan ssh.New
 	dest := net.JoinHostPort(d.DestAddr, strconv.FormatInt(int64(d.DestPort), 10))
 	origin := net.JoinHostPort(d.OriginAddr, strconv.FormatInt(int64(d.OriginPort), 10))
 
-	laddr, err := toTCPAddr(origin, false /* portAny */)
+	oaddr, err := toTCPAddr(origin, false /* portAny */)
 	if err != nil {
 		newChan.Reject(ssh.Prohibited, "specified origin ip or port is invalid")
 		return
 	}
-	dialer := net.Dialer{
-		LocalAddr: laddr,
+
+	if !limiter.acquire() {
+		glog.Warningf("rejected forwarding to %q from %q: too many connections", dest, ctx.RemoteAddr())
+		newChan.Reject(ssh.ResourceShortage, "too many connections")
+		return
 	}
 
-	dconn, err := dialer.DialContext(ctx, "tcp", dest)
+	metrics := newRelayMetrics(labels)
+	dconn, err := dialForOrigin(ctx, ctx.User(), ctx.LocalAddr(), oaddr, dest, targets, d.ProxyProtocol, d.Pod)
 	if err != nil {
+		limiter.release()
+		metrics.failed.Inc()
 		newChan.Reject(ssh.ConnectionFailed, err.Error())
 		return
 	}
 
 	ch, reqs, err := newChan.Accept()
 	if err != nil {
+		limiter.release()
 		dconn.Close()
 		return
 	}
 	go ssh.DiscardRequests(reqs)
+	metrics.accepted.Inc()
+	metrics.active.Inc()
 
+	// Connections are initiated from the channel side
+	var wg sync.WaitGroup
+	wg.Add(2)
 	go func() {
+		defer wg.Done()
 		defer ch.Close()
 		defer dconn.Close()
-		io.Copy(ch, dconn)
+		limiter.copy(&countingWriter{w: ch, counter: metrics.bytesOut}, dconn)
 	}()
 	go func() {
+		defer wg.Done()
 		defer ch.Close()
 		defer dconn.Close()
-		io.Copy(dconn, ch)
+		limiter.copy(&countingWriter{w: dconn, counter: metrics.bytesIn}, ch)
+	}()
+	go func() {
+		wg.Wait()
+		limiter.release()
+		metrics.active.Dec()
 	}()
 }
 
-// NewSSHServer returns ssh server instance that will listen on {addr}
-func NewSSHServer(addr string) glssh.Server {
+// dialDestination connects to {dest} with {dialer} for {client}.
+// If {dest} has multiple targets in TargetGroup returned by {targets}, one of them is selected,
+// while binding the same local address of {dialer}.
+func dialDestination(ctx context.Context, dialer net.Dialer, client, dest string, targets TargetLookup) (net.Conn, error) {
+	var group *TargetGroup
+	if targets != nil {
+		group = targets(client, dest)
+	}
+	if group == nil {
+		return dialer.DialContext(ctx, "tcp", dest)
+	}
+	_, port, err := net.SplitHostPort(dest)
+	if err != nil {
+		return nil, err
+	}
+
+	return group.Dial(ctx, dialer, port)
+}
+
+// keepAliveHandler replies to keepalive requests from clients
+func keepAliveHandler(ctx glssh.Context, srv *glssh.Server, req *ssh.Request) (bool, []byte) {
+	return true, nil
+}
+
+// remoteForwardRequest is the payload of tcpip-forward and cancel-tcpip-forward requests
+type remoteForwardRequest struct {
+	BindAddr string
+	BindPort uint32
+}
+
+// forwardedTCPPayload is the payload of forwarded-tcpip channels
+type forwardedTCPPayload struct {
+	DestAddr   string
+	DestPort   uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// newForwardHandler returns RequestHandler for tcpip-forward and cancel-tcpip-forward, which listens on
+// the requested address like glssh.ForwardedTCPHandler, and also registers the session to {sessions},
+// so that connections accepted by other listeners can be relayed to the client. Nil {sessions} means
+// that sessions aren't registered.
+func newForwardHandler(sessions *ReverseSessions) glssh.RequestHandler {
 	forwardHandler := &glssh.ForwardedTCPHandler{}
+	// unregisters are functions to unregister sessions keyed by session ID and address
+	var mutex sync.Mutex
+	unregisters := map[string]func(){}
+	unregister := func(key string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if f, ok := unregisters[key]; ok {
+			f()
+			delete(unregisters, key)
+		}
+	}
+
+	return func(ctx glssh.Context, srv *glssh.Server, req *ssh.Request) (bool, []byte) {
+		ok, payload := forwardHandler.HandleSSHRequest(ctx, srv, req)
+		if !ok || sessions == nil {
+			return ok, payload
+		}
+		var reqPayload remoteForwardRequest
+		if err := ssh.Unmarshal(req.Payload, &reqPayload); err != nil {
+			return ok, payload
+		}
+		addr := net.JoinHostPort(reqPayload.BindAddr, strconv.Itoa(int(reqPayload.BindPort)))
+		key := ctx.SessionID() + "/" + addr
+
+		switch req.Type {
+		case "tcpip-forward":
+			conn := ctx.Value(glssh.ContextKeyConn).(*ssh.ServerConn)
+			f := sessions.register(addr, func(origin net.Addr) (net.Conn, error) {
+				return openForwardedTCP(conn, reqPayload, origin)
+			})
+			mutex.Lock()
+			unregisters[key] = f
+			mutex.Unlock()
+			go func() {
+				<-ctx.Done()
+				unregister(key)
+			}()
+		case "cancel-tcpip-forward":
+			unregister(key)
+		}
+
+		return ok, payload
+	}
+}
+
+// openForwardedTCP opens forwarded-tcpip channel on {conn} for a connection from {origin} to the address of {req}
+func openForwardedTCP(conn *ssh.ServerConn, req remoteForwardRequest, origin net.Addr) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(origin.String())
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+	payload := ssh.Marshal(&forwardedTCPPayload{
+		DestAddr:   req.BindAddr,
+		DestPort:   req.BindPort,
+		OriginAddr: host,
+		OriginPort: uint32(port),
+	})
+	ch, reqs, err := conn.OpenChannel("forwarded-tcpip", payload)
+	if err != nil {
+		return nil, err
+	}
+	go ssh.DiscardRequests(reqs)
+	laddr := &net.TCPAddr{IP: net.ParseIP(req.BindAddr), Port: int(req.BindPort)}
+
+	return &channelConn{Channel: ch, laddr: laddr, raddr: origin}, nil
+}
+
+// channelConn is net.Conn over a ssh channel
+type channelConn struct {
+	ssh.Channel
+	laddr net.Addr
+	raddr net.Addr
+}
+
+func (c *channelConn) LocalAddr() net.Addr                { return c.laddr }
+func (c *channelConn) RemoteAddr() net.Addr               { return c.raddr }
+func (c *channelConn) SetDeadline(t time.Time) error      { return errDeadlineNotSupported }
+func (c *channelConn) SetReadDeadline(t time.Time) error  { return errDeadlineNotSupported }
+func (c *channelConn) SetWriteDeadline(t time.Time) error { return errDeadlineNotSupported }
+
+// ServerOptions represents options for ssh servers returned by NewSSHServer
+// and mTLS relay servers returned by NewTLSRelayServer
+type ServerOptions struct {
+	// IdleTimeout is the time after which connections that have no activity are closed, so that
+	// resources for dead clients are released. Zero disables it. Clients need to send keepalive
+	// in shorter interval than it. It is applied only to ssh servers.
+	IdleTimeout time.Duration
+	// Lookup returns Limiter to limit forwarding for clients, and labels of metrics recorded for them.
+	// Clients are identified by the users of ssh or Relay-Client header of mTLS relay. Nil means unlimited and no labels.
+	Lookup ForwardingLookup
+	// Targets returns TargetGroup to connect destinations of forwarding via.
+	// Nil means that destinations are connected as they are.
+	Targets TargetLookup
+	// Sessions are where sessions of clients for remote forwarding are registered, if not nil.
+	Sessions *ReverseSessions
+}
+
+// NewSSHServer returns ssh server instance that will listen on {addr} with {opts}
+func NewSSHServer(addr string, opts ServerOptions) glssh.Server {
+	forwardHandler := newForwardHandler(opts.Sessions)
 
 	return glssh.Server{
+		IdleTimeout: opts.IdleTimeout,
 		LocalPortForwardingCallback: glssh.LocalPortForwardingCallback(func(ctx glssh.Context, dhost string, dport uint32) bool {
 			log.Println("Accepted forward", dhost, dport)
 			return true
@@ -344,12 +1233,14 @@ func NewSSHServer(addr string) glssh.Server {
 			return true
 		}),
 		ChannelHandlers: map[string]glssh.ChannelHandler{
-			"session":      glssh.DefaultSessionHandler,
-			"direct-tcpip": DirectTCPIPHandler,
+			"session":        glssh.DefaultSessionHandler,
+			"direct-tcpip":   NewDirectTCPIPHandler(opts),
+			proxyChannelType: NewDirectTCPIPHandler(opts),
 		},
 		RequestHandlers: map[string]glssh.RequestHandler{
-			"tcpip-forward":        forwardHandler.HandleSSHRequest,
-			"cancel-tcpip-forward": forwardHandler.HandleSSHRequest,
+			"tcpip-forward":        forwardHandler,
+			"cancel-tcpip-forward": forwardHandler,
+			keepAliveRequest:       keepAliveHandler,
 		},
 	}
 }
@@ -7,7 +7,9 @@ import (
 	"math/rand"
 	"net"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -157,6 +159,66 @@ func echoClient(addr string, msg string) (string, error) {
 	return strings.TrimSpace(echo), nil
 }
 
+// echoClientWithRetry calls echoClient until it succeeds or timeout expires.
+// It is used to wait for a tunnel that is retrying with exponential backoff.
+func echoClientWithRetry(addr string, msg string, timeout time.Duration) (string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		echo, err := echoClient(addr, msg)
+		if err == nil || time.Now().After(deadline) {
+			return echo, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// startHoldingEchoServer starts an echo server for test canceling tunnels.
+// Unlike startEchoServer, it handles connections concurrently, notifies {received} of a received line,
+// and holds echoing the line back until {release} is closed.
+func startHoldingEchoServer(ctx context.Context, addr string, received chan<- struct{}, release <-chan struct{}) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			line, err := bufio.NewReader(conn).ReadBytes('\n')
+			if err != nil {
+				return
+			}
+			received <- struct{}{}
+			<-release
+			conn.Write(line)
+		}()
+	}
+}
+
+// waitForPort polls {ip}:{port} until it is open if {open}, or closed otherwise.
+// It returns false if the port isn't in the state in {timeout}.
+func waitForPort(ip, port string, open bool, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for IsPortOpen(ip, port) != open {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
 func prepareTestServers(ctx context.Context, t *testing.T, echoAddr, sshAddr string, echoDown, sshDown bool) {
 	// start echo server
 	go func() {
@@ -169,7 +231,7 @@ func prepareTestServers(ctx context.Context, t *testing.T, echoAddr, sshAddr str
 	}()
 
 	// start ssh server
-	sshServer := NewSSHServer(sshAddr)
+	sshServer := NewSSHServer(sshAddr, ServerOptions{})
 	go func() {
 		if sshDown {
 			return
@@ -482,6 +544,606 @@ func TestForwardNB(t *testing.T) {
 	}
 }
 
+func TestCancel(t *testing.T) {
+	testCases := []struct {
+		name         string
+		localAddr    string
+		serverAddr   string
+		remoteAddr   string
+		drainTimeout time.Duration
+		config       *ssh.ClientConfig
+		msg          string
+		expectDrain  bool
+	}{
+		{
+			name:         "Normal case (no drain timeout)",
+			localAddr:    "127.0.0.1:" + genRandomPort(),
+			serverAddr:   "127.0.0.1:" + genRandomPort(),
+			remoteAddr:   "127.0.0.1:" + genRandomPort(),
+			drainTimeout: 0,
+			config: &ssh.ClientConfig{
+				Timeout:         time.Second * 5,
+				Auth:            []ssh.AuthMethod{},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			msg: "hello",
+			// Existing connection should be closed immediately
+			expectDrain: false,
+		},
+		{
+			name:         "Normal case (with drain timeout)",
+			localAddr:    "127.0.0.1:" + genRandomPort(),
+			serverAddr:   "127.0.0.1:" + genRandomPort(),
+			remoteAddr:   "127.0.0.1:" + genRandomPort(),
+			drainTimeout: 2 * time.Second,
+			config: &ssh.ClientConfig{
+				Timeout:         time.Second * 5,
+				Auth:            []ssh.AuthMethod{},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			msg: "hello",
+			// Existing connection should keep working during drain timeout
+			expectDrain: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// start holding echo server on remoteAddr and ssh server on serverAddr
+		received := make(chan struct{}, 1)
+		release := make(chan struct{})
+		go func() {
+			if err := startHoldingEchoServer(ctx, tc.remoteAddr, received, release); err != nil {
+				t.Error(err)
+			}
+		}()
+		prepareTestServers(ctx, t, tc.remoteAddr, tc.serverAddr, true /* echoDown */, false)
+
+		// start tunnel to forward remoteAddr to localAddr
+		tun := NewTunnel(tc.localAddr, tc.serverAddr, tc.remoteAddr, tc.config)
+		tun.SetDrainTimeout(tc.drainTimeout)
+		tun.ForwardNB()
+
+		// Wait for tunnel to be available
+		localPort := strings.Split(tc.localAddr, ":")[1]
+		if !waitForPort("127.0.0.1", localPort, true, 5*time.Second) {
+			t.Fatalf("expected listener to be open, but it is not open")
+		}
+
+		// Connect and send msg before canceling the tunnel
+		conn, err := net.DialTimeout("tcp", tc.localAddr, time.Second)
+		if err != nil {
+			t.Fatalf("connecting to %s failed: %v", tc.localAddr, err)
+		}
+		conn.Write([]byte(tc.msg + "\n"))
+		// Wait for msg to be forwarded to the remote
+		select {
+		case <-received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected msg to be forwarded before cancel, but it is not forwarded")
+		}
+
+		tun.Cancel()
+
+		if !waitForPort("127.0.0.1", localPort, false, 2*time.Second) {
+			t.Errorf("expected listener to be closed after cancel, but it is still open")
+		}
+
+		// Let the remote echo msg back after cancel
+		close(release)
+		conn.SetDeadline(time.Now().Add(time.Second))
+		echo, err := bufio.NewReader(conn).ReadString('\n')
+		if tc.expectDrain {
+			if err != nil {
+				t.Errorf("expected no error during drain, but got error %v", err)
+			}
+			if tc.msg != strings.TrimSpace(echo) {
+				t.Errorf("expected msg %s, but got %s", tc.msg, strings.TrimSpace(echo))
+			}
+			select {
+			case <-tun.Done():
+				t.Errorf("expected tunnel not to be done during drain, but it is done")
+			default:
+			}
+		} else {
+			if err == nil {
+				t.Errorf("expected error after cancel, but no error returned")
+			}
+		}
+
+		// Tunnel should be done after drain timeout
+		select {
+		case <-tun.Done():
+		case <-time.After(tc.drainTimeout + time.Second):
+			t.Errorf("expected tunnel to be done, but it is not done")
+		}
+
+		conn.Close()
+		cancel()
+		// Wait for a millisecond just to be sure that all servers closed
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestForwardLimits(t *testing.T) {
+	testCases := []struct {
+		name          string
+		localAddr     string
+		serverAddr    string
+		remoteAddr    string
+		tunnelLimiter *Limiter
+		serverLimiter *Limiter
+		config        *ssh.ClientConfig
+		msg           string
+	}{
+		{
+			name:          "Normal case (connections limited by tunnel)",
+			localAddr:     "127.0.0.1:" + genRandomPort(),
+			serverAddr:    "127.0.0.1:" + genRandomPort(),
+			remoteAddr:    "127.0.0.1:" + genRandomPort(),
+			tunnelLimiter: NewLimiter(Limits{MaxConnections: 1}),
+			serverLimiter: nil,
+			config: &ssh.ClientConfig{
+				Timeout:         time.Second * 5,
+				Auth:            []ssh.AuthMethod{},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			msg: "hello",
+		},
+		{
+			name:          "Normal case (connections limited by server)",
+			localAddr:     "127.0.0.1:" + genRandomPort(),
+			serverAddr:    "127.0.0.1:" + genRandomPort(),
+			remoteAddr:    "127.0.0.1:" + genRandomPort(),
+			tunnelLimiter: nil,
+			serverLimiter: NewLimiter(Limits{MaxConnections: 1}),
+			config: &ssh.ClientConfig{
+				User:            ForwarderID("ns1", "fwd1"),
+				Timeout:         time.Second * 5,
+				Auth:            []ssh.AuthMethod{},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			msg: "hello",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// start echo server on remoteAddr and ssh server with limiter on serverAddr
+		go startEchoServer(ctx, tc.remoteAddr)
+		sshServer := NewSSHServer(tc.serverAddr, ServerOptions{
+			// Forwarder is identified by the user, not by the address
+			Lookup: func(client string) (*Limiter, TunnelLabels) {
+				if client != ForwarderID("ns1", "fwd1") {
+					return nil, TunnelLabels{}
+				}
+				return tc.serverLimiter, TunnelLabels{}
+			},
+		})
+		go sshServer.ListenAndServe()
+
+		// start tunnel to forward remoteAddr to localAddr
+		tun := NewTunnel(tc.localAddr, tc.serverAddr, tc.remoteAddr, tc.config)
+		tun.SetLimiter(tc.tunnelLimiter)
+		tun.ForwardNB()
+
+		// Wait for two seconds for tunnel to be available
+		time.Sleep(2 * time.Second)
+
+		// First connection is kept open to use up the limit
+		conn, err := net.DialTimeout("tcp", tc.localAddr, time.Second)
+		if err != nil {
+			t.Fatalf("connecting to %s failed: %v", tc.localAddr, err)
+		}
+		conn.SetDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte(tc.msg + "\n"))
+		if echo, err := bufio.NewReader(conn).ReadString('\n'); err != nil || tc.msg != strings.TrimSpace(echo) {
+			t.Errorf("expected msg %s, but got %s (error: %v)", tc.msg, strings.TrimSpace(echo), err)
+		}
+
+		// Second connection should be rejected
+		if _, err := echoClient(tc.localAddr, tc.msg); err == nil {
+			t.Errorf("expected error for connection exceeding limit, but no error returned")
+		}
+		limiter := tc.tunnelLimiter
+		if limiter == nil {
+			limiter = tc.serverLimiter
+		}
+		if rejected := limiter.Counters().RejectedConnections; rejected != 1 {
+			t.Errorf("expected 1 rejected connection, but got %d", rejected)
+		}
+
+		// Rejecting connection shouldn't make the tunnel fail
+		if state := tun.Status().State; state != TunnelEstablished {
+			t.Errorf("expected tunnel to be kept %s, but got %s", TunnelEstablished, state)
+		}
+
+		conn.Close()
+		tun.Cancel()
+		sshServer.Close()
+		cancel()
+		// Wait for a millisecond just to be sure that all servers closed
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestForwardPortRange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Remote endpoint is the first port of the range, and echo server listens on the third one
+	remotePort, _ := strconv.Atoi(genRandomPort())
+	remoteAddr := "127.0.0.1:" + strconv.Itoa(remotePort)
+	go startEchoServer(ctx, "127.0.0.1:"+strconv.Itoa(remotePort+2))
+	serverAddr := "127.0.0.1:" + genRandomPort()
+	sshServer := NewSSHServer(serverAddr, ServerOptions{})
+	go sshServer.ListenAndServe()
+	defer sshServer.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	localAddr := "127.0.0.1:" + genRandomPort()
+	config := &ssh.ClientConfig{
+		Timeout:         time.Second * 5,
+		Auth:            []ssh.AuthMethod{},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	tun := NewTunnel(localAddr, serverAddr, remoteAddr, config)
+	tun.SetPortRange(30000, 30100)
+	// Connections are regarded as redirected from the port of originalPort by iptables
+	originalPort := int32(30002)
+	tun.originalDstPort = func(conn net.Conn) (int, error) { return int(atomic.LoadInt32(&originalPort)), nil }
+	tun.ForwardNB()
+	defer tun.Cancel()
+
+	msg, err := echoClientWithRetry(localAddr, "hello", 5*time.Second)
+	if err != nil {
+		t.Fatalf("expected no error, but got error %v", err)
+	}
+	if msg != "hello" {
+		t.Errorf("expected msg hello, but got %s", msg)
+	}
+
+	// Connections from ports out of the range are rejected
+	atomic.StoreInt32(&originalPort, 30101)
+	if _, err := echoClient(localAddr, "hello"); err == nil {
+		t.Errorf("expected error for port out of range, but no error returned")
+	}
+	if state := tun.Status().State; state != TunnelEstablished {
+		t.Errorf("expected tunnel to be kept %s, but got %s", TunnelEstablished, state)
+	}
+}
+
+func TestTunnelStatus(t *testing.T) {
+	testCases := []struct {
+		name        string
+		localAddr   string
+		serverAddr  string
+		remoteAddr  string
+		sshDown     bool
+		config      *ssh.ClientConfig
+		expected    TunnelState
+		expectError bool
+	}{
+		{
+			name:       "Normal case (established)",
+			localAddr:  "127.0.0.1:" + genRandomPort(),
+			serverAddr: "127.0.0.1:" + genRandomPort(),
+			remoteAddr: "127.0.0.1:" + genRandomPort(),
+			sshDown:    false,
+			config: &ssh.ClientConfig{
+				Timeout:         time.Second * 5,
+				Auth:            []ssh.AuthMethod{},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			expected:    TunnelEstablished,
+			expectError: false,
+		},
+		{
+			name:       "Error case (ssh server down)",
+			localAddr:  "127.0.0.1:" + genRandomPort(),
+			serverAddr: "127.0.0.1:" + genRandomPort(),
+			remoteAddr: "127.0.0.1:" + genRandomPort(),
+			// Down
+			sshDown: true,
+			config: &ssh.ClientConfig{
+				Timeout:         time.Second * 5,
+				Auth:            []ssh.AuthMethod{},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			// Should be retrying
+			expected:    TunnelBackingOff,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// start echo server on remoteAddr and ssh server on serverAddr
+		prepareTestServers(ctx, t, tc.remoteAddr, tc.serverAddr, false, tc.sshDown)
+
+		tun := NewTunnel(tc.localAddr, tc.serverAddr, tc.remoteAddr, tc.config)
+		if st := tun.Status(); st.State != TunnelConnecting {
+			t.Errorf("expected initial state %v, but got %v", TunnelConnecting, st.State)
+		}
+		tun.ForwardNB()
+
+		// Wait for two seconds for tunnel to be available
+		time.Sleep(2 * time.Second)
+
+		st := tun.Status()
+		if tc.expected != st.State {
+			t.Errorf("expected state %v, but got %v", tc.expected, st.State)
+		}
+		if established := st.NotEstablishedSince.IsZero(); established != (tc.expected == TunnelEstablished) {
+			t.Errorf("expected established to be %v, but got not established since %v", tc.expected == TunnelEstablished, st.NotEstablishedSince)
+		}
+		if tc.expectError {
+			if st.LastError == nil || st.LastErrorTime.IsZero() {
+				t.Errorf("expected last error to be recorded, but got %v at %v", st.LastError, st.LastErrorTime)
+			}
+		} else {
+			if st.LastError != nil {
+				t.Errorf("expected no last error, but got %v", st.LastError)
+			}
+		}
+
+		// Cancel servers and tunnel
+		cancel()
+		tun.Cancel()
+
+		// Wait for a millisecond just to be sure that all servers closed
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// startFreezableProxy starts a tcp proxy from {addr} to {targetAddr} for test.
+// After calling returned function, the proxy silently drops all the data
+// without closing connections, to emulate a dead peer.
+func startFreezableProxy(ctx context.Context, t *testing.T, addr, targetAddr string) func() {
+	var frozen int32
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("listening on %s failed: %v", addr, err)
+	}
+
+	pipe := func(dst, src net.Conn) {
+		defer dst.Close()
+		defer src.Close()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				return
+			}
+			if atomic.LoadInt32(&frozen) == 1 {
+				// Drop data
+				continue
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			target, err := net.Dial("tcp", targetAddr)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go pipe(conn, target)
+			go pipe(target, conn)
+		}
+	}()
+
+	return func() { atomic.StoreInt32(&frozen, 1) }
+}
+
+func TestKeepAlive(t *testing.T) {
+	testCases := []struct {
+		name        string
+		localAddr   string
+		proxyAddr   string
+		serverAddr  string
+		remoteAddr  string
+		freeze      bool
+		config      *ssh.ClientConfig
+		msg         string
+		expectError bool
+	}{
+		{
+			name:       "Normal case (server responds to keepalive)",
+			localAddr:  "127.0.0.1:" + genRandomPort(),
+			proxyAddr:  "127.0.0.1:" + genRandomPort(),
+			serverAddr: "127.0.0.1:" + genRandomPort(),
+			remoteAddr: "127.0.0.1:" + genRandomPort(),
+			freeze:     false,
+			config: &ssh.ClientConfig{
+				Timeout:         time.Second * 5,
+				Auth:            []ssh.AuthMethod{},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			msg:         "hello",
+			expectError: false,
+		},
+		{
+			name:       "Error case (server stops responding)",
+			localAddr:  "127.0.0.1:" + genRandomPort(),
+			proxyAddr:  "127.0.0.1:" + genRandomPort(),
+			serverAddr: "127.0.0.1:" + genRandomPort(),
+			remoteAddr: "127.0.0.1:" + genRandomPort(),
+			// Dead peer
+			freeze: true,
+			config: &ssh.ClientConfig{
+				Timeout:         time.Second * 5,
+				Auth:            []ssh.AuthMethod{},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			msg: "hello",
+			// Tunnel should notice dead peer and try to reconnect
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// start echo server on remoteAddr and ssh server on serverAddr
+		prepareTestServers(ctx, t, tc.remoteAddr, tc.serverAddr, false, false)
+		// connect to ssh server via proxy
+		freeze := startFreezableProxy(ctx, t, tc.proxyAddr, tc.serverAddr)
+
+		tun := NewTunnel(tc.localAddr, tc.proxyAddr, tc.remoteAddr, tc.config)
+		tun.SetKeepAlive(200*time.Millisecond, 2)
+		tun.ForwardNB()
+
+		// Wait for two seconds for tunnel to be available
+		time.Sleep(2 * time.Second)
+		if st := tun.Status(); st.State != TunnelEstablished {
+			t.Errorf("expected state %v before freeze, but got %v", TunnelEstablished, st.State)
+		}
+
+		if tc.freeze {
+			freeze()
+		}
+
+		// Wait long enough for keepalive to be missed
+		time.Sleep(time.Second)
+
+		st := tun.Status()
+		msg, err := echoClient(tc.localAddr, tc.msg)
+		if tc.expectError {
+			// Either backing off or connecting again
+			if st.State == TunnelEstablished {
+				t.Errorf("expected state not to be %v, but got %v", TunnelEstablished, st.State)
+			}
+			if err == nil {
+				t.Errorf("expected error, but no error returned")
+			}
+			if st.LastError == nil || !strings.Contains(st.LastError.Error(), "keepalive") {
+				t.Errorf("expected keepalive error, but got %v", st.LastError)
+			}
+		} else {
+			if st.State != TunnelEstablished {
+				t.Errorf("expected state %v, but got %v", TunnelEstablished, st.State)
+			}
+			if err != nil {
+				t.Errorf("expected no error, but got error %v", err)
+			}
+			if tc.msg != msg {
+				t.Errorf("expected msg %s, but got %s", tc.msg, msg)
+			}
+		}
+
+		// Cancel servers and tunnel
+		cancel()
+		tun.Cancel()
+
+		// Wait for a millisecond just to be sure that all servers closed
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSSHServerIdleTimeout(t *testing.T) {
+	testCases := []struct {
+		name        string
+		localAddr   string
+		serverAddr  string
+		remoteAddr  string
+		keepAlive   time.Duration
+		config      *ssh.ClientConfig
+		expectError bool
+	}{
+		{
+			name:       "Normal case (client sends keepalive)",
+			localAddr:  "127.0.0.1:" + genRandomPort(),
+			serverAddr: "127.0.0.1:" + genRandomPort(),
+			remoteAddr: "127.0.0.1:" + genRandomPort(),
+			keepAlive:  200 * time.Millisecond,
+			config: &ssh.ClientConfig{
+				Timeout:         time.Second * 5,
+				Auth:            []ssh.AuthMethod{},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			expectError: false,
+		},
+		{
+			name:       "Error case (client sends no keepalive)",
+			localAddr:  "127.0.0.1:" + genRandomPort(),
+			serverAddr: "127.0.0.1:" + genRandomPort(),
+			remoteAddr: "127.0.0.1:" + genRandomPort(),
+			// No keepalive
+			keepAlive: 0,
+			config: &ssh.ClientConfig{
+				Timeout:         time.Second * 5,
+				Auth:            []ssh.AuthMethod{},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			},
+			// Connection should be closed by server
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		// start ssh server with idle timeout
+		sshServer := NewSSHServer(tc.serverAddr, ServerOptions{IdleTimeout: 500 * time.Millisecond})
+		go sshServer.ListenAndServe()
+		// Wait for a millisecond for ssh server to be available
+		time.Sleep(time.Millisecond)
+
+		tun := NewTunnel(tc.localAddr, tc.serverAddr, tc.remoteAddr, tc.config)
+		tun.SetKeepAlive(tc.keepAlive, 2)
+		tun.ForwardNB()
+
+		// Wait long enough for idle timeout
+		time.Sleep(1500 * time.Millisecond)
+
+		st := tun.Status()
+		if tc.expectError {
+			if st.LastError == nil || !strings.Contains(st.LastError.Error(), "closed") {
+				t.Errorf("expected connection closed error, but got %v", st.LastError)
+			}
+		} else {
+			if st.State != TunnelEstablished {
+				t.Errorf("expected state %v, but got %v", TunnelEstablished, st.State)
+			}
+			if st.LastError != nil {
+				t.Errorf("expected no error, but got %v", st.LastError)
+			}
+		}
+
+		tun.Cancel()
+		sshServer.Close()
+
+		// Wait for a millisecond just to be sure that all servers closed
+		time.Sleep(time.Millisecond)
+	}
+}
+
 func TestRemoteForward(t *testing.T) {
 	testCases := []struct {
 		name        string
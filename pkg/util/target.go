@@ -0,0 +1,239 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+const (
+	// TargetPolicyRoundRobin selects healthy targets in turn for each connection
+	TargetPolicyRoundRobin = "RoundRobin"
+	// TargetPolicyFailover selects the first healthy target, so that the others are used as backups
+	TargetPolicyFailover = "Failover"
+	// targetDialTimeout is the timeout to connect to each target, if there are other targets to fail over
+	targetDialTimeout = 5 * time.Second
+)
+
+var errNoTargets = fmt.Errorf("no targets to connect to")
+
+// TargetLookup returns a TargetGroup to select a target for a connection from {client}, which is the ID
+// sent by the client, to {dest} in host:port format. Nil TargetGroup is returned if {dest} should be connected as is.
+type TargetLookup func(client, dest string) *TargetGroup
+
+// TargetGroupSpec defines targets of a TargetGroup and how to select them
+type TargetGroupSpec struct {
+	// Targets are IP addresses of the targets. The first one is the primary for TargetPolicyFailover.
+	Targets []string
+	// Policy is the policy to select targets, which is TargetPolicyRoundRobin or TargetPolicyFailover
+	Policy string
+	// SourceIP is the IP address to connect to the targets from for health checks
+	SourceIP string
+	// Port is the port of the targets to check health
+	Port string
+	// HealthCheckInterval is the interval of health checks. Zero disables health checks,
+	// then targets are regarded as unhealthy only when they fail to be connected for forwarding.
+	HealthCheckInterval time.Duration
+	// HealthCheckTimeout is the timeout of each health check
+	HealthCheckTimeout time.Duration
+}
+
+// TargetGroup selects targets to connect to from multiple ones by policy.
+// Targets that failed to be connected or failed health checks are tried only after healthy ones.
+type TargetGroup struct {
+	mutex   sync.Mutex
+	spec    TargetGroupSpec
+	healthy map[string]bool
+	next    int
+	cancel  context.CancelFunc
+}
+
+// NewTargetGroup returns a TargetGroup for {spec}, which starts health checks if they are enabled.
+// Stop needs to be called to stop health checks, when the TargetGroup is no longer used.
+func NewTargetGroup(spec TargetGroupSpec) *TargetGroup {
+	g := &TargetGroup{healthy: map[string]bool{}}
+	g.SetSpec(spec)
+
+	return g
+}
+
+// Spec returns the spec of the TargetGroup
+func (g *TargetGroup) Spec() TargetGroupSpec {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.spec
+}
+
+// SetSpec changes the spec of the TargetGroup in place, and restarts health checks.
+// Health of the targets that remain in {spec} is kept, and new targets are regarded as healthy.
+func (g *TargetGroup) SetSpec(spec TargetGroupSpec) {
+	g.Stop()
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	spec.Targets = append([]string{}, spec.Targets...)
+	healthy := map[string]bool{}
+	for _, target := range spec.Targets {
+		if h, ok := g.healthy[target]; ok {
+			healthy[target] = h
+		} else {
+			healthy[target] = true
+		}
+	}
+	g.spec = spec
+	g.healthy = healthy
+
+	if spec.HealthCheckInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.cancel = cancel
+		go g.runHealthCheck(ctx, spec)
+	}
+}
+
+// Stop stops health checks of the TargetGroup
+func (g *TargetGroup) Stop() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.cancel != nil {
+		g.cancel()
+		g.cancel = nil
+	}
+}
+
+// Candidates returns the targets in the order to try for a connection.
+// Healthy targets come first in the order by policy, then unhealthy ones follow
+// in case that all the healthy ones fail.
+func (g *TargetGroup) Candidates() []string {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	healthy := []string{}
+	unhealthy := []string{}
+	for _, target := range g.spec.Targets {
+		if g.healthy[target] {
+			healthy = append(healthy, target)
+		} else {
+			unhealthy = append(unhealthy, target)
+		}
+	}
+
+	if g.spec.Policy != TargetPolicyFailover && len(healthy) > 0 {
+		start := g.next % len(healthy)
+		g.next++
+		healthy = append(healthy[start:], healthy[:start]...)
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// Healthy returns true if {target} is regarded as healthy
+func (g *TargetGroup) Healthy(target string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	return g.healthy[target]
+}
+
+// setHealthy records health of {target}
+func (g *TargetGroup) setHealthy(target string, healthy bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	h, ok := g.healthy[target]
+	if !ok || h == healthy {
+		return
+	}
+	if healthy {
+		glog.Infof("target %q became healthy", target)
+	} else {
+		glog.Warningf("target %q became unhealthy", target)
+	}
+	g.healthy[target] = healthy
+}
+
+// Dial connects to {port} of the targets with {dialer} in the order of Candidates, until one succeeds.
+// Targets that fail to be connected are regarded as unhealthy, and those connected are regarded as healthy.
+func (g *TargetGroup) Dial(ctx context.Context, dialer net.Dialer, port string) (net.Conn, error) {
+	candidates := g.Candidates()
+	if len(candidates) > 1 && dialer.Timeout == 0 {
+		// Don't wait for a dead target too long, when there are others to fail over
+		dialer.Timeout = targetDialTimeout
+	}
+
+	lastErr := errNoTargets
+	for _, target := range candidates {
+		conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(target, port))
+		if err != nil {
+			glog.Warningf("connecting to target %q failed: %v", net.JoinHostPort(target, port), err)
+			g.setHealthy(target, false)
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		g.setHealthy(target, true)
+		return conn, nil
+	}
+
+	return nil, lastErr
+}
+
+// runHealthCheck checks health of the targets in {spec} by connecting to them every interval, until {ctx} is canceled
+func (g *TargetGroup) runHealthCheck(ctx context.Context, spec TargetGroupSpec) {
+	dialer := net.Dialer{
+		LocalAddr: &net.TCPAddr{IP: net.ParseIP(spec.SourceIP)},
+		Timeout:   spec.HealthCheckTimeout,
+	}
+	ticker := time.NewTicker(spec.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, target := range spec.Targets {
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(target, spec.Port))
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				conn.Close()
+			}
+			g.setHealthy(target, err == nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncTargetGroups makes {groups} have TargetGroup with the spec in {expected} for each key.
+// Specs of existing TargetGroups are changed in place, so that health of the targets is kept.
+// TargetGroups for keys not in {expected} are stopped and deleted.
+func SyncTargetGroups(groups map[string]*TargetGroup, expected map[string]TargetGroupSpec) {
+	for k, g := range groups {
+		if _, ok := expected[k]; !ok {
+			g.Stop()
+			delete(groups, k)
+		}
+	}
+
+	for k, spec := range expected {
+		if g, ok := groups[k]; ok {
+			if !reflect.DeepEqual(g.Spec(), spec) {
+				g.SetSpec(spec)
+			}
+			continue
+		}
+		groups[k] = NewTargetGroup(spec)
+	}
+}
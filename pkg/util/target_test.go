@@ -0,0 +1,204 @@
+package util
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCandidates(t *testing.T) {
+	testCases := []struct {
+		name      string
+		policy    string
+		unhealthy []string
+		// expected are candidates returned by calls in order
+		expected [][]string
+	}{
+		{
+			name:   "Normal case (round robin)",
+			policy: TargetPolicyRoundRobin,
+			expected: [][]string{
+				{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+				{"10.0.0.2", "10.0.0.3", "10.0.0.1"},
+				{"10.0.0.3", "10.0.0.1", "10.0.0.2"},
+				{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			},
+		},
+		{
+			name:      "Normal case (round robin with unhealthy target)",
+			policy:    TargetPolicyRoundRobin,
+			unhealthy: []string{"10.0.0.2"},
+			expected: [][]string{
+				{"10.0.0.1", "10.0.0.3", "10.0.0.2"},
+				{"10.0.0.3", "10.0.0.1", "10.0.0.2"},
+				{"10.0.0.1", "10.0.0.3", "10.0.0.2"},
+			},
+		},
+		{
+			name:   "Normal case (failover)",
+			policy: TargetPolicyFailover,
+			expected: [][]string{
+				{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+				{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			},
+		},
+		{
+			name:      "Normal case (failover with unhealthy primary)",
+			policy:    TargetPolicyFailover,
+			unhealthy: []string{"10.0.0.1"},
+			expected: [][]string{
+				{"10.0.0.2", "10.0.0.3", "10.0.0.1"},
+				{"10.0.0.2", "10.0.0.3", "10.0.0.1"},
+			},
+		},
+		{
+			name:      "Normal case (all unhealthy)",
+			policy:    TargetPolicyFailover,
+			unhealthy: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			expected: [][]string{
+				{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		g := NewTargetGroup(TargetGroupSpec{Targets: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}, Policy: tc.policy})
+		for _, target := range tc.unhealthy {
+			g.setHealthy(target, false)
+		}
+		for _, expected := range tc.expected {
+			if candidates := g.Candidates(); !reflect.DeepEqual(expected, candidates) {
+				t.Errorf("expected:%v, but got:%v", expected, candidates)
+			}
+		}
+	}
+}
+
+func TestTargetGroupDial(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Only the backup target listens on the port
+	port := genRandomPort()
+	go startEchoServer(ctx, "127.0.0.2:"+port)
+	time.Sleep(100 * time.Millisecond)
+
+	g := NewTargetGroup(TargetGroupSpec{Targets: []string{"127.0.0.3", "127.0.0.2"}, Policy: TargetPolicyFailover})
+	conn, err := g.Dial(ctx, net.Dialer{}, port)
+	if err != nil {
+		t.Fatalf("expected no error, but got error %v", err)
+	}
+	conn.Close()
+
+	if expected := "127.0.0.2:" + port; conn.RemoteAddr().String() != expected {
+		t.Errorf("expected connection to %s, but got %s", expected, conn.RemoteAddr())
+	}
+	if g.Healthy("127.0.0.3") || !g.Healthy("127.0.0.2") {
+		t.Errorf("expected only 127.0.0.2 to be healthy, but got 127.0.0.3:%v, 127.0.0.2:%v", g.Healthy("127.0.0.3"), g.Healthy("127.0.0.2"))
+	}
+
+	// All targets fail
+	g = NewTargetGroup(TargetGroupSpec{Targets: []string{"127.0.0.3", "127.0.0.4"}, Policy: TargetPolicyRoundRobin})
+	if _, err := g.Dial(ctx, net.Dialer{}, port); err == nil {
+		t.Errorf("expected error, but no error returned")
+	}
+}
+
+func TestTargetGroupHealthCheck(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	port := genRandomPort()
+	go startEchoServer(ctx, "127.0.0.2:"+port)
+	time.Sleep(100 * time.Millisecond)
+
+	g := NewTargetGroup(TargetGroupSpec{
+		Targets:             []string{"127.0.0.3", "127.0.0.2"},
+		Policy:              TargetPolicyFailover,
+		SourceIP:            "127.0.0.1",
+		Port:                port,
+		HealthCheckInterval: 50 * time.Millisecond,
+		HealthCheckTimeout:  time.Second,
+	})
+	defer g.Stop()
+
+	// Unhealthy target is found by health checks without connections for forwarding
+	deadline := time.Now().Add(5 * time.Second)
+	for g.Healthy("127.0.0.3") && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if expected, candidates := []string{"127.0.0.2", "127.0.0.3"}, g.Candidates(); !reflect.DeepEqual(expected, candidates) {
+		t.Errorf("expected:%v, but got:%v", expected, candidates)
+	}
+}
+
+func TestSyncTargetGroups(t *testing.T) {
+	spec1 := TargetGroupSpec{Targets: []string{"10.0.0.1", "10.0.0.2"}, Policy: TargetPolicyFailover}
+	spec2 := TargetGroupSpec{Targets: []string{"10.0.0.2", "10.0.0.3"}, Policy: TargetPolicyRoundRobin}
+
+	groups := map[string]*TargetGroup{}
+	SyncTargetGroups(groups, map[string]TargetGroupSpec{"a": spec1, "b": spec1})
+	a := groups["a"]
+	a.setHealthy("10.0.0.2", false)
+
+	SyncTargetGroups(groups, map[string]TargetGroupSpec{"a": spec2})
+	if len(groups) != 1 {
+		t.Errorf("expected 1 group, but got %d", len(groups))
+	}
+	if groups["a"] != a {
+		t.Errorf("expected group to be updated in place")
+	}
+	if !reflect.DeepEqual(spec2, a.Spec()) {
+		t.Errorf("expected:%v, but got:%v", spec2, a.Spec())
+	}
+	// Health of remaining targets is kept
+	if a.Healthy("10.0.0.2") || !a.Healthy("10.0.0.3") {
+		t.Errorf("expected only 10.0.0.3 to be healthy, but got 10.0.0.2:%v, 10.0.0.3:%v", a.Healthy("10.0.0.2"), a.Healthy("10.0.0.3"))
+	}
+}
+
+func TestForwardToTargetGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Remote endpoint requested by the tunnel is down, but the backup target is up
+	port := genRandomPort()
+	go startEchoServer(ctx, "127.0.0.2:"+port)
+	serverAddr := "127.0.0.1:" + genRandomPort()
+	group := NewTargetGroup(TargetGroupSpec{Targets: []string{"127.0.0.3", "127.0.0.2"}, Policy: TargetPolicyFailover})
+	sshServer := NewSSHServer(serverAddr, ServerOptions{
+		Targets: func(client, dest string) *TargetGroup {
+			if dest == "127.0.0.3:"+port {
+				return group
+			}
+			return nil
+		},
+	})
+	go sshServer.ListenAndServe()
+	defer sshServer.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	localAddr := "127.0.0.1:" + genRandomPort()
+	config := &ssh.ClientConfig{
+		Timeout:         time.Second * 5,
+		Auth:            []ssh.AuthMethod{},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	tun := NewTunnel(localAddr, serverAddr, "127.0.0.3:"+port, config)
+	tun.ForwardNB()
+	defer tun.Cancel()
+
+	msg, err := echoClientWithRetry(localAddr, "hello", 5*time.Second)
+	if err != nil {
+		t.Fatalf("expected no error, but got error %v", err)
+	}
+	if msg != "hello" {
+		t.Errorf("expected msg hello, but got %s", msg)
+	}
+}
@@ -3,6 +3,8 @@ package util
 import (
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 
 	submarinerv1alpha1 "github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
 	"github.com/operator-framework/operator-sdk/pkg/status"
@@ -18,19 +20,23 @@ const (
 	MaxPort = 65536
 )
 
-// RuleUpdatingCondition sets submarinerv1alpha1.ConditionRuleUpdating to stat
-func RuleUpdatingCondition(stat corev1.ConditionStatus) status.Condition {
+// SyncedCondition returns submarinerv1alpha1.ConditionSynced set to {stat} with {reason} and {message}
+func SyncedCondition(stat corev1.ConditionStatus, reason, message string) status.Condition {
 	return status.Condition{
-		Type:   submarinerv1alpha1.ConditionRuleUpdating,
-		Status: stat,
+		Type:    submarinerv1alpha1.ConditionSynced,
+		Status:  stat,
+		Reason:  status.ConditionReason(reason),
+		Message: message,
 	}
 }
 
-// RuleSyncingCondition sets submarinerv1alpha1.ConditionRuleSyncing to stat
-func RuleSyncingCondition(stat corev1.ConditionStatus) status.Condition {
+// ReadyCondition returns submarinerv1alpha1.ConditionReady set to {stat} with {reason} and {message}
+func ReadyCondition(stat corev1.ConditionStatus, reason, message string) status.Condition {
 	return status.Condition{
-		Type:   submarinerv1alpha1.ConditionRuleSyncing,
-		Status: stat,
+		Type:    submarinerv1alpha1.ConditionReady,
+		Status:  stat,
+		Reason:  status.ConditionReason(reason),
+		Message: message,
 	}
 }
 
@@ -59,3 +65,76 @@ func GetRuleName(ip string) (string, error) {
 
 	return gatewayRulePrefix + hexIP, nil
 }
+
+// GetTLSRelayPort returns the port of mTLS relay server of {gw}, which is TLSRelayPort if not specified
+func GetTLSRelayPort(gw *submarinerv1alpha1.Gateway) string {
+	if gw.Spec.TLSRelayPort == "" {
+		return TLSRelayPort
+	}
+
+	return gw.Spec.TLSRelayPort
+}
+
+// ToLimits returns Limits for {sl}. Nil {sl} means unlimited.
+func ToLimits(sl *submarinerv1alpha1.SourceLimits) Limits {
+	if sl == nil {
+		return Limits{}
+	}
+
+	return Limits{
+		MaxConnections: sl.MaxConnections,
+		BytesPerSecond: sl.BytesPerSecond,
+	}
+}
+
+// ToLimitStatus returns LimitStatus for {c}
+func ToLimitStatus(c LimiterCounters) submarinerv1alpha1.LimitStatus {
+	return submarinerv1alpha1.LimitStatus{
+		ActiveConnections:   c.ActiveConnections,
+		RejectedConnections: c.RejectedConnections,
+		ThrottledTransfers:  c.ThrottledTransfers,
+	}
+}
+
+// FormatPortRange returns a port range from {port} to {endPort} like "30000-30100",
+// which is parsed by ParsePortRange. {port} is returned as is if {endPort} is empty.
+func FormatPortRange(port, endPort string) string {
+	if endPort == "" {
+		return port
+	}
+
+	return port + "-" + endPort
+}
+
+// IptablesPortRange returns a port range from {port} to {endPort} in the format of iptables
+// like "30000:30100". {port} is returned as is if {endPort} is empty.
+func IptablesPortRange(port, endPort string) string {
+	if endPort == "" {
+		return port
+	}
+
+	return port + ":" + endPort
+}
+
+// ParsePortRange returns the first and the last ports of {s}, which is a port like "80"
+// or a port range like "30000-30100". Both are the same for a port.
+func ParsePortRange(s string) (int, int, error) {
+	first, last := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		first, last = s[:i], s[i+1:]
+	}
+
+	start, err := strconv.Atoi(first)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+	}
+	end, err := strconv.Atoi(last)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q: %v", s, err)
+	}
+	if start < 1 || end > 65535 || start > end {
+		return 0, 0, fmt.Errorf("invalid port range %q", s)
+	}
+
+	return start, end, nil
+}
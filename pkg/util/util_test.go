@@ -9,33 +9,43 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
-func TestRuleUpdatingCondition(t *testing.T) {
+func TestSyncedCondition(t *testing.T) {
 	testCases := []struct {
 		name     string
 		stat     corev1.ConditionStatus
+		reason   string
+		message  string
 		expected status.Condition
 	}{
 		{
-			name: "Normal case (set true)",
-			stat: corev1.ConditionTrue,
+			name:    "Normal case (set true)",
+			stat:    corev1.ConditionTrue,
+			reason:  ReasonSynced,
+			message: "Rules of generation 2 are synced",
 			expected: status.Condition{
-				Type:   submarinerv1alpha1.ConditionRuleUpdating,
-				Status: corev1.ConditionTrue,
+				Type:    submarinerv1alpha1.ConditionSynced,
+				Status:  corev1.ConditionTrue,
+				Reason:  ReasonSynced,
+				Message: "Rules of generation 2 are synced",
 			},
 		},
 		{
-			name: "Normal case (set false)",
-			stat: corev1.ConditionFalse,
+			name:    "Normal case (set false)",
+			stat:    corev1.ConditionFalse,
+			reason:  ReasonSyncing,
+			message: "Rules of generation 2 are being synced",
 			expected: status.Condition{
-				Type:   submarinerv1alpha1.ConditionRuleUpdating,
-				Status: corev1.ConditionFalse,
+				Type:    submarinerv1alpha1.ConditionSynced,
+				Status:  corev1.ConditionFalse,
+				Reason:  ReasonSyncing,
+				Message: "Rules of generation 2 are being synced",
 			},
 		},
 		{
 			name: "Normal case (set unknown)",
 			stat: corev1.ConditionUnknown,
 			expected: status.Condition{
-				Type:   submarinerv1alpha1.ConditionRuleUpdating,
+				Type:   submarinerv1alpha1.ConditionSynced,
 				Status: corev1.ConditionUnknown,
 			},
 		},
@@ -44,41 +54,42 @@ func TestRuleUpdatingCondition(t *testing.T) {
 	for _, tc := range testCases {
 		t.Logf("test case: %s", tc.name)
 
-		st := RuleUpdatingCondition(tc.stat)
+		st := SyncedCondition(tc.stat, tc.reason, tc.message)
 		if !reflect.DeepEqual(tc.expected, st) {
 			t.Errorf("expected %v, but got %v", tc.expected, st)
 		}
 	}
 }
 
-func TestRuleSyncingCondition(t *testing.T) {
+func TestReadyCondition(t *testing.T) {
 	testCases := []struct {
 		name     string
 		stat     corev1.ConditionStatus
+		reason   string
+		message  string
 		expected status.Condition
 	}{
 		{
-			name: "Normal case (set true)",
-			stat: corev1.ConditionTrue,
+			name:    "Normal case (set true)",
+			stat:    corev1.ConditionTrue,
+			reason:  ReasonReady,
+			message: "",
 			expected: status.Condition{
-				Type:   submarinerv1alpha1.ConditionRuleSyncing,
+				Type:   submarinerv1alpha1.ConditionReady,
 				Status: corev1.ConditionTrue,
+				Reason: ReasonReady,
 			},
 		},
 		{
-			name: "Normal case (set false)",
-			stat: corev1.ConditionFalse,
+			name:    "Normal case (set false)",
+			stat:    corev1.ConditionFalse,
+			reason:  ReasonNotReady,
+			message: "iptables rules are not applied",
 			expected: status.Condition{
-				Type:   submarinerv1alpha1.ConditionRuleSyncing,
-				Status: corev1.ConditionFalse,
-			},
-		},
-		{
-			name: "Normal case (set unknown)",
-			stat: corev1.ConditionUnknown,
-			expected: status.Condition{
-				Type:   submarinerv1alpha1.ConditionRuleSyncing,
-				Status: corev1.ConditionUnknown,
+				Type:    submarinerv1alpha1.ConditionReady,
+				Status:  corev1.ConditionFalse,
+				Reason:  ReasonNotReady,
+				Message: "iptables rules are not applied",
 			},
 		},
 	}
@@ -86,7 +97,7 @@ func TestRuleSyncingCondition(t *testing.T) {
 	for _, tc := range testCases {
 		t.Logf("test case: %s", tc.name)
 
-		st := RuleSyncingCondition(tc.stat)
+		st := ReadyCondition(tc.stat, tc.reason, tc.message)
 		if !reflect.DeepEqual(tc.expected, st) {
 			t.Errorf("expected %v, but got %v", tc.expected, st)
 		}
@@ -177,3 +188,68 @@ func TestGetRuleName(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePortRange(t *testing.T) {
+	testCases := []struct {
+		name          string
+		s             string
+		expectedStart int
+		expectedEnd   int
+		expectErr     bool
+	}{
+		{
+			name:          "Normal case (port)",
+			s:             "80",
+			expectedStart: 80,
+			expectedEnd:   80,
+		},
+		{
+			name:          "Normal case (port range)",
+			s:             FormatPortRange("30000", "30100"),
+			expectedStart: 30000,
+			expectedEnd:   30100,
+		},
+		{
+			name:      "Error case (not a number)",
+			s:         "30000-abc",
+			expectErr: true,
+		},
+		{
+			name:      "Error case (reversed range)",
+			s:         "30100-30000",
+			expectErr: true,
+		},
+		{
+			name:      "Error case (out of range)",
+			s:         "65535-65536",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		start, end, err := ParsePortRange(tc.s)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error, but no error returned")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("expected no error, but got error %v", err)
+		}
+		if tc.expectedStart != start || tc.expectedEnd != end {
+			t.Errorf("expected %d-%d, but got %d-%d", tc.expectedStart, tc.expectedEnd, start, end)
+		}
+	}
+}
+
+func TestIptablesPortRange(t *testing.T) {
+	if expected, s := "80", IptablesPortRange("80", ""); expected != s {
+		t.Errorf("expected %s, but got %s", expected, s)
+	}
+	if expected, s := "30000:30100", IptablesPortRange("30000", "30100"); expected != s {
+		t.Errorf("expected %s, but got %s", expected, s)
+	}
+}
@@ -0,0 +1,237 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+const (
+	// WireGuardPort is the first UDP port that gateways listen on for WireGuard.
+	// Each gateway IP has its own interface, which listens on its own port from it.
+	WireGuardPort = 51820
+	// WireGuardMarkMask is the mask of fwmark used to route packets to WireGuard interfaces.
+	// The highest 8 bits are used to avoid marks used by kube-proxy.
+	WireGuardMarkMask = 0xff000000
+	// WireGuardMaxInterfaces is the maximum number of WireGuard interfaces, which is limited by the mask of fwmark
+	WireGuardMaxInterfaces = 255
+	// wireGuardTableBase is the base of routing tables for WireGuard interfaces
+	wireGuardTableBase = 1000
+	// wireGuardKeepAlive is the interval in seconds of keepalive sent to peers
+	wireGuardKeepAlive = 25
+)
+
+// WireGuardPeer represents a peer of a WireGuard interface
+type WireGuardPeer struct {
+	// PublicKey is the public key of the peer in base64
+	PublicKey string
+	// Endpoint is the address of the peer in host:port format. Empty if the peer connects to us.
+	Endpoint string
+	// AllowedIPs are CIDRs routed to the peer and accepted from the peer
+	AllowedIPs []string
+}
+
+// WireGuardConfig represents a WireGuard interface and the policy routing to it.
+// Packets with the fwmark of Index are routed to the interface.
+type WireGuardConfig struct {
+	// Name is the name of the interface
+	Name string
+	// PrivateKey is the private key of the interface in base64
+	PrivateKey string
+	// ListenPort is the UDP port to listen on. Zero lets the kernel choose it.
+	ListenPort int
+	// Index identifies the fwmark and the routing table for the interface, which is from 0 to 254
+	Index int
+	// Peers are the peers of the interface
+	Peers []WireGuardPeer
+}
+
+// GenerateWireGuardKey returns a new pair of private key and public key for WireGuard in base64
+func GenerateWireGuardKey() (string, string, error) {
+	var private, public [32]byte
+	if _, err := rand.Read(private[:]); err != nil {
+		return "", "", err
+	}
+	// Clamp the private key as curve25519 requires
+	private[0] &= 248
+	private[31] = (private[31] & 127) | 64
+	curve25519.ScalarBaseMult(&public, &private)
+
+	return base64.StdEncoding.EncodeToString(private[:]), base64.StdEncoding.EncodeToString(public[:]), nil
+}
+
+// WireGuardInterfaceName returns the name of WireGuard interface for the peer or the local address {ip}
+// ex) "wgc0a87ac8" for "192.168.122.200"
+func WireGuardInterfaceName(ip string) (string, error) {
+	hexIP, err := GetHexIP(ip)
+	if err != nil {
+		return "", err
+	}
+
+	return "wg" + hexIP, nil
+}
+
+// WireGuardMark returns fwmark with mask for {index} in the format used by iptables
+// ex) "0x1000000/0xff000000" for 0
+func WireGuardMark(index int) string {
+	return fmt.Sprintf("0x%x/0x%x", (index+1)<<24, WireGuardMarkMask)
+}
+
+// wireGuardTable returns the routing table for {index}
+func wireGuardTable(index int) string {
+	return strconv.Itoa(wireGuardTableBase + index)
+}
+
+// Defining used interface to run commands to use mock in unit test
+type commandInterface interface {
+	// Run runs {name} with {args}, and passes {stdin} to it if not empty. It returns the output.
+	Run(stdin, name string, args ...string) (string, error)
+}
+
+type execCommand struct{}
+
+func (execCommand) Run(stdin, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), fmt.Errorf("%s %s failed: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+
+	return string(out), nil
+}
+
+// EnsureWireGuard creates or updates the WireGuard interface and the policy routing for {cfg}.
+// The interface is created by the wireguard kernel module, which is required on the host.
+func EnsureWireGuard(cfg WireGuardConfig) error {
+	return ensureWireGuard(execCommand{}, cfg)
+}
+
+// DeleteWireGuard deletes the WireGuard interface {name} and the policy routing for {index}
+func DeleteWireGuard(name string, index int) error {
+	return deleteWireGuard(execCommand{}, name, index)
+}
+
+// CheckWireGuard checks that the WireGuard interface for {cfg} exists and has all the peers
+func CheckWireGuard(cfg WireGuardConfig) bool {
+	return checkWireGuard(execCommand{}, cfg)
+}
+
+func ensureWireGuard(cmd commandInterface, cfg WireGuardConfig) error {
+	if _, err := cmd.Run("", "ip", "link", "show", "dev", cfg.Name); err != nil {
+		// Userspace implementation isn't used, because pods have neither wireguard-go nor /dev/net/tun
+		if _, err := cmd.Run("", "ip", "link", "add", "dev", cfg.Name, "type", "wireguard"); err != nil {
+			return fmt.Errorf("failed to create wireguard interface %s, which requires wireguard kernel module: %v", cfg.Name, err)
+		}
+	}
+
+	// Private key is passed by stdin not to be exposed in arguments
+	args := []string{"set", cfg.Name, "private-key", "/dev/stdin"}
+	if cfg.ListenPort != 0 {
+		args = append(args, "listen-port", strconv.Itoa(cfg.ListenPort))
+	}
+	if _, err := cmd.Run(cfg.PrivateKey, "wg", args...); err != nil {
+		return err
+	}
+
+	// Remove peers no longer expected
+	expected := map[string]bool{}
+	for _, peer := range cfg.Peers {
+		expected[peer.PublicKey] = true
+	}
+	current, err := wireGuardPeers(cmd, cfg.Name)
+	if err != nil {
+		return err
+	}
+	for _, key := range current {
+		if expected[key] {
+			continue
+		}
+		if _, err := cmd.Run("", "wg", "set", cfg.Name, "peer", key, "remove"); err != nil {
+			return err
+		}
+	}
+
+	for _, peer := range cfg.Peers {
+		args := []string{"set", cfg.Name, "peer", peer.PublicKey, "allowed-ips", strings.Join(peer.AllowedIPs, ",")}
+		if peer.Endpoint != "" {
+			args = append(args, "endpoint", peer.Endpoint, "persistent-keepalive", strconv.Itoa(wireGuardKeepAlive))
+		}
+		if _, err := cmd.Run("", "wg", args...); err != nil {
+			return err
+		}
+	}
+
+	if _, err := cmd.Run("", "ip", "link", "set", "up", "dev", cfg.Name); err != nil {
+		return err
+	}
+
+	// Route packets marked for the interface to it
+	table := wireGuardTable(cfg.Index)
+	if _, err := cmd.Run("", "ip", "route", "replace", "default", "dev", cfg.Name, "table", table); err != nil {
+		return err
+	}
+	rules, err := cmd.Run("", "ip", "rule", "show", "table", table)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(rules) == "" {
+		if _, err := cmd.Run("", "ip", "rule", "add", "fwmark", WireGuardMark(cfg.Index), "table", table); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func deleteWireGuard(cmd commandInterface, name string, index int) error {
+	// Policy routing may not exist, if it failed in the middle of creating
+	_, _ = cmd.Run("", "ip", "rule", "del", "fwmark", WireGuardMark(index), "table", wireGuardTable(index))
+	if _, err := cmd.Run("", "ip", "link", "show", "dev", name); err != nil {
+		// Already deleted
+		return nil
+	}
+	if _, err := cmd.Run("", "ip", "link", "del", "dev", name); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func checkWireGuard(cmd commandInterface, cfg WireGuardConfig) bool {
+	current, err := wireGuardPeers(cmd, cfg.Name)
+	if err != nil {
+		return false
+	}
+	found := map[string]bool{}
+	for _, key := range current {
+		found[key] = true
+	}
+	for _, peer := range cfg.Peers {
+		if !found[peer.PublicKey] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// wireGuardPeers returns public keys of the current peers of WireGuard interface {name}, sorted
+func wireGuardPeers(cmd commandInterface, name string) ([]string, error) {
+	out, err := cmd.Run("", "wg", "show", name, "peers")
+	if err != nil {
+		return nil, err
+	}
+	peers := strings.Fields(out)
+	sort.Strings(peers)
+
+	return peers, nil
+}
@@ -0,0 +1,397 @@
+package util
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// fakeCommand records commands run and returns outputs and errors for them
+type fakeCommand struct {
+	// outputs and errors are keyed by the command line
+	outputs map[string]string
+	errors  map[string]bool
+	run     []string
+	stdin   map[string]string
+}
+
+func (c *fakeCommand) Run(stdin, name string, args ...string) (string, error) {
+	line := strings.Join(append([]string{name}, args...), " ")
+	c.run = append(c.run, line)
+	if stdin != "" {
+		if c.stdin == nil {
+			c.stdin = map[string]string{}
+		}
+		c.stdin[line] = stdin
+	}
+	if c.errors[line] {
+		return "", fmt.Errorf("%s failed", line)
+	}
+
+	return c.outputs[line], nil
+}
+
+func TestGenerateWireGuardKey(t *testing.T) {
+	private, public, err := GenerateWireGuardKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	privBytes, err := base64.StdEncoding.DecodeString(private)
+	if err != nil || len(privBytes) != 32 {
+		t.Fatalf("invalid private key %q: %v", private, err)
+	}
+	if privBytes[0]&7 != 0 || privBytes[31]&128 != 0 || privBytes[31]&64 == 0 {
+		t.Errorf("private key %q is not clamped", private)
+	}
+
+	var priv, pub [32]byte
+	copy(priv[:], privBytes)
+	curve25519.ScalarBaseMult(&pub, &priv)
+	if expected := base64.StdEncoding.EncodeToString(pub[:]); public != expected {
+		t.Errorf("expecting public key %q, but got %q", expected, public)
+	}
+
+	private2, _, err := GenerateWireGuardKey()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if private == private2 {
+		t.Errorf("expecting different keys, but got the same %q", private)
+	}
+}
+
+func TestWireGuardInterfaceName(t *testing.T) {
+	testCases := []struct {
+		name        string
+		ip          string
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:     "Normal case",
+			ip:       "192.168.122.200",
+			expected: "wgc0a87ac8",
+		},
+		{
+			name:        "Error case (invalid IP)",
+			ip:          "192.168.122",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		name, err := WireGuardInterfaceName(tc.ip)
+		if tc.expectedErr {
+			if err == nil {
+				t.Errorf("expecting error, but got no error")
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if name != tc.expected {
+			t.Errorf("expecting %q, but got %q", tc.expected, name)
+		}
+	}
+}
+
+func TestWireGuardMark(t *testing.T) {
+	testCases := []struct {
+		name     string
+		index    int
+		expected string
+	}{
+		{
+			name:     "First index",
+			index:    0,
+			expected: "0x1000000/0xff000000",
+		},
+		{
+			name:     "Last index",
+			index:    254,
+			expected: "0xff000000/0xff000000",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		if mark := WireGuardMark(tc.index); mark != tc.expected {
+			t.Errorf("expecting %q, but got %q", tc.expected, mark)
+		}
+	}
+}
+
+func TestEnsureWireGuard(t *testing.T) {
+	cfg := WireGuardConfig{
+		Name:       "wgc0a87ac8",
+		PrivateKey: "private",
+		ListenPort: 51820,
+		Index:      1,
+		Peers: []WireGuardPeer{
+			{
+				PublicKey:  "peer1",
+				Endpoint:   "192.168.122.200:51820",
+				AllowedIPs: []string{"0.0.0.0/0"},
+			},
+			{
+				PublicKey:  "peer2",
+				AllowedIPs: []string{"10.244.0.34/32", "10.244.0.35/32"},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		outputs     map[string]string
+		errors      map[string]bool
+		expected    []string
+		expectedErr bool
+	}{
+		{
+			name: "Create interface by kernel module",
+			errors: map[string]bool{
+				"ip link show dev wgc0a87ac8": true,
+			},
+			expected: []string{
+				"ip link show dev wgc0a87ac8",
+				"ip link add dev wgc0a87ac8 type wireguard",
+				"wg set wgc0a87ac8 private-key /dev/stdin listen-port 51820",
+				"wg show wgc0a87ac8 peers",
+				"wg set wgc0a87ac8 peer peer1 allowed-ips 0.0.0.0/0 endpoint 192.168.122.200:51820 persistent-keepalive 25",
+				"wg set wgc0a87ac8 peer peer2 allowed-ips 10.244.0.34/32,10.244.0.35/32",
+				"ip link set up dev wgc0a87ac8",
+				"ip route replace default dev wgc0a87ac8 table 1001",
+				"ip rule show table 1001",
+				"ip rule add fwmark 0x2000000/0xff000000 table 1001",
+			},
+		},
+		{
+			name: "Update existing interface with stale peer and rule",
+			outputs: map[string]string{
+				"wg show wgc0a87ac8 peers": "peer1\npeer3\n",
+				"ip rule show table 1001":  "32765:	from all fwmark 0x2000000/0xff000000 lookup 1001\n",
+			},
+			expected: []string{
+				"ip link show dev wgc0a87ac8",
+				"wg set wgc0a87ac8 private-key /dev/stdin listen-port 51820",
+				"wg show wgc0a87ac8 peers",
+				"wg set wgc0a87ac8 peer peer3 remove",
+				"wg set wgc0a87ac8 peer peer1 allowed-ips 0.0.0.0/0 endpoint 192.168.122.200:51820 persistent-keepalive 25",
+				"wg set wgc0a87ac8 peer peer2 allowed-ips 10.244.0.34/32,10.244.0.35/32",
+				"ip link set up dev wgc0a87ac8",
+				"ip route replace default dev wgc0a87ac8 table 1001",
+				"ip rule show table 1001",
+			},
+		},
+		{
+			name: "Error case (no kernel module)",
+			errors: map[string]bool{
+				"ip link show dev wgc0a87ac8":               true,
+				"ip link add dev wgc0a87ac8 type wireguard": true,
+			},
+			// No fallback to wireguard-go
+			expected: []string{
+				"ip link show dev wgc0a87ac8",
+				"ip link add dev wgc0a87ac8 type wireguard",
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		cmd := &fakeCommand{outputs: tc.outputs, errors: tc.errors}
+		err := ensureWireGuard(cmd, cfg)
+		if tc.expectedErr != (err != nil) {
+			t.Errorf("expecting error %v, but got %v", tc.expectedErr, err)
+		}
+		if !reflect.DeepEqual(cmd.run, tc.expected) {
+			t.Errorf("expecting commands %q, but got %q", tc.expected, cmd.run)
+		}
+		if !tc.expectedErr {
+			// Private key must be passed only via stdin
+			if key := cmd.stdin["wg set wgc0a87ac8 private-key /dev/stdin listen-port 51820"]; key != cfg.PrivateKey {
+				t.Errorf("expecting private key %q in stdin, but got %q", cfg.PrivateKey, key)
+			}
+		}
+	}
+}
+
+// writeFakeCommands writes scripts for {names} into {dir}, which log their command lines into {log}
+// and fail for the command lines in {failures} like the real commands
+func writeFakeCommands(t *testing.T, dir, log string, names []string, failures map[string]string) {
+	for _, name := range names {
+		script := "#!/bin/sh\n" +
+			"line=\"" + name + " $*\"\n" +
+			"echo \"$line\" >> " + log + "\n" +
+			"cat > /dev/null\n"
+		for line, msg := range failures {
+			if strings.HasPrefix(line, name+" ") {
+				script += "[ \"$line\" = \"" + line + "\" ] && echo \"" + msg + "\" >&2 && exit 2\n"
+			}
+		}
+		script += "exit 0\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatalf("failed to write fake %s: %v", name, err)
+		}
+	}
+}
+
+func TestEnsureWireGuardInPod(t *testing.T) {
+	cfg := WireGuardConfig{
+		Name:       "wgc0a87ac8",
+		PrivateKey: "private",
+		Index:      1,
+		Peers: []WireGuardPeer{
+			{
+				PublicKey:  "peer1",
+				Endpoint:   "192.168.122.200:51820",
+				AllowedIPs: []string{"0.0.0.0/0"},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		failures    map[string]string
+		expectedErr bool
+	}{
+		{
+			name: "Normal case (kernel module)",
+			failures: map[string]string{
+				"ip link show dev wgc0a87ac8": "Device \"wgc0a87ac8\" does not exist.",
+			},
+			expectedErr: false,
+		},
+		{
+			name: "Error case (no kernel module)",
+			failures: map[string]string{
+				"ip link show dev wgc0a87ac8":               "Device \"wgc0a87ac8\" does not exist.",
+				"ip link add dev wgc0a87ac8 type wireguard": "Error: Unknown device type.",
+			},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+
+		// PATH only has the commands that the images install, ip and wg, and no wireguard-go
+		dir, err := ioutil.TempDir("", "wireguard")
+		if err != nil {
+			t.Fatalf("failed to create temp dir: %v", err)
+		}
+		defer os.RemoveAll(dir)
+		log := filepath.Join(dir, "log")
+		writeFakeCommands(t, dir, log, []string{"ip", "wg"}, tc.failures)
+		path := os.Getenv("PATH")
+		os.Setenv("PATH", dir)
+
+		err = ensureWireGuard(execCommand{}, cfg)
+		os.Setenv("PATH", path)
+		if tc.expectedErr != (err != nil) {
+			t.Errorf("expecting error %v, but got %v", tc.expectedErr, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), "kernel module") {
+			t.Errorf("expecting error to tell kernel module is required, but got %v", err)
+		}
+
+		out, _ := ioutil.ReadFile(log)
+		if !strings.Contains(string(out), "ip link add dev wgc0a87ac8 type wireguard") {
+			t.Errorf("expecting interface to be created by kernel module, but got commands %q", out)
+		}
+		if tc.expectedErr && strings.Contains(string(out), "wg set") {
+			t.Errorf("expecting no wg command after failing to create interface, but got commands %q", out)
+		}
+	}
+}
+
+func TestDeleteWireGuard(t *testing.T) {
+	testCases := []struct {
+		name     string
+		errors   map[string]bool
+		expected []string
+	}{
+		{
+			name: "Delete existing interface",
+			expected: []string{
+				"ip rule del fwmark 0x1000000/0xff000000 table 1000",
+				"ip link show dev wgc0a87ac8",
+				"ip link del dev wgc0a87ac8",
+			},
+		},
+		{
+			name: "Already deleted",
+			errors: map[string]bool{
+				"ip rule del fwmark 0x1000000/0xff000000 table 1000": true,
+				"ip link show dev wgc0a87ac8":                        true,
+			},
+			expected: []string{
+				"ip rule del fwmark 0x1000000/0xff000000 table 1000",
+				"ip link show dev wgc0a87ac8",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		cmd := &fakeCommand{errors: tc.errors}
+		if err := deleteWireGuard(cmd, "wgc0a87ac8", 0); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(cmd.run, tc.expected) {
+			t.Errorf("expecting commands %q, but got %q", tc.expected, cmd.run)
+		}
+	}
+}
+
+func TestCheckWireGuard(t *testing.T) {
+	cfg := WireGuardConfig{
+		Name: "wgc0a87ac8",
+		Peers: []WireGuardPeer{
+			{PublicKey: "peer1"},
+			{PublicKey: "peer2"},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		outputs  map[string]string
+		errors   map[string]bool
+		expected bool
+	}{
+		{
+			name:     "All peers exist",
+			outputs:  map[string]string{"wg show wgc0a87ac8 peers": "peer2\npeer1\n"},
+			expected: true,
+		},
+		{
+			name:     "Peer is missing",
+			outputs:  map[string]string{"wg show wgc0a87ac8 peers": "peer1\n"},
+			expected: false,
+		},
+		{
+			name:     "Interface doesn't exist",
+			errors:   map[string]bool{"wg show wgc0a87ac8 peers": true},
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Logf("test case: %s", tc.name)
+		cmd := &fakeCommand{outputs: tc.outputs, errors: tc.errors}
+		if ok := checkWireGuard(cmd, cfg); ok != tc.expected {
+			t.Errorf("expecting %v, but got %v", tc.expected, ok)
+		}
+	}
+}
@@ -9,13 +9,17 @@ import (
 	"time"
 
 	"github.com/mkimuram/k8s-ext-connector/pkg/apis/submariner/v1alpha1"
+	"github.com/mkimuram/k8s-ext-connector/pkg/controller/externalservice"
+	"github.com/mkimuram/k8s-ext-connector/pkg/util"
 	"github.com/onsi/ginkgo"
 	"github.com/onsi/gomega"
 	framework "github.com/operator-framework/operator-sdk/pkg/test"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var _ = ginkgo.Describe("[k8s-ext-connector]", func() {
@@ -103,6 +107,7 @@ var _ = ginkgo.Describe("[k8s-ext-connector]", func() {
 							SourceIP: esSrcIP,
 						},
 					},
+					ClientService: &v1alpha1.ClientService{},
 					Ports: []corev1.ServicePort{
 						{
 							Protocol: corev1.ProtocolTCP,
@@ -145,8 +150,8 @@ var _ = ginkgo.Describe("[k8s-ext-connector]", func() {
 			// expect podIP or nodeIP (just check that it isn't podIP and externalservice's source IP)
 			gomega.Expect(daccessIP).NotTo(gomega.Equal(esSrcIP), "source IP shouldn't be external service's source IP")
 
-			// access from pod to remote server via external service
-			esAccessIP, _, err := execInPod(f.KubeClient, f.KubeConfig, ns, "pod1", "centos", []string{"curl", fmt.Sprintf("es1.external-services:%d", esPort)})
+			// access from pod to remote server via client service of external service
+			esAccessIP, _, err := execInPod(f.KubeClient, f.KubeConfig, ns, "pod1", "centos", []string{"curl", fmt.Sprintf("%s.%s:%d", es.Name, es.Namespace, esPort)})
 			gomega.Expect(err).NotTo(gomega.HaveOccurred(), "error executing command in pod")
 			gomega.Expect(esAccessIP).To(gomega.Equal(esSrcIP), "source IP should be external services' sourceIP")
 		})
@@ -168,17 +173,28 @@ func createExternalService(t *testing.T, f *framework.Framework, ctx *framework.
 		time.Sleep(time.Second)
 
 		// Confirm that expected resources are created
-		pod := &corev1.Pod{}
-		err = f.Client.Get(goctx.TODO(), types.NamespacedName{Name: es.Name, Namespace: ns}, pod)
-		gomega.Expect(err).NotTo(gomega.HaveOccurred(), "pod isn't created")
+		deploy := &appsv1.Deployment{}
+		err = f.Client.Get(goctx.TODO(), types.NamespacedName{Name: externalservice.ForwarderName(es), Namespace: ns}, deploy)
+		gomega.Expect(err).NotTo(gomega.HaveOccurred(), "deployment isn't created")
 
 		svc := &corev1.Service{}
-		err = f.Client.Get(goctx.TODO(), types.NamespacedName{Name: es.Name, Namespace: ns}, svc)
+		err = f.Client.Get(goctx.TODO(), types.NamespacedName{Name: externalservice.ForwarderName(es), Namespace: ns}, svc)
 		gomega.Expect(err).NotTo(gomega.HaveOccurred(), "service isn't created")
 
-		fwd := &v1alpha1.Forwarder{}
-		err = f.Client.Get(goctx.TODO(), types.NamespacedName{Name: es.Name, Namespace: ns}, fwd)
-		gomega.Expect(err).NotTo(gomega.HaveOccurred(), "forwarder isn't created")
+		if es.Spec.ClientService != nil {
+			clientSvc := &corev1.Service{}
+			err = f.Client.Get(goctx.TODO(), types.NamespacedName{Name: es.Name, Namespace: es.Namespace}, clientSvc)
+			gomega.Expect(err).NotTo(gomega.HaveOccurred(), "client service isn't created")
+		}
+
+		// Forwarders are created per forwarder pod
+		fwds := &v1alpha1.ForwarderList{}
+		err = f.Client.List(goctx.TODO(), fwds, client.InNamespace(ns), client.MatchingLabels{
+			util.ExternalServiceNamespaceLabel: es.Namespace,
+			util.ExternalServiceNameLabel:      es.Name,
+		})
+		gomega.Expect(err).NotTo(gomega.HaveOccurred(), "listing forwarders failed")
+		gomega.Expect(fwds.Items).NotTo(gomega.BeEmpty(), "forwarder isn't created")
 	}
 }
 